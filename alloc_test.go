@@ -0,0 +1,195 @@
+package easyws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+type benchConn struct{}
+
+func (benchConn) RemoteAddr() string         { return "192.0.2.1:49152" }
+func (benchConn) Send(p []byte) (int, error) { return len(p), nil }
+func (benchConn) Close() error               { return nil }
+
+type benchEcho struct{}
+
+func (benchEcho) OnStart() (OpCode, error)      { return 0, nil }
+func (benchEcho) OnConnect() (OpCode, error)    { return 0, nil }
+func (benchEcho) OnUpgraded() (OpCode, error)   { return 0, nil }
+func (benchEcho) OnShutdown() (OpCode, error)   { return 0, nil }
+func (benchEcho) OnClose(error) (OpCode, error) { return 0, nil }
+
+func (benchEcho) OnReceive(msg []byte) ([]byte, OpCode, error) {
+	return msg, OpText, nil
+}
+
+// upgradedHandler returns NetHandler serving echo with upgraded connection.
+func upgradedHandler(tb testing.TB, options ...ServerOption) (*NetHandler, *base.InputStream) {
+	h := NewNetHandler(benchEcho{}, options...)
+	stream := &base.InputStream{}
+	h.OnConnect(benchConn{})
+	stream.Begin([]byte("GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"\r\n",
+	))
+	if _, err := h.OnReceive(benchConn{}, stream); err != nil {
+		tb.Fatal(err)
+	}
+	return h, stream
+}
+
+func maskedFrame(op OpCode, p []byte) []byte {
+	return MustCompileFrame(MaskFrameWith(NewFrame(op, true, p), [4]byte{1, 2, 3, 4}))
+}
+
+func TestNetHandlerEchoAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items randomly with race detector")
+	}
+	for _, test := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"text", maskedFrame(OpText, []byte("hello, world!"))},
+		{"binary", maskedFrame(OpBinary, make([]byte, 1024))},
+		{"ping", maskedFrame(OpPing, []byte("ping"))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h, stream := upgradedHandler(t, WithReuseBuffers())
+			allocs := testing.AllocsPerRun(100, func() {
+				stream.Begin(test.frame)
+				if _, err := h.OnReceive(benchConn{}, stream); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if allocs != 0 {
+				t.Fatalf("OnReceive() made %v allocations; want 0", allocs)
+			}
+		})
+	}
+}
+
+// retainHandler keeps received messages, copying them if copy is set.
+type retainHandler struct {
+	benchEcho
+	copy bool
+	msgs [][]byte
+}
+
+func (h *retainHandler) OnReceive(msg []byte) ([]byte, OpCode, error) {
+	if h.copy {
+		msg = append([]byte(nil), msg...)
+	}
+	h.msgs = append(h.msgs, msg)
+	return nil, 0, nil
+}
+
+func TestNetHandlerRetain(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		options []ServerOption
+		copy    bool
+	}{
+		{"default", nil, false},
+		// Handlers must copy messages when buffers are reused.
+		{"reuse buffers", []ServerOption{WithReuseBuffers()}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rh := &retainHandler{copy: test.copy}
+			h := NewNetHandler(rh, test.options...)
+			conn := &recordConn{addr: "192.0.2.1:1"}
+			h.OnConnect(conn)
+			stream := &base.InputStream{}
+			stream.Begin([]byte(handshakeRequest))
+			if _, err := h.OnReceive(conn, stream); err != nil {
+				t.Fatal(err)
+			}
+			var exp [][]byte
+			for i := 0; i < 3; i++ {
+				p := bytes.Repeat([]byte{'a' + byte(i)}, 100)
+				exp = append(exp, p)
+				stream.Begin(maskedFrame(OpBinary, p))
+				if _, err := h.OnReceive(conn, stream); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, msg := range rh.msgs {
+				if !bytes.Equal(msg, exp[i]) {
+					t.Fatalf("retained message #%d is %q; want %q", i, msg, exp[i])
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNetHandlerEcho(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		size int
+	}{
+		{"16B", 16},
+		{"125B", 125},
+		{"1KiB", 1024},
+		{"64KiB", 64 * 1024},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			h, stream := upgradedHandler(b, WithReuseBuffers())
+			frame := maskedFrame(OpBinary, make([]byte, bench.size))
+			b.SetBytes(int64(bench.size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				stream.Begin(frame)
+				if _, err := h.OnReceive(benchConn{}, stream); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAppendHeader(b *testing.B) {
+	h := Header{
+		Fin:    true,
+		OpCode: OpBinary,
+		Length: 1 << 20,
+		Masked: true,
+		Mask:   [4]byte{1, 2, 3, 4},
+	}
+	buf := make([]byte, 0, MaxHeaderSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = AppendHeader(buf[:0], h)
+	}
+}
+
+func BenchmarkUpgrade(b *testing.B) {
+	req := []byte("GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"User-Agent: Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36\r\n" +
+		"\r\n",
+	)
+	var (
+		u      Upgrader
+		stream base.InputStream
+		out    []byte
+	)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		stream.Begin(req)
+		if _, out, err = u.upgrade(&stream, out[:0], nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package easyws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Identity describes an authenticated client.
+type Identity struct {
+	// Subject is the identifier of the client, like user name.
+	Subject string
+
+	// Claims contains optional attributes of the client, like the claims
+	// of a verified token.
+	Claims map[string]interface{}
+}
+
+// HandshakeRequest describes the handshake request passed to Authenticator.
+type HandshakeRequest struct {
+	// RequestURI is the Request-URI of the request.
+	RequestURI string
+
+	// Header contains all headers of the request.
+	Header http.Header
+}
+
+// Query returns parsed query of the request URI.
+func (r *HandshakeRequest) Query() url.Values {
+	i := strings.IndexByte(r.RequestURI, '?')
+	if i == -1 {
+		return url.Values{}
+	}
+	q, _ := url.ParseQuery(r.RequestURI[i+1:])
+	return q
+}
+
+// Cookie returns the value of the cookie with given name.
+func (r *HandshakeRequest) Cookie(name string) (string, bool) {
+	for _, h := range r.Header[headerCookieCanonical] {
+		if v, ok := cookieValue([]byte(h), name); ok {
+			return string(v), true
+		}
+	}
+	return "", false
+}
+
+// Protocols returns the list of subprotocols offered by the client in
+// Sec-WebSocket-Protocol header. Since browsers can not set arbitrary headers
+// of WebSocket handshake, it is sometimes used to pass the credentials.
+func (r *HandshakeRequest) Protocols() []string {
+	var ps []string
+	for _, h := range r.Header[headerSecProtocolCanonical] {
+		ScanTokens([]byte(h), func(v []byte) bool {
+			ps = append(ps, string(v))
+			return true
+		})
+	}
+	return ps
+}
+
+// Authenticator authenticates clients during the handshake.
+//
+// Authenticate is called by Upgrader after the request is validated and
+// before OnBeforeUpgrade. Returned identity is available via
+// Handshake.Identity and Conn.Identity. Nil identity means anonymous
+// client.
+//
+// If returned error is non-nil then connection is rejected. If error is not
+// created by RejectConnectionError, response is sent with 401 status code.
+// Unauthorized could be used to send the WWW-Authenticate challenge.
+type Authenticator interface {
+	Authenticate(r *HandshakeRequest) (*Identity, error)
+}
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as
+// Authenticator.
+type AuthenticatorFunc func(r *HandshakeRequest) (*Identity, error)
+
+// Authenticate implements Authenticator.
+func (f AuthenticatorFunc) Authenticate(r *HandshakeRequest) (*Identity, error) {
+	return f(r)
+}
+
+// Unauthorized returns an error that rejects connection with 401 status
+// code, given reason and WWW-Authenticate challenge, like `Basic
+// realm="chat"`. Challenge is omitted if empty.
+func Unauthorized(reason, challenge string) error {
+	options := []RejectOption{
+		RejectionStatus(http.StatusUnauthorized),
+		RejectionReason(reason),
+	}
+	if challenge != "" {
+		options = append(options, RejectionHeader(HandshakeHeaderHTTP{
+			"WWW-Authenticate": {challenge},
+		}))
+	}
+	return RejectConnectionError(options...)
+}
+
+// Forbidden returns an error that rejects connection with 403 status code
+// and given reason.
+func Forbidden(reason string) error {
+	return RejectConnectionError(
+		RejectionStatus(http.StatusForbidden),
+		RejectionReason(reason),
+	)
+}
+
+// authenticate runs a on the complete HTTP request in data.
+func authenticate(a Authenticator, data []byte) (*Identity, error) {
+	rl, _ := nextLine(data)
+	req, _ := httpParseRequestLine(rl)
+	r := &HandshakeRequest{
+		RequestURI: string(req.uri),
+		Header:     http.Header{},
+	}
+	httpScanHeaders(data, func(k, v []byte) {
+		key := string(k)
+		r.Header[key] = append(r.Header[key], string(v))
+	})
+	id, err := a.Authenticate(r)
+	if err == nil {
+		return id, nil
+	}
+	if _, ok := err.(*ConnectionRejectedError); !ok {
+		err = Unauthorized(err.Error(), "")
+	}
+	return nil, err
+}
@@ -0,0 +1,98 @@
+package easyws
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+func TestUpgraderAuthenticator(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		err       error
+		status    int
+		challenge string
+	}{
+		{
+			name:      "unauthorized",
+			err:       Unauthorized("invalid token", `Bearer realm="chat"`),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="chat"`,
+		},
+		{
+			name:   "forbidden",
+			err:    Forbidden("banned"),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "error",
+			err:    errors.New("no way"),
+			status: http.StatusUnauthorized,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var req *HandshakeRequest
+			u := Upgrader{Authenticator: AuthenticatorFunc(func(r *HandshakeRequest) (*Identity, error) {
+				req = r
+				return nil, test.err
+			})}
+			stream := &base.InputStream{}
+			stream.Begin([]byte("GET /ws?token=x HTTP/1.1\r\n" +
+				"Host: localhost\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Version: 13\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+				"Authorization: Bearer x\r\n" +
+				"\r\n",
+			))
+			_, out, err := u.Upgrade(stream)
+			if err == nil {
+				t.Fatalf("Upgrade() error is nil")
+			}
+			if req == nil {
+				t.Fatalf("Authenticate() is not called")
+			}
+			if req.RequestURI != "/ws?token=x" || req.Query().Get("token") != "x" || req.Header.Get("Authorization") != "Bearer x" {
+				t.Fatalf("unexpected handshake request: %+v", req)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(out)), nil)
+			if err != nil {
+				t.Fatalf("can not read response: %v\n%q", err, out)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("response status is %d; want %d", resp.StatusCode, test.status)
+			}
+			if act := resp.Header.Get("WWW-Authenticate"); act != test.challenge {
+				t.Fatalf("challenge is %q; want %q", act, test.challenge)
+			}
+		})
+	}
+}
+
+func TestConnIdentity(t *testing.T) {
+	var identity *Identity
+	ch := &connHandler{open: func(c *Conn) error {
+		identity = c.Identity()
+		return nil
+	}}
+	h := NewNetHandler(ch, WithUpgrader(Upgrader{
+		Authenticator: AuthenticatorFunc(func(r *HandshakeRequest) (*Identity, error) {
+			return &Identity{Subject: "alice", Claims: map[string]interface{}{"role": "admin"}}, nil
+		}),
+	}))
+	conn := &recordConn{addr: "192.0.2.1:1"}
+	h.OnConnect(conn)
+	stream := &base.InputStream{}
+	stream.Begin([]byte(handshakeRequest))
+	if _, err := h.OnReceive(conn, stream); err != nil {
+		t.Fatal(err)
+	}
+	if identity == nil || identity.Subject != "alice" || identity.Claims["role"] != "admin" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+}
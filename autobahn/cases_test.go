@@ -0,0 +1,758 @@
+package autobahn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wsutil"
+)
+
+func TestServer(t *testing.T) {
+	addr := startServer(t)
+
+	var cases []testCase
+	cases = append(cases, framingCases()...)
+	cases = append(cases, pingCases()...)
+	cases = append(cases, reservedBitsCases()...)
+	cases = append(cases, opCodeCases()...)
+	cases = append(cases, fragmentationCases()...)
+	cases = append(cases, utf8Cases()...)
+	cases = append(cases, closeCases()...)
+	cases = append(cases, limitsCases()...)
+
+	runCases(t, cases, dialer(addr, easyws.Dialer{}))
+
+	limited := startServer(t,
+		easyws.WithMaxFrameSize(tooBigFrameSize),
+		easyws.WithMaxMessageSize(tooBigMessageSize),
+	)
+	runCases(t, tooBigCases(), dialer(limited, easyws.Dialer{}))
+}
+
+// echoCase returns case which sends frames and expects single data frame
+// with given op code and payload to be echoed.
+func echoCase(id, desc string, op easyws.OpCode, p []byte, chop int, frames ...easyws.Frame) testCase {
+	return testCase{id, desc, func(c *client) error {
+		var err error
+		if chop > 0 {
+			err = c.sendChopped(chop, frames...)
+		} else {
+			err = c.send(frames...)
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.expect(easyws.NewFrame(op, true, p)); err != nil {
+			return err
+		}
+		return c.closeNormal()
+	}}
+}
+
+// failCase returns case which sends frames and expects server to fail the
+// connection with given code after echoing messages in echoed.
+func failCase(id, desc string, code easyws.StatusCode, echoed []easyws.Frame, frames ...easyws.Frame) testCase {
+	return testCase{id, desc, func(c *client) error {
+		if err := c.send(frames...); err != nil {
+			return err
+		}
+		if err := c.expect(echoed...); err != nil {
+			return err
+		}
+		return c.expectClose(code)
+	}}
+}
+
+// Case family 1: framing.
+func framingCases() []testCase {
+	lengths := []int{0, 125, 126, 127, 128, 65535, 65536}
+
+	var cases []testCase
+	for i, op := range []easyws.OpCode{easyws.OpText, easyws.OpBinary} {
+		pattern := "*"
+		if op == easyws.OpBinary {
+			pattern = "\xfe"
+		}
+		for j, n := range lengths {
+			p := payload(n, pattern)
+			cases = append(cases, echoCase(
+				fmt.Sprintf("1.%d.%d", i+1, j+1),
+				fmt.Sprintf("send %s message with payload of %d bytes", op2str(op), n),
+				op, p, 0, easyws.NewFrame(op, true, p),
+			))
+		}
+		p := payload(65536, pattern)
+		cases = append(cases, echoCase(
+			fmt.Sprintf("1.%d.%d", i+1, len(lengths)+1),
+			fmt.Sprintf("send %s message with payload of 65536 bytes chopped into chunks of 997 bytes", op2str(op)),
+			op, p, 997, easyws.NewFrame(op, true, p),
+		))
+	}
+	cases = append(cases, testCase{"1.3.1", "send unmasked text message", func(c *client) error {
+		if err := c.sendRaw(easyws.NewTextFrame([]byte("Hello, world!"))); err != nil {
+			return err
+		}
+		return c.expectClose(easyws.StatusProtocolError)
+	}})
+	return cases
+}
+
+// Case family 2: pings and pongs.
+func pingCases() []testCase {
+	ping := easyws.NewPingFrame
+	pong := easyws.NewPongFrame
+	binary := []byte{0x00, 0xff, 0xfe, 0xfd, 0xfc, 0xfb, 0x00, 0xff}
+
+	cases := []testCase{
+		{"2.1", "send ping without payload", func(c *client) error {
+			if err := c.send(ping(nil)); err != nil {
+				return err
+			}
+			if err := c.expect(pong(nil)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"2.2", "send ping with small text payload", func(c *client) error {
+			p := []byte("Hello, world!")
+			if err := c.send(ping(p)); err != nil {
+				return err
+			}
+			if err := c.expect(pong(p)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"2.3", "send ping with small binary payload", func(c *client) error {
+			if err := c.send(ping(binary)); err != nil {
+				return err
+			}
+			if err := c.expect(pong(binary)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"2.4", "send ping with payload of 125 bytes", func(c *client) error {
+			p := payload(125, "\xfe")
+			if err := c.send(ping(p)); err != nil {
+				return err
+			}
+			if err := c.expect(pong(p)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		failCase("2.5", "send ping with payload of 126 bytes",
+			easyws.StatusProtocolError, nil, ping(payload(126, "\xfe")),
+		),
+		{"2.6", "send ping with payload of 125 bytes chopped into 1 byte pieces", func(c *client) error {
+			p := payload(125, "\xfe")
+			if err := c.sendChopped(1, ping(p)); err != nil {
+				return err
+			}
+			if err := c.expect(pong(p)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"2.7", "send unsolicited pong without payload", func(c *client) error {
+			if err := c.send(pong(nil)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"2.8", "send unsolicited pong with payload", func(c *client) error {
+			if err := c.send(pong([]byte("unsolicited pong payload"))); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"2.9", "send unsolicited pong with payload, then ping", func(c *client) error {
+			p := []byte("ping payload")
+			if err := c.send(pong([]byte("unsolicited pong payload")), ping(p)); err != nil {
+				return err
+			}
+			if err := c.expect(pong(p)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"2.10", "send 10 pings with payload", func(c *client) error {
+			var frames, want []easyws.Frame
+			for i := 0; i < 10; i++ {
+				p := []byte(fmt.Sprintf("payload-%d", i))
+				frames = append(frames, ping(p))
+				want = append(want, pong(p))
+			}
+			if err := c.send(frames...); err != nil {
+				return err
+			}
+			if err := c.expect(want...); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"2.11", "send 10 pings with payload chopped into 1 byte pieces", func(c *client) error {
+			var frames, want []easyws.Frame
+			for i := 0; i < 10; i++ {
+				p := []byte(fmt.Sprintf("payload-%d", i))
+				frames = append(frames, ping(p))
+				want = append(want, pong(p))
+			}
+			if err := c.sendChopped(1, frames...); err != nil {
+				return err
+			}
+			if err := c.expect(want...); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+	}
+	return cases
+}
+
+// Case family 3: reserved bits.
+func reservedBitsCases() []testCase {
+	text := []byte("Hello, world!")
+	frames := []func(p []byte) easyws.Frame{
+		easyws.NewTextFrame,
+		easyws.NewBinaryFrame,
+		easyws.NewPingFrame,
+	}
+
+	var cases []testCase
+	for rsv := byte(1); rsv <= 7; rsv++ {
+		f := frames[int(rsv-1)%len(frames)](text)
+		f.Header.Rsv = rsv
+		cases = append(cases, failCase(
+			fmt.Sprintf("3.%d", rsv),
+			fmt.Sprintf("send small text message, then %s frame with RSV = %d", op2str(f.Header.OpCode), rsv),
+			easyws.StatusProtocolError,
+			[]easyws.Frame{easyws.NewTextFrame(text)},
+			easyws.NewTextFrame(text), f,
+		))
+	}
+	return cases
+}
+
+// Case family 4: op codes.
+func opCodeCases() []testCase {
+	text := []byte("Hello, world!")
+
+	var cases []testCase
+	for i, op := range []easyws.OpCode{3, 4, 5, 6, 7} {
+		cases = append(cases, failCase(
+			fmt.Sprintf("4.1.%d", i+1),
+			fmt.Sprintf("send frame with reserved non-control op code = %d", op),
+			easyws.StatusProtocolError,
+			[]easyws.Frame{easyws.NewTextFrame(text)},
+			easyws.NewTextFrame(text), easyws.NewFrame(op, true, text),
+		))
+	}
+	for i, op := range []easyws.OpCode{11, 12, 13, 14, 15} {
+		cases = append(cases, failCase(
+			fmt.Sprintf("4.2.%d", i+1),
+			fmt.Sprintf("send frame with reserved control op code = %d", op),
+			easyws.StatusProtocolError,
+			[]easyws.Frame{easyws.NewTextFrame(text)},
+			easyws.NewTextFrame(text), easyws.NewFrame(op, true, text),
+		))
+	}
+	return cases
+}
+
+// Case family 5: fragmentation.
+func fragmentationCases() []testCase {
+	var (
+		frag1 = []byte("fragment1")
+		frag2 = []byte("fragment2")
+		whole = []byte("fragment1fragment2")
+		ping  = []byte("ping payload")
+	)
+	cases := []testCase{
+		failCase("5.1", "send ping in 2 fragments",
+			easyws.StatusProtocolError, nil,
+			easyws.NewFrame(easyws.OpPing, false, frag1),
+			easyws.NewFrame(easyws.OpContinuation, true, frag2),
+		),
+		failCase("5.2", "send pong in 2 fragments",
+			easyws.StatusProtocolError, nil,
+			easyws.NewFrame(easyws.OpPong, false, frag1),
+			easyws.NewFrame(easyws.OpContinuation, true, frag2),
+		),
+		echoCase("5.3", "send text message in 2 fragments",
+			easyws.OpText, whole, 0,
+			easyws.NewFrame(easyws.OpText, false, frag1),
+			easyws.NewFrame(easyws.OpContinuation, true, frag2),
+		),
+		echoCase("5.4", "send text message in 2 fragments chopped into 1 byte pieces",
+			easyws.OpText, whole, 1,
+			easyws.NewFrame(easyws.OpText, false, frag1),
+			easyws.NewFrame(easyws.OpContinuation, true, frag2),
+		),
+		echoCase("5.5", "send binary message in 2 fragments",
+			easyws.OpBinary, whole, 0,
+			easyws.NewFrame(easyws.OpBinary, false, frag1),
+			easyws.NewFrame(easyws.OpContinuation, true, frag2),
+		),
+		{"5.6", "send text message in 2 fragments with ping in between", func(c *client) error {
+			err := c.send(
+				easyws.NewFrame(easyws.OpText, false, frag1),
+				easyws.NewPingFrame(ping),
+				easyws.NewFrame(easyws.OpContinuation, true, frag2),
+			)
+			if err != nil {
+				return err
+			}
+			if err := c.expect(easyws.NewPongFrame(ping), easyws.NewTextFrame(whole)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"5.7", "send text message in 2 fragments with ping in between, chopped into 1 byte pieces", func(c *client) error {
+			err := c.sendChopped(1,
+				easyws.NewFrame(easyws.OpText, false, frag1),
+				easyws.NewPingFrame(ping),
+				easyws.NewFrame(easyws.OpContinuation, true, frag2),
+			)
+			if err != nil {
+				return err
+			}
+			if err := c.expect(easyws.NewPongFrame(ping), easyws.NewTextFrame(whole)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		failCase("5.8", "send unfragmented continuation frame with no message in progress",
+			easyws.StatusProtocolError, nil,
+			easyws.NewFrame(easyws.OpContinuation, true, frag1),
+		),
+		failCase("5.9", "send fragmented continuation frame with no message in progress",
+			easyws.StatusProtocolError, nil,
+			easyws.NewFrame(easyws.OpContinuation, false, frag1),
+			easyws.NewFrame(easyws.OpContinuation, true, frag2),
+		),
+		failCase("5.10", "send new text message while previous one is not finished",
+			easyws.StatusProtocolError, nil,
+			easyws.NewFrame(easyws.OpText, false, frag1),
+			easyws.NewFrame(easyws.OpText, true, frag2),
+		),
+		{"5.11", "send text message in 1 byte fragments with pings in between", func(c *client) error {
+			var frames, want []easyws.Frame
+			for i, f := range fragments(easyws.OpText, whole, 1) {
+				p := []byte(fmt.Sprintf("%d", i))
+				frames = append(frames, f, easyws.NewPingFrame(p))
+				want = append(want, easyws.NewPongFrame(p))
+			}
+			// Last ping is sent after the message is complete.
+			want = append(want[:len(want)-1], easyws.NewTextFrame(whole), want[len(want)-1])
+			if err := c.send(frames...); err != nil {
+				return err
+			}
+			if err := c.expect(want...); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		echoCase("5.12", "send text message in 2 fragments, first fragment is empty",
+			easyws.OpText, frag2, 0,
+			easyws.NewFrame(easyws.OpText, false, nil),
+			easyws.NewFrame(easyws.OpContinuation, true, frag2),
+		),
+		echoCase("5.13", "send text message in 3 fragments, all fragments are empty",
+			easyws.OpText, nil, 0,
+			easyws.NewFrame(easyws.OpText, false, nil),
+			easyws.NewFrame(easyws.OpContinuation, false, nil),
+			easyws.NewFrame(easyws.OpContinuation, true, nil),
+		),
+	}
+	return cases
+}
+
+// Case family 6: UTF-8 handling.
+func utf8Cases() []testCase {
+	valid := []string{
+		"",
+		"Hello-µ@ßöäüàá-UTF-8!!",
+		"κόσμε",
+		"\u0080",
+		"\u07ff",
+		"\u0800",
+		"\uffff",
+		"\U00010000",
+		"\U0010ffff",
+		"\ufeff",
+	}
+	invalid := []string{
+		"\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80\x65\x64\x69\x74\x65\x64",
+		"\x80",
+		"\xbf",
+		"\xc0\xaf",
+		"\xe0\x80\xaf",
+		"\xf0\x80\x80\xaf",
+		"\xed\xa0\x80",
+		"\xed\xbf\xbf",
+		"\xf4\x90\x80\x80",
+		"\xfe",
+		"\xff",
+		"\xce\xba\xe1\xbd",
+	}
+
+	var cases []testCase
+	for i, s := range valid {
+		p := []byte(s)
+		cases = append(cases,
+			echoCase(
+				fmt.Sprintf("6.1.%d", i+1),
+				fmt.Sprintf("send valid UTF-8 text message %q", s),
+				easyws.OpText, p, 0, easyws.NewTextFrame(p),
+			),
+			echoCase(
+				fmt.Sprintf("6.2.%d", i+1),
+				fmt.Sprintf("send valid UTF-8 text message %q in 1 byte fragments", s),
+				easyws.OpText, p, 0, fragments(easyws.OpText, p, 1)...,
+			),
+		)
+	}
+	for i, s := range invalid {
+		p := []byte(s)
+		cases = append(cases,
+			failCase(
+				fmt.Sprintf("6.3.%d", i+1),
+				fmt.Sprintf("send invalid UTF-8 text message %q", s),
+				easyws.StatusInvalidFramePayloadData, nil,
+				easyws.NewTextFrame(p),
+			),
+			failCase(
+				fmt.Sprintf("6.4.%d", i+1),
+				fmt.Sprintf("send invalid UTF-8 text message %q in 1 byte fragments", s),
+				easyws.StatusInvalidFramePayloadData, nil,
+				fragments(easyws.OpText, p, 1)...,
+			),
+		)
+	}
+	return cases
+}
+
+// Case family 7: close handling.
+func closeCases() []testCase {
+	text := []byte("Hello, world!")
+
+	cases := []testCase{
+		{"7.1.1", "send text message, then close", func(c *client) error {
+			if err := c.send(easyws.NewTextFrame(text)); err != nil {
+				return err
+			}
+			if err := c.expect(easyws.NewTextFrame(text)); err != nil {
+				return err
+			}
+			return c.closeNormal()
+		}},
+		{"7.1.2", "send two close frames", func(c *client) error {
+			err := c.send(
+				closeFrame(easyws.StatusNormalClosure, ""),
+				closeFrame(easyws.StatusNormalClosure, ""),
+			)
+			if err != nil {
+				return err
+			}
+			return c.expectClose(easyws.StatusNormalClosure)
+		}},
+		{"7.1.3", "send close, then ping", func(c *client) error {
+			err := c.send(
+				closeFrame(easyws.StatusNormalClosure, ""),
+				easyws.NewPingFrame(text),
+			)
+			if err != nil {
+				return err
+			}
+			return c.expectClose(easyws.StatusNormalClosure)
+		}},
+		{"7.1.4", "send close, then text message", func(c *client) error {
+			err := c.send(
+				closeFrame(easyws.StatusNormalClosure, ""),
+				easyws.NewTextFrame(text),
+			)
+			if err != nil {
+				return err
+			}
+			return c.expectClose(easyws.StatusNormalClosure)
+		}},
+		{"7.1.5", "send message fragment, then close", func(c *client) error {
+			err := c.send(
+				easyws.NewFrame(easyws.OpText, false, text),
+				closeFrame(easyws.StatusNormalClosure, ""),
+			)
+			if err != nil {
+				return err
+			}
+			return c.expectClose(easyws.StatusNormalClosure)
+		}},
+		{"7.3.1", "send close with empty payload", func(c *client) error {
+			if err := c.send(easyws.NewCloseFrame(nil)); err != nil {
+				return err
+			}
+			return c.expectClose(0, easyws.StatusNormalClosure)
+		}},
+		failCase("7.3.2", "send close with payload of 1 byte",
+			easyws.StatusProtocolError, nil,
+			easyws.NewCloseFrame([]byte{'a'}),
+		),
+		{"7.3.3", "send close with status code 1000 and reason", func(c *client) error {
+			if err := c.send(closeFrame(easyws.StatusNormalClosure, "Hello World!")); err != nil {
+				return err
+			}
+			return c.expectClose(easyws.StatusNormalClosure)
+		}},
+		{"7.3.4", "send close with status code 1000 and reason of 123 bytes", func(c *client) error {
+			if err := c.send(closeFrame(easyws.StatusNormalClosure, string(payload(123, "*")))); err != nil {
+				return err
+			}
+			return c.expectClose(easyws.StatusNormalClosure)
+		}},
+		failCase("7.3.5", "send close with status code 1000 and reason of 124 bytes",
+			easyws.StatusProtocolError, nil,
+			easyws.NewCloseFrame(append([]byte{0x03, 0xe8}, payload(124, "*")...)),
+		),
+		failCase("7.5.1", "send close with invalid UTF-8 reason",
+			easyws.StatusInvalidFramePayloadData, nil,
+			easyws.NewCloseFrame(append([]byte{0x03, 0xe8}, "\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80\x65\x64\x69\x74\x65\x64"...)),
+		),
+	}
+
+	valid := []easyws.StatusCode{1000, 1001, 1002, 1003, 1007, 1008, 1009, 1010, 1011, 3000, 3999, 4000, 4999}
+	for i, code := range valid {
+		code := code
+		cases = append(cases, testCase{
+			fmt.Sprintf("7.7.%d", i+1),
+			fmt.Sprintf("send close with valid status code %d", code),
+			func(c *client) error {
+				if err := c.send(closeFrame(code, "")); err != nil {
+					return err
+				}
+				return c.expectClose(code)
+			},
+		})
+	}
+
+	invalid := []easyws.StatusCode{0, 999, 1004, 1005, 1006, 1016, 1100, 2000, 2999, 5000, 65535}
+	for i, code := range invalid {
+		cases = append(cases, failCase(
+			fmt.Sprintf("7.9.%d", i+1),
+			fmt.Sprintf("send close with invalid status code %d", code),
+			easyws.StatusProtocolError, nil,
+			closeFrame(code, ""),
+		))
+	}
+	return cases
+}
+
+// Case family 9: limits.
+func limitsCases() []testCase {
+	sizes := []int{64 << 10, 256 << 10, 1 << 20, 4 << 20}
+	chunks := []int{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10}
+
+	var cases []testCase
+	for i, op := range []easyws.OpCode{easyws.OpText, easyws.OpBinary} {
+		pattern := "BAsd7&jh23"
+		if op == easyws.OpBinary {
+			pattern = "\xfe"
+		}
+		for j, n := range sizes {
+			p := payload(n, pattern)
+			cases = append(cases, echoCase(
+				fmt.Sprintf("9.%d.%d", i+1, j+1),
+				fmt.Sprintf("send %s message with payload of %d bytes", op2str(op), n),
+				op, p, 0, easyws.NewFrame(op, true, p),
+			))
+		}
+		p := payload(1<<20, pattern)
+		for j, size := range chunks {
+			cases = append(cases, echoCase(
+				fmt.Sprintf("9.%d.%d", i+3, j+1),
+				fmt.Sprintf("send fragmented %s message of %d bytes in %d bytes fragments", op2str(op), len(p), size),
+				op, p, 0, fragments(op, p, size)...,
+			))
+		}
+	}
+	return cases
+}
+
+// Limits of the server used by tooBigCases.
+const (
+	tooBigFrameSize   = 64 << 10
+	tooBigMessageSize = 256 << 10
+)
+
+// tooBigCases returns cases of the limits family which check that server
+// with MaxFrameSize and MaxMessageSize set closes the connection with
+// StatusMessageTooBig.
+func tooBigCases() []testCase {
+	p := payload(tooBigMessageSize, "*")
+	return []testCase{
+		echoCase("9.7.1", "send message of the maximum size in fragments of the maximum size",
+			easyws.OpBinary, p, 0, fragments(easyws.OpBinary, p, tooBigFrameSize)...,
+		),
+		failCase("9.7.2", "send frame larger than the maximum frame size",
+			easyws.StatusMessageTooBig, nil,
+			easyws.NewBinaryFrame(payload(tooBigFrameSize+1, "*")),
+		),
+		failCase("9.7.3", "send message larger than the maximum size in fragments",
+			easyws.StatusMessageTooBig, nil,
+			fragments(easyws.OpBinary, payload(tooBigMessageSize+1, "*"), tooBigFrameSize)...,
+		),
+		{"9.7.4", "send frame header announcing huge payload", func(c *client) error {
+			f := easyws.NewBinaryFrame(nil)
+			f.Header.Length = 1 << 40
+			if err := c.send(f); err != nil {
+				return err
+			}
+			return c.expectClose(easyws.StatusMessageTooBig)
+		}},
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := startServer(t, easyws.WithUpgrader(easyws.Upgrader{
+		Protocol: func(p []byte) bool {
+			return string(p) == "chat"
+		},
+	}))
+
+	// dialError returns error of the Dialer handshake with the fuzzing
+	// server which responds with given response.
+	dialError := func(response string) error {
+		addr := fuzzingServer(t, []byte(response), nil)
+		ctx, cancel := context.WithTimeout(context.Background(), caseTimeout)
+		defer cancel()
+		conn, _, _, err := easyws.Dialer{}.Dial(ctx, "ws://"+addr+"/")
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	// receive returns case in which fuzzing server sends frames to the
+	// Dialer client, which reads them with wsutil and expects to get either
+	// "Hello, world!" message or given error.
+	receive := func(id, desc string, want error, frames ...easyws.Frame) testCase {
+		addr := fuzzingServer(t, nil, func(conn net.Conn) error {
+			for _, f := range frames {
+				if _, err := conn.Write(frameBytes(f)); err != nil {
+					return err
+				}
+			}
+			// Wait for the client to hang up.
+			_, err := conn.Read(make([]byte, 1))
+			return err
+		})
+		return testCase{id, desc, func(*client) error {
+			c := dialer(addr, easyws.Dialer{})(t)
+			defer c.conn.Close()
+
+			msg, _, err := wsutil.ReadServerData(c)
+			switch {
+			case want != nil && err != want:
+				return fmt.Errorf("client error is %v; want %v", err, want)
+			case want == nil && err != nil:
+				return err
+			case want == nil && string(msg) != "Hello, world!":
+				return fmt.Errorf("unexpected message %q", msg)
+			}
+			return nil
+		}}
+	}
+
+	cases := []testCase{
+		{"C.1.1", "negotiate subprotocol with the server", func(*client) error {
+			c := dialer(server, easyws.Dialer{Protocols: []string{"superchat", "chat"}})(t)
+			defer c.conn.Close()
+			if c.hs.Protocol != "chat" {
+				return fmt.Errorf("negotiated protocol %q; want %q", c.hs.Protocol, "chat")
+			}
+			return c.closeNormal()
+		}},
+		{"C.1.2", "reject response with bad Sec-WebSocket-Accept", func(*client) error {
+			err := dialError("HTTP/1.1 101 Switching Protocols\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n",
+			)
+			if err != easyws.ErrHandshakeBadSecAccept {
+				return fmt.Errorf("dial error is %v; want %v", err, easyws.ErrHandshakeBadSecAccept)
+			}
+			return nil
+		}},
+		{"C.1.3", "reject non-101 response", func(*client) error {
+			err := dialError("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n")
+			if err != easyws.StatusError(403) {
+				return fmt.Errorf("dial error is %v; want %v", err, easyws.StatusError(403))
+			}
+			return nil
+		}},
+		{"C.1.4", "reject subprotocol which was not requested", func(*client) error {
+			err := dialError("HTTP/1.1 101 Switching Protocols\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Protocol: chat\r\n\r\n",
+			)
+			if err != easyws.ErrHandshakeBadSubProtocol {
+				return fmt.Errorf("dial error is %v; want %v", err, easyws.ErrHandshakeBadSubProtocol)
+			}
+			return nil
+		}},
+		receive("C.2.1", "receive unmasked text message", nil,
+			easyws.NewTextFrame([]byte("Hello, world!")),
+		),
+		receive("C.2.2", "receive text message in 3 fragments with ping in between", nil,
+			easyws.NewFrame(easyws.OpText, false, []byte("Hello")),
+			easyws.NewFrame(easyws.OpContinuation, false, []byte(", ")),
+			easyws.NewPingFrame(nil),
+			easyws.NewFrame(easyws.OpContinuation, true, []byte("world!")),
+		),
+		receive("C.2.3", "detect masked frame from server", easyws.ErrProtocolMaskUnexpected,
+			easyws.MaskFrame(easyws.NewTextFrame([]byte("Hello, world!"))),
+		),
+		receive("C.2.4", "detect non-zero RSV bits", easyws.ErrProtocolNonZeroRsv,
+			easyws.Frame{
+				Header:  easyws.Header{Fin: true, Rsv: 1, OpCode: easyws.OpText, Length: 13},
+				Payload: []byte("Hello, world!"),
+			},
+		),
+		receive("C.2.5", "detect reserved op code", easyws.ErrProtocolOpCodeReserved,
+			easyws.NewFrame(5, true, []byte("Hello, world!")),
+		),
+		receive("C.2.6", "detect unexpected continuation frame", easyws.ErrProtocolContinuationUnexpected,
+			easyws.NewFrame(easyws.OpContinuation, true, []byte("Hello, world!")),
+		),
+		receive("C.2.7", "detect control frame overflow", easyws.ErrProtocolControlPayloadOverflow,
+			easyws.NewPingFrame(payload(126, "*")),
+		),
+		receive("C.2.8", "receive close frame", easyws.ClosedError{Code: easyws.StatusGoingAway, Reason: "bye"},
+			closeFrame(easyws.StatusGoingAway, "bye"),
+		),
+	}
+	runCases(t, cases, nil)
+}
+
+func op2str(op easyws.OpCode) string {
+	switch op {
+	case easyws.OpText:
+		return "text"
+	case easyws.OpBinary:
+		return "binary"
+	case easyws.OpPing:
+		return "ping"
+	case easyws.OpPong:
+		return "pong"
+	case easyws.OpClose:
+		return "close"
+	case easyws.OpContinuation:
+		return "continuation"
+	default:
+		return fmt.Sprintf("%#x", byte(op))
+	}
+}
@@ -0,0 +1,13 @@
+// Package autobahn contains an offline conformance test suite modeled after
+// the Autobahn TestSuite (https://github.com/crossbario/autobahn-testsuite).
+//
+// The suite reproduces the core Autobahn case families – framing, pings and
+// pongs, reserved bits, op codes, fragmentation, UTF-8 handling, close
+// handling and limits – and runs them against an EasyWs echo server and the
+// Dialer client on loopback. It needs neither Python nor network access, so
+// it is run as a part of the regular test suite:
+//
+//	go test ./autobahn -v
+//
+// Pass -autobahn.report=path to additionally write per case results as JSON.
+package autobahn
@@ -0,0 +1,498 @@
+package autobahn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easynet/base"
+	"github.com/EternalVow/easyws"
+)
+
+var report = flag.String("autobahn.report", "", "write per case results as JSON into given file")
+
+// caseTimeout limits the time of a single case.
+const caseTimeout = 10 * time.Second
+
+// result describes the outcome of a single case.
+type result struct {
+	ID          string        `json:"id"`
+	Description string        `json:"description"`
+	Behavior    string        `json:"behavior"`
+	Reason      string        `json:"reason,omitempty"`
+	Duration    time.Duration `json:"duration"`
+}
+
+var results struct {
+	sync.Mutex
+	list []result
+}
+
+func record(r result) {
+	results.Lock()
+	results.list = append(results.list, r)
+	results.Unlock()
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+
+	results.Lock()
+	list := results.list
+	results.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return lessID(list[i].ID, list[j].ID)
+	})
+
+	if testing.Verbose() {
+		var failed int
+		for _, r := range list {
+			if r.Behavior != "OK" {
+				failed++
+			}
+			fmt.Printf("%-10s %-6s %s\n", r.ID, r.Behavior, r.Description)
+		}
+		fmt.Printf("autobahn: %d cases, %d failed\n", len(list), failed)
+	}
+	if path := *report; path != "" {
+		bts, err := json.MarshalIndent(list, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, bts, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "autobahn: write report: %v\n", err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// lessID compares dotted case identifiers such as "2.10" and "2.9" treating
+// numeric parts as numbers.
+func lessID(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		if aerr == nil && berr == nil {
+			return an < bn
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
+
+// testCase is a single conformance check.
+type testCase struct {
+	id   string
+	desc string
+	run  func(*client) error
+}
+
+// runCases runs every case as a subtest using dial to establish client
+// connection. If dial is nil, cases are called with nil client.
+func runCases(t *testing.T, cases []testCase, dial func(t *testing.T) *client) {
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.id, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Now()
+			var c *client
+			if dial != nil {
+				c = dial(t)
+				defer c.conn.Close()
+			}
+
+			err := tc.run(c)
+			r := result{
+				ID:          tc.id,
+				Description: tc.desc,
+				Behavior:    "OK",
+				Duration:    time.Since(start),
+			}
+			if err != nil {
+				r.Behavior = "FAILED"
+				r.Reason = err.Error()
+				t.Errorf("case %s (%s): %v", tc.id, tc.desc, err)
+			}
+			record(r)
+		})
+	}
+}
+
+// echo is an IEasyWs which sends every received message back with the same
+// op code.
+type echo struct{}
+
+func (echo) OnStart() (easyws.OpCode, error)    { return easyws.OpContinuation, nil }
+func (echo) OnConnect() (easyws.OpCode, error)  { return easyws.OpContinuation, nil }
+func (echo) OnUpgraded() (easyws.OpCode, error) { return easyws.OpContinuation, nil }
+func (echo) OnShutdown() (easyws.OpCode, error) { return easyws.OpContinuation, nil }
+
+func (echo) OnClose(error) (easyws.OpCode, error) { return easyws.OpContinuation, nil }
+
+func (echo) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return msg, easyws.OpText, nil
+}
+
+func (echo) OnMessage(_ *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	return msg, op, nil
+}
+
+// connection adapts net.Conn to the easynet IConnection interface.
+type connection struct {
+	conn net.Conn
+}
+
+func (c connection) RemoteAddr() string         { return c.conn.RemoteAddr().String() }
+func (c connection) Send(p []byte) (int, error) { return c.conn.Write(p) }
+func (c connection) Close() error               { return c.conn.Close() }
+
+// startServer starts EasyWs echo server on loopback. It drives NetHandler
+// the same way easynet "Net" plugin does, but is bound to an ephemeral port
+// and is stopped when test finishes.
+func startServer(t *testing.T, options ...easyws.ServerOption) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	h := easyws.NewNetHandler(echo{}, options...)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(h, conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveConn(h *easyws.NetHandler, conn net.Conn) {
+	var (
+		c      = connection{conn}
+		stream = &base.InputStream{}
+		err    error
+	)
+	defer func() {
+		conn.Close()
+		h.OnClose(c, err)
+	}()
+	if err = h.OnConnect(c); err != nil {
+		return
+	}
+	buf := make([]byte, 4096)
+	for {
+		var n int
+		if n, err = conn.Read(buf); err != nil {
+			return
+		}
+		stream.Begin(buf[:n])
+
+		var out []byte
+		out, err = h.OnReceive(c, stream)
+		if len(out) > 0 {
+			if _, err := conn.Write(out); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// client is the client side of a case.
+type client struct {
+	conn net.Conn
+	r    io.Reader
+	hs   easyws.Handshake
+}
+
+// Read implements io.Reader. It reads from the buffered handshake reader if
+// server sent frames right after the response.
+func (c *client) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// Write implements io.Writer.
+func (c *client) Write(p []byte) (int, error) { return c.conn.Write(p) }
+
+// dialer returns a function that connects to the server at addr with d.
+func dialer(addr string, d easyws.Dialer) func(*testing.T) *client {
+	return func(t *testing.T) *client {
+		ctx, cancel := context.WithTimeout(context.Background(), caseTimeout)
+		defer cancel()
+
+		conn, br, hs, err := d.Dial(ctx, "ws://"+addr+"/")
+		if err != nil {
+			t.Fatalf("dial error: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(caseTimeout))
+
+		c := &client{conn: conn, r: conn, hs: hs}
+		if br != nil {
+			c.r = br
+		}
+		return c
+	}
+}
+
+// frameBytes returns binary representation of f.
+func frameBytes(f easyws.Frame) []byte {
+	header, err := easyws.WriteHeader(f.Header)
+	if err != nil {
+		panic(err)
+	}
+	return append(header, f.Payload...)
+}
+
+// send masks frames and writes them to the server at once.
+func (c *client) send(frames ...easyws.Frame) error {
+	var buf bytes.Buffer
+	for _, f := range frames {
+		buf.Write(frameBytes(easyws.MaskFrame(f)))
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// sendChopped is like send but writes frames by chunks of given size with
+// short pauses between them.
+func (c *client) sendChopped(chunk int, frames ...easyws.Frame) error {
+	var buf bytes.Buffer
+	for _, f := range frames {
+		buf.Write(frameBytes(easyws.MaskFrame(f)))
+	}
+	p := buf.Bytes()
+	for len(p) > 0 {
+		n := chunk
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := c.conn.Write(p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+// sendRaw writes unmasked frames. It is used to check server reaction on
+// protocol violations.
+func (c *client) sendRaw(frames ...easyws.Frame) error {
+	var buf bytes.Buffer
+	for _, f := range frames {
+		buf.Write(frameBytes(f))
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// readFrame reads next frame from r.
+func readFrame(r io.Reader) (f easyws.Frame, err error) {
+	bts := make([]byte, easyws.MaxHeaderSize)
+	if _, err = io.ReadFull(r, bts[:easyws.MinHeaderSize]); err != nil {
+		return f, err
+	}
+	n := easyws.MinHeaderSize
+	if bts[1]&0x80 != 0 {
+		n += 4
+	}
+	switch bts[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if _, err = io.ReadFull(r, bts[easyws.MinHeaderSize:n]); err != nil {
+		return f, err
+	}
+
+	stream := &base.InputStream{}
+	stream.Begin(bts[:n])
+	if f.Header, err = easyws.ReadHeader(stream); err != nil {
+		return f, err
+	}
+	f.Payload = make([]byte, f.Header.Length)
+	_, err = io.ReadFull(r, f.Payload)
+	return f, err
+}
+
+// read reads next frame sent by the server. It checks that server frames are
+// valid for the client side.
+func (c *client) read() (easyws.Frame, error) {
+	f, err := readFrame(c.r)
+	if err != nil {
+		return f, err
+	}
+	if err := easyws.CheckHeader(f.Header, easyws.StateClientSide); err != nil {
+		return f, fmt.Errorf("server sent invalid frame: %w", err)
+	}
+	return f, nil
+}
+
+// expect reads frames from the server and compares them with want.
+func (c *client) expect(want ...easyws.Frame) error {
+	for i, w := range want {
+		f, err := c.read()
+		if err != nil {
+			return fmt.Errorf("reading frame #%d: %w", i, err)
+		}
+		if f.Header.OpCode != w.Header.OpCode || f.Header.Fin != w.Header.Fin {
+			return fmt.Errorf(
+				"frame #%d: got op %#x fin %t; want op %#x fin %t",
+				i, f.Header.OpCode, f.Header.Fin, w.Header.OpCode, w.Header.Fin,
+			)
+		}
+		if !bytes.Equal(f.Payload, w.Payload) {
+			return fmt.Errorf(
+				"frame #%d: unexpected payload of %d bytes; want %d bytes",
+				i, len(f.Payload), len(w.Payload),
+			)
+		}
+	}
+	return nil
+}
+
+// expectClose expects server to send close frame with one of the codes and
+// then to close TCP connection. Empty code means close frame without body.
+func (c *client) expectClose(codes ...easyws.StatusCode) error {
+	f, err := c.read()
+	if err != nil {
+		return fmt.Errorf("reading close frame: %w", err)
+	}
+	if f.Header.OpCode != easyws.OpClose {
+		return fmt.Errorf("got frame with op %#x; want close frame", f.Header.OpCode)
+	}
+	code, _ := easyws.ParseCloseFrameData(f.Payload)
+	var ok bool
+	for _, want := range codes {
+		ok = ok || code == want
+	}
+	if !ok {
+		return fmt.Errorf("got close code %d; want one of %v", code, codes)
+	}
+	return c.expectEOF()
+}
+
+// expectEOF expects server to close TCP connection without sending anything.
+func (c *client) expectEOF() error {
+	var p [1]byte
+	n, err := c.r.Read(p[:])
+	switch {
+	case n > 0:
+		return fmt.Errorf("unexpected data after close")
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		return nil
+	case err != nil:
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && !opErr.Timeout() {
+			// Connection reset.
+			return nil
+		}
+		return fmt.Errorf("waiting for connection close: %w", err)
+	}
+	return fmt.Errorf("connection is not closed")
+}
+
+// closeNormal sends normal close frame and expects it to be echoed.
+func (c *client) closeNormal() error {
+	if err := c.send(closeFrame(easyws.StatusNormalClosure, "")); err != nil {
+		return err
+	}
+	return c.expectClose(easyws.StatusNormalClosure)
+}
+
+// fuzzingServer starts a raw server on loopback which completes the
+// handshake using Upgrader and then calls script with the connection. If
+// response is non-nil, it is sent instead of the Upgrader response.
+func fuzzingServer(t *testing.T, response []byte, script func(net.Conn) error) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(caseTimeout))
+
+		br := bufio.NewReader(conn)
+		var req []byte
+		for {
+			line, err := br.ReadSlice('\n')
+			if err != nil {
+				return
+			}
+			req = append(req, line...)
+			if len(bytes.TrimSpace(line)) == 0 {
+				break
+			}
+		}
+		stream := &base.InputStream{}
+		stream.Begin(req)
+		_, resp, err := easyws.Upgrader{}.Upgrade(stream)
+		if response != nil {
+			resp = response
+		}
+		if _, werr := conn.Write(resp); werr != nil || err != nil {
+			return
+		}
+		if script != nil {
+			script(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func closeFrame(code easyws.StatusCode, reason string) easyws.Frame {
+	return easyws.NewCloseFrame(easyws.NewCloseFrameBody(code, reason))
+}
+
+// fragments splits p into frames of given size. The first frame has given op
+// code, the rest are continuation frames.
+func fragments(op easyws.OpCode, p []byte, size int) []easyws.Frame {
+	var frames []easyws.Frame
+	for {
+		n := size
+		if n > len(p) {
+			n = len(p)
+		}
+		frames = append(frames, easyws.NewFrame(op, n == len(p), p[:n]))
+		op = easyws.OpContinuation
+		p = p[n:]
+		if len(p) == 0 {
+			return frames
+		}
+	}
+}
+
+// payload returns n bytes of repeated pattern.
+func payload(n int, pattern string) []byte {
+	return bytes.Repeat([]byte(pattern), n/len(pattern)+1)[:n]
+}
@@ -0,0 +1,211 @@
+package easyws
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+func TestCheckHeader(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		h     Header
+		state State
+		err   error
+	}{
+		{
+			name:  "masked text",
+			h:     Header{Fin: true, OpCode: OpText, Masked: true},
+			state: StateServerSide,
+		},
+		{
+			name:  "unmasked from client",
+			h:     Header{Fin: true, OpCode: OpText},
+			state: StateServerSide,
+			err:   ErrProtocolMaskRequired,
+		},
+		{
+			name:  "masked from server",
+			h:     Header{Fin: true, OpCode: OpText, Masked: true},
+			state: StateClientSide,
+			err:   ErrProtocolMaskUnexpected,
+		},
+		{
+			name:  "rsv without extension",
+			h:     Header{Fin: true, OpCode: OpText, Masked: true, Rsv: Rsv(true, false, false)},
+			state: StateServerSide,
+			err:   ErrProtocolNonZeroRsv,
+		},
+		{
+			name:  "rsv with extension",
+			h:     Header{Fin: true, OpCode: OpText, Masked: true, Rsv: Rsv(true, false, false)},
+			state: StateServerSide | StateExtended,
+		},
+		{
+			name:  "reserved op code",
+			h:     Header{Fin: true, OpCode: 0xb, Masked: true},
+			state: StateServerSide,
+			err:   ErrProtocolOpCodeReserved,
+		},
+		{
+			name:  "fragmented control",
+			h:     Header{OpCode: OpPing, Masked: true},
+			state: StateServerSide,
+			err:   ErrProtocolControlNotFinal,
+		},
+		{
+			name:  "control overflow",
+			h:     Header{Fin: true, OpCode: OpPing, Masked: true, Length: MaxControlFramePayloadSize + 1},
+			state: StateServerSide,
+			err:   ErrProtocolControlPayloadOverflow,
+		},
+		{
+			name:  "continuation without message",
+			h:     Header{Fin: true, OpCode: OpContinuation, Masked: true},
+			state: StateServerSide,
+			err:   ErrProtocolContinuationUnexpected,
+		},
+		{
+			name:  "data frame inside message",
+			h:     Header{Fin: true, OpCode: OpText, Masked: true},
+			state: StateServerSide | StateFragmented,
+			err:   ErrProtocolContinuationExpected,
+		},
+		{
+			name:  "control frame inside message",
+			h:     Header{Fin: true, OpCode: OpPing, Masked: true},
+			state: StateServerSide | StateFragmented,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := CheckHeader(test.h, test.state); err != test.err {
+				t.Errorf("CheckHeader() = %v; want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestCheckCloseFramePayload(t *testing.T) {
+	body := func(code StatusCode, reason string) []byte {
+		return NewCloseFrameBody(code, reason)
+	}
+	for _, test := range []struct {
+		name string
+		p    []byte
+		err  error
+	}{
+		{"empty", nil, nil},
+		{"one byte", []byte{0x03}, ErrProtocolCloseBodyTooShort},
+		{"normal", body(StatusNormalClosure, "bye"), nil},
+		{"not in use", body(999, ""), ErrProtocolStatusCodeNotInUse},
+		{"no meaning yet", body(StatusNoMeaningYet, ""), ErrProtocolStatusCodeNoMeaning},
+		{"no status", body(StatusNoStatusRcvd, ""), ErrProtocolStatusCodeApplicationLevel},
+		{"abnormal", body(StatusAbnormalClosure, ""), ErrProtocolStatusCodeApplicationLevel},
+		{"tls", body(StatusTLSHandshake, ""), ErrProtocolStatusCodeApplicationLevel},
+		{"protocol undefined", body(1016, ""), ErrProtocolStatusCodeUnknown},
+		{"application", body(3000, ""), nil},
+		{"private max", body(4999, ""), nil},
+		{"above private", body(5000, ""), ErrProtocolStatusCodeUnknown},
+		{"max", body(65535, ""), ErrProtocolStatusCodeUnknown},
+		{"invalid utf8", body(StatusNormalClosure, "\xff"), ErrProtocolInvalidUTF8},
+		{"truncated utf8", body(StatusNormalClosure, "\xd1"), ErrProtocolInvalidUTF8},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkCloseFramePayload(test.p); err != test.err {
+				t.Errorf("checkCloseFramePayload(%x) = %v; want %v", test.p, err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidator(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		lenient bool
+		frame   []byte
+		err     error
+		// code is the status of the close frame sent in reply. Zero means
+		// that connection must stay open.
+		code StatusCode
+	}{
+		{
+			name:  "reserved op code",
+			frame: maskedFrame(0xb, []byte("x")),
+			err:   ErrProtocolOpCodeReserved,
+			code:  StatusProtocolError,
+		},
+		{
+			name:    "lenient reserved op code",
+			lenient: true,
+			frame:   maskedFrame(0xb, []byte("x")),
+			err:     ErrProtocolOpCodeReserved,
+		},
+		{
+			name:  "short close body",
+			frame: maskedFrame(OpClose, []byte{0x03}),
+			err:   ErrProtocolCloseBodyTooShort,
+			code:  StatusProtocolError,
+		},
+		{
+			name:  "invalid utf8",
+			frame: maskedFrame(OpText, []byte{0xff}),
+			err:   ErrProtocolTextInvalidUTF8,
+			code:  StatusInvalidFramePayloadData,
+		},
+		{
+			name:  "valid",
+			frame: maskedFrame(OpText, []byte("x")),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var errs []error
+			h := NewNetHandler(&connHandler{}, WithValidator(Validator{
+				Lenient: test.lenient,
+				OnProtocolError: func(_ *Conn, _ Header, err error) {
+					errs = append(errs, err)
+				},
+			}))
+			conn := &recordConn{addr: "192.0.2.1:1"}
+			h.OnConnect(conn)
+			stream := &base.InputStream{}
+			in := append([]byte(handshakeRequest), test.frame...)
+			stream.Begin(append(in, maskedFrame(OpText, []byte("hello"))...))
+			out, _ := h.OnReceive(conn, stream)
+			sent, closed := conn.take()
+			sent = append(sent, out...)
+
+			if test.err == nil && len(errs) != 0 || test.err != nil && (len(errs) != 1 || errs[0] != test.err) {
+				t.Fatalf("OnProtocolError() is called with %v; want %v", errs, test.err)
+			}
+			if closed != (test.code != 0) {
+				t.Fatalf("connection closed is %t; want %t", closed, test.code != 0)
+			}
+			br := bufio.NewReader(bytes.NewReader(sent))
+			if _, err := http.ReadResponse(br, nil); err != nil {
+				t.Fatal(err)
+			}
+			f, err := ReadFrame(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.code != 0 {
+				if code, reason := ParseCloseFrameData(f.Payload); f.Header.OpCode != OpClose || code != test.code || reason != test.err.Error() {
+					t.Fatalf("unexpected frame: %v %d %q; want close %d", f.Header.OpCode, code, reason, test.code)
+				}
+				return
+			}
+			if f.Header.OpCode == OpText && string(f.Payload) == "x" {
+				f, err = ReadFrame(br)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if f.Header.OpCode != OpText || string(f.Payload) != "hello" {
+				t.Fatalf("unexpected frame: %v %q; want text hello", f.Header.OpCode, f.Payload)
+			}
+		})
+	}
+}
@@ -12,6 +12,16 @@ import (
 // direction of the translation, e.g., the same steps are applied to
 // mask the data as to unmask the data.
 func Cipher(payload []byte, mask [4]byte, offset int) {
+	if len(payload) < 8 {
+		cipherGeneric(payload, mask, offset)
+		return
+	}
+	cipher(payload, mask, offset)
+}
+
+// cipherGeneric is a pure Go implementation of Cipher. It processes payload
+// by 8 bytes in each iteration.
+func cipherGeneric(payload []byte, mask [4]byte, offset int) {
 	n := len(payload)
 	if n < 8 {
 		for i := 0; i < n; i++ {
@@ -0,0 +1,30 @@
+//go:build !purego
+
+package easyws
+
+import "golang.org/x/sys/cpu"
+
+var useAVX2 = cpu.X86.HasAVX2
+
+func cipher(payload []byte, mask [4]byte, offset int) {
+	key := cipherKey(mask, offset)
+	if useAVX2 && len(payload) >= 32 {
+		// Chunks of 32 bytes keep the key aligned for the rest of payload.
+		n := len(payload) &^ 31
+		cipherAVX2(payload[:n], key)
+		payload = payload[n:]
+	}
+	cipherSSE2(payload, key)
+}
+
+// cipherAVX2 applies XOR cipher to the b using key. It processes b by 128 and
+// then by 32 bytes in each iteration. Length of b must be a multiple of 32.
+//
+//go:noescape
+func cipherAVX2(b []byte, key uint32)
+
+// cipherSSE2 applies XOR cipher to the b using key. It processes b by 16
+// bytes in each iteration and then the rest of it by 8, 4 and 1 bytes.
+//
+//go:noescape
+func cipherSSE2(b []byte, key uint32)
@@ -0,0 +1,90 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func cipherSSE2(b []byte, key uint32)
+TEXT ·cipherSSE2(SB), NOSPLIT, $0-28
+	MOVQ b_base+0(FP), SI
+	MOVQ b_len+8(FP), CX
+	MOVL key+24(FP), AX
+	MOVQ AX, DX
+	SHLQ $32, DX
+	ORQ  AX, DX
+	CMPQ CX, $16
+	JB   tail8
+	MOVQ DX, X0
+	PUNPCKLQDQ X0, X0
+
+loop16:
+	MOVOU (SI), X1
+	PXOR  X0, X1
+	MOVOU X1, (SI)
+	ADDQ  $16, SI
+	SUBQ  $16, CX
+	CMPQ  CX, $16
+	JAE   loop16
+
+tail8:
+	CMPQ CX, $8
+	JB   tail4
+	XORQ DX, (SI)
+	ADDQ $8, SI
+	SUBQ $8, CX
+
+tail4:
+	CMPQ CX, $4
+	JB   tail1
+	XORL AX, (SI)
+	ADDQ $4, SI
+	SUBQ $4, CX
+
+tail1:
+	TESTQ CX, CX
+	JZ    done
+	XORB  AL, (SI)
+	INCQ  SI
+	SHRL  $8, AX
+	DECQ  CX
+	JMP   tail1
+
+done:
+	RET
+
+// func cipherAVX2(b []byte, key uint32)
+TEXT ·cipherAVX2(SB), NOSPLIT, $0-28
+	MOVQ b_base+0(FP), SI
+	MOVQ b_len+8(FP), CX
+	MOVL key+24(FP), AX
+	MOVQ AX, DX
+	SHLQ $32, DX
+	ORQ  AX, DX
+	MOVQ DX, X0
+	VPBROADCASTQ X0, Y0
+
+loop128:
+	CMPQ    CX, $128
+	JB      loop32
+	VPXOR   (SI), Y0, Y1
+	VPXOR   32(SI), Y0, Y2
+	VPXOR   64(SI), Y0, Y3
+	VPXOR   96(SI), Y0, Y4
+	VMOVDQU Y1, (SI)
+	VMOVDQU Y2, 32(SI)
+	VMOVDQU Y3, 64(SI)
+	VMOVDQU Y4, 96(SI)
+	ADDQ    $128, SI
+	SUBQ    $128, CX
+	JMP     loop128
+
+loop32:
+	CMPQ    CX, $32
+	JB      done
+	VPXOR   (SI), Y0, Y1
+	VMOVDQU Y1, (SI)
+	ADDQ    $32, SI
+	SUBQ    $32, CX
+	JMP     loop32
+
+done:
+	VZEROUPPER
+	RET
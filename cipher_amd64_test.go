@@ -0,0 +1,11 @@
+//go:build !purego
+
+package easyws
+
+import "testing"
+
+func TestCipherSSE2(t *testing.T) {
+	defer func(v bool) { useAVX2 = v }(useAVX2)
+	useAVX2 = false
+	testCipher(t, Cipher)
+}
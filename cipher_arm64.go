@@ -0,0 +1,21 @@
+//go:build !purego
+
+package easyws
+
+import "golang.org/x/sys/cpu"
+
+var useNEON = cpu.ARM64.HasASIMD
+
+func cipher(payload []byte, mask [4]byte, offset int) {
+	if !useNEON {
+		cipherGeneric(payload, mask, offset)
+		return
+	}
+	cipherNEON(payload, cipherKey(mask, offset))
+}
+
+// cipherNEON applies XOR cipher to the b using key. It processes b by 64 and
+// then by 16 bytes in each iteration.
+//
+//go:noescape
+func cipherNEON(b []byte, key uint32)
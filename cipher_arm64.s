@@ -0,0 +1,60 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func cipherNEON(b []byte, key uint32)
+TEXT ·cipherNEON(SB), NOSPLIT, $0-28
+	MOVD  b_base+0(FP), R0
+	MOVD  b_len+8(FP), R1
+	MOVWU key+24(FP), R2
+	ORR   R2<<32, R2, R3
+	VDUP  R3, V0.D2
+
+loop64:
+	CMP  $64, R1
+	BLT  loop16
+	VLD1 (R0), [V1.B16, V2.B16, V3.B16, V4.B16]
+	VEOR V0.B16, V1.B16, V1.B16
+	VEOR V0.B16, V2.B16, V2.B16
+	VEOR V0.B16, V3.B16, V3.B16
+	VEOR V0.B16, V4.B16, V4.B16
+	VST1.P [V1.B16, V2.B16, V3.B16, V4.B16], 64(R0)
+	SUB  $64, R1
+	B    loop64
+
+loop16:
+	CMP    $16, R1
+	BLT    tail8
+	VLD1   (R0), [V1.B16]
+	VEOR   V0.B16, V1.B16, V1.B16
+	VST1.P [V1.B16], 16(R0)
+	SUB    $16, R1
+	B      loop16
+
+tail8:
+	CMP    $8, R1
+	BLT    tail4
+	MOVD   (R0), R4
+	EOR    R3, R4
+	MOVD.P R4, 8(R0)
+	SUB    $8, R1
+
+tail4:
+	CMP    $4, R1
+	BLT    tail1
+	MOVWU  (R0), R4
+	EORW   R2, R4
+	MOVW.P R4, 4(R0)
+	SUB    $4, R1
+
+tail1:
+	CBZ    R1, done
+	MOVBU  (R0), R4
+	EORW   R2, R4
+	MOVB.P R4, 1(R0)
+	LSRW   $8, R2
+	SUB    $1, R1
+	B      tail1
+
+done:
+	RET
@@ -0,0 +1,38 @@
+//go:build !purego
+
+package easyws
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCipherNEON(t *testing.T) {
+	if !useNEON {
+		t.Skip("NEON is not supported")
+	}
+	// Compare against cipherGeneric, bypassing the dispatch of Cipher.
+	rnd := rand.New(rand.NewSource(42))
+	src := make([]byte, 1024)
+	rnd.Read(src)
+	for _, mask := range [][4]byte{{0x11, 0x22, 0x44, 0x88}, {0xff, 0, 0x7f, 0x80}} {
+		for n := 0; n <= 300; n++ {
+			for offset := 0; offset < 8; offset++ {
+				for _, start := range []int{0, 1, 3, 7} {
+					exp := append([]byte(nil), src...)
+					act := append([]byte(nil), src...)
+					cipherGeneric(exp[start:start+n], mask, offset)
+					cipherNEON(act[start:start+n], cipherKey(mask, offset))
+					if !bytes.Equal(act, exp) {
+						t.Fatalf(
+							"unexpected result for mask=%x n=%d offset=%d start=%d:\nact: %x\nexp: %x",
+							mask, n, offset, start, act, exp,
+						)
+					}
+				}
+			}
+		}
+	}
+	t.Run("Cipher", func(t *testing.T) { testCipher(t, Cipher) })
+}
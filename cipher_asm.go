@@ -0,0 +1,14 @@
+//go:build !purego && (amd64 || arm64)
+
+package easyws
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// cipherKey returns mask as little endian 32-bit key rotated such that its
+// first byte is the mask byte used at given offset.
+func cipherKey(mask [4]byte, offset int) uint32 {
+	return bits.RotateLeft32(binary.LittleEndian.Uint32(mask[:]), -8*(offset&3))
+}
@@ -0,0 +1,7 @@
+//go:build purego || !(amd64 || arm64)
+
+package easyws
+
+func cipher(payload []byte, mask [4]byte, offset int) {
+	cipherGeneric(payload, mask, offset)
+}
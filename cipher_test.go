@@ -0,0 +1,67 @@
+package easyws
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func cipherNaive(p []byte, mask [4]byte, offset int) {
+	for i := range p {
+		p[i] ^= mask[(offset+i)%4]
+	}
+}
+
+func testCipher(t *testing.T, cipher func([]byte, [4]byte, int)) {
+	rnd := rand.New(rand.NewSource(42))
+	mask := [4]byte{0x11, 0x22, 0x44, 0x88}
+	src := make([]byte, 1024)
+	rnd.Read(src)
+	for n := 0; n <= len(src); n++ {
+		for offset := 0; offset < 8; offset++ {
+			// Cipher subslices with different alignment within the buffer.
+			for _, start := range []int{0, 1, 3} {
+				if start+n > len(src) {
+					continue
+				}
+				exp := append([]byte(nil), src...)
+				act := append([]byte(nil), src...)
+				cipherNaive(exp[start:start+n], mask, offset)
+				cipher(act[start:start+n], mask, offset)
+				if !bytes.Equal(act, exp) {
+					t.Fatalf(
+						"unexpected result for n=%d offset=%d start=%d:\nact: %x\nexp: %x",
+						n, offset, start, act, exp,
+					)
+				}
+			}
+		}
+	}
+}
+
+func TestCipher(t *testing.T) {
+	t.Run("Cipher", func(t *testing.T) { testCipher(t, Cipher) })
+	t.Run("generic", func(t *testing.T) { testCipher(t, cipherGeneric) })
+}
+
+func BenchmarkCipher(b *testing.B) {
+	mask := [4]byte{0x11, 0x22, 0x44, 0x88}
+	for _, size := range []int{64, 512, 4 << 10, 64 << 10, 1 << 20} {
+		p := make([]byte, size)
+		for _, bench := range []struct {
+			name   string
+			cipher func([]byte, [4]byte, int)
+		}{
+			{"Cipher", Cipher},
+			{"generic", cipherGeneric},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", bench.name, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					bench.cipher(p, mask, 1)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package easyws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_interface "github.com/EternalVow/easynet/interface"
+	"github.com/EternalVow/easynet/plugin/evio"
+	"github.com/EternalVow/easynet/plugin/gnet"
+)
+
+// lastConnID is the last identifier assigned to a Conn.
+var lastConnID atomic.Uint64
+
+// ErrConnClosed is returned by Conn.WriteMessage and Conn.Close if the
+// connection is closed.
+var ErrConnClosed = errors.New("connection closed")
+
+// ErrPushUnsupported is returned by Conn.WriteMessage, Conn.Ping and
+// Conn.Close if the easynet plugin serving the connection can not write to
+// it outside of the received data callback, which is the case for "Evio".
+var ErrPushUnsupported = errors.New("easynet plugin does not support writes outside of the event loop")
+
+// Conn represents a single client connection served by NetHandler.
+//
+// It holds the handshake result and the receive state of the connection. Conn
+// methods are safe to call only from the callbacks NetHandler invokes for
+// this connection, except WriteMessage, Ping, LastFrame and Close.
+type Conn struct {
+	raw _interface.IConnection
+	// push reports whether raw could be written from any goroutine.
+	push    bool
+	metrics *Metrics
+	id      uint64
+	addr    string
+
+	// remote is the address of the client. It differs from addr if the
+	// connection is proxied.
+	remote string
+	// proxied reports whether PROXY protocol header was received.
+	proxied bool
+
+	// uri is the Request-URI of the handshake request.
+	uri string
+
+	// ctx is the connection context. It holds the trace context received
+	// within the handshake request. msgCtx is the context of the message
+	// being handled, if any.
+	ctx    context.Context
+	msgCtx context.Context
+
+	hs       Handshake
+	upgraded bool
+	closed   bool
+
+	// state is the RFC6455 state of the connection used by Validator.
+	state State
+
+	// op and message describe the data message which is being assembled
+	// from fragments. Zero op means that there is no open message.
+	op      OpCode
+	message []byte
+
+	// out is the buffer for bytes returned to easynet, reused between
+	// receives.
+	out []byte
+
+	// rmu serializes receives with the handling of the message delayed by
+	// Limiter, which may happen outside of the event loop; resuming is set
+	// then. Received data is held until the delayed message is handled.
+	rmu      sync.Mutex
+	delayed  *delayedMessage
+	held     []byte
+	resuming bool
+
+	// wmu serializes the writes made by WriteMessage with the closure of
+	// the connection. writeClosed is set once the connection is closed.
+	// While opening is set, messages are buffered in pending to be sent
+	// after the handshake response.
+	wmu         sync.Mutex
+	writeClosed bool
+	opening     bool
+	pending     []byte
+
+	// lastFrame is the time in Unix nanoseconds when the last frame was
+	// received. It is read by other goroutines.
+	lastFrame atomic.Int64
+
+	// admitted reports whether connection was admitted by Limiter.
+	// Messages and bytes are the rate limiting buckets.
+	admitted bool
+	messages tokenBucket
+	bytes    tokenBucket
+}
+
+func newConn(raw _interface.IConnection) *Conn {
+	addr := raw.RemoteAddr()
+	return &Conn{
+		raw:    raw,
+		push:   canPush(raw),
+		id:     lastConnID.Add(1),
+		ctx:    context.Background(),
+		addr:   addr,
+		remote: addr,
+		state:  StateServerSide,
+	}
+}
+
+// ID returns the identifier of the connection which is unique within the
+// process.
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
+// Context returns the context of the connection. While a message is being
+// handled, it returns the context of the message span started by Tracer.
+func (c *Conn) Context() context.Context {
+	if c.msgCtx != nil {
+		return c.msgCtx
+	}
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// RemoteAddr returns the network address of the client. If the connection
+// is served behind proxies configured by ProxyConfig, it is the address
+// reported by them; the address taken from forwarded headers may have no
+// port.
+func (c *Conn) RemoteAddr() string {
+	return c.remote
+}
+
+// PeerAddr returns the remote network address of the underlying connection,
+// which is the address of the proxy if the connection is proxied.
+func (c *Conn) PeerAddr() string {
+	return c.addr
+}
+
+// RequestURI returns the Request-URI of the handshake request. It is empty
+// until the connection is upgraded.
+func (c *Conn) RequestURI() string {
+	return c.uri
+}
+
+// Path returns the path part of the handshake Request-URI.
+func (c *Conn) Path() string {
+	if i := strings.IndexByte(c.uri, '?'); i != -1 {
+		return c.uri[:i]
+	}
+	return c.uri
+}
+
+// Handshake returns the result of the WebSocket handshake. It is zero until
+// the connection is upgraded.
+func (c *Conn) Handshake() Handshake {
+	return c.hs
+}
+
+// Identity returns the client identity returned by Upgrader.Authenticator.
+// It is nil for anonymous clients.
+func (c *Conn) Identity() *Identity {
+	return c.hs.Identity
+}
+
+// Upgraded reports whether the connection has completed the WebSocket
+// handshake.
+func (c *Conn) Upgraded() bool {
+	return c.upgraded
+}
+
+// WriteMessage sends a message with given op code and payload to the client
+// as a single frame. Unlike other methods, it is safe to call from any
+// goroutine, so it could be used to push messages to the client outside of
+// the callbacks. It returns ErrConnClosed if the connection is closed and
+// ErrPushUnsupported if easynet plugin can not write to the connection
+// outside of the event loop.
+//
+// Writes are passed to the event loop of the connection if the plugin has
+// one, so they are not ordered with the replies returned by the handler for
+// the concurrently received messages. Messages sent while IEasyWsConn.OnOpen
+// is running are sent right after the handshake response, or dropped if
+// OnOpen fails; this works with any plugin.
+func (c *Conn) WriteMessage(op OpCode, p []byte) error {
+	f := NewFrame(op, true, p)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.writeClosed {
+		return ErrConnClosed
+	}
+	if c.opening {
+		c.metrics.frameOut(f)
+		c.pending = appendFrame(c.pending, f)
+		return nil
+	}
+	if err := c.send(appendFrame(nil, f)); err != nil {
+		return err
+	}
+	c.metrics.frameOut(f)
+	return nil
+}
+
+// Ping sends ping frame with given payload to the client. Like WriteMessage,
+// it is safe to call from any goroutine. Clients answer with pong frames,
+// which update LastFrame, so Ping could be used to check that idle client
+// is alive.
+func (c *Conn) Ping(p []byte) error {
+	return c.WriteMessage(OpPing, p)
+}
+
+// LastFrame returns the time when the last frame, including control one, was
+// received from the client. Before any frame is received, it is the time the
+// connection was upgraded. It is safe to call from any goroutine.
+func (c *Conn) LastFrame() time.Time {
+	return time.Unix(0, c.lastFrame.Load())
+}
+
+// Close sends close frame with given status code and reason to the client
+// and closes the connection. Like WriteMessage, it is safe to call from any
+// goroutine and returns the same errors. If it is called from
+// IEasyWsConn.OnOpen, the connection is closed after the handshake response
+// and messages written before are sent.
+func (c *Conn) Close(code StatusCode, reason string) error {
+	f := NewCloseFrame(NewCloseFrameBody(code, reason))
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.writeClosed {
+		return ErrConnClosed
+	}
+	if c.opening {
+		c.writeClosed = true
+		c.metrics.frameOut(f)
+		c.pending = appendFrame(c.pending, f)
+		return nil
+	}
+	if err := c.send(appendFrame(nil, f)); err != nil {
+		return err
+	}
+	c.writeClosed = true
+	c.metrics.frameOut(f)
+	return c.raw.Close()
+}
+
+// open calls OnOpen of h, buffering messages written meanwhile. If OnOpen
+// succeeds, it returns the handshake response resp followed by the buffered
+// messages, which must be sent to the connection. If Close was called by
+// OnOpen, open reports true and the connection must be closed after the
+// bytes are sent.
+//
+// If the connection could be written from other goroutines, open sends the
+// bytes by itself, so that the messages pushed after OnOpen returns could
+// not outrun the handshake response. Returned bytes are empty then.
+func (c *Conn) open(h IEasyWsConn, resp []byte) (out []byte, closed bool, err error) {
+	c.wmu.Lock()
+	c.opening = true
+	c.wmu.Unlock()
+
+	err = h.OnOpen(c)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.opening = false
+	pending := c.pending
+	c.pending = nil
+	if err != nil {
+		// Connection is rejected, messages could not be sent anymore.
+		c.writeClosed = true
+		return nil, false, err
+	}
+	out = append(resp, pending...)
+	if c.writeClosed || !c.push {
+		return out, c.writeClosed, nil
+	}
+	c.raw.Send(out)
+	return nil, false, nil
+}
+
+// send sends p to the connection from any goroutine. Easynet plugins having
+// the event loop, like "Gnet", do not allow to write the connection from
+// other goroutines directly, so p is passed to the loop instead.
+func (c *Conn) send(p []byte) error {
+	switch raw := c.raw.(type) {
+	case *gnet.Connection:
+		return raw.Conn.AsyncWrite(p, nil)
+	case gnet.Connection:
+		return raw.Conn.AsyncWrite(p, nil)
+	}
+	if !c.push {
+		return ErrPushUnsupported
+	}
+	_, err := c.raw.Send(p)
+	return err
+}
+
+// canPush reports whether raw could be written by Conn.send.
+func canPush(raw _interface.IConnection) bool {
+	switch raw.(type) {
+	case *evio.Connection, evio.Connection:
+		// Evio connections could be written only by returning bytes from
+		// the data callback, Send does nothing.
+		return false
+	}
+	return true
+}
+
+// closeWrite makes subsequent calls to WriteMessage fail.
+func (c *Conn) closeWrite() {
+	c.wmu.Lock()
+	c.writeClosed = true
+	c.wmu.Unlock()
+}
+
+// Raw returns the underlying easynet connection.
+func (c *Conn) Raw() _interface.IConnection {
+	return c.raw
+}
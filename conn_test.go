@@ -0,0 +1,342 @@
+package easyws
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easynet/base"
+	"github.com/EternalVow/easynet/plugin/evio"
+	"github.com/EternalVow/easynet/plugin/gnet"
+)
+
+// recordConn is an easynet connection which records sent bytes.
+type recordConn struct {
+	addr string
+
+	mu     sync.Mutex
+	sent   []byte
+	closed bool
+}
+
+func (c *recordConn) RemoteAddr() string { return c.addr }
+
+func (c *recordConn) Send(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, p...)
+	return len(p), nil
+}
+
+func (c *recordConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// take returns bytes sent so far and reports whether connection is closed.
+func (c *recordConn) take() ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sent := c.sent
+	c.sent = nil
+	return sent, c.closed
+}
+
+// connHandler is an echo handler which tracks connections.
+type connHandler struct {
+	benchEcho
+	upgraded     error
+	open         func(*Conn) error
+	disconnected int
+}
+
+func (h *connHandler) OnUpgraded() (OpCode, error) { return 0, h.upgraded }
+
+func (h *connHandler) OnOpen(c *Conn) error {
+	if h.open != nil {
+		return h.open(c)
+	}
+	return nil
+}
+
+func (h *connHandler) OnDisconnect(*Conn, error) { h.disconnected++ }
+
+const handshakeRequest = "GET / HTTP/1.1\r\n" +
+	"Host: localhost\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: Upgrade\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"\r\n"
+
+func TestConnOpen(t *testing.T) {
+	hello := []byte("hello")
+	for _, test := range []struct {
+		name     string
+		upgraded error
+		open     func(*Conn) error
+		status   int
+		frames   []Frame
+		closed   bool
+	}{
+		{
+			name:   "ok",
+			status: http.StatusSwitchingProtocols,
+			frames: []Frame{NewTextFrame([]byte("x"))},
+		},
+		{
+			name: "write",
+			open: func(c *Conn) error {
+				return c.WriteMessage(OpText, hello)
+			},
+			status: http.StatusSwitchingProtocols,
+			frames: []Frame{NewTextFrame(hello), NewTextFrame([]byte("x"))},
+		},
+		{
+			name: "close",
+			open: func(c *Conn) error {
+				c.WriteMessage(OpText, hello)
+				if err := c.Close(StatusGoingAway, "bye"); err != nil {
+					return err
+				}
+				if err := c.WriteMessage(OpText, hello); err != ErrConnClosed {
+					return fmt.Errorf("WriteMessage() after Close() error is %v", err)
+				}
+				return nil
+			},
+			status: http.StatusSwitchingProtocols,
+			frames: []Frame{
+				NewTextFrame(hello),
+				NewCloseFrame(NewCloseFrameBody(StatusGoingAway, "bye")),
+			},
+			closed: true,
+		},
+		{
+			name: "open error",
+			open: func(c *Conn) error {
+				c.WriteMessage(OpText, hello)
+				return errors.New("no way")
+			},
+			status: http.StatusInternalServerError,
+			closed: true,
+		},
+		{
+			name: "open rejected",
+			open: func(c *Conn) error {
+				return RejectConnectionError(RejectionStatus(http.StatusForbidden))
+			},
+			status: http.StatusForbidden,
+			closed: true,
+		},
+		{
+			name:     "upgraded error",
+			upgraded: errors.New("no way"),
+			status:   http.StatusInternalServerError,
+			closed:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ch := &connHandler{upgraded: test.upgraded, open: test.open}
+			m := NewMetrics()
+			h := NewNetHandler(ch, WithMetrics(m))
+			conn := &recordConn{addr: "192.0.2.1:1"}
+			h.OnConnect(conn)
+
+			stream := &base.InputStream{}
+			stream.Begin(append([]byte(handshakeRequest), maskedFrame(OpText, []byte("x"))...))
+			out, err := h.OnReceive(conn, stream)
+			sent, closed := conn.take()
+			sent = append(sent, out...)
+			if closed != test.closed {
+				t.Fatalf("connection closed is %t; want %t", closed, test.closed)
+			}
+			if test.status != http.StatusSwitchingProtocols && err == nil {
+				t.Fatalf("OnReceive() error is nil")
+			}
+
+			br := bufio.NewReader(bytes.NewReader(sent))
+			resp, rerr := http.ReadResponse(br, nil)
+			if rerr != nil {
+				t.Fatalf("can not read response: %v\n%q", rerr, sent)
+			}
+			if resp.StatusCode != test.status {
+				t.Fatalf("response status is %d; want %d", resp.StatusCode, test.status)
+			}
+			io.Copy(io.Discard, resp.Body)
+			if test.status == http.StatusSwitchingProtocols {
+				for i, exp := range test.frames {
+					f, err := ReadFrame(br)
+					if err != nil {
+						t.Fatalf("can not read frame #%d: %v", i, err)
+					}
+					if f.Header.OpCode != exp.Header.OpCode || !bytes.Equal(f.Payload, exp.Payload) {
+						t.Fatalf("frame #%d is %v %q; want %v %q", i, f.Header.OpCode, f.Payload, exp.Header.OpCode, exp.Payload)
+					}
+				}
+			}
+			if n := br.Buffered(); n != 0 {
+				t.Fatalf("unexpected %d bytes after response", n)
+			}
+
+			h.OnClose(conn, err)
+			if exp := btoi(test.status == http.StatusSwitchingProtocols); ch.disconnected != exp {
+				t.Fatalf("OnDisconnect() called %d times; want %d", ch.disconnected, exp)
+			}
+			var buf bytes.Buffer
+			m.WriteTo(&buf)
+			if exp := fmt.Sprintf("easyws_handshakes_total %d\n", btoi(test.status == http.StatusSwitchingProtocols)); !bytes.Contains(buf.Bytes(), []byte(exp)) {
+				t.Fatalf("no %q in metrics:\n%s", exp, buf.String())
+			}
+		})
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestConnPush(t *testing.T) {
+	const (
+		pushes = 1000
+		echoes = 1000
+	)
+	for _, test := range []struct {
+		plugin string
+		run    func(port int32, h *NetHandler) error
+		err    error
+	}{
+		{
+			plugin: "Gnet",
+			run: func(port int32, h *NetHandler) error {
+				config := &gnet.YamlConfig{Protocol: "tcp", Ip: "127.0.0.1", Port: port}
+				return gnet.NewGnetEasyNetPlugin(context.Background(), config, h).Run()
+			},
+		},
+		{
+			plugin: "Evio",
+			run: func(port int32, h *NetHandler) error {
+				config := &evio.YamlConfig{Protocol: "tcp", Ip: "127.0.0.1", Port: port}
+				return evio.NewEvioEasyNetPlugin(context.Background(), config, h).Run()
+			},
+			err: ErrPushUnsupported,
+		},
+	} {
+		t.Run(test.plugin, func(t *testing.T) {
+			var (
+				errs  = make(chan error, 1)
+				close = make(chan struct{})
+			)
+			h := NewNetHandler(&connHandler{
+				open: func(c *Conn) error {
+					go func() {
+						var err error
+						for i := 0; i < pushes && err == nil; i++ {
+							p := make([]byte, 4096)
+							p[0], p[1] = byte(i>>8), byte(i)
+							err = c.WriteMessage(OpBinary, p)
+						}
+						errs <- err
+						<-close
+						errs <- c.Close(StatusNormalClosure, "")
+					}()
+					return nil
+				},
+			})
+			addr := servePlugin(t, h, test.run)
+
+			conn, br, _, err := Dialer{}.Dial(context.Background(), "ws://"+addr+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			var r io.Reader = conn
+			if br != nil {
+				r = io.MultiReader(br, conn)
+			}
+
+			// Client messages are echoed by the event loop concurrently with
+			// the pushes.
+			go func() {
+				for i := 0; i < echoes; i++ {
+					if err := WriteFrame(conn, MaskFrame(NewTextFrame([]byte("echo")))); err != nil {
+						return
+					}
+				}
+			}()
+			// Do not read until all messages are pushed, so they are
+			// buffered by the plugin along with the echoed ones.
+			if err := <-errs; err != test.err {
+				t.Fatalf("push error is %v; want %v", err, test.err)
+			}
+			var echoed, pushed int
+			for echoed < echoes || (test.err == nil && pushed < pushes) {
+				f, err := ReadFrame(r)
+				if err != nil {
+					t.Fatalf("can not read frame (echoed %d, pushed %d): %v", echoed, pushed, err)
+				}
+				switch f.Header.OpCode {
+				case OpText:
+					echoed++
+				case OpBinary:
+					if n := int(f.Payload[0])<<8 | int(f.Payload[1]); n != pushed {
+						t.Fatalf("pushed message #%d is received as #%d", n, pushed)
+					}
+					pushed++
+				default:
+					t.Fatalf("unexpected %v frame", f.Header.OpCode)
+				}
+			}
+			close <- struct{}{}
+			if err := <-errs; err != test.err {
+				t.Fatalf("Close() error is %v; want %v", err, test.err)
+			}
+			if test.err != nil {
+				return
+			}
+			f, err := ReadFrame(r)
+			if err != nil || f.Header.OpCode != OpClose {
+				t.Fatalf("no close frame: %v %v", f.Header.OpCode, err)
+			}
+		})
+	}
+}
+
+// servePlugin runs h by easynet plugin on a free port and waits for it to
+// accept connections.
+func servePlugin(t *testing.T, h *NetHandler, run func(port int32, h *NetHandler) error) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	port := int32(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	// Plugins could not be stopped, so servers live until tests exit.
+	go run(port, h)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("plugin does not accept connections: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -29,6 +29,10 @@ type Handshake struct {
 
 	// Extensions is the list of negotiated extensions.
 	Extensions []httphead.Option
+
+	// Identity is the client identity returned by Upgrader.Authenticator.
+	// It is always nil on the client side.
+	Identity *Identity
 }
 
 // Errors used by the websocket client.
@@ -289,23 +293,19 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 			headerSeenSecAccept
 	)
 
-	//br = pbufio.GetReader(conn,
-	//	nonZero(d.ReadBufferSize, DefaultClientReadBufferSize),
-	//)
-	//bw := pbufio.GetWriter(conn,
-	//	nonZero(d.WriteBufferSize, DefaultClientWriteBufferSize),
-	//)
-	//defer func() {
-	//	pbufio.PutWriter(bw)
-	//	if br.Buffered() == 0 || err != nil {
-	//		// Server does not wrote additional bytes to the connection or
-	//		// error occurred. That is, no reason to return buffer.
-	//		pbufio.PutReader(br)
-	//		br = nil
-	//	}
-	//}()
-
-	var bw *bytes.Buffer
+	br = bufio.NewReaderSize(conn,
+		nonZero(d.ReadBufferSize, DefaultClientReadBufferSize),
+	)
+	bw := bytes.NewBuffer(make([]byte, 0,
+		nonZero(d.WriteBufferSize, DefaultClientWriteBufferSize),
+	))
+	defer func() {
+		if br.Buffered() == 0 || err != nil {
+			// Server does not wrote additional bytes to the connection or
+			// error occurred. That is, no reason to return buffer.
+			br = nil
+		}
+	}()
 
 	nonce := make([]byte, nonceSize)
 	initNonce(nonce)
@@ -316,17 +316,14 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 	}
 
 	// Read HTTP status line like "HTTP/1.1 101 Switching Protocols".
-	// todo dialer
-	//sl, err := readLine(br)
+	sl, err := readLineBuffered(br)
 	if err != nil {
 		return br, hs, err
 	}
 	// Begin validation of the response.
 	// See https://tools.ietf.org/html/rfc6455#section-4.2.2
 	// Parse request line data like HTTP version, uri and method.
-	// todo fix
-	//resp, err := httpParseResponseLine(sl)
-	resp, err := httpParseResponseLine(nil)
+	resp, err := httpParseResponseLine(sl)
 	if err != nil {
 		return br, hs, err
 	}
@@ -342,9 +339,7 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 			// Invoke callback with multireader of status-line bytes br.
 			onStatusError(resp.status, resp.reason,
 				io.MultiReader(
-					// todo fix
-					//bytes.NewReader(sl),
-					bytes.NewReader(nil),
+					bytes.NewReader(sl),
 					strings.NewReader(crlf),
 					br,
 				),
@@ -358,9 +353,7 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 	// technical errors (such as parsing error) and protocol errors.
 	var headerSeen byte
 	for {
-		//line, e := readLine(br)
-		// todo fix
-		line, e := readLine(nil)
+		line, e := readLineBuffered(br)
 		if e != nil {
 			err = e
 			return br, hs, err
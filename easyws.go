@@ -6,6 +6,9 @@ import (
 	//"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/EternalVow/easynet"
 	_interface "github.com/EternalVow/easynet/interface"
@@ -15,58 +18,496 @@ import (
 type NetHandler struct {
 	IsUpgrade     map[string]bool
 	EasyWsHandler IEasyWs
+
+	// Upgrader is used to upgrade incoming connections to WebSocket.
+	Upgrader Upgrader
+
+	// Validator checks every received frame to be RFC6455 compliant.
+	Validator Validator
+
+	// MaxFrameSize is the maximum payload length of a received frame. Larger
+	// frames make the connection to be closed with StatusMessageTooBig
+	// before their payload is buffered. Zero means no limit.
+	MaxFrameSize int64
+
+	// MaxMessageSize is the maximum length of a received message, including
+	// all of its fragments. Larger messages make the connection to be closed
+	// with StatusMessageTooBig. Zero means no limit.
+	MaxMessageSize int64
+
+	// ReuseBuffers makes NetHandler to receive frames into pooled buffers,
+	// which are reused once the frame is handled. Unfragmented messages
+	// passed to IEasyWs.OnReceive and IEasyWsMessage.OnMessage are then
+	// valid only until the callback returns and must be copied to be
+	// retained. It makes echo of small messages allocation free.
+	ReuseBuffers bool
+
+	// Metrics is an optional collector of the connections statistics.
+	Metrics *Metrics
+
+	// Tracer is an optional tracer of handshakes, messages and writes.
+	Tracer Tracer
+
+	// Limiter is an optional limiter of connections and messages rate.
+	Limiter *Limiter
+
+	// IPFilter is an optional filter of connections by client IP address.
+	IPFilter *IPFilter
+
+	// Proxy describes how to find out the real client address when served
+	// behind proxies.
+	Proxy ProxyConfig
+
+	mu    sync.RWMutex
+	conns map[string]*Conn
+
+	log *logger
+}
+
+// NewNetHandler creates NetHandler which serves WebSocket connections with
+// given IEasyWs implementation.
+func NewNetHandler(easyWsHandler IEasyWs, options ...ServerOption) *NetHandler {
+	h := &NetHandler{
+		IsUpgrade:     map[string]bool{},
+		EasyWsHandler: easyWsHandler,
+		conns:         map[string]*Conn{},
+	}
+	for _, opt := range options {
+		opt(h)
+	}
+	if h.Limiter != nil && h.Metrics != nil {
+		h.Metrics.Register(h.Limiter.collect)
+	}
+	if h.IPFilter != nil && h.Metrics != nil {
+		h.Metrics.Register(h.IPFilter.collect)
+	}
+	if m, ok := h.EasyWsHandler.(*ProtocolMux); ok {
+		// Report conflicting Upgrader early; it is set up for every
+		// handshake by upgrader.
+		u := h.Upgrader
+		m.setup(&u)
+	}
+	return h
+}
+
+// upgrader returns the Upgrader of the handshake. If the handler is a
+// ProtocolMux, the returned copy of Upgrader negotiates its subprotocols.
+func (h *NetHandler) upgrader() Upgrader {
+	u := h.Upgrader
+	if m, ok := h.EasyWsHandler.(*ProtocolMux); ok {
+		m.setup(&u)
+	}
+	return u
 }
 
-func (h NetHandler) OnStart(conn _interface.IConnection) error {
+// conn returns the state of given connection, creating it if necessary.
+func (h *NetHandler) conn(conn _interface.IConnection) *Conn {
+	addr := conn.RemoteAddr()
+
+	h.mu.RLock()
+	c, ok := h.conns[addr]
+	h.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	c = newConn(conn)
+	c.metrics = h.Metrics
+	h.Metrics.connOpened()
+	h.mu.Lock()
+	if h.conns == nil {
+		h.conns = map[string]*Conn{}
+	}
+	if h.IsUpgrade == nil {
+		h.IsUpgrade = map[string]bool{}
+	}
+	h.conns[addr] = c
+	h.IsUpgrade[addr] = false
+	h.mu.Unlock()
+
+	return c
+}
+
+func (h *NetHandler) OnStart(conn _interface.IConnection) error {
 	_, err := h.EasyWsHandler.OnStart()
 	return err
 }
 
-func (h NetHandler) OnConnect(conn _interface.IConnection) error {
-	h.IsUpgrade[conn.RemoteAddr()] = false
+func (h *NetHandler) OnConnect(conn _interface.IConnection) error {
+	// Connections from proxies are checked when the client address is known.
+	if f := h.IPFilter; f != nil && !h.Proxy.proxy(conn.RemoteAddr()) && !f.allowed(conn.RemoteAddr(), ipStageConnect) {
+		conn.Close()
+		return ErrIPDenied
+	}
+	if l := h.Limiter; l != nil {
+		if !l.connect(remoteIP(conn.RemoteAddr())) {
+			conn.Close()
+			return ErrConnLimit
+		}
+		h.conn(conn).admitted = true
+	} else {
+		h.conn(conn)
+	}
 	_, err := h.EasyWsHandler.OnConnect()
 	return err
 }
 
-func (h NetHandler) OnReceive(conn _interface.IConnection, stream _interface.IInputStream) ([]byte, error) {
+func (h *NetHandler) OnReceive(conn _interface.IConnection, stream _interface.IInputStream) ([]byte, error) {
+	c := h.conn(conn)
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.closed {
+		stream.End(nil)
+		return nil, nil
+	}
+
+	// Reuse the buffer returned by the previous call. Easynet is done with it
+	// by this time.
+	out := c.out[:0]
+	if c.delayed != nil || len(c.held) > 0 {
+		data := stream.Begin(nil)
+		if d := c.delayed; d != nil && time.Now().Before(d.at) {
+			// Hold received data until the delayed message is handled.
+			c.held = append(c.held, data...)
+			stream.End(nil)
+			if len(c.held) > maxHeldSize {
+				return nil, h.fail(c, nil, StatusPolicyViolation, "rate limit exceeded")
+			}
+			return nil, nil
+		}
+		stream.End(append(c.held, data...))
+		c.held = nil
+		if c.delayed != nil {
+			// Delay is over, but the timer has not fired yet or could not
+			// be used.
+			var err error
+			if out, err = h.undelay(c, out); err != nil || c.closed {
+				return nil, err
+			}
+		}
+	}
+	out, err := h.receive(c, stream, out)
+	if cap(out) <= maxPooledSize {
+		c.out = out[:0]
+	} else {
+		c.out = nil
+	}
+	return out, err
+}
+
+// receive handles bytes received from c and appends the response to out.
+func (h *NetHandler) receive(c *Conn, stream _interface.IInputStream, out []byte) ([]byte, error) {
+	if h.Proxy.ProxyProtocol && !c.proxied {
+		ok, err := h.proxyHeader(c, stream)
+		if err != nil {
+			h.Metrics.handshake(err)
+			h.log.log(logHandshakeRejected, c, err)
+			h.close(c, nil)
+			return nil, err
+		}
+		if !ok {
+			// Wait for the rest of the header.
+			return nil, nil
+		}
+	}
 
 	// handover
-	isUpgrade, ok := h.IsUpgrade[conn.RemoteAddr()]
-	if (!ok) || (!isUpgrade) {
-		upgrader := &Upgrader{}
-		_, out, err := upgrader.Upgrade(stream)
+	if !c.upgraded {
+		data := stream.Begin(nil)
+		if !httpRequestComplete(data) {
+			stream.End(data)
+			if len(data) >= maxRequestSize {
+				return nil, h.reject(c, out, ErrMalformedRequest)
+			}
+			// Wait for the rest of the request.
+			return nil, nil
+		}
+		if len(h.Proxy.TrustedProxies) > 0 && h.Proxy.trusted(c.remote) {
+			if addr := h.Proxy.forwardedFor(data); addr != "" {
+				if err := h.setRemoteAddr(c, addr); err != nil {
+					stream.End(nil)
+					h.close(c, out)
+					return nil, err
+				}
+			}
+		}
+		if f := h.IPFilter; f != nil {
+			// Proxy did not report the client address, so check the proxy
+			// address itself.
+			unchecked := c.remote == c.addr && h.Proxy.proxy(c.addr)
+			if unchecked && !f.allowed(c.remote, ipStageProxy) ||
+				!f.allowedRoute(c.remote, data) {
+				stream.End(nil)
+				return nil, h.reject(c, out, ErrHandshakeIPDenied)
+			}
+		}
+		if l := h.Limiter; l != nil && !l.handshake(remoteIP(c.remote)) {
+			stream.End(nil)
+			return nil, h.reject(c, out, ErrHandshakeRateLimit)
+		}
+		var span Span
+		if h.Tracer != nil {
+			c.ctx, span = h.startSpan(requestTraceContext(data), SpanHandshake,
+				Field{FieldConnID, c.id},
+				Field{FieldRemoteAddr, c.remote},
+			)
+		}
+		hs, resp, err := h.upgrader().upgrade(stream, out, c)
+		endSpan(span, err)
 		if err != nil {
+			h.Metrics.handshake(err)
+			h.log.log(logHandshakeRejected, c, err)
+			// Send the error response before the connection is closed.
+			h.close(c, resp)
 			return nil, err
 		}
-		h.IsUpgrade[conn.RemoteAddr()] = true
-		_, err = h.EasyWsHandler.OnUpgraded()
+		c.hs = hs
+		c.upgraded = true
+		c.lastFrame.Store(time.Now().UnixNano())
+		if len(hs.Extensions) > 0 {
+			c.state = c.state.Set(StateExtended)
+		}
+		// Client may send frames right after the request, so proceed with
+		// the rest of the stream.
+		out = resp
+		var closed bool
+		if _, err = h.EasyWsHandler.OnUpgraded(); err == nil {
+			if ch, ok := h.EasyWsHandler.(IEasyWsConn); ok {
+				// Messages written by OnOpen are sent along with the
+				// response, so they could not be sent before it.
+				out, closed, err = c.open(ch, resp)
+			}
+		}
 		if err != nil {
+			// Handler refused the connection, so the response is not sent.
+			// OnDisconnect is not called for it.
+			c.upgraded = false
+			stream.End(nil)
+			return nil, h.reject(c, nil, err)
+		}
+		h.Metrics.handshake(nil)
+		h.log.log(logHandshake, c, nil)
+		h.mu.Lock()
+		h.IsUpgrade[c.addr] = true
+		h.mu.Unlock()
+		if closed {
+			// OnOpen closed the connection.
+			stream.End(nil)
+			h.close(c, out)
+			return nil, nil
+		}
+	}
+
+	for {
+		data := stream.Begin(nil)
+		header, n, err := parseHeader(data)
+		if err == io.ErrUnexpectedEOF {
+			// Wait for the rest of the header.
+			return out, nil
+		}
+		if err != nil {
+			return nil, h.fail(c, out, StatusProtocolError, err.Error())
+		}
+		if reason := h.tooBig(c, header); reason != "" {
+			stream.End(nil)
+			return nil, h.fail(c, out, StatusMessageTooBig, reason)
+		}
+		if int64(len(data)-n) < header.Length {
+			// Wait for the rest of the payload.
+			return out, nil
+		}
+		end := n + int(header.Length)
+		var (
+			p       *[]byte
+			payload []byte
+		)
+		if h.ReuseBuffers {
+			p = getBytes(int(header.Length))
+			payload = *p
+		} else {
+			payload = make([]byte, header.Length)
+		}
+		copy(payload, data[n:end])
+		stream.End(data[end:])
+		h.Metrics.frameIn(header)
+		c.lastFrame.Store(time.Now().UnixNano())
+
+		out, err = h.frame(c, out, header, payload)
+		if p != nil {
+			putBytes(p)
+		}
+		if err != nil || c.closed {
 			return nil, err
 		}
+		if c.delayed != nil {
+			// Hold the rest until the delayed message is handled.
+			c.held = append(c.held, stream.Begin(nil)...)
+			stream.End(nil)
+			return out, nil
+		}
+	}
+}
+
+// frame handles received frame. Payload is valid only until frame returns
+// if ReuseBuffers is set.
+func (h *NetHandler) frame(c *Conn, out []byte, header Header, payload []byte) ([]byte, error) {
+	if err := CheckHeader(header, c.state); h.Validator.report(c, header, err) {
+		return nil, h.fail(c, out, statusForError(err), err.Error())
+	}
+	if header.Masked {
+		Cipher(payload, header.Mask, 0)
+	}
+
+	switch {
+	case header.OpCode.IsReserved():
+		// Could be reached only in lenient mode.
 		return out, nil
+
+	case header.OpCode.IsControl():
+		return h.control(c, out, header, payload)
+
+	default:
+		return h.data(c, out, header, payload)
 	}
+}
 
-	var outFrame []byte
-	header, err := ReadHeader(stream)
-	if err != nil {
-		return nil, err
+// control handles received control frame.
+func (h *NetHandler) control(c *Conn, out []byte, header Header, payload []byte) ([]byte, error) {
+	switch header.OpCode {
+	case OpPing:
+		return h.appendFrame(c, out, NewPongFrame(payload)), nil
+
+	case OpPong:
+		return out, nil
+
+	default:
+		// OpClose.
+		if err := checkCloseFramePayload(payload); h.Validator.report(c, header, err) {
+			return nil, h.fail(c, out, statusForError(err), err.Error())
+		}
+		// [RFC6455]: If an endpoint receives a Close frame and did not
+		// previously send a Close frame, the endpoint MUST send a Close
+		// frame in response. When sending a Close frame in response, the
+		// endpoint typically echos the status code it received.
+		code, reason := ParseCloseFrameData(payload)
+		h.Metrics.closeIn(code)
+		var body []byte
+		if !code.Empty() {
+			body = NewCloseFrameBody(code, "")
+		}
+		h.close(c, h.appendFrame(c, out, NewCloseFrame(body)))
+		return nil, ClosedError{Code: code, Reason: reason}
 	}
-	payload := make([]byte, header.Length)
-	data := stream.Begin(nil)
-	copy(payload, data[:header.Length])
-	if int64(len(data)) > header.Length {
-		stream.End(data[header.Length:])
-	} else {
-		stream.End(nil)
+}
+
+// tooBig checks the frame with given header against MaxFrameSize and
+// MaxMessageSize. It returns non-empty close reason if any of them is
+// exceeded.
+func (h *NetHandler) tooBig(c *Conn, header Header) string {
+	if h.MaxFrameSize > 0 && header.Length > h.MaxFrameSize {
+		return "frame too large"
+	}
+	if h.MaxMessageSize <= 0 || header.OpCode.IsControl() {
+		return ""
+	}
+	size := header.Length
+	if header.OpCode == OpContinuation {
+		size += int64(len(c.message))
 	}
+	if size > h.MaxMessageSize {
+		return "message too big"
+	}
+	return ""
+}
 
-	if header.Masked {
-		Cipher(payload, header.Mask, 0)
+// data handles received data frame. It assembles fragmented messages and
+// passes completed ones to the IEasyWs.
+func (h *NetHandler) data(c *Conn, out []byte, header Header, payload []byte) ([]byte, error) {
+	var (
+		op  OpCode
+		msg []byte
+	)
+	switch {
+	case header.Fin && header.OpCode != OpContinuation:
+		// Unfragmented message; the most common case.
+		op, msg = header.OpCode, payload
+		// Could be non-zero only in lenient mode.
+		c.op, c.message = 0, nil
+
+	case header.OpCode != OpContinuation:
+		c.op, c.message = header.OpCode, append(c.message[:0], payload...)
+
+	default:
+		if c.op == 0 {
+			// Could be reached only in lenient mode.
+			c.op = OpBinary
+		}
+		c.message = append(c.message, payload...)
 	}
 
+	if !header.Fin {
+		c.state = c.state.Set(StateFragmented)
+		return out, nil
+	}
+	c.state = c.state.Clear(StateFragmented)
+	if msg == nil {
+		op, msg = c.op, c.message
+		c.op, c.message = 0, nil
+	}
+
+	if op == OpText && !utf8.Valid(msg) {
+		if err := ErrProtocolTextInvalidUTF8; h.Validator.report(c, header, err) {
+			return nil, h.fail(c, out, statusForError(err), err.Error())
+		}
+	}
+
+	if l := h.Limiter; l != nil {
+		ok, action, wait := l.message(c, len(msg))
+		if !ok {
+			if action == LimitClose {
+				return nil, h.fail(c, out, StatusPolicyViolation, "rate limit exceeded")
+			}
+			return out, nil
+		}
+		if wait > 0 {
+			h.delay(c, op, msg, wait)
+			return out, nil
+		}
+	}
+	return h.handle(c, out, op, msg)
+}
+
+// handle passes the data message to the IEasyWs and appends its reply to
+// out.
+func (h *NetHandler) handle(c *Conn, out []byte, op OpCode, msg []byte) ([]byte, error) {
 	// to do something
-	wsOutForBiz, opCode, err := h.EasyWsHandler.OnReceive(payload)
+	var (
+		wsOutForBiz []byte
+		opCode      OpCode
+		err         error
+	)
+	var span Span
+	if h.Tracer != nil {
+		c.msgCtx, span = h.startSpan(c.Context(), SpanMessage,
+			Field{FieldConnID, c.id},
+			Field{"opcode", opCodeName(op)},
+			Field{"size", len(msg)},
+		)
+		// Reply is written within the message span.
+		defer func() { c.msgCtx = nil }()
+	}
+	start := h.Metrics.now()
+	if mh, ok := h.EasyWsHandler.(IEasyWsMessage); ok {
+		wsOutForBiz, opCode, err = mh.OnMessage(c, op, msg)
+	} else {
+		wsOutForBiz, opCode, err = h.EasyWsHandler.OnReceive(msg)
+	}
+	if m := h.Metrics; m != nil {
+		m.message(len(msg), time.Since(start))
+	}
+	endSpan(span, err)
 	if err != nil {
+		h.log.log(logHandlerError, c, err)
 		return nil, err
 	}
 	var f Frame
@@ -80,43 +521,151 @@ func (h NetHandler) OnReceive(conn _interface.IConnection, stream _interface.IIn
 	case OpPong:
 		f = NewPingFrame(wsOutForBiz)
 	case OpClose:
-		f = NewCloseFrame(wsOutForBiz)
+		h.close(c, h.appendFrame(c, out, NewCloseFrame(wsOutForBiz)))
+		return nil, nil
+	default:
+		// Nothing to reply.
+		return out, nil
 	}
 
 	// Reset the Masked flag, server frames must not be masked as
 	// RFC6455 says.
 	f.Header.Masked = false
 
-	wheaderBuf, err := WriteHeader(f.Header)
+	return h.appendFrame(c, out, f), nil
+}
+
+// proxyHeader reads PROXY protocol header sent to c. It returns false if
+// the header is not received completely yet.
+func (h *NetHandler) proxyHeader(c *Conn, stream _interface.IInputStream) (bool, error) {
+	data := stream.Begin(nil)
+	addr, n, err := parseProxyHeader(data)
+	if err == io.ErrUnexpectedEOF {
+		stream.End(data)
+		return false, nil
+	}
+	if err == nil && len(h.Proxy.TrustedProxies) > 0 && !h.Proxy.trusted(c.addr) {
+		err = ErrProxyUntrusted
+	}
 	if err != nil {
-		return nil, err
+		stream.End(nil)
+		return false, err
 	}
-	outFrame = make([]byte, len(wheaderBuf)+len(f.Payload))
-	copy(outFrame[:len(wheaderBuf)], wheaderBuf)
-	copy(outFrame[len(wheaderBuf):len(wheaderBuf)+len(f.Payload)], f.Payload)
-	if f.Header.OpCode == OpClose {
-		return nil, nil
+	stream.End(data[n:])
+	c.proxied = true
+	if addr == "" {
+		return true, nil
+	}
+	return true, h.setRemoteAddr(c, addr)
+}
+
+// setRemoteAddr sets the client address of c reported by proxy, checking it
+// with IPFilter and moving the connection to the new address within Limiter.
+func (h *NetHandler) setRemoteAddr(c *Conn, addr string) error {
+	if f := h.IPFilter; f != nil && !f.allowed(addr, ipStageProxy) {
+		return ErrIPDenied
+	}
+	if c.admitted && !h.Limiter.move(remoteIP(c.remote), remoteIP(addr)) {
+		c.admitted = false
+		return ErrConnLimit
+	}
+	c.remote = addr
+	return nil
+}
+
+// reject rejects the handshake of c with err, sending the error response
+// after out. It returns err.
+func (h *NetHandler) reject(c *Conn, out []byte, err error) error {
+	h.Metrics.handshake(err)
+	h.log.log(logHandshakeRejected, c, err)
+
+	code := http.StatusInternalServerError
+	var header HandshakeHeaderFunc
+	if rej, ok := err.(*ConnectionRejectedError); ok {
+		code = rej.code
+		if rej.header != nil {
+			header = rej.header.WriteTo
+		}
+	}
+	buf := getBuffer(DefaultServerWriteBufferSize)
+	httpWriteResponseError(buf, err, code, header)
+	h.close(c, append(out, buf.Bytes()...))
+	putBuffer(buf)
+
+	return err
+}
+
+// appendFrame appends f which is sent to c to out, accounting it in metrics
+// and traces.
+func (h *NetHandler) appendFrame(c *Conn, out []byte, f Frame) []byte {
+	h.Metrics.frameOut(f)
+	if h.Tracer == nil {
+		return appendFrame(out, f)
 	}
-	return outFrame, nil
+	_, span := h.startSpan(c.Context(), SpanWrite,
+		Field{FieldConnID, c.id},
+		Field{"opcode", opCodeName(f.Header.OpCode)},
+		Field{"size", len(f.Payload)},
+	)
+	out = appendFrame(out, f)
+	endSpan(span, nil)
+	return out
+}
+
+// fail sends out followed by close frame with given code and reason and then
+// closes the connection. It returns ClosedError describing the closure.
+func (h *NetHandler) fail(c *Conn, out []byte, code StatusCode, reason string) error {
+	h.close(c, h.appendFrame(c, out, NewCloseFrame(NewCloseFrameBody(code, reason))))
+	err := ClosedError{Code: code, Reason: reason}
+	h.log.log(logProtocolError, c, err)
+	return err
+}
 
+// close sends out to the connection and closes it.
+func (h *NetHandler) close(c *Conn, out []byte) {
+	c.closed = true
+	c.wmu.Lock()
+	c.writeClosed = true
+	if !c.resuming {
+		c.raw.Send(out)
+	} else if len(out) > 0 {
+		c.send(out)
+	}
+	c.raw.Close()
+	c.wmu.Unlock()
 }
 
-func (h NetHandler) OnShutdown(conn _interface.IConnection) error {
+func (h *NetHandler) OnShutdown(conn _interface.IConnection) error {
 	_, err := h.EasyWsHandler.OnShutdown()
 	return err
 }
 
-func (h NetHandler) OnClose(conn _interface.IConnection, err error) error {
+func (h *NetHandler) OnClose(conn _interface.IConnection, err error) error {
+	addr := conn.RemoteAddr()
+	h.mu.Lock()
+	c, ok := h.conns[addr]
+	delete(h.conns, addr)
+	delete(h.IsUpgrade, addr)
+	h.mu.Unlock()
+	if ok {
+		c.closeWrite()
+		h.Metrics.connClosed()
+		h.log.log(logClosed, c, err)
+		if c.admitted {
+			h.Limiter.disconnect(remoteIP(c.remote))
+		}
+		if ch, ok := h.EasyWsHandler.(IEasyWsConn); ok && c.upgraded {
+			ch.OnDisconnect(c, err)
+		}
+	}
+
 	_, err = h.EasyWsHandler.OnClose(err)
 	return err
 }
 
-func NewEasyWs(easyWsHanler IEasyWs, ip string, port int32) *EasyWs {
+func NewEasyWs(easyWsHanler IEasyWs, ip string, port int32, options ...ServerOption) *EasyWs {
 	config := easynet.NewDefaultNetConfig("tcp", ip, port)
-	handler := &NetHandler{
-		IsUpgrade:     map[string]bool{},
-		EasyWsHandler: easyWsHanler,
-	}
+	handler := NewNetHandler(easyWsHanler, options...)
 	net := easynet.NewEasyNet(context.Background(), "NetPoll", config, handler)
 	ws := &EasyWs{
 		EasyNetHandler: handler,
@@ -135,12 +684,21 @@ type EasyWs struct {
 	EasyWsHandler IEasyWs
 }
 
+// Constants used by Upgrader.
+const (
+	DefaultServerReadBufferSize  = 4096
+	DefaultServerWriteBufferSize = 512
+)
+
 // Upgrader contains options for upgrading connection to websocket.
 type Upgrader struct {
 	// ReadBufferSize and WriteBufferSize is an I/O buffer sizes.
 	// They used to read and write http data while upgrading to WebSocket.
 	// Allocated buffers are pooled with sync.Pool to avoid extra allocations.
 	//
+	// Note that request is parsed in place of the input stream, so
+	// ReadBufferSize is currently unused and is kept for compatibility.
+	//
 	// If a size is zero then default value is used.
 	//
 	// Usually it is useful to set read buffer size bigger than write buffer
@@ -260,6 +818,43 @@ type Upgrader struct {
 	// RejectConnectionError could be used to get more control on response.
 	OnHeader func(key, value []byte) error
 
+	// OnProtocol is a callback that will be called after all headers are
+	// parsed with the subprotocol selected by Protocol or ProtocolCustom. The
+	// protocol is empty if none was selected or if the client did not offer
+	// any.
+	//
+	// If returned error is non-nil then connection is rejected and response is
+	// sent with appropriate HTTP error code and body set to error message.
+	//
+	// RejectConnectionError could be used to get more control on response.
+	OnProtocol func(protocol string) error
+
+	// CheckOrigin is the callback that checks the Origin header of the
+	// request against the Host header to protect from cross-site WebSocket
+	// hijacking. It is not called for requests without Origin header, which
+	// are sent by non-browser clients.
+	//
+	// If CheckOrigin is nil, SameOrigin is used. AllowOrigins and AnyOrigin
+	// could be used to relax the check.
+	//
+	// If it returns false, connection is rejected with
+	// ErrHandshakeBadOrigin.
+	//
+	// The arguments are only valid until the callback returns.
+	CheckOrigin func(origin, host []byte) bool
+
+	// Authenticator is an optional authenticator of the clients. See
+	// Authenticator for details.
+	//
+	// Note that if credentials are passed within Sec-WebSocket-Protocol
+	// header, Protocol and ProtocolCustom must not select them.
+	Authenticator Authenticator
+
+	// CSRF configures optional CSRF token verification. If request does
+	// not pass the verification, connection is rejected with
+	// ErrHandshakeBadCSRFToken.
+	CSRF CSRFCheck
+
 	// OnBeforeUpgrade is a callback that will be called before sending
 	// successful upgrade response.
 	//
@@ -285,7 +880,16 @@ type Upgrader struct {
 // malformed and usually connection should be closed.
 // Even when error is non-nil Upgrade will write appropriate response into
 // connection in compliance with RFC.
+//
+// If stream does not contain the whole request yet, Upgrade returns
+// io.ErrUnexpectedEOF and leaves stream untouched.
 func (u Upgrader) Upgrade(stream _interface.IInputStream) (hs Handshake, out []byte, err error) {
+	return u.upgrade(stream, nil, nil)
+}
+
+// upgrade is like Upgrade but appends response to out. If c is non-nil, it
+// is filled with the request data.
+func (u Upgrader) upgrade(stream _interface.IInputStream, out []byte, c *Conn) (hs Handshake, _ []byte, err error) {
 	// headerSeen constants helps to report whether or not some header was seen
 	// during reading request bytes.
 	const (
@@ -305,15 +909,28 @@ func (u Upgrader) Upgrade(stream _interface.IInputStream) (hs Handshake, out []b
 			headerSeenSecKey
 	)
 
-	// Read HTTP request line like "GET /ws HTTP/1.1".
-	rl, err := readLine(stream)
-	if err != nil {
-		return hs, nil, err
+	// The whole request is parsed in place of the stream buffer, so all parsed
+	// values are valid only until the stream is ended.
+	data := stream.Begin(nil)
+	if !httpRequestComplete(data) {
+		stream.End(data)
+		return hs, out, io.ErrUnexpectedEOF
 	}
+	rest := data
+	defer func() {
+		stream.End(rest)
+	}()
+
+	// Read HTTP request line like "GET /ws HTTP/1.1".
+	var rl []byte
+	rl, rest = nextLine(rest)
 	// Parse request line data like HTTP version, uri and method.
 	req, err := httpParseRequestLine(rl)
 	if err != nil {
-		return hs, nil, err
+		return hs, out, err
+	}
+	if c != nil {
+		c.uri = string(req.uri)
 	}
 
 	// Prepare stack-based handshake header list.
@@ -358,14 +975,16 @@ func (u Upgrader) Upgrade(stream _interface.IInputStream) (hs Handshake, out []b
 		// bit on.
 		headerSeen byte
 
+		// host, origin and cookie are the values of corresponding headers
+		// used by origin and CSRF checks.
+		host, origin, cookie []byte
+
 		nonce = make([]byte, nonceSize)
 	)
 	for err == nil {
-		line, e := readLine(stream)
-		if e != nil {
-			return hs, nil, e
-		}
-		if len(httphead.Trim(string(line))) == 0 {
+		var line []byte
+		line, rest = nextLine(rest)
+		if len(btrim(line)) == 0 {
 			// Blank line, no more lines to read.
 			break
 		}
@@ -379,6 +998,7 @@ func (u Upgrader) Upgrade(stream _interface.IInputStream) (hs Handshake, out []b
 		switch httphead.BtsToString(k) {
 		case headerHostCanonical:
 			headerSeen |= headerSeenHost
+			host = v
 			if onHost := u.OnHost; onHost != nil {
 				err = onHost(v)
 			}
@@ -440,12 +1060,20 @@ func (u Upgrader) Upgrade(stream _interface.IInputStream) (hs Handshake, out []b
 			}
 
 		default:
+			switch httphead.BtsToString(k) {
+			case headerOriginCanonical:
+				origin = v
+			case headerCookieCanonical:
+				cookie = v
+			}
 			if onHeader := u.OnHeader; onHeader != nil {
 				err = onHeader(k, v)
 			}
 		}
 	}
-	var buffer bytes.Buffer
+	if err == nil && headerSeen == headerSeenAll && u.OnProtocol != nil {
+		err = u.OnProtocol(hs.Protocol)
+	}
 	switch {
 	case err == nil && headerSeen != headerSeenAll:
 		switch {
@@ -483,9 +1111,22 @@ func (u Upgrader) Upgrade(stream _interface.IInputStream) (hs Handshake, out []b
 			panic("unknown headers state")
 		}
 
-	case err == nil && u.OnBeforeUpgrade != nil:
+	case err == nil && origin != nil && !nonNilOrigin(u.CheckOrigin)(origin, host):
+		err = ErrHandshakeBadOrigin
+
+	case err == nil && !u.CSRF.check(req.uri, cookie):
+		err = ErrHandshakeBadCSRFToken
+
+	case err == nil && u.Authenticator != nil:
+		hs.Identity, err = authenticate(u.Authenticator, data)
+	}
+	if err == nil && u.OnBeforeUpgrade != nil {
 		header[1], err = u.OnBeforeUpgrade()
 	}
+
+	buf := getBuffer(nonZero(u.WriteBufferSize, DefaultServerWriteBufferSize))
+	defer putBuffer(buf)
+
 	if err != nil {
 		var code int
 		if rej, ok := err.(*ConnectionRejectedError); ok {
@@ -495,14 +1136,26 @@ func (u Upgrader) Upgrade(stream _interface.IInputStream) (hs Handshake, out []b
 		if code == 0 {
 			code = http.StatusInternalServerError
 		}
-		httpWriteResponseError(&buffer, err, code, header.WriteTo)
-		// Do not store Flush() error to not override already existing one.
-		return hs, []byte(buffer.String()), err
+		httpWriteResponseError(buf, err, code, header.WriteTo)
+		return hs, append(out, buf.Bytes()...), err
+	}
+
+	// Negotiated extensions may refer to the request bytes, which are about
+	// to be reused by stream.
+	for i, opt := range hs.Extensions {
+		hs.Extensions[i] = opt.Clone()
 	}
 
-	httpWriteResponseUpgrade(&buffer, nonce, hs, header.WriteTo)
+	httpWriteResponseUpgrade(buf, nonce, hs, header.WriteTo)
 
-	return hs, []byte(buffer.String()), err
+	return hs, append(out, buf.Bytes()...), nil
+}
+
+func nonNilOrigin(check func(origin, host []byte) bool) func(origin, host []byte) bool {
+	if check == nil {
+		return SameOrigin
+	}
+	return check
 }
 
 type handshakeHeader [2]HandshakeHeader
@@ -3,6 +3,7 @@ package easyws
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
 // RejectOption represents an option used to control the way connection is
@@ -130,3 +131,16 @@ var ErrNotHijacker = RejectConnectionError(
 	RejectionStatus(http.StatusInternalServerError),
 	RejectionReason("given http.ResponseWriter is not a http.Hijacker"),
 )
+
+// ClosedError is returned by NetHandler when the connection is closed by
+// close frame exchange, either initiated by the peer or by NetHandler itself
+// due to protocol violation.
+type ClosedError struct {
+	Code   StatusCode
+	Reason string
+}
+
+// Error implements error interface.
+func (err ClosedError) Error() string {
+	return "ws closed: " + strconv.FormatUint(uint64(err.Code), 10) + " " + err.Reason
+}
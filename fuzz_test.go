@@ -0,0 +1,285 @@
+package easyws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+	"github.com/EternalVow/easyws/httphead"
+)
+
+// Handshake requests sent by real browsers.
+var seedRequests = []string{
+	// Chrome.
+	"GET /chat HTTP/1.1\r\n" +
+		"Host: localhost:9001\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Pragma: no-cache\r\n" +
+		"Cache-Control: no-cache\r\n" +
+		"User-Agent: Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Origin: http://localhost:9001\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Accept-Encoding: gzip, deflate, br\r\n" +
+		"Accept-Language: en-US,en;q=0.9\r\n" +
+		"Sec-WebSocket-Key: 2CZqBf9uJzdPgVp1w3L4Kw==\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n" +
+		"\r\n",
+	// Firefox.
+	"GET /chat HTTP/1.1\r\n" +
+		"Host: localhost:9001\r\n" +
+		"User-Agent: Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:119.0) Gecko/20100101 Firefox/119.0\r\n" +
+		"Accept: */*\r\n" +
+		"Accept-Language: en-US,en;q=0.5\r\n" +
+		"Accept-Encoding: gzip, deflate, br\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Origin: http://localhost:9001\r\n" +
+		"Sec-WebSocket-Protocol: graphql-transport-ws, mqtt\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n" +
+		"Sec-WebSocket-Key: Rw0M8yCQbPmKVNv7XNZc8A==\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-Fetch-Dest: websocket\r\n" +
+		"Sec-Fetch-Mode: websocket\r\n" +
+		"Sec-Fetch-Site: same-origin\r\n" +
+		"Pragma: no-cache\r\n" +
+		"Cache-Control: no-cache\r\n" +
+		"Upgrade: websocket\r\n" +
+		"\r\n",
+	// Safari.
+	"GET /chat HTTP/1.1\r\n" +
+		"Host: localhost:9001\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: x3JJHMbDL1EzLkh9GBhXDw==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits=15; server_no_context_takeover\r\n" +
+		"User-Agent: Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15\r\n" +
+		"Origin: http://localhost:9001\r\n" +
+		"Cookie: session=4f0c2a; theme=\"dark mode\"\r\n" +
+		"\r\n",
+}
+
+// Frames sent by real browsers: masked "Hello, world!" text, masked ping,
+// masked close with 1001 (page navigation) and masked 256 bytes binary.
+var seedFrames = [][]byte{
+	{0x81, 0x8d, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58, 0xd6, 0x01, 0x4a, 0x58, 0x88, 0x4d, 0x59, 0x16},
+	{0x89, 0x80, 0x9a, 0x4b, 0x0c, 0x11},
+	{0x88, 0x82, 0x5f, 0x31, 0xe4, 0x02, 0x5c, 0xd8},
+	append([]byte{0x82, 0xfe, 0x01, 0x00, 0x12, 0x34, 0x56, 0x78}, make([]byte, 256)...),
+	{0x01, 0x03, 'f', 'o', 'o', 0x80, 0x03, 'b', 'a', 'r'},
+	{0x82, 0x7f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00},
+}
+
+// Header values which are parsed as options.
+var seedOptions = []string{
+	"permessage-deflate; client_max_window_bits",
+	"permessage-deflate; client_max_window_bits=15; server_no_context_takeover",
+	"permessage-deflate, x-webkit-deflate-frame",
+	`foo; bar="baz \"qux\""; a=b, foo; c`,
+	"graphql-transport-ws, mqtt, v12.stomp",
+	"",
+}
+
+func FuzzReadHeader(f *testing.F) {
+	for _, p := range seedFrames {
+		f.Add(p)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		stream := &base.InputStream{}
+		stream.Begin(data)
+		h, err := ReadHeader(stream)
+		if err != nil {
+			return
+		}
+		if h.Length < 0 {
+			t.Fatalf("negative length %d", h.Length)
+		}
+		// Property: header written back must be read the same.
+		bts, err := WriteHeader(h)
+		if err != nil {
+			t.Fatalf("can not write read header %+v: %v", h, err)
+		}
+		if n := HeaderSize(h); n != len(bts) {
+			t.Fatalf("HeaderSize() = %d; written %d bytes", n, len(bts))
+		}
+		stream = &base.InputStream{}
+		stream.Begin(bts)
+		act, err := ReadHeader(stream)
+		if err != nil {
+			t.Fatalf("can not read written header: %v", err)
+		}
+		if act != h {
+			t.Fatalf("round trip mismatch:\nact: %+v\nexp: %+v", act, h)
+		}
+	})
+}
+
+func FuzzWriteHeader(f *testing.F) {
+	f.Add(true, byte(0), byte(OpText), true, uint32(0x12345678), int64(13))
+	f.Add(false, byte(1), byte(OpBinary), false, uint32(0), int64(126))
+	f.Add(true, byte(7), byte(OpClose), true, uint32(1), int64(1<<16))
+	f.Add(true, byte(0), byte(OpPing), false, uint32(0), int64(-1))
+	f.Fuzz(func(t *testing.T, fin bool, rsv, op byte, masked bool, mask uint32, length int64) {
+		h := Header{
+			Fin:    fin,
+			Rsv:    rsv & 0x7,
+			OpCode: OpCode(op & 0xf),
+			Masked: masked,
+			Length: length,
+		}
+		if masked {
+			h.Mask = [4]byte{byte(mask >> 24), byte(mask >> 16), byte(mask >> 8), byte(mask)}
+		}
+		bts, err := WriteHeader(h)
+		if length < 0 {
+			if err == nil {
+				t.Fatalf("no error for negative length")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stream := &base.InputStream{}
+		stream.Begin(bts)
+		act, err := ReadHeader(stream)
+		if err != nil {
+			t.Fatalf("can not read written header: %v", err)
+		}
+		if act != h {
+			t.Fatalf("round trip mismatch:\nact: %+v\nexp: %+v", act, h)
+		}
+	})
+}
+
+func FuzzHTTPParseRequestLine(f *testing.F) {
+	for _, req := range seedRequests {
+		f.Add([]byte(req[:bytes.IndexByte([]byte(req), '\r')]))
+	}
+	f.Add([]byte("GET / HTTP/1.0"))
+	f.Add([]byte("GET / HTTP/2.11"))
+	f.Fuzz(func(t *testing.T, line []byte) {
+		req, err := httpParseRequestLine(line)
+		if err != nil {
+			return
+		}
+		if req.major < 0 || req.minor < 0 {
+			t.Fatalf("negative version %d.%d", req.major, req.minor)
+		}
+	})
+}
+
+func FuzzHTTPParseHeaderLine(f *testing.F) {
+	for _, req := range seedRequests {
+		for _, line := range bytes.Split([]byte(req), []byte("\r\n"))[1:] {
+			f.Add(line)
+		}
+	}
+	f.Fuzz(func(t *testing.T, line []byte) {
+		k, v, ok := httpParseHeaderLine(line)
+		if !ok {
+			return
+		}
+		if bytes.IndexByte(k, ':') != -1 {
+			t.Fatalf("key %q contains colon", k)
+		}
+		if len(btrim(v)) != len(v) {
+			t.Fatalf("value %q is not trimmed", v)
+		}
+	})
+}
+
+func FuzzUpgrade(f *testing.F) {
+	for _, req := range seedRequests {
+		f.Add([]byte(req))
+	}
+	f.Fuzz(func(t *testing.T, req []byte) {
+		u := Upgrader{
+			Protocol: func(p []byte) bool {
+				return string(p) == "mqtt"
+			},
+			Negotiate: func(opt httphead.Option) (httphead.Option, error) {
+				return opt, nil
+			},
+		}
+		stream := &base.InputStream{}
+		stream.Begin(req)
+		u.Upgrade(stream)
+	})
+}
+
+func FuzzScanner(f *testing.F) {
+	for _, opt := range seedOptions {
+		f.Add([]byte(opt))
+	}
+	f.Add([]byte(`(comment (nested \) comment)) token "quoted \" string"`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := NewScanner(data)
+		for s.Next() {
+			if s.Type() == ItemUndef {
+				t.Fatalf("undefined item is returned")
+			}
+		}
+		s = NewScanner(data)
+		for s.FetchUntil(',') {
+			s.Advance(1)
+		}
+		ScanTokens(data, func([]byte) bool { return true })
+	})
+}
+
+func FuzzScanOptions(f *testing.F) {
+	for _, opt := range seedOptions {
+		f.Add([]byte(opt))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, ctl := range []Control{ControlContinue, ControlSkip, ControlBreak} {
+			ScanOptions(data, func(int, []byte, []byte, []byte) Control {
+				return ctl
+			})
+		}
+	})
+}
+
+func FuzzParseOptions(f *testing.F) {
+	for _, opt := range seedOptions {
+		f.Add([]byte(opt))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		options, ok := ParseOptions(data, nil)
+		if !ok {
+			return
+		}
+		// Property: written options must be parsed the same.
+		var buf bytes.Buffer
+		if _, err := httphead.WriteOptions(&buf, options); err != nil {
+			t.Fatal(err)
+		}
+		act, ok := ParseOptions(buf.Bytes(), nil)
+		if !ok {
+			t.Fatalf("can not parse written options %q (from %q)", buf.Bytes(), data)
+		}
+		if len(act) != len(options) {
+			t.Fatalf("parsed %d options from %q; want %d", len(act), buf.Bytes(), len(options))
+		}
+		for i := range act {
+			if !act[i].Equal(options[i]) {
+				t.Fatalf("option #%d mismatch: %s; want %s", i, act[i], options[i])
+			}
+		}
+	})
+}
+
+func FuzzNegotiateExtensions(f *testing.F) {
+	for _, opt := range seedOptions {
+		f.Add([]byte(opt))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		negotiateExtensions(data, nil, func(opt httphead.Option) (httphead.Option, error) {
+			if len(opt.Name) == 0 {
+				t.Fatalf("empty option name")
+			}
+			return opt, nil
+		})
+	})
+}
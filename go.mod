@@ -5,6 +5,8 @@ go 1.19
 require (
 	github.com/EternalVow/easynet v0.0.0-20230720161816-02b106ce910f
 	github.com/gobwas/httphead v0.1.0
+	go.uber.org/zap v1.21.0
+	golang.org/x/sys v0.8.0
 )
 
 require (
@@ -23,9 +25,7 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	go.uber.org/zap v1.21.0 // indirect
 	golang.org/x/sync v0.2.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
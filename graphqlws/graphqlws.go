@@ -0,0 +1,149 @@
+// Package graphqlws implements the server side of GraphQL over WebSocket
+// protocol, also known as graphql-transport-ws.
+//
+// Server is an easyws.IEasyWs which accepts connections with the
+// "graphql-transport-ws" subprotocol and passes their operations to an
+// Executor, which is usually an adapter of a GraphQL implementation.
+// Operations are cancelled when the client completes them or disconnects.
+//
+// The specification: https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Subprotocol is the WebSocket subprotocol of the protocol.
+const Subprotocol = "graphql-transport-ws"
+
+// Close codes defined by the protocol.
+const (
+	CloseBadRequest         easyws.StatusCode = 4400
+	CloseUnauthorized       easyws.StatusCode = 4401
+	CloseForbidden          easyws.StatusCode = 4403
+	CloseInitTimeout        easyws.StatusCode = 4408
+	CloseSubscriberExists   easyws.StatusCode = 4409
+	CloseTooManyInitRequest easyws.StatusCode = 4429
+)
+
+// Message types defined by the protocol.
+const (
+	TypeConnectionInit = "connection_init"
+	TypeConnectionAck  = "connection_ack"
+	TypePing           = "ping"
+	TypePong           = "pong"
+	TypeSubscribe      = "subscribe"
+	TypeNext           = "next"
+	TypeError          = "error"
+	TypeComplete       = "complete"
+)
+
+// Request is the payload of the subscribe message.
+type Request struct {
+	OperationName string                 `json:"operationName,omitempty"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Result is an execution result sent to the client with the next message.
+type Result struct {
+	Data       interface{}            `json:"data,omitempty"`
+	Errors     []*Error               `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Error is a GraphQL error.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Location is a location of the error within the query.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (e *Error) Error() string {
+	return "graphqlws: " + e.Message
+}
+
+// Errors is a list of errors returned by Executor when the operation could
+// not be executed, for example because of validation errors. They are sent
+// to the client with the error message.
+type Errors []*Error
+
+func (es Errors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Message
+	}
+	return "graphqlws: " + strings.Join(msgs, "; ")
+}
+
+// Executor executes GraphQL operations.
+type Executor interface {
+	// Execute starts the operation described by req and returns a channel
+	// of its results. Queries and mutations send a single result, while
+	// subscriptions send a result for every event. Executor closes the
+	// channel when the operation is done, and the client is notified
+	// about the completion.
+	//
+	// Ctx is cancelled when the client completes the operation or
+	// disconnects; Executor must stop sending results and close the
+	// channel then.
+	//
+	// If the operation could not be executed, Execute returns an error.
+	// Errors and *Error are sent to the client as is, other errors are
+	// reported with their message.
+	Execute(ctx context.Context, req *Request) (<-chan *Result, error)
+}
+
+// ExecutorFunc is an adapter to allow the use of ordinary functions as
+// Executor.
+type ExecutorFunc func(ctx context.Context, req *Request) (<-chan *Result, error)
+
+// Execute implements Executor.
+func (f ExecutorFunc) Execute(ctx context.Context, req *Request) (<-chan *Result, error) {
+	return f(ctx, req)
+}
+
+// message is a message of the protocol.
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type (
+	connKey    struct{}
+	payloadKey struct{}
+	idKey      struct{}
+)
+
+// ConnFromContext returns the connection the operation executed with ctx is
+// received from.
+func ConnFromContext(ctx context.Context) *easyws.Conn {
+	c, _ := ctx.Value(connKey{}).(*easyws.Conn)
+	return c
+}
+
+// InitPayload returns the payload of connection_init message of the
+// connection the operation executed with ctx is received from.
+func InitPayload(ctx context.Context) json.RawMessage {
+	p, _ := ctx.Value(payloadKey{}).(json.RawMessage)
+	return p
+}
+
+// OperationID returns the identifier of the operation executed with ctx.
+func OperationID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
@@ -0,0 +1,189 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+type userKey struct{}
+
+// executor serves a query, a subscription and a failing query.
+type executor struct {
+	ticks     chan int
+	cancelled chan string
+}
+
+func (e *executor) Execute(ctx context.Context, req *Request) (<-chan *Result, error) {
+	ch := make(chan *Result, 1)
+	switch req.Query {
+	case "{ hello }":
+		user, _ := ctx.Value(userKey{}).(string)
+		ch <- &Result{Data: map[string]string{"hello": user}}
+		close(ch)
+	case "subscription { ticks }":
+		go func() {
+			defer close(ch)
+			for {
+				select {
+				case n := <-e.ticks:
+					ch <- &Result{Data: map[string]int{"ticks": n}}
+				case <-ctx.Done():
+					e.cancelled <- OperationID(ctx)
+					return
+				}
+			}
+		}()
+	case "{ init }":
+		ch <- &Result{Data: map[string]json.RawMessage{"init": InitPayload(ctx)}}
+		close(ch)
+	default:
+		return nil, Errors{{Message: "Cannot query field", Locations: []Location{{1, 3}}}}
+	}
+	return ch, nil
+}
+
+type client struct {
+	*wstest.Harness
+}
+
+func dial(t *testing.T, s *Server) client {
+	return client{wstest.Dial(t, s, wstest.Request{Protocols: []string{Subprotocol}})}
+}
+
+// expect waits for the next message sent by the server, which is sent
+// asynchronously for operations.
+func (c client) expect(s string) {
+	c.T().Helper()
+	if act := c.WaitText(); act != s {
+		c.T().Fatalf("unexpected message: %q; want %q", act, s)
+	}
+}
+
+func (c client) expectClose(code easyws.StatusCode) {
+	c.T().Helper()
+	c.WaitClosed()
+	c.ExpectClose(code)
+}
+
+func TestServer(t *testing.T) {
+	ex := &executor{
+		ticks:     make(chan int),
+		cancelled: make(chan string, 1),
+	}
+	s := &Server{
+		Executor: ex,
+		OnInit: func(ctx context.Context, payload json.RawMessage) (context.Context, error) {
+			var p struct{ Token string }
+			if err := json.Unmarshal(payload, &p); err != nil || p.Token != "secret" {
+				return nil, errors.New("bad token")
+			}
+			if ConnFromContext(ctx) == nil {
+				return nil, errors.New("no connection")
+			}
+			return context.WithValue(ctx, userKey{}, "gopher"), nil
+		},
+	}
+	const init = `{"type":"connection_init","payload":{"token":"secret"}}`
+	ack := func(t *testing.T) client {
+		c := dial(t, s)
+		c.SendText(init)
+		c.expect(`{"type":"connection_ack"}`)
+		return c
+	}
+
+	t.Run("init", func(t *testing.T) {
+		c := dial(t, s)
+		c.SendText(`{"type":"connection_init","payload":{"token":"wrong"}}`)
+		c.expectClose(CloseForbidden)
+
+		c = dial(t, s)
+		c.SendText(`{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`)
+		c.expectClose(CloseUnauthorized)
+
+		c = ack(t)
+		c.SendText(`{"type":"ping"}`)
+		c.expect(`{"type":"pong"}`)
+		c.SendText(`{"type":"pong"}`)
+		c.ExpectNothing()
+		c.SendText(init)
+		c.expectClose(CloseTooManyInitRequest)
+
+		c = dial(t, s)
+		c.SendText(`{"type":"next"}`)
+		c.expectClose(CloseBadRequest)
+
+		c = dial(t, s)
+		c.SendText(`not json`)
+		c.expectClose(CloseBadRequest)
+
+		c = dial(t, &Server{InitTimeout: 10 * time.Millisecond})
+		c.expectClose(CloseInitTimeout)
+	})
+
+	t.Run("query", func(t *testing.T) {
+		c := ack(t)
+		c.SendText(`{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`)
+		c.expect(`{"id":"1","type":"next","payload":{"data":{"hello":"gopher"}}}`)
+		c.expect(`{"id":"1","type":"complete"}`)
+
+		c.SendText(`{"id":"2","type":"subscribe","payload":{"query":"{ nope }"}}`)
+		c.expect(`{"id":"2","type":"error","payload":[{"message":"Cannot query field","locations":[{"line":1,"column":3}]}]}`)
+
+		c.SendText(`{"id":"3","type":"subscribe","payload":{"query":"{ init }"}}`)
+		c.expect(`{"id":"3","type":"next","payload":{"data":{"init":{"token":"secret"}}}}`)
+		c.expect(`{"id":"3","type":"complete"}`)
+
+		// Identifiers of completed operations could be reused.
+		c.SendText(`{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`)
+		c.expect(`{"id":"1","type":"next","payload":{"data":{"hello":"gopher"}}}`)
+		c.expect(`{"id":"1","type":"complete"}`)
+
+		c.SendText(`{"id":"4","type":"subscribe","payload":{}}`)
+		c.expectClose(CloseBadRequest)
+	})
+
+	t.Run("subscription", func(t *testing.T) {
+		c := ack(t)
+		c.SendText(`{"id":"s","type":"subscribe","payload":{"query":"subscription { ticks }"}}`)
+		ex.ticks <- 1
+		c.expect(`{"id":"s","type":"next","payload":{"data":{"ticks":1}}}`)
+		ex.ticks <- 2
+		c.expect(`{"id":"s","type":"next","payload":{"data":{"ticks":2}}}`)
+
+		// Operation is cancelled on complete without the server complete
+		// message.
+		c.SendText(`{"id":"s","type":"complete"}`)
+		if id := <-ex.cancelled; id != "s" {
+			t.Fatalf("unexpected cancelled operation: %q", id)
+		}
+		c.ExpectNothing()
+
+		// Close reason does not include the identifier, which could make it
+		// too long.
+		dup := strings.Repeat("ы", 100)
+		sub := `{"id":"` + dup + `","type":"subscribe","payload":{"query":"subscription { ticks }"}}`
+		c.SendText(sub)
+		c.SendText(sub)
+		c.ExpectFrame(easyws.OpClose, easyws.NewCloseFrameBody(CloseSubscriberExists, "Subscriber already exists"))
+		if id := <-ex.cancelled; id != dup {
+			t.Fatalf("unexpected cancelled operation: %q", id)
+		}
+
+		// Operations are cancelled on disconnect.
+		c = ack(t)
+		c.SendText(`{"id":"d","type":"subscribe","payload":{"query":"subscription { ticks }"}}`)
+		ex.ticks <- 3
+		c.expect(`{"id":"d","type":"next","payload":{"data":{"ticks":3}}}`)
+		c.Close()
+		if id := <-ex.cancelled; id != "d" {
+			t.Fatalf("unexpected cancelled operation: %q", id)
+		}
+	})
+}
@@ -0,0 +1,310 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+)
+
+// DefaultInitTimeout is the default time the server waits for the
+// connection_init message.
+const DefaultInitTimeout = 3 * time.Second
+
+// Server serves GraphQL operations over easyws connections. It implements
+// easyws.IEasyWs and must be served by NetHandler, which passes connections
+// to its callbacks.
+type Server struct {
+	// Executor executes the operations.
+	Executor Executor
+
+	// OnInit is called for the connection_init message with its payload,
+	// usually to authenticate the client. Ctx holds the connection, which
+	// could be retrieved with ConnFromContext, and the payload. OnInit
+	// returns the context the operations of the connection are executed
+	// with; it could be nil to use ctx. If OnInit returns an error, the
+	// connection is closed with CloseForbidden. If nil, all connections are
+	// acknowledged.
+	//
+	// OnInit blocks handling of the connection messages until it returns.
+	OnInit func(ctx context.Context, payload json.RawMessage) (context.Context, error)
+
+	// InitTimeout limits the time the server waits for the connection_init
+	// message after the connection is opened. If zero, DefaultInitTimeout
+	// is used; negative value disables the timeout.
+	InitTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[*easyws.Conn]*session
+}
+
+// session is the state of a connection.
+type session struct {
+	server *Server
+	conn   *easyws.Conn
+	timer  *time.Timer
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	init   bool
+	acked  bool
+	ops    map[string]*operation
+}
+
+// operation is an operation being executed.
+type operation struct {
+	cancel context.CancelFunc
+}
+
+// Register registers s within m for the "graphql-transport-ws" subprotocol.
+func (s *Server) Register(m *easyws.ProtocolMux) {
+	m.Handle(Subprotocol, s)
+}
+
+// OnOpen implements easyws.IEasyWsConn.
+func (s *Server) OnOpen(conn *easyws.Conn) error {
+	ctx, cancel := context.WithCancel(context.WithValue(conn.Context(), connKey{}, conn))
+	ss := &session{
+		server: s,
+		conn:   conn,
+		ctx:    ctx,
+		cancel: cancel,
+		ops:    make(map[string]*operation),
+	}
+	timeout := s.InitTimeout
+	if timeout == 0 {
+		timeout = DefaultInitTimeout
+	}
+	if timeout > 0 {
+		ss.timer = time.AfterFunc(timeout, func() {
+			ss.mu.Lock()
+			init := ss.init
+			ss.mu.Unlock()
+			if !init {
+				conn.Close(CloseInitTimeout, "Connection initialisation timeout")
+			}
+		})
+	}
+	s.mu.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[*easyws.Conn]*session)
+	}
+	s.sessions[conn] = ss
+	s.mu.Unlock()
+	return nil
+}
+
+// OnDisconnect implements easyws.IEasyWsConn. Operations of the connection
+// are cancelled.
+func (s *Server) OnDisconnect(conn *easyws.Conn, err error) {
+	s.mu.Lock()
+	ss := s.sessions[conn]
+	delete(s.sessions, conn)
+	s.mu.Unlock()
+	if ss != nil {
+		ss.close()
+	}
+}
+
+// OnMessage implements easyws.IEasyWsMessage. Clients violating the protocol
+// are disconnected with the close code defined by it.
+func (s *Server) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	s.mu.Lock()
+	ss := s.sessions[conn]
+	s.mu.Unlock()
+	if ss == nil {
+		return easyws.CloseReply(easyws.StatusInternalServerError, "graphqlws: connection is not open")
+	}
+	var m message
+	if op != easyws.OpText || json.Unmarshal(msg, &m) != nil {
+		return easyws.CloseReply(CloseBadRequest, "Invalid message received")
+	}
+	if code, reason := ss.handle(&m); code != 0 {
+		ss.close()
+		return easyws.CloseReply(code, reason)
+	}
+	return nil, 0, nil
+}
+
+// OnReceive implements easyws.IEasyWs. Server is served by OnMessage only;
+// see easyws.IEasyWsMessage.
+func (s *Server) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return easyws.CloseReply(easyws.StatusInternalServerError, "graphqlws: connection is not open")
+}
+
+func (s *Server) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (s *Server) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (s *Server) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (s *Server) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (s *Server) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
+
+// handle handles a message received from the client. It returns non-zero
+// close code if the connection must be closed.
+func (ss *session) handle(m *message) (easyws.StatusCode, string) {
+	switch m.Type {
+	case TypeConnectionInit:
+		return ss.connectionInit(m.Payload)
+
+	case TypePing:
+		ss.write(&message{Type: TypePong})
+
+	case TypePong:
+
+	case TypeSubscribe:
+		var req Request
+		if m.ID == "" || json.Unmarshal(m.Payload, &req) != nil || req.Query == "" {
+			return CloseBadRequest, "Invalid message received"
+		}
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		if !ss.acked {
+			return CloseUnauthorized, "Unauthorized"
+		}
+		if _, dup := ss.ops[m.ID]; dup {
+			return CloseSubscriberExists, "Subscriber already exists"
+		}
+		ctx, cancel := context.WithCancel(context.WithValue(ss.ctx, idKey{}, m.ID))
+		o := &operation{cancel: cancel}
+		ss.ops[m.ID] = o
+		go ss.run(ctx, m.ID, o, &req)
+
+	case TypeComplete:
+		ss.mu.Lock()
+		if o := ss.ops[m.ID]; o != nil {
+			delete(ss.ops, m.ID)
+			o.cancel()
+		}
+		ss.mu.Unlock()
+
+	default:
+		return CloseBadRequest, "Invalid message received"
+	}
+	return 0, ""
+}
+
+// connectionInit handles connection_init message.
+func (ss *session) connectionInit(payload json.RawMessage) (easyws.StatusCode, string) {
+	ss.mu.Lock()
+	init := ss.init
+	ss.init = true
+	ctx := ss.ctx
+	ss.mu.Unlock()
+	if init {
+		return CloseTooManyInitRequest, "Too many initialisation requests"
+	}
+	if ss.timer != nil {
+		ss.timer.Stop()
+	}
+
+	ctx = context.WithValue(ctx, payloadKey{}, payload)
+	if f := ss.server.OnInit; f != nil {
+		c, err := f(ctx, payload)
+		if err != nil {
+			return CloseForbidden, "Forbidden"
+		}
+		if c != nil {
+			ctx = c
+		}
+	}
+	ss.mu.Lock()
+	ss.ctx = ctx
+	ss.acked = true
+	ss.mu.Unlock()
+	ss.write(&message{Type: TypeConnectionAck})
+	return 0, ""
+}
+
+// run executes the operation and sends its results to the client.
+func (ss *session) run(ctx context.Context, id string, o *operation, req *Request) {
+	defer o.cancel()
+	var (
+		ch  <-chan *Result
+		err error
+	)
+	if ex := ss.server.Executor; ex != nil {
+		ch, err = ex.Execute(ctx, req)
+	} else {
+		err = errors.New("executor is not set")
+	}
+	if err != nil {
+		var errs Errors
+		switch e := err.(type) {
+		case Errors:
+			errs = e
+		case *Error:
+			errs = Errors{e}
+		default:
+			errs = Errors{{Message: err.Error()}}
+		}
+		ss.finish(id, o, TypeError, errs)
+		return
+	}
+	for {
+		select {
+		case r, ok := <-ch:
+			if !ok {
+				ss.finish(id, o, TypeComplete, nil)
+				return
+			}
+			ss.mu.Lock()
+			if ss.ops[id] == o {
+				ss.write(&message{ID: id, Type: TypeNext, Payload: encode(r)})
+			}
+			ss.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finish sends the last message of the operation unless it is completed by
+// the client.
+func (ss *session) finish(id string, o *operation, typ string, payload interface{}) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.ops[id] != o {
+		return
+	}
+	delete(ss.ops, id)
+	m := &message{ID: id, Type: typ}
+	if payload != nil {
+		m.Payload = encode(payload)
+	}
+	ss.write(m)
+}
+
+// close cancels the operations of the connection.
+func (ss *session) close() {
+	if ss.timer != nil {
+		ss.timer.Stop()
+	}
+	ss.mu.Lock()
+	ss.ops = make(map[string]*operation)
+	ss.mu.Unlock()
+	ss.cancel()
+}
+
+// write sends m to the client. Errors are ignored: the connection is closed
+// and its operations will be cancelled.
+func (ss *session) write(m *message) {
+	p, err := json.Marshal(m)
+	if err != nil {
+		// Payloads are already encoded, so it could not happen.
+		panic(err)
+	}
+	ss.conn.WriteMessage(easyws.OpText, p)
+}
+
+// encode encodes the payload. Results which could not be encoded are
+// replaced with an error result.
+func encode(v interface{}) json.RawMessage {
+	p, err := json.Marshal(v)
+	if err != nil {
+		p, _ = json.Marshal(&Result{Errors: []*Error{{Message: err.Error()}}})
+	}
+	return p
+}
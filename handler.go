@@ -7,6 +7,9 @@ type IEasyWs interface {
 
 	OnUpgraded() (OpCode, error)
 
+	// OnReceive is called for every received data message. If
+	// NetHandler.ReuseBuffers is set, msg is valid only until OnReceive
+	// returns, so it must be copied to be retained.
 	OnReceive(msg []byte) ([]byte, OpCode, error)
 
 	OnShutdown() (OpCode, error)
@@ -15,3 +18,39 @@ type IEasyWs interface {
 
 	// todo to add more
 }
+
+// IEasyWsMessage is an optional interface that could be implemented by
+// IEasyWs to receive messages together with the connection they came from
+// and their op code (OpText or OpBinary). If implemented, OnMessage is called
+// instead of IEasyWs.OnReceive.
+//
+// Returned bytes and op code are handled the same way as OnReceive results.
+// As with OnReceive, msg is valid only until OnMessage returns if
+// NetHandler.ReuseBuffers is set.
+//
+// Handlers which keep state per connection can not serve messages without
+// it, so their OnReceive usually just closes the connection with CloseReply.
+type IEasyWsMessage interface {
+	OnMessage(conn *Conn, op OpCode, msg []byte) ([]byte, OpCode, error)
+}
+
+// CloseReply returns results of IEasyWs.OnReceive or IEasyWsMessage.OnMessage
+// which make NetHandler send close frame with given code and reason and then
+// close the connection.
+func CloseReply(code StatusCode, reason string) ([]byte, OpCode, error) {
+	return NewCloseFrameBody(code, reason), OpClose, nil
+}
+
+// IEasyWsConn is an optional interface that could be implemented by IEasyWs
+// to track the lifecycle of particular connections.
+//
+// OnOpen is called right after IEasyWs.OnUpgraded, before the handshake
+// response is sent. If either of them returns an error, the handshake is
+// rejected with HTTP error response and the connection is closed; status
+// code could be set with RejectConnectionError, it is 500 otherwise.
+// OnDisconnect is called when a connection which was opened successfully is
+// closed, right before IEasyWs.OnClose.
+type IEasyWsConn interface {
+	OnOpen(conn *Conn) error
+	OnDisconnect(conn *Conn, err error)
+}
@@ -15,6 +15,10 @@ const (
 	commaAndSpace = ", "
 )
 
+// maxRequestSize limits the size of HTTP request buffered while waiting for
+// its end.
+const maxRequestSize = http.DefaultMaxHeaderBytes
+
 const (
 	textHeadUpgrade = "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"
 )
@@ -80,6 +84,29 @@ type httpResponseLine struct {
 	reason       []byte
 }
 
+// httpRequestComplete reports whether bts contains the whole HTTP request
+// head, that is, request line and headers terminated by blank line.
+func httpRequestComplete(bts []byte) bool {
+	return bytes.Contains(bts, []byte("\n\r\n")) || bytes.Contains(bts, []byte("\n\n"))
+}
+
+// httpScanHeaders calls fn for every header of the complete HTTP request in
+// bts. Keys passed to fn are canonicalized in place.
+func httpScanHeaders(bts []byte, fn func(k, v []byte)) {
+	// Skip request line.
+	_, bts = nextLine(bts)
+	for len(bts) > 0 {
+		var line []byte
+		line, bts = nextLine(bts)
+		if len(btrim(line)) == 0 {
+			return
+		}
+		if k, v, ok := httpParseHeaderLine(line); ok {
+			fn(k, v)
+		}
+	}
+}
+
 // httpParseRequestLine parses http request line like "GET / HTTP/1.0".
 func httpParseRequestLine(line []byte) (req httpRequestLine, err error) {
 	var proto []byte
@@ -314,6 +341,7 @@ func httpWriteUpgradeRequest(
 
 	if len(extensions) > 0 {
 		httpWriteHeaderKey(bw, headerSecExtensions)
+		httphead.WriteOptions(bw, extensions)
 		bw.WriteString(crlf)
 	}
 
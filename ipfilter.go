@@ -0,0 +1,364 @@
+package easyws
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Errors returned by NetHandler when client address is denied by IPFilter.
+var (
+	ErrIPDenied = errors.New("ip address denied")
+
+	ErrHandshakeIPDenied = RejectConnectionError(
+		RejectionStatus(http.StatusForbidden),
+		RejectionReason("handshake error: ip address denied"),
+	)
+)
+
+// IPList is a list of networks allowed and denied to connect.
+type IPList struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// Allowed reports whether ip is allowed by l. Deny rules take precedence over
+// allow rules. If there are no allow rules, then any address which is not
+// denied is allowed.
+func (l IPList) Allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range l.Deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.Allow) == 0 {
+		return true
+	}
+	for _, p := range l.Allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPRules describes the rules applied by IPFilter.
+type IPRules struct {
+	// IPList contains rules applied to every connection when it is
+	// established, before any bytes are received.
+	IPList
+
+	// Routes contains rules applied to the handshake requests in addition
+	// to the global ones. Keys are the request paths; a key ending with slash
+	// matches the path without it and any path beginning with it. Only the
+	// longest matching key is used.
+	//
+	// Request paths are unescaped and cleaned before matching, so
+	// "/%61dmin/x", "//admin/x" and "/x/../admin/x" all match "/admin/".
+	Routes map[string]IPList
+}
+
+// route returns the rules for the given cleaned request path.
+func (r *IPRules) route(path string) (IPList, bool) {
+	if l, ok := r.Routes[path]; ok {
+		return l, true
+	}
+	var (
+		list IPList
+		best = -1
+	)
+	for p, l := range r.Routes {
+		if !strings.HasSuffix(p, "/") || len(p) <= best {
+			continue
+		}
+		if strings.HasPrefix(path, p) || path == p[:len(p)-1] {
+			list, best = l, len(p)
+		}
+	}
+	return list, best != -1
+}
+
+// requestPath returns the unescaped and cleaned path of the HTTP request in
+// data. It returns false if the path could not be unescaped.
+func requestPath(data []byte) (string, bool) {
+	rl, _ := nextLine(data)
+	req, _ := httpParseRequestLine(rl)
+	uri := req.uri
+	if i := bytes.IndexAny(uri, "?#"); i != -1 {
+		uri = uri[:i]
+	}
+	// Absolute form, like "http://example.com/path".
+	if i := bytes.Index(uri, []byte("://")); i != -1 && bytes.IndexByte(uri[:i], '/') == -1 {
+		uri = uri[i+3:]
+		if i := bytes.IndexByte(uri, '/'); i != -1 {
+			uri = uri[i:]
+		} else {
+			uri = nil
+		}
+	}
+	p, err := url.PathUnescape(string(uri))
+	if err != nil {
+		return "", false
+	}
+	return path.Clean("/" + p), true
+}
+
+// ParseIPRules parses rules from r. Each non-empty line which is not a
+// comment starting with '#' has the form:
+//
+//	allow|deny <cidr or ip> [path]
+//
+// Rules with path are added to IPRules.Routes.
+func ParseIPRules(r io.Reader) (IPRules, error) {
+	var (
+		rules IPRules
+		sc    = bufio.NewScanner(r)
+	)
+	for line := 1; sc.Scan(); line++ {
+		s, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return IPRules{}, fmt.Errorf("ip rules: line %d: malformed rule", line)
+		}
+		prefix, err := parsePrefix(fields[1])
+		if err != nil {
+			return IPRules{}, fmt.Errorf("ip rules: line %d: %w", line, err)
+		}
+		list := &rules.IPList
+		var route IPList
+		if len(fields) == 3 {
+			if rules.Routes == nil {
+				rules.Routes = map[string]IPList{}
+			}
+			route = rules.Routes[fields[2]]
+			list = &route
+		}
+		switch fields[0] {
+		case "allow":
+			list.Allow = append(list.Allow, prefix)
+		case "deny":
+			list.Deny = append(list.Deny, prefix)
+		default:
+			return IPRules{}, fmt.Errorf("ip rules: line %d: unknown action %q", line, fields[0])
+		}
+		if len(fields) == 3 {
+			rules.Routes[fields[2]] = route
+		}
+	}
+	return rules, sc.Err()
+}
+
+// parsePrefix parses CIDR or single IP address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.IndexByte(s, '/') != -1 {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// LoadIPRules reads and parses the rules file at path. See ParseIPRules for
+// the file format.
+func LoadIPRules(path string) (IPRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return IPRules{}, err
+	}
+	defer f.Close()
+	return ParseIPRules(f)
+}
+
+// IPFilter allows or denies connections by the client IP address. Rules
+// could be replaced at runtime with SetRules or reloaded from the file with
+// Watch.
+//
+// If NetHandler is served behind proxies configured by ProxyConfig, the
+// global rules are checked for the client address when it is reported by
+// the proxy, or for the proxy address if it is not.
+type IPFilter struct {
+	rules atomic.Pointer[IPRules]
+
+	denied  [ipStagesCount]atomic.Uint64
+	reloads [2]atomic.Uint64
+}
+
+// ipStage enumerates the stages where connections are denied.
+type ipStage int
+
+const (
+	ipStageConnect ipStage = iota
+	ipStageProxy
+	ipStageRoute
+
+	ipStagesCount
+)
+
+var ipStageNames = [ipStagesCount]string{
+	ipStageConnect: "connect",
+	ipStageProxy:   "proxy",
+	ipStageRoute:   "route",
+}
+
+// NewIPFilter creates IPFilter applying given rules.
+func NewIPFilter(rules IPRules) *IPFilter {
+	f := &IPFilter{}
+	f.SetRules(rules)
+	return f
+}
+
+// WithIPFilter returns an option that makes NetHandler to filter connections
+// with f. If metrics are collected, the number of denied connections is
+// exposed as well.
+func WithIPFilter(f *IPFilter) ServerOption {
+	return func(h *NetHandler) {
+		h.IPFilter = f
+	}
+}
+
+// SetRules replaces the rules. It is safe to call SetRules concurrently with
+// connections being served. Already open connections are not affected.
+func (f *IPFilter) SetRules(rules IPRules) {
+	f.rules.Store(&rules)
+}
+
+// Rules returns current rules.
+func (f *IPFilter) Rules() IPRules {
+	return *f.rules.Load()
+}
+
+// Watch loads the rules from the file at path and then checks the file for
+// modifications every interval, reloading the rules until ctx is done. If
+// the file could not be loaded, then the current rules are kept and onError
+// is called, if not nil.
+//
+// It returns an error if the file could not be loaded initially.
+func (f *IPFilter) Watch(ctx context.Context, path string, interval time.Duration, onError func(error)) error {
+	stat, err := os.Stat(path)
+	if err == nil {
+		err = f.reload(path)
+	}
+	if err != nil {
+		return err
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			s, err := os.Stat(path)
+			if err == nil {
+				if s.ModTime().Equal(stat.ModTime()) && s.Size() == stat.Size() {
+					continue
+				}
+				stat = s
+				err = f.reload(path)
+			}
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (f *IPFilter) reload(path string) error {
+	rules, err := LoadIPRules(path)
+	if err != nil {
+		f.reloads[1].Add(1)
+		return err
+	}
+	f.SetRules(rules)
+	f.reloads[0].Add(1)
+	return nil
+}
+
+// allowed reports whether addr is allowed by the global rules. It counts the
+// denial at given stage.
+func (f *IPFilter) allowed(addr string, stage ipStage) bool {
+	ip, ok := parseIP(addr)
+	if !ok || !f.rules.Load().Allowed(ip) {
+		f.denied[stage].Add(1)
+		return false
+	}
+	return true
+}
+
+// allowedRoute reports whether addr is allowed to make the handshake request
+// in data.
+func (f *IPFilter) allowedRoute(addr string, data []byte) bool {
+	rules := f.rules.Load()
+	if len(rules.Routes) == 0 {
+		return true
+	}
+	path, ok := requestPath(data)
+	if !ok {
+		// Malformed path could not be matched against the routes.
+		f.denied[ipStageRoute].Add(1)
+		return false
+	}
+	list, ok := rules.route(path)
+	if !ok {
+		return true
+	}
+	ip, ok := parseIP(addr)
+	if !ok || !list.Allowed(ip) {
+		f.denied[ipStageRoute].Add(1)
+		return false
+	}
+	return true
+}
+
+// collect writes IPFilter metrics in Prometheus text format.
+func (f *IPFilter) collect(w io.Writer) {
+	bw, ok := w.(metricWriter)
+	if !ok {
+		buf := bufio.NewWriter(w)
+		defer buf.Flush()
+		bw = buf
+	}
+	const denied = "easyws_ip_denied_total"
+	family(bw, denied, "counter", "Total number of connections denied by IP filter by stage.")
+	for i := range f.denied {
+		sample(bw, denied, label("stage", ipStageNames[i]), float64(f.denied[i].Load()))
+	}
+	const reloads = "easyws_ip_rules_reloads_total"
+	family(bw, reloads, "counter", "Total number of IP rules file reloads by result.")
+	for i, result := range [...]string{"success", "error"} {
+		sample(bw, reloads, label("result", result), float64(f.reloads[i].Load()))
+	}
+	rules := f.rules.Load()
+	routes := make([]string, 0, len(rules.Routes))
+	for p := range rules.Routes {
+		routes = append(routes, p)
+	}
+	sort.Strings(routes)
+	family(bw, "easyws_ip_rules", "gauge", "Number of IP rules by route.")
+	sample(bw, "easyws_ip_rules", label("route", ""), float64(len(rules.Allow)+len(rules.Deny)))
+	for _, p := range routes {
+		sample(bw, "easyws_ip_rules", label("route", p), float64(len(rules.Routes[p].Allow)+len(rules.Routes[p].Deny)))
+	}
+}
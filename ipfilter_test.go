@@ -0,0 +1,188 @@
+package easyws
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+func TestParseIPRules(t *testing.T) {
+	rules, err := ParseIPRules(strings.NewReader(`
+# Block abusive ranges.
+deny 192.0.2.0/24
+deny 2001:db8::1
+
+allow 10.0.0.0/8  /admin/  # Admin endpoints are internal.
+deny  10.0.0.9    /admin/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		addr  string
+		path  string
+		allow bool
+	}{
+		{"192.0.2.1", "/", false},
+		{"198.51.100.1", "/", true},
+		{"2001:db8::1", "/", false},
+		{"2001:db8::2", "/", true},
+		{"198.51.100.1", "/admin/users", false},
+		{"10.0.0.1", "/admin/users", true},
+		{"10.0.0.9", "/admin/users", false},
+		{"198.51.100.1", "/admin", false},
+		{"10.0.0.1", "/admin", true},
+		{"198.51.100.1", "/administrator", true},
+	} {
+		ip := netip.MustParseAddr(test.addr)
+		allow := rules.Allowed(ip)
+		if list, ok := rules.route(test.path); ok {
+			allow = allow && list.Allowed(ip)
+		}
+		if allow != test.allow {
+			t.Errorf("%s %s allowed is %t; want %t", test.addr, test.path, allow, test.allow)
+		}
+	}
+
+	for _, bad := range []string{
+		"deny",
+		"deny 192.0.2.0/33",
+		"block 192.0.2.1",
+		"deny 192.0.2.1 /admin extra",
+	} {
+		if _, err := ParseIPRules(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseIPRules(%q) error is nil", bad)
+		}
+	}
+}
+
+func TestNetHandlerIPFilter(t *testing.T) {
+	f := NewIPFilter(IPRules{
+		IPList: IPList{
+			Deny: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+		},
+		Routes: map[string]IPList{
+			"/admin/": {Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		},
+	})
+	m := NewMetrics()
+	h := NewNetHandler(benchEcho{}, WithIPFilter(f), WithMetrics(m))
+
+	handshake := func(addr, path string) error {
+		conn := testConn(addr)
+		if err := h.OnConnect(conn); err != nil {
+			return err
+		}
+		defer h.OnClose(conn, nil)
+		stream := &base.InputStream{}
+		stream.Begin([]byte("GET " + path + " HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Version: 13\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+			"\r\n",
+		))
+		_, err := h.OnReceive(conn, stream)
+		return err
+	}
+	for _, test := range []struct {
+		addr string
+		path string
+		err  error
+	}{
+		{"192.0.2.1:1", "/", ErrIPDenied},
+		{"198.51.100.1:1", "/", nil},
+		{"198.51.100.1:1", "/admin?x=1", ErrHandshakeIPDenied},
+		{"10.0.0.1:1", "/admin", nil},
+		{"10.0.0.1:1", "/admin/x", nil},
+		{"198.51.100.1:1", "/admin/x", ErrHandshakeIPDenied},
+		{"198.51.100.1:1", "/%61dmin/x", ErrHandshakeIPDenied},
+		{"198.51.100.1:1", "//admin/x", ErrHandshakeIPDenied},
+		{"198.51.100.1:1", "/x/../admin/", ErrHandshakeIPDenied},
+		{"198.51.100.1:1", "/./admin", ErrHandshakeIPDenied},
+		{"198.51.100.1:1", "/x/%2e%2e/admin/x", ErrHandshakeIPDenied},
+		{"198.51.100.1:1", "http://localhost/admin/x", ErrHandshakeIPDenied},
+		{"198.51.100.1:1", "/admin%zz", ErrHandshakeIPDenied},
+		{"198.51.100.1:1", "/administrator", nil},
+	} {
+		if err := handshake(test.addr, test.path); err != test.err {
+			t.Errorf("handshake from %s to %s error is %v; want %v", test.addr, test.path, err, test.err)
+		}
+	}
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	for _, exp := range []string{
+		`easyws_ip_denied_total{stage="connect"} 1`,
+		`easyws_ip_denied_total{stage="route"} 9`,
+		`easyws_handshake_rejections_total{reason="ip_denied"} 9`,
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("no %q in metrics:\n%s", exp, buf.String())
+		}
+	}
+}
+
+func TestIPFilterWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules")
+	// write replaces the file atomically, so the watcher could not see it
+	// partially written.
+	write := func(s string, mtime time.Time) {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(tmp, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Now()
+	write("deny 192.0.2.1\n", mtime)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 10)
+	f := NewIPFilter(IPRules{})
+	if err := f.Watch(ctx, path, time.Millisecond, func(err error) { errs <- err }); err != nil {
+		t.Fatal(err)
+	}
+	denied := func(addr string) bool {
+		return !f.Rules().Allowed(netip.MustParseAddr(addr))
+	}
+	if !denied("192.0.2.1") {
+		t.Fatalf("rules are not loaded")
+	}
+	wait := func(cond func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); !cond(); {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	mtime = mtime.Add(time.Second)
+	write("deny 192.0.2.2\n", mtime)
+	wait(func() bool { return denied("192.0.2.2") && !denied("192.0.2.1") })
+
+	// Broken file keeps the previous rules.
+	mtime = mtime.Add(time.Second)
+	write("deny garbage\n", mtime)
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !denied("192.0.2.2") {
+		t.Fatalf("rules are changed after failed reload")
+	}
+}
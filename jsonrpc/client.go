@@ -0,0 +1,163 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wsutil"
+)
+
+// Dialer contains options for connecting to JSON-RPC server.
+type Dialer struct {
+	// Dialer is used to establish WebSocket connection.
+	Dialer easyws.Dialer
+
+	// Server contains methods which could be called by the server. If nil,
+	// calls of the server fail with CodeMethodNotFound.
+	Server *Server
+
+	// Timeout is the Conn.Timeout of the connection.
+	Timeout time.Duration
+}
+
+// Dial is like Dialer{}.Dial().
+func Dial(ctx context.Context, urlstr string) (*Client, error) {
+	return Dialer{}.Dial(ctx, urlstr)
+}
+
+// Dial connects to JSON-RPC server at given WebSocket url.
+func (d Dialer) Dial(ctx context.Context, urlstr string) (*Client, error) {
+	conn, br, _, err := d.Dialer.Dial(ctx, urlstr)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClient(conn, br, d.Server)
+	c.Timeout = d.Timeout
+	return c, nil
+}
+
+// Client is a JSON-RPC connection to the server.
+type Client struct {
+	*Conn
+
+	conn net.Conn
+	wmu  sync.Mutex
+	done chan struct{}
+	err  error
+}
+
+// NewClient returns Client working over WebSocket connection conn, which
+// must be already upgraded. Br is an optional reader of the data buffered
+// during the handshake, as returned by easyws.Dialer. Methods of s could be
+// called by the server; s could be nil.
+//
+// NewClient starts a goroutine reading the connection until it is closed.
+func NewClient(conn net.Conn, br *bufio.Reader, s *Server) *Client {
+	c := &Client{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	c.Conn = newConn(s, c.write)
+	var r io.Reader = conn
+	if br != nil {
+		r = br
+	}
+	go c.read(r)
+	return c
+}
+
+func (c *Client) write(p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return wsutil.WriteClientText(c.conn, p)
+}
+
+func (c *Client) read(r io.Reader) {
+	defer close(c.done)
+	rd := wsutil.Reader{
+		Source:         r,
+		State:          easyws.StateClientSide,
+		CheckUTF8:      true,
+		OnIntermediate: c.control,
+	}
+	ctx := context.Background()
+	for {
+		msg, err := c.next(&rd)
+		if err != nil {
+			c.err = err
+			c.Conn.close()
+			c.conn.Close()
+			return
+		}
+		// Serve calls of the server concurrently, so they could call the
+		// server back.
+		go func() {
+			if resp := c.receive(ctx, msg); resp != nil {
+				c.write(resp)
+			}
+		}()
+	}
+}
+
+// next returns the next received data message, handling control frames.
+func (c *Client) next(rd *wsutil.Reader) ([]byte, error) {
+	for {
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := c.control(hdr, rd); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return io.ReadAll(rd)
+	}
+}
+
+// control handles control frame, sending the reply frame at once.
+func (c *Client) control(hdr easyws.Header, r io.Reader) error {
+	var buf bytes.Buffer
+	err := wsutil.ControlHandler{
+		Src:   r,
+		Dst:   &buf,
+		State: easyws.StateClientSide,
+	}.Handle(hdr)
+	if buf.Len() > 0 {
+		c.wmu.Lock()
+		c.conn.Write(buf.Bytes())
+		c.wmu.Unlock()
+	}
+	return err
+}
+
+// Close closes the connection. Pending calls fail with ErrClosed.
+func (c *Client) Close() error {
+	c.wmu.Lock()
+	err := wsutil.WriteClientMessage(c.conn, easyws.OpClose, easyws.NewCloseFrameBody(easyws.StatusNormalClosure, ""))
+	c.wmu.Unlock()
+	c.Conn.close()
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	<-c.done
+	return err
+}
+
+// Done returns a channel which is closed when the connection is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error the connection was closed with. It must be called
+// after Done is closed.
+func (c *Client) Err() error {
+	return c.err
+}
@@ -0,0 +1,296 @@
+// Package jsonrpc implements JSON-RPC 2.0 over easyws connections.
+//
+// Server serves methods to the clients connected to easyws server and is
+// able to call methods of the clients. Client is a connection made with
+// easyws.Dialer, which calls methods of the server and optionally serves
+// methods called by the server.
+//
+// Both sides support batch requests and notifications as defined by the
+// specification: https://www.jsonrpc.org/specification
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws/wscorr"
+)
+
+// Error codes defined by the specification.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Error is a JSON-RPC error object.
+//
+// Methods return Error to make the caller receive its code, message and
+// data. Other errors are reported as CodeInternalError without details.
+// Call returns Error received from the peer.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// NewError returns Error with given code and message.
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return "jsonrpc: " + e.Message + " (" + strconv.Itoa(e.Code) + ")"
+}
+
+// ErrClosed is returned by Call and Notify made on closed connection. Calls
+// waiting for the response when the connection is closed fail with it too.
+var ErrClosed = errors.New("jsonrpc: connection closed")
+
+// message is a request or response object.
+type message struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// response is the response object, which unlike message always contains
+// the id member.
+type response struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+var null = json.RawMessage("null")
+
+const version = "2.0"
+
+// Conn is one side of JSON-RPC connection. It makes calls to the peer and
+// serves calls made by the peer with the methods of its Server.
+//
+// Conn methods are safe for concurrent use.
+type Conn struct {
+	// Timeout limits the time Call waits for the response if the context
+	// passed to it has no deadline. Zero means no limit.
+	Timeout time.Duration
+
+	server *Server
+	send   func([]byte) error
+	corr   wscorr.Correlator[*message]
+	values sync.Map
+}
+
+func newConn(s *Server, send func([]byte) error) *Conn {
+	return &Conn{
+		server: s,
+		send:   send,
+	}
+}
+
+type connKey struct{}
+
+// ConnFromContext returns Conn which received the request handled with
+// ctx. It could be used by methods to call the caller back.
+func ConnFromContext(ctx context.Context) *Conn {
+	c, _ := ctx.Value(connKey{}).(*Conn)
+	return c
+}
+
+// Set stores a value within the connection.
+func (c *Conn) Set(key, value interface{}) {
+	c.values.Store(key, value)
+}
+
+// Get returns a value stored by Set.
+func (c *Conn) Get(key interface{}) (interface{}, bool) {
+	return c.values.Load(key)
+}
+
+// Call calls the method of the peer with given params and waits for the
+// response. The result is decoded into result, unless it is nil. Error
+// returned by the peer is returned as *Error.
+//
+// Call must not be made synchronously from the method served by the same
+// Conn: the response could not be received until the method returns.
+func (c *Conn) Call(ctx context.Context, method string, params, result interface{}) error {
+	if _, has := ctx.Deadline(); !has && c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	p, err := encodeParams(params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.corr.Do(ctx, func(id uint64) error {
+		req, err := json.Marshal(message{
+			Version: version,
+			ID:      json.RawMessage(strconv.FormatUint(id, 10)),
+			Method:  method,
+			Params:  p,
+		})
+		if err != nil {
+			return err
+		}
+		return c.send(req)
+	})
+	if err == wscorr.ErrClosed {
+		return ErrClosed
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// Notify sends notification of the method with given params to the peer.
+func (c *Conn) Notify(method string, params interface{}) error {
+	p, err := encodeParams(params)
+	if err != nil {
+		return err
+	}
+	if c.corr.Closed() {
+		return ErrClosed
+	}
+	req, err := json.Marshal(message{
+		Version: version,
+		Method:  method,
+		Params:  p,
+	})
+	if err != nil {
+		return err
+	}
+	return c.send(req)
+}
+
+func encodeParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	return json.Marshal(params)
+}
+
+// close fails pending calls and makes subsequent calls fail with ErrClosed.
+func (c *Conn) close() {
+	c.corr.Close()
+}
+
+// receive handles received message which is a single request or response
+// object or a batch of them. It returns the response to be sent, if any.
+func (c *Conn) receive(ctx context.Context, data []byte) []byte {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return encodeResponse(errorResponse(null, CodeParseError, "Parse error"))
+		}
+		if len(batch) == 0 {
+			return encodeResponse(errorResponse(null, CodeInvalidRequest, "Invalid Request"))
+		}
+		var resps []*response
+		for _, p := range batch {
+			if r := c.handle(ctx, p); r != nil {
+				resps = append(resps, r)
+			}
+		}
+		if len(resps) == 0 {
+			return nil
+		}
+		return encodeResponse(resps)
+	}
+	if !json.Valid(data) {
+		return encodeResponse(errorResponse(null, CodeParseError, "Parse error"))
+	}
+	if r := c.handle(ctx, data); r != nil {
+		return encodeResponse(r)
+	}
+	return nil
+}
+
+func encodeResponse(v interface{}) []byte {
+	p, err := json.Marshal(v)
+	if err != nil {
+		// Results are already encoded, so it could not happen.
+		panic(err)
+	}
+	return p
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *response {
+	return &response{
+		Version: version,
+		ID:      id,
+		Error:   NewError(code, msg),
+	}
+}
+
+// handle handles single object of the received message.
+func (c *Conn) handle(ctx context.Context, data []byte) *response {
+	var m message
+	if err := json.Unmarshal(data, &m); err != nil || m.Version != version {
+		id := m.ID
+		if err != nil || !validID(id) {
+			id = null
+		}
+		return errorResponse(id, CodeInvalidRequest, "Invalid Request")
+	}
+	if m.Method == "" && (m.Result != nil || m.Error != nil) {
+		c.resolve(&m)
+		return nil
+	}
+	if m.Method == "" || !validID(m.ID) {
+		id := m.ID
+		if !validID(id) {
+			id = null
+		}
+		return errorResponse(id, CodeInvalidRequest, "Invalid Request")
+	}
+
+	result, err := c.server.call(context.WithValue(ctx, connKey{}, c), m.Method, m.Params)
+	if m.ID == nil {
+		// Notifications are never answered.
+		return nil
+	}
+	if err != nil {
+		return &response{Version: version, ID: m.ID, Error: err}
+	}
+	return &response{Version: version, ID: m.ID, Result: result}
+}
+
+// validID reports whether id is absent, a string, a number or null.
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// resolve passes the response to the pending call it is for.
+func (c *Conn) resolve(m *message) {
+	if id, err := strconv.ParseUint(string(m.ID), 10, 64); err == nil {
+		c.corr.Resolve(id, m)
+	}
+}
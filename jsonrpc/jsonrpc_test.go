@@ -0,0 +1,321 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wstest"
+	"github.com/EternalVow/easyws/wsutil"
+)
+
+type sumParams struct {
+	A, B int
+}
+
+func newServer(t *testing.T) *Server {
+	s := NewServer()
+	Method(s, "sum", func(ctx context.Context, p sumParams) (int, error) {
+		return p.A + p.B, nil
+	})
+	err := s.Register("concat", func(ctx context.Context, args []string) (string, error) {
+		var r string
+		for _, a := range args {
+			r += a
+		}
+		return r, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("log", func(ctx context.Context, msg string) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("fail", func(ctx context.Context) error {
+		return errors.New("disk is full")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	Method(s, "deny", func(ctx context.Context, _ struct{}) (struct{}, error) {
+		return struct{}{}, &Error{Code: 403, Message: "Forbidden", Data: "admins only"}
+	})
+	return s
+}
+
+func TestRegister(t *testing.T) {
+	s := NewServer()
+	for _, fn := range []interface{}{
+		42,
+		func() error { return nil },
+		func(ctx context.Context) int { return 0 },
+		func(ctx context.Context, a, b int) error { return nil },
+		func(a int) error { return nil },
+	} {
+		if err := s.Register("m", fn); err == nil {
+			t.Errorf("no error for %T", fn)
+		}
+	}
+	if ms := s.Methods(); len(ms) != 0 {
+		t.Fatalf("unexpected methods registered: %v", ms)
+	}
+}
+
+func TestServer(t *testing.T) {
+	var internal []error
+	s := newServer(t)
+	s.OnError = func(ctx context.Context, method string, err error) {
+		internal = append(internal, err)
+	}
+	ws := wstest.Dial(t, s, wstest.Request{})
+
+	for _, test := range []struct {
+		name    string
+		in, out string
+	}{
+		{
+			"call",
+			`{"jsonrpc":"2.0","method":"sum","params":{"A":1,"B":2},"id":1}`,
+			`{"jsonrpc":"2.0","id":1,"result":3}`,
+		},
+		{
+			"positional params",
+			`{"jsonrpc":"2.0","method":"concat","params":["a","b"],"id":"x"}`,
+			`{"jsonrpc":"2.0","id":"x","result":"ab"}`,
+		},
+		{
+			"no result",
+			`{"jsonrpc":"2.0","method":"log","params":"hi","id":2}`,
+			`{"jsonrpc":"2.0","id":2,"result":null}`,
+		},
+		{
+			"notification",
+			`{"jsonrpc":"2.0","method":"log","params":"hi"}`,
+			``,
+		},
+		{
+			"unknown notification",
+			`{"jsonrpc":"2.0","method":"nope"}`,
+			``,
+		},
+		{
+			"method not found",
+			`{"jsonrpc":"2.0","method":"nope","id":3}`,
+			`{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"Method not found"}}`,
+		},
+		{
+			"invalid params",
+			`{"jsonrpc":"2.0","method":"sum","params":[1,2],"id":4}`,
+			`{"jsonrpc":"2.0","id":4,"error":{"code":-32602,"message":"Invalid params","data":"json: cannot unmarshal array into Go value of type jsonrpc.sumParams"}}`,
+		},
+		{
+			"internal error",
+			`{"jsonrpc":"2.0","method":"fail","id":5}`,
+			`{"jsonrpc":"2.0","id":5,"error":{"code":-32603,"message":"Internal error"}}`,
+		},
+		{
+			"custom error",
+			`{"jsonrpc":"2.0","method":"deny","id":6}`,
+			`{"jsonrpc":"2.0","id":6,"error":{"code":403,"message":"Forbidden","data":"admins only"}}`,
+		},
+		{
+			"parse error",
+			`{"jsonrpc":"2.0","method":"foobar,"params":"bar","baz]`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"Parse error"}}`,
+		},
+		{
+			"invalid request",
+			`{"jsonrpc":"2.0","method":1,"params":"bar"}`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid Request"}}`,
+		},
+		{
+			"wrong version",
+			`{"jsonrpc":"1.0","method":"sum","id":7}`,
+			`{"jsonrpc":"2.0","id":7,"error":{"code":-32600,"message":"Invalid Request"}}`,
+		},
+		{
+			"empty batch",
+			`[]`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid Request"}}`,
+		},
+		{
+			"invalid batch",
+			`[1,2]`,
+			`[{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid Request"}},{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid Request"}}]`,
+		},
+		{
+			"batch",
+			`[
+				{"jsonrpc":"2.0","method":"sum","params":{"A":1,"B":1},"id":"1"},
+				{"jsonrpc":"2.0","method":"log","params":"hi"},
+				{"foo":"boo"},
+				{"jsonrpc":"2.0","method":"nope","id":"2"}
+			]`,
+			`[{"jsonrpc":"2.0","id":"1","result":2},` +
+				`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid Request"}},` +
+				`{"jsonrpc":"2.0","id":"2","error":{"code":-32601,"message":"Method not found"}}]`,
+		},
+		{
+			"notifications batch",
+			`[{"jsonrpc":"2.0","method":"log","params":"a"},{"jsonrpc":"2.0","method":"log","params":"b"}]`,
+			``,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ws.Write(wstest.Text(test.in))
+			if test.out == "" {
+				ws.ExpectNothing()
+				return
+			}
+			ws.ExpectText(test.out)
+		})
+	}
+	if len(internal) != 1 || internal[0].Error() != "disk is full" {
+		t.Fatalf("unexpected OnError calls: %v", internal)
+	}
+}
+
+func TestServerCall(t *testing.T) {
+	s := newServer(t)
+	conns := make(chan *Conn, 1)
+	s.Connected = func(c *Conn) { conns <- c }
+	s.Timeout = time.Minute
+
+	ws := wstest.Dial(t, s, wstest.Request{})
+	c := <-conns
+
+	type result struct {
+		v   string
+		err error
+	}
+	results := make(chan result, 1)
+	call := func(ctx context.Context) {
+		go func() {
+			var v string
+			err := c.Call(ctx, "client.version", []int{1}, &v)
+			results <- result{v, err}
+		}()
+	}
+
+	call(context.Background())
+	if req := ws.WaitText(); req != `{"jsonrpc":"2.0","id":1,"method":"client.version","params":[1]}` {
+		t.Fatalf("unexpected request: %s", req)
+	}
+	ws.Write(wstest.Text(`{"jsonrpc":"2.0","id":1,"result":"1.2.3"}`))
+	if r := <-results; r.err != nil || r.v != "1.2.3" {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+
+	call(context.Background())
+	ws.WaitText()
+	ws.Write(wstest.Text(`{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"Method not found"}}`))
+	var e *Error
+	if r := <-results; !errors.As(r.err, &e) || e.Code != CodeMethodNotFound {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	call(ctx)
+	ws.WaitText()
+	if r := <-results; r.err != context.DeadlineExceeded {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+
+	if err := c.Notify("client.tick", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := ws.WaitText(); n != `{"jsonrpc":"2.0","method":"client.tick"}` {
+		t.Fatalf("unexpected notification: %s", n)
+	}
+
+	// Pending calls fail once the connection is closed.
+	call(context.Background())
+	ws.WaitText()
+	ws.Close()
+	if r := <-results; r.err != ErrClosed {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if err := c.Notify("client.tick", nil); err != ErrClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs := s.Conns(); len(cs) != 0 {
+		t.Fatalf("unexpected connections: %v", cs)
+	}
+}
+
+func TestClient(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	methods := NewServer()
+	Method(methods, "ping", func(ctx context.Context, p string) (string, error) {
+		// Call the server back while serving its call.
+		var v string
+		err := ConnFromContext(ctx).Call(ctx, "echo", p, &v)
+		return "pong " + v, err
+	})
+	c := NewClient(client, nil, methods)
+	c.Timeout = time.Second
+
+	read := func() map[string]interface{} {
+		t.Helper()
+		p, err := wsutil.ReadClientText(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(p, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	write := func(s string) {
+		t.Helper()
+		if err := wsutil.WriteServerText(server, []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	var sum int
+	go func() { done <- c.Call(context.Background(), "sum", sumParams{2, 3}, &sum) }()
+	if m := read(); m["method"] != "sum" || m["id"] != 1.0 {
+		t.Fatalf("unexpected request: %v", m)
+	}
+	write(`{"jsonrpc":"2.0","id":1,"result":5}`)
+	if err := <-done; err != nil || sum != 5 {
+		t.Fatalf("Call() = %d, %v", sum, err)
+	}
+
+	write(`{"jsonrpc":"2.0","id":"s1","method":"ping","params":"x"}`)
+	m := read()
+	if m["method"] != "echo" || m["params"] != "x" {
+		t.Fatalf("unexpected request: %v", m)
+	}
+	write(`{"jsonrpc":"2.0","id":2,"result":"x"}`)
+	if m := read(); m["id"] != "s1" || m["result"] != "pong x" {
+		t.Fatalf("unexpected response: %v", m)
+	}
+
+	go func() { done <- c.Call(context.Background(), "slow", nil, nil) }()
+	read()
+	// Server closes the connection.
+	if _, err := server.Write(easyws.CompiledCloseNormalClosure); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := easyws.ReadFrame(server); err != nil || f.Header.OpCode != easyws.OpClose {
+		t.Fatalf("unexpected close reply: %+v, %v", f.Header, err)
+	}
+	if err := <-done; err != ErrClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-c.Done()
+	var closed easyws.ClosedError
+	if !errors.As(c.Err(), &closed) {
+		t.Fatalf("unexpected error: %v", c.Err())
+	}
+}
@@ -0,0 +1,256 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+)
+
+// MethodFunc is a method which receives raw params and returns raw result.
+type MethodFunc func(ctx context.Context, params json.RawMessage) (json.RawMessage, error)
+
+// Server is a set of methods. It is an easyws.IEasyWs serving them to the
+// clients, and it could be also used by Client to serve the calls made by
+// the server.
+//
+// Methods must be registered before Server is served.
+type Server struct {
+	// Timeout is the default Conn.Timeout of the client connections.
+	Timeout time.Duration
+
+	// OnError is called for errors returned by methods which are not
+	// *Error. Such errors are reported to the caller as CodeInternalError
+	// without details.
+	OnError func(ctx context.Context, method string, err error)
+
+	// Connected and Disconnected are called when a client connection is
+	// opened and closed. Conn passed to Connected could be used to call
+	// methods of the client outside of the callback.
+	Connected    func(conn *Conn)
+	Disconnected func(conn *Conn)
+
+	methods map[string]MethodFunc
+
+	mu    sync.Mutex
+	conns map[*easyws.Conn]*Conn
+}
+
+// NewServer returns a new Server.
+func NewServer() *Server {
+	return &Server{
+		methods: make(map[string]MethodFunc),
+		conns:   make(map[*easyws.Conn]*Conn),
+	}
+}
+
+// HandleFunc registers the method with given name. It panics if the method
+// is already registered.
+func (s *Server) HandleFunc(name string, fn MethodFunc) {
+	if _, has := s.methods[name]; has {
+		panic(fmt.Sprintf("jsonrpc: multiple registrations for %q", name))
+	}
+	if s.methods == nil {
+		s.methods = make(map[string]MethodFunc)
+	}
+	s.methods[name] = fn
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Register registers the method with given name implemented by fn, which
+// must be a function of one of the forms:
+//
+//	func(ctx context.Context, params P) (R, error)
+//	func(ctx context.Context, params P) error
+//	func(ctx context.Context) (R, error)
+//	func(ctx context.Context) error
+//
+// Params are decoded into a value of type P with encoding/json; use slice
+// or array type to receive positional params. Result R is encoded with
+// encoding/json.
+//
+// See also Method, which is the type safe alternative.
+func (s *Server) Register(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func ||
+		t.NumIn() < 1 || t.NumIn() > 2 || t.In(0) != contextType ||
+		t.NumOut() < 1 || t.NumOut() > 2 || t.Out(t.NumOut()-1) != errorType {
+		return fmt.Errorf("jsonrpc: unsupported method type %T", fn)
+	}
+	var params reflect.Type
+	if t.NumIn() == 2 {
+		params = t.In(1)
+	}
+	s.HandleFunc(name, func(ctx context.Context, raw json.RawMessage) (json.RawMessage, error) {
+		args := []reflect.Value{reflect.ValueOf(ctx)}
+		if params != nil {
+			p := reflect.New(params)
+			if err := decodeParams(raw, p.Interface()); err != nil {
+				return nil, err
+			}
+			args = append(args, p.Elem())
+		}
+		out := v.Call(args)
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return nil, err
+		}
+		if len(out) == 1 {
+			return null, nil
+		}
+		return json.Marshal(out[0].Interface())
+	})
+	return nil
+}
+
+// Method registers the method with given name within s. See Server.Register
+// for the description of params and result.
+func Method[P, R any](s *Server, name string, fn func(ctx context.Context, params P) (R, error)) {
+	s.HandleFunc(name, func(ctx context.Context, raw json.RawMessage) (json.RawMessage, error) {
+		var p P
+		if err := decodeParams(raw, &p); err != nil {
+			return nil, err
+		}
+		r, err := fn(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(r)
+	})
+}
+
+func decodeParams(raw json.RawMessage, p interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, p); err != nil {
+		e := NewError(CodeInvalidParams, "Invalid params")
+		e.Data = err.Error()
+		return e
+	}
+	return nil
+}
+
+// Methods returns the sorted list of registered method names.
+func (s *Server) Methods() []string {
+	ms := make([]string, 0, len(s.methods))
+	for m := range s.methods {
+		ms = append(ms, m)
+	}
+	sort.Strings(ms)
+	return ms
+}
+
+// call calls the method. It is safe to call on nil Server.
+func (s *Server) call(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, *Error) {
+	var fn MethodFunc
+	if s != nil {
+		fn = s.methods[method]
+	}
+	if fn == nil {
+		return nil, NewError(CodeMethodNotFound, "Method not found")
+	}
+	result, err := fn(ctx, params)
+	if err == nil {
+		return result, nil
+	}
+	if e, ok := err.(*Error); ok {
+		return nil, e
+	}
+	if s.OnError != nil {
+		s.OnError(ctx, method, err)
+	}
+	return nil, NewError(CodeInternalError, "Internal error")
+}
+
+// Conn returns the JSON-RPC connection of the client connected with conn.
+// It returns nil if conn is not served by s or is closed.
+func (s *Server) Conn(conn *easyws.Conn) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[conn]
+}
+
+// Conns returns the JSON-RPC connections of all connected clients.
+func (s *Server) Conns() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs := make([]*Conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+// OnOpen implements easyws.IEasyWsConn.
+func (s *Server) OnOpen(conn *easyws.Conn) error {
+	c := newConn(s, func(p []byte) error {
+		return conn.WriteMessage(easyws.OpText, p)
+	})
+	c.Timeout = s.Timeout
+	s.mu.Lock()
+	if s.conns == nil {
+		s.conns = make(map[*easyws.Conn]*Conn)
+	}
+	s.conns[conn] = c
+	s.mu.Unlock()
+	if s.Connected != nil {
+		s.Connected(c)
+	}
+	return nil
+}
+
+// OnDisconnect implements easyws.IEasyWsConn.
+func (s *Server) OnDisconnect(conn *easyws.Conn, err error) {
+	s.mu.Lock()
+	c := s.conns[conn]
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	if c == nil {
+		return
+	}
+	c.close()
+	if s.Disconnected != nil {
+		s.Disconnected(c)
+	}
+}
+
+// OnMessage implements easyws.IEasyWsMessage.
+func (s *Server) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	c := s.Conn(conn)
+	if c == nil {
+		// Not served by NetHandler, so calls of the client are not
+		// possible.
+		c = newConn(s, func([]byte) error { return ErrClosed })
+		c.close()
+	}
+	ctx := context.Background()
+	if conn != nil {
+		ctx = conn.Context()
+	}
+	if resp := c.receive(ctx, msg); resp != nil {
+		return resp, easyws.OpText, nil
+	}
+	return nil, 0, nil
+}
+
+// OnReceive implements easyws.IEasyWs. Requests are served without
+// connection, so handlers can not call the client.
+func (s *Server) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return s.OnMessage(nil, 0, msg)
+}
+
+func (s *Server) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (s *Server) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (s *Server) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (s *Server) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (s *Server) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
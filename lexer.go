@@ -299,7 +299,7 @@ func RemoveByte(data []byte, c byte) []byte {
 	result := make([]byte, n)
 	k := copy(result, data[:j])
 
-	for i := j + 1; i < n; {
+	for i := j + 1; i < len(data); {
 		j = bytes.IndexByte(data[i:], c)
 		if j != -1 {
 			k += copy(result[k:], data[i:i+j])
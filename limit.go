@@ -0,0 +1,425 @@
+package easyws
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+// LimitAction is the action taken by NetHandler when a message exceeds the
+// per-connection rate limits.
+type LimitAction uint8
+
+const (
+	// LimitClose closes the connection with StatusPolicyViolation.
+	LimitClose LimitAction = iota
+	// LimitDrop silently drops the message.
+	LimitDrop
+	// LimitDelay delays handling of the message until the limits allow it.
+	// Messages received meanwhile are held and handled in order after it;
+	// if more than 1MiB is held, the connection is closed as with
+	// LimitClose. The event loop is not blocked: delayed message is handled
+	// by a timer, or, if easynet plugin could not write outside of the event
+	// loop (like "Evio"), when more data is received after the delay.
+	LimitDelay
+)
+
+// maxHeldSize limits the data held while a message is delayed.
+const maxHeldSize = 1 << 20
+
+// Limits describes limits enforced by Limiter. Zero values mean no limit.
+type Limits struct {
+	// MessagesPerSecond and BytesPerSecond are the rates of data messages
+	// and their payload bytes allowed for each connection. MessageBurst and
+	// BytesBurst are the sizes of corresponding token buckets; if zero, the
+	// rate is used as a burst size.
+	MessagesPerSecond float64
+	MessageBurst      int
+	BytesPerSecond    float64
+	BytesBurst        int
+
+	// Action is the action taken on messages exceeding the rates above.
+	Action LimitAction
+
+	// MaxConns and MaxConnsPerIP limit the number of concurrent connections
+	// globally and from a single IP address.
+	MaxConns      int
+	MaxConnsPerIP int
+
+	// HandshakesPerSecondPerIP is the rate of handshakes allowed from a
+	// single IP address. HandshakeBurst is the size of the token bucket; if
+	// zero, the rate is used as a burst size.
+	HandshakesPerSecondPerIP float64
+	HandshakeBurst           int
+}
+
+// Errors returned by NetHandler when limits are exceeded.
+var (
+	ErrConnLimit = errors.New("connection limit exceeded")
+
+	ErrHandshakeRateLimit = RejectConnectionError(
+		RejectionStatus(http.StatusTooManyRequests),
+		RejectionReason("handshake error: too many requests"),
+	)
+)
+
+// Limiter enforces Limits on connections served by NetHandler. Limits could
+// be adjusted at runtime with SetLimits.
+//
+// Limiter must be used by a single NetHandler.
+type Limiter struct {
+	limits atomic.Pointer[Limits]
+
+	conns atomic.Int64
+
+	mu         sync.Mutex
+	ips        map[string]int
+	handshakes map[string]*tokenBucket
+	lastSweep  int64
+
+	exceeded [limitKindsCount]atomic.Uint64
+
+	// now is used for testing.
+	now func() int64
+}
+
+// limitKind enumerates limits which could be exceeded.
+type limitKind int
+
+const (
+	limitMessages limitKind = iota
+	limitBytes
+	limitConns
+	limitConnsPerIP
+	limitHandshakes
+
+	limitKindsCount
+)
+
+var limitKindNames = [limitKindsCount]string{
+	limitMessages:   "messages",
+	limitBytes:      "bytes",
+	limitConns:      "connections",
+	limitConnsPerIP: "connections_per_ip",
+	limitHandshakes: "handshakes",
+}
+
+// NewLimiter creates Limiter enforcing given limits.
+func NewLimiter(limits Limits) *Limiter {
+	l := &Limiter{
+		ips:        map[string]int{},
+		handshakes: map[string]*tokenBucket{},
+		now:        func() int64 { return time.Now().UnixNano() },
+	}
+	l.SetLimits(limits)
+	return l
+}
+
+// WithLimiter returns an option that makes NetHandler to enforce limits with
+// l. If metrics are collected, the number of exceeded limits is exposed as
+// well.
+func WithLimiter(l *Limiter) ServerOption {
+	return func(h *NetHandler) {
+		h.Limiter = l
+	}
+}
+
+// SetLimits replaces the limits. It is safe to call SetLimits concurrently
+// with connections being served. New limits apply to the existing
+// connections too; already open connections are not closed though.
+func (l *Limiter) SetLimits(limits Limits) {
+	l.limits.Store(&limits)
+}
+
+// Limits returns current limits.
+func (l *Limiter) Limits() Limits {
+	return *l.limits.Load()
+}
+
+// Conns returns the number of connections currently admitted by l.
+func (l *Limiter) Conns() int {
+	return int(l.conns.Load())
+}
+
+// connect reports whether connection from ip could be admitted. If it
+// returns true, disconnect must be called when connection is closed.
+func (l *Limiter) connect(ip string) bool {
+	limits := l.limits.Load()
+	if n := l.conns.Add(1); limits.MaxConns > 0 && n > int64(limits.MaxConns) {
+		l.conns.Add(-1)
+		l.exceeded[limitConns].Add(1)
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := l.ips[ip]; limits.MaxConnsPerIP > 0 && n >= limits.MaxConnsPerIP {
+		l.conns.Add(-1)
+		l.exceeded[limitConnsPerIP].Add(1)
+		return false
+	}
+	l.ips[ip]++
+	return true
+}
+
+func (l *Limiter) disconnect(ip string) {
+	l.conns.Add(-1)
+	l.mu.Lock()
+	if n := l.ips[ip] - 1; n > 0 {
+		l.ips[ip] = n
+	} else {
+		delete(l.ips, ip)
+	}
+	l.mu.Unlock()
+}
+
+// move moves connection admitted from ip to the newIP. If newIP exceeds the
+// per-IP limit, it reports false and the connection is disconnected.
+func (l *Limiter) move(ip, newIP string) bool {
+	if ip == newIP {
+		return true
+	}
+	l.disconnect(ip)
+	return l.connect(newIP)
+}
+
+// handshake reports whether handshake from ip is allowed.
+func (l *Limiter) handshake(ip string) bool {
+	limits := l.limits.Load()
+	rate := limits.HandshakesPerSecondPerIP
+	if rate <= 0 {
+		return true
+	}
+	burst := burstSize(rate, limits.HandshakeBurst)
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now, rate, burst)
+	b := l.handshakes[ip]
+	if b == nil {
+		b = &tokenBucket{}
+		l.handshakes[ip] = b
+	}
+	if !b.allow(1, rate, burst, now) {
+		l.exceeded[limitHandshakes].Add(1)
+		return false
+	}
+	return true
+}
+
+// sweep drops handshake buckets which are full, that is, equal to the new
+// ones. It does it at most once per second.
+func (l *Limiter) sweep(now int64, rate, burst float64) {
+	if now-l.lastSweep < int64(time.Second) {
+		return
+	}
+	l.lastSweep = now
+	for ip, b := range l.handshakes {
+		if b.refill(rate, burst, now) >= burst {
+			delete(l.handshakes, ip)
+		}
+	}
+}
+
+// message applies limits to the data message of n bytes received by c. It
+// reports whether message could be handled. If limits action is LimitDelay,
+// message takes the tokens in advance and must be handled after wait.
+func (l *Limiter) message(c *Conn, n int) (ok bool, action LimitAction, wait time.Duration) {
+	limits := l.limits.Load()
+	if limits.MessagesPerSecond <= 0 && limits.BytesPerSecond <= 0 {
+		return true, 0, 0
+	}
+	now := l.now()
+	buckets := [...]struct {
+		kind  limitKind
+		b     *tokenBucket
+		n     float64
+		rate  float64
+		burst float64
+	}{
+		{limitMessages, &c.messages, 1, limits.MessagesPerSecond, burstSize(limits.MessagesPerSecond, limits.MessageBurst)},
+		{limitBytes, &c.bytes, float64(n), limits.BytesPerSecond, burstSize(limits.BytesPerSecond, limits.BytesBurst)},
+	}
+	// Check all buckets first, so that message rejected by one of them
+	// does not take tokens of the others.
+	for _, x := range buckets {
+		if x.rate <= 0 {
+			continue
+		}
+		d := x.b.wait(x.n, x.rate, x.burst, now)
+		if d == 0 {
+			continue
+		}
+		l.exceeded[x.kind].Add(1)
+		if limits.Action != LimitDelay {
+			return false, limits.Action, 0
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+	for _, x := range buckets {
+		if x.rate > 0 {
+			x.b.tokens -= x.n
+		}
+	}
+	return true, limits.Action, wait
+}
+
+// delayedMessage is a data message delayed by Limiter.
+type delayedMessage struct {
+	op    OpCode
+	msg   []byte
+	at    time.Time
+	timer *time.Timer
+}
+
+// delay makes message to be handled after wait. If c could be written from
+// other goroutines, it is handled by a timer, otherwise when more data is
+// received.
+func (h *NetHandler) delay(c *Conn, op OpCode, msg []byte, wait time.Duration) {
+	d := &delayedMessage{
+		op:  op,
+		msg: append([]byte(nil), msg...),
+		at:  time.Now().Add(wait),
+	}
+	if c.push {
+		d.timer = time.AfterFunc(wait, func() { h.resume(c, d) })
+	}
+	c.delayed = d
+}
+
+// undelay handles the delayed message of c.
+func (h *NetHandler) undelay(c *Conn, out []byte) ([]byte, error) {
+	d := c.delayed
+	c.delayed = nil
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	return h.handle(c, out, d.op, d.msg)
+}
+
+// resume handles delayed message d and the data held after it. It is called
+// by the timer, outside of the event loop, so the replies are sent with
+// Conn.send.
+func (h *NetHandler) resume(c *Conn, d *delayedMessage) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	c.wmu.Lock()
+	closed := c.writeClosed
+	c.wmu.Unlock()
+	if closed || c.closed || c.delayed != d {
+		// Connection is closed or message is handled by OnReceive.
+		return
+	}
+	c.resuming = true
+	defer func() { c.resuming = false }()
+
+	var stream base.InputStream
+	stream.Begin(c.held)
+	c.held = nil
+	out, err := h.undelay(c, nil)
+	if err == nil && !c.closed {
+		out, err = h.receive(c, &stream, out)
+	}
+	if c.closed {
+		return
+	}
+	// Keep incomplete frame until more data is received.
+	c.held = append(c.held, stream.Begin(nil)...)
+	if err != nil {
+		h.close(c, out)
+		return
+	}
+	if len(out) > 0 {
+		c.wmu.Lock()
+		if !c.writeClosed {
+			err = c.send(out)
+		}
+		c.wmu.Unlock()
+	}
+	if err != nil {
+		h.close(c, nil)
+	}
+}
+
+// collect writes Limiter metrics in Prometheus text format.
+func (l *Limiter) collect(w io.Writer) {
+	bw, ok := w.(metricWriter)
+	if !ok {
+		buf := bufio.NewWriter(w)
+		defer buf.Flush()
+		bw = buf
+	}
+	const name = "easyws_limit_exceeded_total"
+	family(bw, name, "counter", "Total number of times limits were exceeded by kind.")
+	for i := range l.exceeded {
+		sample(bw, name, label("limit", limitKindNames[i]), float64(l.exceeded[i].Load()))
+	}
+	family(bw, "easyws_limiter_connections", "gauge", "Number of connections admitted by limiter.")
+	sample(bw, "easyws_limiter_connections", "", float64(l.conns.Load()))
+}
+
+func burstSize(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// remoteIP returns IP part of the remote address.
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// tokenBucket implements token bucket algorithm. Zero tokenBucket is full.
+type tokenBucket struct {
+	tokens float64
+	last   int64
+}
+
+// refill adds tokens accumulated since the last update and returns the
+// number of tokens.
+func (b *tokenBucket) refill(rate, burst float64, now int64) float64 {
+	if b.last == 0 {
+		b.tokens = burst
+	} else if elapsed := now - b.last; elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+rate*float64(elapsed)/float64(time.Second))
+	}
+	b.last = now
+	return b.tokens
+}
+
+// allow takes n tokens if possible. Requests larger than burst are allowed
+// when the bucket is full, making the bucket debt.
+func (b *tokenBucket) allow(n, rate, burst float64, now int64) bool {
+	if b.wait(n, rate, burst, now) > 0 {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// wait returns the time after which n tokens could be taken. It does not
+// take them.
+func (b *tokenBucket) wait(n, rate, burst float64, now int64) time.Duration {
+	lack := math.Min(n, burst) - b.refill(rate, burst, now)
+	if lack <= 0 {
+		return 0
+	}
+	if d := time.Duration(lack / rate * float64(time.Second)); d > 0 {
+		return d
+	}
+	return 1
+}
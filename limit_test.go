@@ -0,0 +1,250 @@
+package easyws
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+func TestLimiterConns(t *testing.T) {
+	l := NewLimiter(Limits{MaxConns: 3, MaxConnsPerIP: 2})
+	h := NewNetHandler(benchEcho{}, WithLimiter(l))
+	for _, test := range []struct {
+		addr string
+		err  error
+	}{
+		{"192.0.2.1:1", nil},
+		{"192.0.2.1:2", nil},
+		{"192.0.2.1:3", ErrConnLimit},
+		{"192.0.2.2:1", nil},
+		{"192.0.2.3:1", ErrConnLimit},
+	} {
+		if err := h.OnConnect(testConn(test.addr)); err != test.err {
+			t.Fatalf("OnConnect(%s) = %v; want %v", test.addr, err, test.err)
+		}
+	}
+	h.OnClose(testConn("192.0.2.1:1"), nil)
+	if err := h.OnConnect(testConn("192.0.2.3:1")); err != nil {
+		t.Fatalf("OnConnect() after close = %v", err)
+	}
+
+	// Limits could be relaxed at runtime.
+	l.SetLimits(Limits{})
+	if err := h.OnConnect(testConn("192.0.2.1:4")); err != nil {
+		t.Fatalf("OnConnect() after SetLimits() = %v", err)
+	}
+	if n := l.Conns(); n != 4 {
+		t.Fatalf("Conns() = %d; want 4", n)
+	}
+}
+
+func TestLimiterHandshakes(t *testing.T) {
+	var now int64 = 1
+	l := NewLimiter(Limits{HandshakesPerSecondPerIP: 1, HandshakeBurst: 2})
+	l.now = func() int64 { return now }
+	m := NewMetrics()
+	h := NewNetHandler(benchEcho{}, WithLimiter(l), WithMetrics(m))
+
+	req := []byte("GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"\r\n",
+	)
+	handshake := func(addr string) error {
+		stream := &base.InputStream{}
+		stream.Begin(req)
+		_, err := h.OnReceive(testConn(addr), stream)
+		h.OnClose(testConn(addr), err)
+		return err
+	}
+	for i, exp := range []error{nil, nil, ErrHandshakeRateLimit} {
+		if err := handshake("192.0.2.1:1"); err != exp {
+			t.Fatalf("#%d handshake error is %v; want %v", i, err, exp)
+		}
+	}
+	if err := handshake("192.0.2.2:1"); err != nil {
+		t.Fatalf("handshake from other ip error is %v", err)
+	}
+	now += int64(time.Second)
+	if err := handshake("192.0.2.1:1"); err != nil {
+		t.Fatalf("handshake after a second error is %v", err)
+	}
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	if exp := `easyws_handshake_rejections_total{reason="rate_limit"} 1`; !strings.Contains(buf.String(), exp) {
+		t.Fatalf("no %s in metrics:\n%s", exp, buf.String())
+	}
+}
+
+func TestLimiterMessages(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		limits Limits
+		sent   int
+		err    error
+	}{
+		{
+			name:   "drop",
+			limits: Limits{MessagesPerSecond: 2, Action: LimitDrop},
+			sent:   2,
+		},
+		{
+			name:   "close",
+			limits: Limits{BytesPerSecond: 10, Action: LimitClose},
+			sent:   2,
+			err:    ClosedError{Code: StatusPolicyViolation, Reason: "rate limit exceeded"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := NewLimiter(test.limits)
+			l.now = func() int64 { return 1 }
+			m := NewMetrics()
+			h, stream := upgradedHandler(t, WithLimiter(l), WithMetrics(m))
+
+			var err error
+			for i := 0; i < 4 && err == nil; i++ {
+				stream.Begin(maskedFrame(OpText, []byte("hello")))
+				_, err = h.OnReceive(benchConn{}, stream)
+			}
+			if err != test.err {
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			}
+
+			var buf bytes.Buffer
+			m.WriteTo(&buf)
+			sent := `easyws_frames_sent_total{opcode="text"} `
+			if i := strings.Index(buf.String(), sent); i == -1 || buf.String()[i+len(sent)]-'0' != byte(test.sent) {
+				t.Fatalf("unexpected number of replies; want %d:\n%s", test.sent, buf.String())
+			}
+			if !strings.Contains(buf.String(), "easyws_limit_exceeded_total{") {
+				t.Fatalf("no limiter metrics:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestLimiterBuckets(t *testing.T) {
+	l := NewLimiter(Limits{
+		MessagesPerSecond: 10,
+		MessageBurst:      2,
+		BytesPerSecond:    10,
+		Action:            LimitDrop,
+	})
+	l.now = func() int64 { return 1 }
+	c := &Conn{}
+	for i, test := range []struct {
+		n  int
+		ok bool
+	}{
+		{8, true},
+		// Rejected by the bytes bucket, so the message token is kept.
+		{5, false},
+		{2, true},
+		{1, false},
+	} {
+		if ok, _, _ := l.message(c, test.n); ok != test.ok {
+			t.Fatalf("message #%d of %d bytes allowed is %t; want %t", i, test.n, ok, test.ok)
+		}
+	}
+}
+
+func TestLimiterDelay(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	for _, test := range []struct {
+		name string
+		push bool
+	}{
+		{"timer", true},
+		{"receive", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := NewLimiter(Limits{MessagesPerSecond: float64(time.Second / interval), MessageBurst: 1, Action: LimitDelay})
+			h := NewNetHandler(benchEcho{}, WithLimiter(l))
+			conn := &recordConn{addr: "192.0.2.1:1"}
+			h.OnConnect(conn)
+			h.conn(conn).push = test.push
+			stream := &base.InputStream{}
+			stream.Begin([]byte(handshakeRequest))
+			if _, err := h.OnReceive(conn, stream); err != nil {
+				t.Fatal(err)
+			}
+			conn.take()
+
+			start := time.Now()
+			var in []byte
+			for _, s := range []string{"1", "2", "3"} {
+				in = append(in, maskedFrame(OpText, []byte(s))...)
+			}
+			stream.Begin(in)
+			out, err := h.OnReceive(conn, stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Returned bytes are reused by OnReceive.
+			out = append([]byte(nil), out...)
+			// Messages received during the delay are held.
+			stream.Begin(maskedFrame(OpText, []byte("4")))
+			held, err := h.OnReceive(conn, stream)
+			if err != nil || len(held) != 0 {
+				t.Fatalf("OnReceive() during delay returned %q, %v", held, err)
+			}
+
+			for deadline := time.Now().Add(5 * time.Second); len(out) < 4*3; {
+				if time.Now().After(deadline) {
+					t.Fatalf("delayed messages are not handled: %q", out)
+				}
+				time.Sleep(interval / 5)
+				if test.push {
+					sent, _ := conn.take()
+					out = append(out, sent...)
+					continue
+				}
+				// Plugin could not be written from the timer, so messages
+				// are handled on receive.
+				stream.Begin(nil)
+				p, err := h.OnReceive(conn, stream)
+				if err != nil {
+					t.Fatal(err)
+				}
+				out = append(out, p...)
+			}
+			if elapsed := time.Since(start); elapsed < 3*interval {
+				t.Fatalf("messages are handled in %s; want at least %s", elapsed, 3*interval)
+			}
+			br := bytes.NewReader(out)
+			for _, exp := range []string{"1", "2", "3", "4"} {
+				f, err := ReadFrame(br)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(f.Payload) != exp {
+					t.Fatalf("unexpected message %q; want %q", f.Payload, exp)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterDelayHeld(t *testing.T) {
+	l := NewLimiter(Limits{MessagesPerSecond: 1, Action: LimitDelay})
+	h, stream := upgradedHandler(t, WithLimiter(l))
+	stream.Begin(append(maskedFrame(OpText, []byte("1")), maskedFrame(OpText, []byte("2"))...))
+	if _, err := h.OnReceive(benchConn{}, stream); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	for i := 0; i < 32 && err == nil; i++ {
+		stream.Begin(maskedFrame(OpBinary, make([]byte, 64<<10)))
+		_, err = h.OnReceive(benchConn{}, stream)
+	}
+	if exp := (ClosedError{Code: StatusPolicyViolation, Reason: "rate limit exceeded"}); err != exp {
+		t.Fatalf("unexpected error: %v; want %v", err, exp)
+	}
+}
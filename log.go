@@ -0,0 +1,182 @@
+package easyws
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel is the importance of the logged event. Its values match the
+// values of log/slog levels.
+type LogLevel int
+
+// Levels of the logged events.
+const (
+	LevelDebug LogLevel = -4
+	LevelInfo  LogLevel = 0
+	LevelWarn  LogLevel = 4
+	LevelError LogLevel = 8
+)
+
+// String returns textual representation of the level.
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Field is a key-value pair attached to the logged event.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Keys of the fields attached to the events logged by NetHandler.
+const (
+	FieldConnID      = "conn_id"
+	FieldRemoteAddr  = "remote_addr"
+	FieldPath        = "path"
+	FieldSubprotocol = "subprotocol"
+	FieldError       = "error"
+)
+
+// Logger is the interface used by NetHandler to log connection events such
+// as handshakes, protocol and handler errors and closures.
+//
+// Fields passed to Log are valid only until Log returns.
+//
+// See wslog package for log/slog and zap adapters.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...Field)
+}
+
+// LoggerFunc is an adapter to allow the use of ordinary functions as Logger.
+type LoggerFunc func(level LogLevel, msg string, fields ...Field)
+
+// Log implements Logger.
+func (f LoggerFunc) Log(level LogLevel, msg string, fields ...Field) {
+	f(level, msg, fields...)
+}
+
+// LogConfig contains options of logging made by NetHandler.
+type LogConfig struct {
+	// Level is the minimum level of events to be logged.
+	Level LogLevel
+
+	// SampleFirst and SampleThereafter configure sampling of the events. If
+	// SampleFirst is non-zero, then within each SampleTick only first
+	// SampleFirst events with the same message are logged, and then only
+	// every SampleThereafter-th of them. Zero SampleThereafter drops the rest
+	// of events within the tick.
+	//
+	// If SampleFirst is zero, then events are not sampled.
+	SampleFirst, SampleThereafter int
+
+	// SampleTick is the sampling interval. If zero, then one second is used.
+	SampleTick time.Duration
+}
+
+// WithLogger returns an option that makes NetHandler to log connection events
+// to l as configured by config.
+func WithLogger(l Logger, config LogConfig) ServerOption {
+	return func(h *NetHandler) {
+		h.log = &logger{
+			logger: l,
+			config: config,
+			tick:   int64(nonZeroDuration(config.SampleTick, time.Second)),
+		}
+	}
+}
+
+// logEvent enumerates events logged by NetHandler.
+type logEvent int
+
+const (
+	logHandshake logEvent = iota
+	logHandshakeRejected
+	logProtocolError
+	logHandlerError
+	logClosed
+
+	logEventsCount
+)
+
+var logEvents = [logEventsCount]struct {
+	level LogLevel
+	msg   string
+}{
+	logHandshake:         {LevelInfo, "websocket handshake"},
+	logHandshakeRejected: {LevelWarn, "websocket handshake rejected"},
+	logProtocolError:     {LevelWarn, "websocket protocol error"},
+	logHandlerError:      {LevelError, "websocket handler error"},
+	logClosed:            {LevelInfo, "websocket connection closed"},
+}
+
+// logger logs events of NetHandler. Nil *logger is valid and logs nothing.
+type logger struct {
+	logger Logger
+	config LogConfig
+	tick   int64
+
+	counters [logEventsCount]struct {
+		n     atomic.Uint64
+		reset atomic.Int64
+	}
+}
+
+// log logs the event e related to c with additional fields.
+func (l *logger) log(e logEvent, c *Conn, err error) {
+	if l == nil {
+		return
+	}
+	ev := &logEvents[e]
+	if ev.level < l.config.Level || !l.sample(e) {
+		return
+	}
+	fields := [...]Field{
+		{FieldConnID, c.id},
+		{FieldRemoteAddr, c.remote},
+		{FieldPath, c.Path()},
+		{FieldSubprotocol, c.hs.Protocol},
+		{FieldError, err},
+	}
+	n := len(fields)
+	if err == nil {
+		n--
+	}
+	l.logger.Log(ev.level, ev.msg, fields[:n]...)
+}
+
+// sample reports whether event e should be logged.
+func (l *logger) sample(e logEvent) bool {
+	first := uint64(l.config.SampleFirst)
+	if first == 0 {
+		return true
+	}
+	c := &l.counters[e]
+	now := time.Now().UnixNano()
+	if r := c.reset.Load(); now > r && c.reset.CompareAndSwap(r, now+l.tick) {
+		c.n.Store(0)
+	}
+	n := c.n.Add(1)
+	if n <= first {
+		return true
+	}
+	every := uint64(l.config.SampleThereafter)
+	return every != 0 && (n-first)%every == 0
+}
+
+func nonZeroDuration(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,93 @@
+package easyws
+
+import (
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+func recordLogger(entries *[]logEntry) Logger {
+	return LoggerFunc(func(level LogLevel, msg string, fields ...Field) {
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			m[f.Key] = f.Value
+		}
+		*entries = append(*entries, logEntry{level, msg, m})
+	})
+}
+
+func TestNetHandlerLog(t *testing.T) {
+	var entries []logEntry
+	h := NewNetHandler(benchEcho{}, WithLogger(recordLogger(&entries), LogConfig{
+		Level: LevelInfo,
+	}))
+	stream := &base.InputStream{}
+	stream.Begin([]byte("GET /chat?room=1 HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"\r\n",
+	))
+	h.OnConnect(benchConn{})
+	if _, err := h.OnReceive(benchConn{}, stream); err != nil {
+		t.Fatal(err)
+	}
+	// Unmasked frame from client is a protocol error.
+	stream.Begin(MustCompileFrame(NewTextFrame([]byte("hello"))))
+	err := ClosedError{Code: StatusProtocolError, Reason: ErrProtocolMaskRequired.Error()}
+	if _, act := h.OnReceive(benchConn{}, stream); act != err {
+		t.Fatalf("unexpected error: %v", act)
+	}
+	h.OnClose(benchConn{}, err)
+
+	exp := []struct {
+		level LogLevel
+		msg   string
+	}{
+		{LevelInfo, "websocket handshake"},
+		{LevelWarn, "websocket protocol error"},
+		{LevelInfo, "websocket connection closed"},
+	}
+	if len(entries) != len(exp) {
+		t.Fatalf("unexpected log entries: %+v", entries)
+	}
+	for i, e := range entries {
+		if e.level != exp[i].level || e.msg != exp[i].msg {
+			t.Errorf("#%d entry is %v %q; want %v %q", i, e.level, e.msg, exp[i].level, exp[i].msg)
+		}
+		if e.fields[FieldRemoteAddr] != "192.0.2.1:49152" || e.fields[FieldPath] != "/chat" {
+			t.Errorf("#%d entry has unexpected fields: %v", i, e.fields)
+		}
+	}
+	if entries[1].fields[FieldError] != err {
+		t.Errorf("unexpected error field: %v", entries[1].fields[FieldError])
+	}
+}
+
+func TestLoggerSample(t *testing.T) {
+	var entries []logEntry
+	l := &logger{
+		logger: recordLogger(&entries),
+		config: LogConfig{
+			SampleFirst:      2,
+			SampleThereafter: 3,
+		},
+		tick: int64(1 << 62),
+	}
+	c := &Conn{}
+	for i := 0; i < 10; i++ {
+		l.log(logHandlerError, c, nil)
+	}
+	// First two events and then 5th and 8th.
+	if n := len(entries); n != 4 {
+		t.Fatalf("logged %d events; want 4", n)
+	}
+}
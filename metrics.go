@@ -0,0 +1,408 @@
+package easyws
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics collects statistics of connections served by NetHandler and
+// exposes them in Prometheus text format.
+//
+// Zero Metrics is not ready to use; NewMetrics must be used instead. Nil
+// *Metrics is valid and collects nothing.
+//
+// Metrics implements http.Handler, so it could be mounted on any HTTP server:
+//
+//	m := easyws.NewMetrics()
+//	ws := easyws.NewEasyWs(handler, ip, port, easyws.WithMetrics(m))
+//	http.ListenAndServe(":9090", m)
+type Metrics struct {
+	connActive atomic.Int64
+	connTotal  atomic.Uint64
+
+	handshakes atomic.Uint64
+	rejections [len(handshakeRejections)]atomic.Uint64
+
+	framesIn  [16]atomic.Uint64
+	framesOut [16]atomic.Uint64
+	bytesIn   [16]atomic.Uint64
+	bytesOut  [16]atomic.Uint64
+
+	mu         sync.Mutex
+	closesIn   map[StatusCode]uint64
+	closesOut  map[StatusCode]uint64
+	collectors []func(w io.Writer)
+
+	messageSize    *histogram
+	handlerLatency *histogram
+}
+
+// Default buckets of the histograms collected by Metrics.
+var (
+	// DefaultMessageSizeBuckets holds upper bounds in bytes.
+	DefaultMessageSizeBuckets = []uint64{
+		64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20,
+	}
+	// DefaultLatencyBuckets holds upper bounds of handler latency.
+	DefaultLatencyBuckets = []time.Duration{
+		50 * time.Microsecond,
+		100 * time.Microsecond,
+		500 * time.Microsecond,
+		time.Millisecond,
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+	}
+)
+
+// NewMetrics creates Metrics with default histogram buckets.
+func NewMetrics() *Metrics {
+	latency := make([]uint64, len(DefaultLatencyBuckets))
+	for i, d := range DefaultLatencyBuckets {
+		latency[i] = uint64(d)
+	}
+	return &Metrics{
+		closesIn:       map[StatusCode]uint64{},
+		closesOut:      map[StatusCode]uint64{},
+		messageSize:    newHistogram(DefaultMessageSizeBuckets, 1),
+		handlerLatency: newHistogram(latency, float64(time.Second)),
+	}
+}
+
+// WithMetrics returns an option that makes NetHandler to collect statistics
+// into m.
+func WithMetrics(m *Metrics) ServerOption {
+	return func(h *NetHandler) {
+		h.Metrics = m
+	}
+}
+
+// Indices of the handshakeRejections items describing rejections made by
+// user-defined hooks.
+const (
+	rejectionUnauthorized = iota
+	rejectionForbidden
+	rejectionOther
+)
+
+// handshakeRejections maps handshake errors to the values of "reason" label.
+var handshakeRejections = [...]struct {
+	err    error
+	reason string
+}{
+	rejectionUnauthorized: {nil, "unauthorized"},
+	rejectionForbidden:    {nil, "forbidden"},
+	rejectionOther:        {nil, "other"},
+
+	{ErrHandshakeBadProtocol, "bad_protocol"},
+	{ErrHandshakeBadMethod, "bad_method"},
+	{ErrHandshakeBadHost, "bad_host"},
+	{ErrHandshakeBadUpgrade, "bad_upgrade"},
+	{ErrHandshakeBadConnection, "bad_connection"},
+	{ErrHandshakeBadSecAccept, "bad_sec_accept"},
+	{ErrHandshakeBadSecKey, "bad_sec_key"},
+	{ErrHandshakeBadSecVersion, "bad_sec_version"},
+	{ErrHandshakeUpgradeRequired, "upgrade_required"},
+	{ErrMalformedRequest, "malformed_request"},
+	{ErrHandshakeBadOrigin, "bad_origin"},
+	{ErrHandshakeBadCSRFToken, "bad_csrf_token"},
+	{ErrHandshakeIPDenied, "ip_denied"},
+	{ErrHandshakeNoProtocol, "no_protocol"},
+	{ErrHandshakeRateLimit, "rate_limit"},
+}
+
+func (m *Metrics) connOpened() {
+	if m == nil {
+		return
+	}
+	m.connActive.Add(1)
+	m.connTotal.Add(1)
+}
+
+func (m *Metrics) connClosed() {
+	if m == nil {
+		return
+	}
+	m.connActive.Add(-1)
+}
+
+func (m *Metrics) handshake(err error) {
+	if m == nil {
+		return
+	}
+	if err == nil {
+		m.handshakes.Add(1)
+		return
+	}
+	m.rejections[rejectionIndex(err)].Add(1)
+}
+
+// rejectionIndex returns index of the handshakeRejections item matching
+// err.
+func rejectionIndex(err error) int {
+	for i, r := range handshakeRejections {
+		if r.err != nil && r.err == err {
+			return i
+		}
+	}
+	if rej, ok := err.(*ConnectionRejectedError); ok {
+		switch rej.code {
+		case http.StatusUnauthorized:
+			return rejectionUnauthorized
+		case http.StatusForbidden:
+			return rejectionForbidden
+		}
+	}
+	return rejectionOther
+}
+
+func (m *Metrics) frameIn(h Header) {
+	if m == nil {
+		return
+	}
+	m.framesIn[h.OpCode&0xf].Add(1)
+	m.bytesIn[h.OpCode&0xf].Add(uint64(h.Length))
+}
+
+func (m *Metrics) frameOut(f Frame) {
+	if m == nil {
+		return
+	}
+	m.framesOut[f.Header.OpCode&0xf].Add(1)
+	m.bytesOut[f.Header.OpCode&0xf].Add(uint64(len(f.Payload)))
+	if f.Header.OpCode == OpClose {
+		code, _ := ParseCloseFrameData(f.Payload)
+		m.closeCode(m.closesOut, code)
+	}
+}
+
+func (m *Metrics) closeIn(code StatusCode) {
+	if m == nil {
+		return
+	}
+	m.closeCode(m.closesIn, code)
+}
+
+func (m *Metrics) closeCode(counts map[StatusCode]uint64, code StatusCode) {
+	m.mu.Lock()
+	counts[code]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) message(size int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.messageSize.observe(uint64(size))
+	m.handlerLatency.observe(uint64(latency))
+}
+
+// now returns current time if metrics are collected. It allows to avoid
+// clock calls when m is nil.
+func (m *Metrics) now() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// Register adds a collector which is called every time metrics are written.
+// It is intended to expose values which are owned by other components, such
+// as limiters or filters. Collector must write complete metric families in
+// Prometheus text format.
+func (m *Metrics) Register(collector func(w io.Writer)) {
+	m.mu.Lock()
+	m.collectors = append(m.collectors, collector)
+	m.mu.Unlock()
+}
+
+// ServeHTTP implements http.Handler. It writes metrics in Prometheus text
+// exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes metrics to w in Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	family(bw, "easyws_connections_active", "gauge", "Number of currently open connections.")
+	sample(bw, "easyws_connections_active", "", float64(m.connActive.Load()))
+	family(bw, "easyws_connections_total", "counter", "Total number of accepted connections.")
+	sample(bw, "easyws_connections_total", "", float64(m.connTotal.Load()))
+
+	family(bw, "easyws_handshakes_total", "counter", "Total number of successful WebSocket handshakes.")
+	sample(bw, "easyws_handshakes_total", "", float64(m.handshakes.Load()))
+	family(bw, "easyws_handshake_rejections_total", "counter", "Total number of rejected WebSocket handshakes by reason.")
+	for i := range m.rejections {
+		reason := handshakeRejections[i].reason
+		sample(bw, "easyws_handshake_rejections_total", label("reason", reason), float64(m.rejections[i].Load()))
+	}
+
+	opcodes(bw, "easyws_frames_received_total", "Total number of received frames by op code.", &m.framesIn)
+	opcodes(bw, "easyws_frames_sent_total", "Total number of sent frames by op code.", &m.framesOut)
+	opcodes(bw, "easyws_payload_bytes_received_total", "Total number of received payload bytes by op code.", &m.bytesIn)
+	opcodes(bw, "easyws_payload_bytes_sent_total", "Total number of sent payload bytes by op code.", &m.bytesOut)
+
+	m.mu.Lock()
+	closeCodes(bw, "easyws_close_codes_received_total", "Total number of received close frames by status code.", m.closesIn)
+	closeCodes(bw, "easyws_close_codes_sent_total", "Total number of sent close frames by status code.", m.closesOut)
+	collectors := m.collectors
+	m.mu.Unlock()
+
+	m.messageSize.writeTo(bw, "easyws_message_size_bytes", "Size of received data messages.")
+	m.handlerLatency.writeTo(bw, "easyws_handler_duration_seconds", "Time spent in handler processing a data message.")
+
+	for _, c := range collectors {
+		c(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func opcodes(w metricWriter, name, help string, counts *[16]atomic.Uint64) {
+	family(w, name, "counter", help)
+	for _, op := range []OpCode{OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong} {
+		sample(w, name, label("opcode", opCodeName(op)), float64(counts[op].Load()))
+	}
+}
+
+func opCodeName(op OpCode) string {
+	switch op {
+	case OpContinuation:
+		return "continuation"
+	case OpText:
+		return "text"
+	case OpBinary:
+		return "binary"
+	case OpClose:
+		return "close"
+	case OpPing:
+		return "ping"
+	case OpPong:
+		return "pong"
+	}
+	return "reserved"
+}
+
+func closeCodes(w metricWriter, name, help string, counts map[StatusCode]uint64) {
+	family(w, name, "counter", help)
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		sample(w, name, label("code", strconv.Itoa(code)), float64(counts[StatusCode(code)]))
+	}
+}
+
+// metricWriter is the writer of metrics, such as *bufio.Writer.
+type metricWriter interface {
+	io.Writer
+	io.StringWriter
+	io.ByteWriter
+}
+
+func family(w metricWriter, name, typ, help string) {
+	w.WriteString("# HELP ")
+	w.WriteString(name)
+	w.WriteByte(' ')
+	w.WriteString(help)
+	w.WriteString("\n# TYPE ")
+	w.WriteString(name)
+	w.WriteByte(' ')
+	w.WriteString(typ)
+	w.WriteByte('\n')
+}
+
+// sample writes single sample line. Labels must be already formatted, like
+// `a="b",c="d"`.
+func sample(w metricWriter, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	w.WriteByte('\n')
+}
+
+// labelEscaper escapes label values as Prometheus text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// histogram is a lock-free histogram of uint64 observations. Bounds and sum
+// are divided by scale when written.
+type histogram struct {
+	bounds []uint64
+	scale  float64
+	counts []atomic.Uint64
+	sum    atomic.Uint64
+	count  atomic.Uint64
+}
+
+func newHistogram(bounds []uint64, scale float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		scale:  scale,
+		counts: make([]atomic.Uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v uint64) {
+	i := sort.Search(len(h.bounds), func(i int) bool {
+		return v <= h.bounds[i]
+	})
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.sum.Add(v)
+	h.count.Add(1)
+}
+
+func (h *histogram) writeTo(w metricWriter, name, help string) {
+	family(w, name, "histogram", help)
+	var n uint64
+	for i, b := range h.bounds {
+		n += h.counts[i].Load()
+		le := strconv.FormatFloat(float64(b)/h.scale, 'g', -1, 64)
+		sample(w, name+"_bucket", label("le", le), float64(n))
+	}
+	count := h.count.Load()
+	sample(w, name+"_bucket", label("le", "+Inf"), float64(count))
+	sample(w, name+"_sum", "", float64(h.sum.Load())/h.scale)
+	sample(w, name+"_count", "", float64(count))
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
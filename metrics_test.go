@@ -0,0 +1,78 @@
+package easyws
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	h, stream := upgradedHandler(t, WithMetrics(m))
+	for _, frame := range [][]byte{
+		maskedFrame(OpText, []byte("hello")),
+		maskedFrame(OpPing, nil),
+		maskedFrame(OpClose, NewCloseFrameBody(StatusGoingAway, "")),
+	} {
+		stream.Begin(frame)
+		h.OnReceive(benchConn{}, stream)
+	}
+	h.OnClose(benchConn{}, nil)
+
+	bad := &base.InputStream{}
+	bad.Begin([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	if _, err := h.OnReceive(testConn("192.0.2.2:1"), bad); err != ErrHandshakeBadUpgrade {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"easyws_connections_active 1",
+		"easyws_connections_total 2",
+		"easyws_handshakes_total 1",
+		`easyws_handshake_rejections_total{reason="bad_upgrade"} 1`,
+		`easyws_frames_received_total{opcode="text"} 1`,
+		`easyws_frames_received_total{opcode="ping"} 1`,
+		`easyws_frames_sent_total{opcode="text"} 1`,
+		`easyws_frames_sent_total{opcode="pong"} 1`,
+		`easyws_payload_bytes_received_total{opcode="text"} 5`,
+		`easyws_close_codes_received_total{code="1001"} 1`,
+		`easyws_close_codes_sent_total{code="1001"} 1`,
+		`easyws_message_size_bytes_bucket{le="64"} 1`,
+		"easyws_message_size_bytes_sum 5",
+		"easyws_handler_duration_seconds_count 1",
+	} {
+		if !strings.Contains(buf.String(), "\n"+line+"\n") {
+			t.Errorf("no %q line in output:\n%s", line, buf.String())
+		}
+	}
+}
+
+type testConn string
+
+func (c testConn) RemoteAddr() string       { return string(c) }
+func (testConn) Send(p []byte) (int, error) { return len(p), nil }
+func (testConn) Close() error               { return nil }
+
+func TestLabel(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		exp   string
+	}{
+		{"plain", `l="plain"`},
+		{`a\b`, `l="a\\b"`},
+		{`say "hi"`, `l="say \"hi\""`},
+		{"two\nlines", `l="two\nlines"`},
+		{"tab\there", "l=\"tab\there\""},
+		{"привет", `l="привет"`},
+	} {
+		if act := label("l", test.value); act != test.exp {
+			t.Errorf("label(%q) = %s; want %s", test.value, act, test.exp)
+		}
+	}
+}
@@ -0,0 +1,596 @@
+package mqtt
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Default limits of the Broker.
+const (
+	DefaultMaxInflight = 32
+	DefaultMaxQueued   = 1000
+)
+
+// errDisconnect is returned by Broker.handle for DISCONNECT packet.
+var errDisconnect = errors.New("mqtt: disconnect")
+
+// refusedError is returned by Broker.handle when CONNACK refusing the
+// connection is sent.
+type refusedError struct {
+	code byte
+}
+
+func (e refusedError) Error() string {
+	return (&ConnectError{Code: e.code}).Error()
+}
+
+// Broker is an in-memory MQTT 3.1.1 broker serving clients connected over
+// easyws. It implements easyws.IEasyWs and must be served by NetHandler,
+// which passes connections to its callbacks.
+//
+// Messages are delivered with QoS 0 and 1; clients publishing with QoS 2 are
+// disconnected. Every subscribed session receives a single copy of the
+// message with the highest QoS granted by its matching subscriptions.
+//
+// The zero value is ready to use. Broker is safe for concurrent use.
+type Broker struct {
+	// Authenticate is called for every CONNECT packet. If it returns an
+	// error, the connection is refused with the code of *ConnectError or
+	// with RefusedNotAuthorized for other errors. If nil, all clients are
+	// accepted.
+	Authenticate func(conn *easyws.Conn, clientID, username string, password []byte) error
+
+	// SessionExpiry limits the time the session of a client connected with
+	// clean session flag unset is kept after the client disconnects. Zero
+	// means that such sessions are kept until the client connects with
+	// clean session flag set.
+	SessionExpiry time.Duration
+
+	// MaxInflight limits the number of QoS 1 messages sent to a client and
+	// not acknowledged yet. Other messages are queued until acknowledgement.
+	// If zero, DefaultMaxInflight is used.
+	MaxInflight int
+
+	// MaxQueued limits the number of QoS 1 messages queued for a session
+	// while its client is disconnected or has too many messages inflight.
+	// When the queue is full, the oldest messages are dropped. If zero,
+	// DefaultMaxQueued is used.
+	MaxQueued int
+
+	// MaxPacketSize limits the size of packets received from the clients.
+	// Clients sending larger packets are disconnected. Zero means no limit.
+	MaxPacketSize int
+
+	mu       sync.Mutex
+	seq      uint64
+	peers    map[*easyws.Conn]*peer
+	sessions map[string]*session
+	retained map[string]Message
+}
+
+// peer is a client connection.
+type peer struct {
+	conn *easyws.Conn
+
+	// buf holds received bytes of incomplete packet. Packets may span or
+	// share WebSocket messages.
+	buf []byte
+
+	// session is nil until CONNECT is accepted and after the connection is
+	// dropped.
+	session   *session
+	will      *Message
+	keepAlive time.Duration
+	timer     *time.Timer
+	seen      time.Time
+}
+
+// session is the state of a client kept between connections.
+type session struct {
+	id     string
+	clean  bool
+	subs   map[string]byte
+	peer   *peer
+	expiry *time.Timer
+
+	lastID   uint16
+	inflight []outbound
+	queue    []Message
+}
+
+// outbound is a QoS 1 message sent and not acknowledged yet.
+type outbound struct {
+	id  uint16
+	msg Message
+}
+
+// Register registers b within m for the "mqtt" subprotocol.
+func (b *Broker) Register(m *easyws.ProtocolMux) {
+	m.Handle(Subprotocol, b)
+}
+
+// Publish publishes m to the subscribers as if it was received from a
+// client.
+func (b *Broker) Publish(m Message) error {
+	if !validTopic(m.Topic) {
+		return ErrTopic
+	}
+	if m.QoS > 1 {
+		return ErrQoS
+	}
+	m.Dup = false
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.publish(m)
+	return nil
+}
+
+// Sessions returns sorted client identifiers of the sessions kept by the
+// broker, including sessions of disconnected clients.
+func (b *Broker) Sessions() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := make([]string, 0, len(b.sessions))
+	for id := range b.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Retained returns the message retained for given topic.
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.retained[topic]
+	return m, ok
+}
+
+// OnOpen implements easyws.IEasyWsConn.
+func (b *Broker) OnOpen(conn *easyws.Conn) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.peers == nil {
+		b.peers = make(map[*easyws.Conn]*peer)
+		b.sessions = make(map[string]*session)
+		b.retained = make(map[string]Message)
+	}
+	b.peers[conn] = &peer{conn: conn}
+	return nil
+}
+
+// OnDisconnect implements easyws.IEasyWsConn. The will message of the client
+// is published unless it sent DISCONNECT.
+func (b *Broker) OnDisconnect(conn *easyws.Conn, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p := b.peers[conn]; p != nil {
+		b.drop(p)
+	}
+}
+
+// OnMessage implements easyws.IEasyWsMessage. Clients violating the protocol
+// are disconnected with StatusProtocolError.
+func (b *Broker) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	if op != easyws.OpBinary {
+		return easyws.CloseReply(easyws.StatusUnsupportedData, "mqtt: only binary messages are allowed")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := b.peers[conn]
+	if p == nil {
+		return easyws.CloseReply(easyws.StatusInternalServerError, "mqtt: connection is not open")
+	}
+	p.buf = append(p.buf, msg...)
+	var off int
+	for {
+		pkt, n, err := readPacket(p.buf[off:], b.MaxPacketSize)
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err == nil {
+			err = b.handle(p, pkt)
+		}
+		if err == errDisconnect {
+			return easyws.CloseReply(easyws.StatusNormalClosure, "")
+		}
+		if _, ok := err.(refusedError); ok {
+			return easyws.CloseReply(easyws.StatusPolicyViolation, err.Error())
+		}
+		if err != nil {
+			return easyws.CloseReply(easyws.StatusProtocolError, err.Error())
+		}
+		off += n
+	}
+	p.buf = append(p.buf[:0], p.buf[off:]...)
+	if p.timer != nil {
+		p.seen = time.Now()
+		p.timer.Reset(p.keepAlive)
+	}
+	return nil, 0, nil
+}
+
+// OnReceive implements easyws.IEasyWs. Broker is served by OnMessage only;
+// see easyws.IEasyWsMessage.
+func (b *Broker) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return easyws.CloseReply(easyws.StatusInternalServerError, "mqtt: connection is not open")
+}
+
+func (b *Broker) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (b *Broker) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (b *Broker) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (b *Broker) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (b *Broker) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
+
+// handle handles a packet received from p.
+func (b *Broker) handle(p *peer, pkt packet) error {
+	if p.session == nil {
+		if pkt.typ != typeConnect {
+			return errors.New("mqtt: first packet must be CONNECT")
+		}
+		return b.connect(p, pkt)
+	}
+	s := p.session
+	switch pkt.typ {
+	case typePublish:
+		var m publishPacket
+		if err := m.decode(pkt); err != nil {
+			return err
+		}
+		if m.QoS > 1 {
+			return ErrQoS
+		}
+		if !validTopic(m.Topic) {
+			return ErrTopic
+		}
+		m.Dup = false
+		b.publish(m.Message)
+		if m.QoS == 1 {
+			b.send(p, encodeID(typePuback, 0, m.id))
+		}
+
+	case typePuback:
+		id, err := decodeID(pkt)
+		if err != nil {
+			return err
+		}
+		for i, o := range s.inflight {
+			if o.id == id {
+				s.inflight = append(s.inflight[:i], s.inflight[i+1:]...)
+				break
+			}
+		}
+		b.drain(s)
+
+	case typeSubscribe:
+		id, subs, err := decodeSubscribe(pkt)
+		if err != nil {
+			return err
+		}
+		codes := appendUint16(nil, id)
+		for _, sub := range subs {
+			if !validFilter(sub.filter) || sub.qos > 2 {
+				codes = append(codes, 0x80)
+				continue
+			}
+			qos := sub.qos
+			if qos > 1 {
+				qos = 1
+			}
+			s.subs[sub.filter] = qos
+			codes = append(codes, qos)
+		}
+		b.send(p, appendPacket(nil, typeSuback, 0, codes))
+
+		for i, sub := range subs {
+			granted := codes[2+i]
+			if granted == 0x80 {
+				continue
+			}
+			for _, m := range b.retained {
+				if Match(sub.filter, m.Topic) {
+					m.QoS = min(m.QoS, granted)
+					b.deliver(s, m)
+				}
+			}
+		}
+
+	case typeUnsubscribe:
+		id, filters, err := decodeUnsubscribe(pkt)
+		if err != nil {
+			return err
+		}
+		for _, f := range filters {
+			delete(s.subs, f)
+		}
+		b.send(p, encodeID(typeUnsuback, 0, id))
+
+	case typePingreq:
+		b.send(p, appendPacket(nil, typePingresp, 0, nil))
+
+	case typeDisconnect:
+		p.will = nil
+		b.drop(p)
+		return errDisconnect
+
+	case typeConnect:
+		return errors.New("mqtt: second CONNECT packet")
+
+	case typePubrec, typePubrel, typePubcomp:
+		return ErrQoS
+
+	default:
+		return errors.New("mqtt: unexpected packet type " + strconv.Itoa(int(pkt.typ)))
+	}
+	return nil
+}
+
+// connect handles CONNECT packet received from p.
+func (b *Broker) connect(p *peer, pkt packet) error {
+	var c connectPacket
+	if err := c.decode(pkt); err != nil {
+		return err
+	}
+	if c.protocol != "MQTT" {
+		return errors.New("mqtt: unknown protocol name " + strconv.Quote(c.protocol))
+	}
+	if c.level != 4 {
+		return b.refuse(p, RefusedProtocolVersion)
+	}
+	if c.will != nil {
+		if c.will.QoS > 1 {
+			return ErrQoS
+		}
+		if !validTopic(c.will.Topic) {
+			return ErrTopic
+		}
+	}
+	if c.clientID == "" {
+		if !c.cleanSession {
+			return b.refuse(p, RefusedIdentifier)
+		}
+		b.seq++
+		c.clientID = "easyws-" + strconv.FormatUint(b.seq, 10)
+	}
+	if b.Authenticate != nil {
+		var username string
+		if c.username != nil {
+			username = *c.username
+		}
+		if err := b.Authenticate(p.conn, c.clientID, username, c.password); err != nil {
+			code := byte(RefusedNotAuthorized)
+			if ce, ok := err.(*ConnectError); ok {
+				code = ce.Code
+			}
+			return b.refuse(p, code)
+		}
+	}
+
+	s := b.sessions[c.clientID]
+	if s != nil && s.peer != nil {
+		// The client is connected already, so the old connection is
+		// closed without its will.
+		old := s.peer
+		old.will = nil
+		b.drop(old)
+		old.conn.Close(easyws.StatusPolicyViolation, "mqtt: session taken over")
+	}
+	present := s != nil && !c.cleanSession
+	if s != nil && !present {
+		b.discard(s)
+		s = nil
+	}
+	if s == nil {
+		s = &session{
+			id:   c.clientID,
+			subs: make(map[string]byte),
+		}
+		b.sessions[s.id] = s
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.clean = c.cleanSession
+	s.peer = p
+	p.session = s
+	p.will = c.will
+	if c.keepAlive > 0 {
+		// The client is disconnected if nothing is received within one and
+		// a half keep alive period.
+		p.keepAlive = time.Duration(c.keepAlive) * time.Second * 3 / 2
+		p.seen = time.Now()
+		p.timer = time.AfterFunc(p.keepAlive, func() { b.expire(p) })
+	}
+
+	b.send(p, encodeConnack(present, Accepted))
+	for _, o := range s.inflight {
+		m := publishPacket{Message: o.msg, id: o.id}
+		m.Dup = true
+		b.send(p, m.encode())
+	}
+	b.drain(s)
+	return nil
+}
+
+// refuse sends CONNACK with given code to p.
+func (b *Broker) refuse(p *peer, code byte) error {
+	b.send(p, encodeConnack(false, code))
+	return refusedError{code}
+}
+
+// expire disconnects p which keep alive period is expired.
+func (b *Broker) expire(p *peer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.peers[p.conn] != p || time.Since(p.seen) < p.keepAlive {
+		// Dropped already or a packet is received while the timer fired.
+		return
+	}
+	b.drop(p)
+	p.conn.Close(easyws.StatusPolicyViolation, "mqtt: keep alive timeout")
+}
+
+// drop forgets connection p, publishing its will.
+func (b *Broker) drop(p *peer) {
+	if b.peers[p.conn] != p {
+		return
+	}
+	delete(b.peers, p.conn)
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	s := p.session
+	if s == nil {
+		return
+	}
+	p.session = nil
+	s.peer = nil
+	switch {
+	case s.clean:
+		b.discard(s)
+	case b.SessionExpiry > 0:
+		s.expiry = time.AfterFunc(b.SessionExpiry, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if s.peer == nil && b.sessions[s.id] == s {
+				b.discard(s)
+			}
+		})
+	}
+	if p.will != nil {
+		b.publish(*p.will)
+		p.will = nil
+	}
+}
+
+func (b *Broker) discard(s *session) {
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	delete(b.sessions, s.id)
+}
+
+// publish stores m if it is retained and delivers it to the subscribers.
+func (b *Broker) publish(m Message) {
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	for _, s := range b.sessions {
+		qos, ok := s.match(m.Topic)
+		if !ok {
+			continue
+		}
+		b.deliver(s, Message{
+			Topic:   m.Topic,
+			Payload: m.Payload,
+			QoS:     min(qos, m.QoS),
+		})
+	}
+}
+
+// match returns the highest QoS of the session subscriptions matching topic.
+func (s *session) match(topic string) (qos byte, ok bool) {
+	for f, q := range s.subs {
+		if Match(f, topic) {
+			ok = true
+			qos = max(qos, q)
+		}
+	}
+	return qos, ok
+}
+
+// deliver sends m to the client of s or queues it.
+func (b *Broker) deliver(s *session, m Message) {
+	if m.QoS == 0 {
+		if s.peer != nil {
+			b.send(s.peer, (&publishPacket{Message: m}).encode())
+		}
+		return
+	}
+	if s.peer == nil || len(s.inflight) >= b.maxInflight() {
+		if len(s.queue) >= b.maxQueued() {
+			s.queue = s.queue[1:]
+		}
+		s.queue = append(s.queue, m)
+		return
+	}
+	b.sendInflight(s, m)
+}
+
+// drain sends queued messages of s while it is possible.
+func (b *Broker) drain(s *session) {
+	for len(s.queue) > 0 && s.peer != nil && len(s.inflight) < b.maxInflight() {
+		m := s.queue[0]
+		s.queue[0] = Message{}
+		s.queue = s.queue[1:]
+		b.sendInflight(s, m)
+	}
+}
+
+func (b *Broker) sendInflight(s *session, m Message) {
+	id := s.nextID()
+	s.inflight = append(s.inflight, outbound{id: id, msg: m})
+	b.send(s.peer, (&publishPacket{Message: m, id: id}).encode())
+}
+
+// nextID returns packet identifier which is not used by inflight messages.
+func (s *session) nextID() uint16 {
+next:
+	for {
+		s.lastID++
+		if s.lastID == 0 {
+			continue
+		}
+		for _, o := range s.inflight {
+			if o.id == s.lastID {
+				continue next
+			}
+		}
+		return s.lastID
+	}
+}
+
+// send sends packet to p. Errors are ignored: the connection is closed and
+// will be dropped.
+func (b *Broker) send(p *peer, pkt []byte) {
+	p.conn.WriteMessage(easyws.OpBinary, pkt)
+}
+
+func (b *Broker) maxInflight() int {
+	if b.MaxInflight > 0 {
+		return b.MaxInflight
+	}
+	return DefaultMaxInflight
+}
+
+func (b *Broker) maxQueued() int {
+	if b.MaxQueued > 0 {
+		return b.MaxQueued
+	}
+	return DefaultMaxQueued
+}
+
+func min(a, b byte) byte {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b byte) byte {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,412 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wsutil"
+)
+
+// Dialer contains options for connecting to MQTT broker.
+type Dialer struct {
+	// Dialer is used to establish WebSocket connection. The "mqtt"
+	// subprotocol is appended to its Protocols.
+	Dialer easyws.Dialer
+
+	// ClientID identifies the session of the client. It could be empty if
+	// CleanSession is set, then the broker assigns a unique identifier.
+	ClientID string
+
+	// CleanSession makes the broker discard the previous session of the
+	// client and discard the session when the client disconnects.
+	CleanSession bool
+
+	// KeepAlive is the maximum interval between packets sent by the client.
+	// The client sends PINGREQ packets if there is nothing else to send.
+	// It is rounded down to seconds; zero disables keep alive.
+	KeepAlive time.Duration
+
+	// Username and Password are sent to the broker if not empty.
+	Username string
+	Password []byte
+
+	// Will is published by the broker if the client disconnects without
+	// DISCONNECT packet.
+	Will *Message
+
+	// Timeout limits the time Client methods wait for acknowledgements if
+	// the context passed to them has no deadline. Zero means no limit.
+	Timeout time.Duration
+}
+
+// Dial connects to MQTT broker at given WebSocket url. Handler is called
+// for every message delivered to the client; it could be nil.
+func (d Dialer) Dial(ctx context.Context, urlstr string, handler func(Message)) (*Client, error) {
+	wd := d.Dialer
+	wd.Protocols = append(append([]string(nil), wd.Protocols...), Subprotocol)
+	conn, br, _, err := wd.Dial(ctx, urlstr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		handler: handler,
+		timeout: d.Timeout,
+		pending: make(map[uint16]chan packet),
+		done:    make(chan struct{}),
+	}
+	c.rd = wsutil.Reader{
+		Source:         conn,
+		State:          easyws.StateClientSide,
+		OnIntermediate: c.control,
+	}
+	if br != nil {
+		c.rd.Source = br
+	}
+
+	connect := connectPacket{
+		protocol:     "MQTT",
+		level:        4,
+		cleanSession: d.CleanSession,
+		keepAlive:    uint16(d.KeepAlive / time.Second),
+		clientID:     d.ClientID,
+		will:         d.Will,
+	}
+	if d.Username != "" {
+		connect.username = &d.Username
+	}
+	if d.Password != nil {
+		connect.password = d.Password
+	}
+	if err := c.write(connect.encode()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	p, err := c.next()
+	conn.SetReadDeadline(time.Time{})
+	if err == nil && (p.typ != typeConnack || len(p.body) != 2) {
+		err = ErrMalformedPacket
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if code := p.body[1]; code != Accepted {
+		conn.Close()
+		return nil, &ConnectError{Code: code}
+	}
+	c.sessionPresent = p.body[0]&0x01 != 0
+
+	go c.read()
+	if connect.keepAlive > 0 {
+		go c.ping(time.Duration(connect.keepAlive) * time.Second)
+	}
+	return c, nil
+}
+
+// Client is a connection to MQTT broker.
+//
+// Client methods are safe for concurrent use.
+type Client struct {
+	conn    net.Conn
+	handler func(Message)
+	timeout time.Duration
+
+	// rd and buf are used by the reading goroutine only.
+	rd  wsutil.Reader
+	buf []byte
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	lastID  uint16
+	pending map[uint16]chan packet
+	closed  bool
+
+	sessionPresent bool
+	done           chan struct{}
+	err            error
+	once           sync.Once
+	closeErr       error
+}
+
+// SessionPresent reports whether the broker resumed the previous session of
+// the client.
+func (c *Client) SessionPresent() bool {
+	return c.sessionPresent
+}
+
+// Publish publishes m. If m.QoS is 1, Publish waits for the acknowledgement
+// of the broker.
+//
+// Publish with QoS 1 must not be called from the message handler, which
+// blocks receiving of the acknowledgement.
+func (c *Client) Publish(ctx context.Context, m Message) error {
+	if !validTopic(m.Topic) {
+		return ErrTopic
+	}
+	if m.QoS > 1 {
+		return ErrQoS
+	}
+	if m.QoS == 0 {
+		return c.write((&publishPacket{Message: m}).encode())
+	}
+	_, err := c.request(ctx, func(id uint16) []byte {
+		return (&publishPacket{Message: m, id: id}).encode()
+	})
+	return err
+}
+
+// Subscribe subscribes the client to topic filter with given QoS. It returns
+// the QoS granted by the broker or ErrSubscriptionRejected.
+func (c *Client) Subscribe(ctx context.Context, filter string, qos byte) (byte, error) {
+	p, err := c.request(ctx, func(id uint16) []byte {
+		return encodeSubscribe(id, []subscription{{filter, qos}})
+	})
+	if err != nil {
+		return 0, err
+	}
+	if p.typ != typeSuback || len(p.body) != 3 {
+		return 0, ErrMalformedPacket
+	}
+	if p.body[2] == 0x80 {
+		return 0, ErrSubscriptionRejected
+	}
+	return p.body[2], nil
+}
+
+// Unsubscribe unsubscribes the client from given topic filters.
+func (c *Client) Unsubscribe(ctx context.Context, filters ...string) error {
+	_, err := c.request(ctx, func(id uint16) []byte {
+		return encodeUnsubscribe(id, filters)
+	})
+	return err
+}
+
+// request sends the packet made by encode with a new packet identifier and
+// waits for the acknowledgement.
+func (c *Client) request(ctx context.Context, encode func(id uint16) []byte) (packet, error) {
+	if _, has := ctx.Deadline(); !has && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return packet{}, ErrClosed
+	}
+	for {
+		c.lastID++
+		if _, busy := c.pending[c.lastID]; c.lastID != 0 && !busy {
+			break
+		}
+	}
+	id := c.lastID
+	ch := make(chan packet, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	forget := func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+	if err := c.write(encode(id)); err != nil {
+		forget()
+		return packet{}, err
+	}
+	select {
+	case p, ok := <-ch:
+		if !ok {
+			return packet{}, ErrClosed
+		}
+		return p, nil
+	case <-ctx.Done():
+		forget()
+		return packet{}, ctx.Err()
+	}
+}
+
+// Disconnect sends DISCONNECT packet, so the broker discards the will of
+// the client, and waits for the broker to close the connection.
+func (c *Client) Disconnect() error {
+	err := c.write(appendPacket(nil, typeDisconnect, 0, nil))
+	if err == nil {
+		t := time.NewTimer(disconnectTimeout)
+		select {
+		case <-c.done:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	c.shutdown()
+	<-c.done
+	return err
+}
+
+// disconnectTimeout limits the time Disconnect waits for the broker.
+const disconnectTimeout = 5 * time.Second
+
+// Close closes the connection without DISCONNECT packet, so the broker
+// publishes the will of the client.
+func (c *Client) Close() error {
+	err := c.shutdown()
+	<-c.done
+	return err
+}
+
+func (c *Client) shutdown() error {
+	c.once.Do(func() { c.closeErr = c.conn.Close() })
+	return c.closeErr
+}
+
+// Done returns a channel which is closed when the connection is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error the connection was closed with. It must be called
+// after Done is closed.
+func (c *Client) Err() error {
+	return c.err
+}
+
+func (c *Client) write(pkt []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return wsutil.WriteClientBinary(c.conn, pkt)
+}
+
+func (c *Client) ping(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.write(appendPacket(nil, typePingreq, 0, nil))
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) read() {
+	defer close(c.done)
+	for {
+		p, err := c.next()
+		if err == nil {
+			err = c.handle(p)
+		}
+		if err != nil {
+			c.err = err
+			c.mu.Lock()
+			c.closed = true
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			c.shutdown()
+			return
+		}
+	}
+}
+
+// handle handles a packet received from the broker.
+func (c *Client) handle(p packet) error {
+	switch p.typ {
+	case typePublish:
+		var m publishPacket
+		if err := m.decode(p); err != nil {
+			return err
+		}
+		if c.handler != nil {
+			c.handler(m.Message)
+		}
+		if m.QoS == 1 {
+			return c.write(encodeID(typePuback, 0, m.id))
+		}
+	case typePuback, typeSuback, typeUnsuback:
+		if len(p.body) < 2 {
+			return ErrMalformedPacket
+		}
+		id := uint16(p.body[0])<<8 | uint16(p.body[1])
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- p
+		}
+	case typePingresp:
+	default:
+		return errors.New("mqtt: unexpected packet from broker")
+	}
+	return nil
+}
+
+// next returns the next packet received from the broker.
+func (c *Client) next() (packet, error) {
+	for {
+		if len(c.buf) > 0 {
+			p, n, err := readPacket(c.buf, 0)
+			if err == nil {
+				// The body is copied as the head of buf is overwritten.
+				p.body = append([]byte(nil), p.body...)
+				c.buf = append(c.buf[:0], c.buf[n:]...)
+				return p, nil
+			}
+			if err != io.ErrUnexpectedEOF {
+				return p, err
+			}
+		}
+		msg, err := c.message()
+		if err != nil {
+			return packet{}, err
+		}
+		c.buf = append(c.buf, msg...)
+	}
+}
+
+// message returns the next received data message, handling control frames.
+func (c *Client) message() ([]byte, error) {
+	for {
+		hdr, err := c.rd.NextFrame()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := c.control(hdr, &c.rd); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return io.ReadAll(&c.rd)
+	}
+}
+
+// control handles control frame, sending the reply frame at once.
+func (c *Client) control(hdr easyws.Header, r io.Reader) error {
+	var buf bytes.Buffer
+	err := wsutil.ControlHandler{
+		Src:   r,
+		Dst:   &buf,
+		State: easyws.StateClientSide,
+	}.Handle(hdr)
+	if buf.Len() > 0 {
+		c.wmu.Lock()
+		c.conn.Write(buf.Bytes())
+		c.wmu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+// Package mqtt implements MQTT 3.1.1 over WebSocket.
+//
+// Broker is an easyws.IEasyWs which serves MQTT clients connected with the
+// "mqtt" subprotocol. It keeps sessions, subscriptions and retained messages
+// in memory and supports QoS 0 and 1, topic wildcards, will messages and
+// session expiry. Client is a connection to the broker made with
+// easyws.Dialer.
+//
+// The specification: http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/mqtt-v3.1.1.html
+package mqtt
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Subprotocol is the WebSocket subprotocol of MQTT.
+const Subprotocol = "mqtt"
+
+// Message is an application message.
+type Message struct {
+	Topic   string
+	Payload []byte
+
+	// QoS is the quality of service level: 0 (at most once) or 1 (at least
+	// once). QoS 2 is not supported.
+	QoS byte
+
+	// Retain makes the broker keep the message for future subscribers of
+	// the topic. Messages delivered to the client have Retain set if they
+	// were retained before the subscription.
+	Retain bool
+
+	// Dup is set for the messages delivered again because the previous
+	// attempt was not acknowledged.
+	Dup bool
+}
+
+// Return codes of the CONNACK packet.
+const (
+	Accepted                 = 0
+	RefusedProtocolVersion   = 1
+	RefusedIdentifier        = 2
+	RefusedServerUnavailable = 3
+	RefusedBadCredentials    = 4
+	RefusedNotAuthorized     = 5
+)
+
+// ConnectError is returned by Dial if the broker refused the connection.
+type ConnectError struct {
+	Code byte
+}
+
+func (e *ConnectError) Error() string {
+	var reason string
+	switch e.Code {
+	case RefusedProtocolVersion:
+		reason = "unacceptable protocol version"
+	case RefusedIdentifier:
+		reason = "identifier rejected"
+	case RefusedServerUnavailable:
+		reason = "server unavailable"
+	case RefusedBadCredentials:
+		reason = "bad user name or password"
+	case RefusedNotAuthorized:
+		reason = "not authorized"
+	default:
+		reason = "code " + strconv.Itoa(int(e.Code))
+	}
+	return "mqtt: connection refused: " + reason
+}
+
+var (
+	// ErrClosed is returned by Client methods called after the connection
+	// is closed. Requests waiting for acknowledgement when the connection
+	// is closed fail with it too.
+	ErrClosed = errors.New("mqtt: connection closed")
+
+	// ErrSubscriptionRejected is returned by Client.Subscribe if the broker
+	// rejected the subscription.
+	ErrSubscriptionRejected = errors.New("mqtt: subscription rejected")
+
+	// ErrQoS is returned for messages published with unsupported QoS.
+	ErrQoS = errors.New("mqtt: unsupported QoS")
+
+	// ErrTopic is returned for messages published with invalid topic name.
+	ErrTopic = errors.New("mqtt: invalid topic name")
+)
@@ -0,0 +1,420 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"+/+", "/b", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "b", false},
+		{"#", "a/b", true},
+		{"#", "$SYS/uptime", false},
+		{"+/uptime", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+		{"a/b", "a/b/c", false},
+		{"a/b/c", "a/b", false},
+	} {
+		if act := Match(test.filter, test.topic); act != test.match {
+			t.Errorf("Match(%q, %q) = %v; want %v", test.filter, test.topic, act, test.match)
+		}
+	}
+	for f, valid := range map[string]bool{
+		"a/b":   true,
+		"a/+/c": true,
+		"#":     true,
+		"a/#":   true,
+		"a/#/c": false,
+		"a+":    false,
+		"a/b#":  false,
+		"":      false,
+	} {
+		if act := validFilter(f); act != valid {
+			t.Errorf("validFilter(%q) = %v; want %v", f, act, valid)
+		}
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	body := bytes.Repeat([]byte{'x'}, 200)
+	pkt := appendPacket(nil, typePublish, 0x03, body)
+	if !bytes.Equal(pkt[:3], []byte{0x33, 0xc8, 0x01}) {
+		t.Fatalf("unexpected fixed header: % x", pkt[:3])
+	}
+	for i := 0; i < len(pkt); i++ {
+		if _, _, err := readPacket(pkt[:i], 0); err != io.ErrUnexpectedEOF {
+			t.Fatalf("readPacket(%d bytes) error = %v", i, err)
+		}
+	}
+	p, n, err := readPacket(append(pkt, 0xc0, 0x00), 0)
+	if err != nil || n != len(pkt) || p.typ != typePublish || p.flags != 0x03 || !bytes.Equal(p.body, body) {
+		t.Fatalf("readPacket() = %+v, %d, %v", p, n, err)
+	}
+	if _, _, err := readPacket(pkt, 100); err != ErrPacketTooLarge {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := readPacket([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}, 0); err != ErrMalformedPacket {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func connect(clientID string, clean bool, keepAlive uint16) []byte {
+	return (&connectPacket{
+		protocol:     "MQTT",
+		level:        4,
+		cleanSession: clean,
+		keepAlive:    keepAlive,
+		clientID:     clientID,
+	}).encode()
+}
+
+func publish(topic, payload string, qos byte, retain bool, id uint16) []byte {
+	return (&publishPacket{
+		Message: Message{Topic: topic, Payload: []byte(payload), QoS: qos, Retain: retain},
+		id:      id,
+	}).encode()
+}
+
+func send(ws *wstest.Harness, pkts ...[]byte) {
+	ws.Write(wstest.ClientFrame(easyws.OpBinary, true, bytes.Join(pkts, nil)))
+}
+
+func open(t *testing.T, b *Broker) *wstest.Harness {
+	return wstest.Dial(t, b, wstest.Request{Protocols: []string{Subprotocol}})
+}
+
+func TestBroker(t *testing.T) {
+	b := &Broker{}
+
+	t.Run("protocol errors", func(t *testing.T) {
+		ws := open(t, b)
+		send(ws, publish("a", "", 0, false, 0))
+		ws.ExpectClose(easyws.StatusProtocolError)
+
+		ws = open(t, b)
+		ws.Write(wstest.Text("hello"))
+		ws.ExpectClose(easyws.StatusUnsupportedData)
+
+		ws = open(t, b)
+		send(ws, connect("c", true, 0), publish("a", "", 2, false, 1))
+		ws.ExpectBinary(encodeConnack(false, Accepted))
+		ws.ExpectClose(easyws.StatusProtocolError)
+		ws.Close()
+
+		ws = open(t, b)
+		c := connectPacket{protocol: "MQTT", level: 3, clientID: "c"}
+		send(ws, c.encode())
+		ws.ExpectBinary(encodeConnack(false, RefusedProtocolVersion))
+		ws.ExpectClose(easyws.StatusPolicyViolation)
+
+		ws = open(t, b)
+		send(ws, connect("", false, 0))
+		ws.ExpectBinary(encodeConnack(false, RefusedIdentifier))
+		ws.ExpectClose(easyws.StatusPolicyViolation)
+	})
+
+	t.Run("publish", func(t *testing.T) {
+		sub := open(t, b)
+		// Packets are split between WebSocket messages.
+		pkt := connect("sub", true, 0)
+		send(sub, pkt[:3])
+		sub.ExpectNothing()
+		send(sub, pkt[3:], encodeSubscribe(1, []subscription{{"a/+", 1}, {"a/b", 0}, {"a/#/b", 0}, {"x/#", 2}}))
+		sub.ExpectBinary(encodeConnack(false, Accepted))
+		sub.ExpectBinary(appendPacket(nil, typeSuback, 0, []byte{0, 1, 1, 0, 0x80, 1}))
+
+		pub := open(t, b)
+		send(pub, connect("pub", true, 0))
+		pub.ExpectBinary(encodeConnack(false, Accepted))
+		send(pub, publish("a/b", "one", 1, false, 7))
+		pub.ExpectBinary(encodeID(typePuback, 0, 7))
+		// Single copy is delivered with the maximum QoS of the matching
+		// subscriptions.
+		sub.ExpectBinary(publish("a/b", "one", 1, false, 1))
+		sub.ExpectNothing()
+		send(sub, encodeID(typePuback, 0, 1))
+
+		send(pub, publish("a/c", "two", 0, false, 0))
+		sub.ExpectBinary(publish("a/c", "two", 0, false, 0))
+
+		send(sub, encodeUnsubscribe(2, []string{"a/+", "a/b"}))
+		sub.ExpectBinary(encodeID(typeUnsuback, 0, 2))
+		send(pub, publish("a/b", "three", 0, false, 0))
+		sub.ExpectNothing()
+
+		send(pub, appendPacket(nil, typePingreq, 0, nil))
+		pub.ExpectBinary(appendPacket(nil, typePingresp, 0, nil))
+
+		send(pub, appendPacket(nil, typeDisconnect, 0, nil))
+		pub.ExpectClose(easyws.StatusNormalClosure)
+		sub.Close()
+		if s := b.Sessions(); len(s) != 0 {
+			t.Fatalf("unexpected sessions: %q", s)
+		}
+	})
+
+	t.Run("retained", func(t *testing.T) {
+		pub := open(t, b)
+		send(pub, connect("pub", true, 0), publish("r/1", "one", 1, true, 1), publish("r/2", "two", 0, true, 0))
+		pub.ExpectBinary(encodeConnack(false, Accepted))
+		pub.ExpectBinary(encodeID(typePuback, 0, 1))
+		if m, ok := b.Retained("r/1"); !ok || string(m.Payload) != "one" {
+			t.Fatalf("unexpected retained message: %+v", m)
+		}
+
+		sub := open(t, b)
+		send(sub, connect("sub", true, 0), encodeSubscribe(1, []subscription{{"r/1", 0}}))
+		sub.ExpectBinary(encodeConnack(false, Accepted))
+		sub.ExpectBinary(appendPacket(nil, typeSuback, 0, []byte{0, 1, 0}))
+		sub.ExpectBinary(publish("r/1", "one", 0, true, 0))
+
+		// Retained messages are forwarded with retain flag cleared.
+		send(pub, publish("r/1", "uno", 0, true, 0))
+		sub.ExpectBinary(publish("r/1", "uno", 0, false, 0))
+
+		send(pub, publish("r/1", "", 0, true, 0))
+		sub.ExpectBinary(publish("r/1", "", 0, false, 0))
+		if _, ok := b.Retained("r/1"); ok {
+			t.Fatalf("retained message is not deleted")
+		}
+		pub.Close()
+		sub.Close()
+	})
+
+	t.Run("will", func(t *testing.T) {
+		sub := open(t, b)
+		send(sub, connect("sub", true, 0), encodeSubscribe(1, []subscription{{"will/#", 1}}))
+		sub.ExpectBinary(encodeConnack(false, Accepted))
+		sub.ExpectBinary(appendPacket(nil, typeSuback, 0, []byte{0, 1, 1}))
+
+		c := connectPacket{
+			protocol:     "MQTT",
+			level:        4,
+			cleanSession: true,
+			clientID:     "dev",
+			will:         &Message{Topic: "will/dev", Payload: []byte("gone")},
+		}
+		dev := open(t, b)
+		send(dev, c.encode(), appendPacket(nil, typeDisconnect, 0, nil))
+		dev.ExpectBinary(encodeConnack(false, Accepted))
+		dev.ExpectClose(easyws.StatusNormalClosure)
+		dev.Close()
+		sub.ExpectNothing()
+
+		dev = open(t, b)
+		send(dev, c.encode())
+		dev.ExpectBinary(encodeConnack(false, Accepted))
+		dev.Close()
+		sub.ExpectBinary(publish("will/dev", "gone", 0, false, 0))
+		sub.Close()
+	})
+
+	t.Run("persistent session", func(t *testing.T) {
+		sub := open(t, b)
+		send(sub, connect("sub", false, 0), encodeSubscribe(1, []subscription{{"p", 1}}))
+		sub.ExpectBinary(encodeConnack(false, Accepted))
+		sub.ExpectBinary(appendPacket(nil, typeSuback, 0, []byte{0, 1, 1}))
+
+		pub := open(t, b)
+		send(pub, connect("pub", true, 0), publish("p", "one", 1, false, 1))
+		pub.ExpectBinary(encodeConnack(false, Accepted))
+		pub.ExpectBinary(encodeID(typePuback, 0, 1))
+		sub.ExpectBinary(publish("p", "one", 1, false, 1))
+		sub.Close()
+
+		send(pub, publish("p", "two", 1, false, 2), publish("p", "three", 0, false, 0))
+		pub.ExpectBinary(encodeID(typePuback, 0, 2))
+
+		// Not acknowledged message is sent again, then the queued one.
+		sub = open(t, b)
+		send(sub, connect("sub", false, 0))
+		sub.ExpectBinary(encodeConnack(true, Accepted))
+		dup := publishPacket{Message: Message{Topic: "p", Payload: []byte("one"), QoS: 1, Dup: true}, id: 1}
+		sub.ExpectBinary(dup.encode())
+		sub.ExpectBinary(publish("p", "two", 1, false, 2))
+		sub.ExpectNothing()
+
+		// Connection with the same client ID takes the session over.
+		next := open(t, b)
+		send(next, connect("sub", true, 0))
+		next.ExpectBinary(encodeConnack(false, Accepted))
+		sub.ExpectClose(easyws.StatusPolicyViolation)
+		sub.Close()
+		next.Close()
+		pub.Close()
+		if s := b.Sessions(); len(s) != 0 {
+			t.Fatalf("unexpected sessions: %q", s)
+		}
+	})
+
+	t.Run("inflight", func(t *testing.T) {
+		b := &Broker{MaxInflight: 1}
+		sub := open(t, b)
+		send(sub, connect("sub", true, 0), encodeSubscribe(1, []subscription{{"q", 1}}))
+		sub.ExpectBinary(encodeConnack(false, Accepted))
+		sub.ExpectBinary(appendPacket(nil, typeSuback, 0, []byte{0, 1, 1}))
+
+		b.Publish(Message{Topic: "q", Payload: []byte("1"), QoS: 1})
+		b.Publish(Message{Topic: "q", Payload: []byte("2"), QoS: 1})
+		sub.ExpectBinary(publish("q", "1", 1, false, 1))
+		sub.ExpectNothing()
+		send(sub, encodeID(typePuback, 0, 1))
+		sub.ExpectBinary(publish("q", "2", 1, false, 2))
+	})
+}
+
+func TestBrokerExpiry(t *testing.T) {
+	b := &Broker{SessionExpiry: 20 * time.Millisecond}
+	ws := open(t, b)
+	send(ws, connect("dev", false, 1))
+	ws.ExpectBinary(encodeConnack(false, Accepted))
+
+	// Keep alive of one second expires in one and a half.
+	ws.WaitClosed()
+	ws.ExpectClose(easyws.StatusPolicyViolation)
+	deadline := time.Now().Add(wstest.WaitTimeout)
+	for len(b.Sessions()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("session is not expired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type inbox struct {
+	mu   sync.Mutex
+	msgs []Message
+	ch   chan struct{}
+}
+
+func newInbox() *inbox {
+	return &inbox{ch: make(chan struct{}, 100)}
+}
+
+func (i *inbox) handle(m Message) {
+	i.mu.Lock()
+	i.msgs = append(i.msgs, m)
+	i.mu.Unlock()
+	i.ch <- struct{}{}
+}
+
+func (i *inbox) expect(t *testing.T, topic, payload string, retain bool) {
+	t.Helper()
+	select {
+	case <-i.ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message %q is not received", payload)
+	}
+	i.mu.Lock()
+	m := i.msgs[0]
+	i.msgs = i.msgs[1:]
+	i.mu.Unlock()
+	if m.Topic != topic || string(m.Payload) != payload || m.Retain != retain {
+		t.Fatalf("unexpected message: %+v; want %s %q", m, topic, payload)
+	}
+}
+
+func TestClient(t *testing.T) {
+	b := &Broker{
+		Authenticate: func(_ *easyws.Conn, clientID, username string, password []byte) error {
+			if username == "bad" {
+				return &ConnectError{Code: RefusedBadCredentials}
+			}
+			return nil
+		},
+	}
+	mux := easyws.NewProtocolMux()
+	b.Register(mux)
+	url := wstest.Serve(t, mux)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := Dialer{Username: "bad", CleanSession: true}.Dial(ctx, url, nil)
+	var ce *ConnectError
+	if !errors.As(err, &ce) || ce.Code != RefusedBadCredentials {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	in := newInbox()
+	sub, err := Dialer{ClientID: "sub", KeepAlive: time.Second, Timeout: 5 * time.Second}.Dial(ctx, url, in.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.SessionPresent() {
+		t.Fatalf("unexpected session")
+	}
+	if qos, err := sub.Subscribe(ctx, "home/+/temp", 1); err != nil || qos != 1 {
+		t.Fatalf("Subscribe() = %d, %v", qos, err)
+	}
+	if _, err := sub.Subscribe(ctx, "home/#/temp", 0); err != ErrSubscriptionRejected {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pub, err := Dialer{
+		CleanSession: true,
+		Will:         &Message{Topic: "home/pub/temp", Payload: []byte("offline"), Retain: true},
+	}.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish(ctx, Message{Topic: "home/kitchen/temp", Payload: []byte("21"), QoS: 1}); err != nil {
+		t.Fatal(err)
+	}
+	in.expect(t, "home/kitchen/temp", "21", false)
+
+	// Ungraceful close publishes the will.
+	pub.Close()
+	in.expect(t, "home/pub/temp", "offline", false)
+	if m, ok := b.Retained("home/pub/temp"); !ok || string(m.Payload) != "offline" {
+		t.Fatalf("will is not retained: %+v", m)
+	}
+
+	// Messages published while the client is offline are delivered when it
+	// resumes the session.
+	if err := sub.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	b.Publish(Message{Topic: "home/hall/temp", Payload: []byte("19"), QoS: 1})
+	sub, err = Dialer{ClientID: "sub"}.Dial(ctx, url, in.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sub.SessionPresent() {
+		t.Fatalf("session is not resumed")
+	}
+	in.expect(t, "home/hall/temp", "19", false)
+
+	if err := sub.Unsubscribe(ctx, "home/+/temp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Publish(ctx, Message{Topic: "x", QoS: 1}); err != ErrClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := b.Sessions(); !reflect.DeepEqual(s, []string{"sub"}) {
+		t.Fatalf("unexpected sessions: %q", s)
+	}
+}
@@ -0,0 +1,390 @@
+package mqtt
+
+import (
+	"errors"
+	"io"
+)
+
+// Control packet types.
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typePubrec      = 5
+	typePubrel      = 6
+	typePubcomp     = 7
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typeUnsubscribe = 10
+	typeUnsuback    = 11
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+)
+
+// maxRemainingLength is the largest remaining length encodable with four
+// bytes.
+const maxRemainingLength = 268435455
+
+// Errors used when packets could not be decoded.
+var (
+	ErrMalformedPacket = errors.New("mqtt: malformed packet")
+	ErrPacketTooLarge  = errors.New("mqtt: packet too large")
+)
+
+// packet is a control packet split into the fixed header and the rest.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads a packet from the beginning of buf. It returns the number
+// of bytes the packet takes. If buf does not hold the whole packet,
+// io.ErrUnexpectedEOF is returned. Max limits the size of the packet; zero
+// means no limit except the protocol one.
+func readPacket(buf []byte, max int) (p packet, n int, err error) {
+	if len(buf) < 2 {
+		return p, 0, io.ErrUnexpectedEOF
+	}
+	var (
+		length int
+		shift  uint
+		i      = 1
+	)
+	for {
+		if i == len(buf) {
+			return p, 0, io.ErrUnexpectedEOF
+		}
+		if i > 4 {
+			return p, 0, ErrMalformedPacket
+		}
+		b := buf[i]
+		i++
+		length |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	n = i + length
+	if max > 0 && n > max {
+		return p, 0, ErrPacketTooLarge
+	}
+	if len(buf) < n {
+		return p, 0, io.ErrUnexpectedEOF
+	}
+	p = packet{
+		typ:   buf[0] >> 4,
+		flags: buf[0] & 0x0f,
+		body:  buf[i:n],
+	}
+	return p, n, nil
+}
+
+// appendPacket appends encoded packet with given type, flags and body to
+// dst.
+func appendPacket(dst []byte, typ, flags byte, body []byte) []byte {
+	dst = append(dst, typ<<4|flags&0x0f)
+	n := len(body)
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			b |= 0x80
+		}
+		dst = append(dst, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(dst, body...)
+}
+
+func appendUint16(dst []byte, v uint16) []byte {
+	return append(dst, byte(v>>8), byte(v))
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = appendUint16(dst, uint16(len(s)))
+	return append(dst, s...)
+}
+
+func appendBytes(dst []byte, p []byte) []byte {
+	dst = appendUint16(dst, uint16(len(p)))
+	return append(dst, p...)
+}
+
+// decoder reads fields of the packet body. The first error is kept in err
+// and makes subsequent reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = ErrMalformedPacket
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.b) < 2 {
+		d.err = ErrMalformedPacket
+		return 0
+	}
+	v := uint16(d.b[0])<<8 | uint16(d.b[1])
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.b) < n {
+		d.err = ErrMalformedPacket
+		return nil
+	}
+	v := d.b[:n:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// rest returns a copy of the remaining bytes.
+func (d *decoder) rest() []byte {
+	v := append([]byte{}, d.b...)
+	d.b = nil
+	return v
+}
+
+func (d *decoder) empty() bool {
+	return len(d.b) == 0
+}
+
+// connectPacket is the CONNECT packet.
+type connectPacket struct {
+	protocol     string
+	level        byte
+	cleanSession bool
+	keepAlive    uint16
+	clientID     string
+	will         *Message
+	username     *string
+	password     []byte
+}
+
+const (
+	flagUsername     = 0x80
+	flagPassword     = 0x40
+	flagWillRetain   = 0x20
+	flagWill         = 0x04
+	flagCleanSession = 0x02
+)
+
+func (c *connectPacket) encode() []byte {
+	var flags byte
+	if c.cleanSession {
+		flags |= flagCleanSession
+	}
+	if c.will != nil {
+		flags |= flagWill | c.will.QoS<<3
+		if c.will.Retain {
+			flags |= flagWillRetain
+		}
+	}
+	if c.username != nil {
+		flags |= flagUsername
+	}
+	if c.password != nil {
+		flags |= flagPassword
+	}
+	b := appendString(nil, c.protocol)
+	b = append(b, c.level, flags)
+	b = appendUint16(b, c.keepAlive)
+	b = appendString(b, c.clientID)
+	if c.will != nil {
+		b = appendString(b, c.will.Topic)
+		b = appendBytes(b, c.will.Payload)
+	}
+	if c.username != nil {
+		b = appendString(b, *c.username)
+	}
+	if c.password != nil {
+		b = appendBytes(b, c.password)
+	}
+	return appendPacket(nil, typeConnect, 0, b)
+}
+
+func (c *connectPacket) decode(p packet) error {
+	d := decoder{b: p.body}
+	c.protocol = d.string()
+	c.level = d.byte()
+	flags := d.byte()
+	c.keepAlive = d.uint16()
+	if d.err != nil {
+		return d.err
+	}
+	if c.protocol != "MQTT" || c.level != 4 {
+		// The rest is not decoded: the caller refuses the connection.
+		return nil
+	}
+	if p.flags != 0 || flags&0x01 != 0 {
+		return ErrMalformedPacket
+	}
+	willQoS := flags >> 3 & 0x03
+	if flags&flagWill == 0 && (willQoS != 0 || flags&flagWillRetain != 0) || willQoS > 2 {
+		return ErrMalformedPacket
+	}
+	if flags&flagPassword != 0 && flags&flagUsername == 0 {
+		return ErrMalformedPacket
+	}
+	c.cleanSession = flags&flagCleanSession != 0
+	c.clientID = d.string()
+	if flags&flagWill != 0 {
+		c.will = &Message{
+			Topic:  d.string(),
+			QoS:    willQoS,
+			Retain: flags&flagWillRetain != 0,
+		}
+		c.will.Payload = append([]byte{}, d.bytes()...)
+	}
+	if flags&flagUsername != 0 {
+		s := d.string()
+		c.username = &s
+	}
+	if flags&flagPassword != 0 {
+		c.password = append([]byte{}, d.bytes()...)
+	}
+	if d.err == nil && !d.empty() {
+		return ErrMalformedPacket
+	}
+	return d.err
+}
+
+func encodeConnack(sessionPresent bool, code byte) []byte {
+	var sp byte
+	if sessionPresent {
+		sp = 1
+	}
+	return appendPacket(nil, typeConnack, 0, []byte{sp, code})
+}
+
+// publishPacket is the PUBLISH packet.
+type publishPacket struct {
+	Message
+	id uint16
+}
+
+func (p *publishPacket) encode() []byte {
+	var flags byte
+	if p.Dup {
+		flags |= 0x08
+	}
+	flags |= p.QoS << 1
+	if p.Retain {
+		flags |= 0x01
+	}
+	b := appendString(make([]byte, 0, 4+len(p.Topic)+len(p.Payload)), p.Topic)
+	if p.QoS > 0 {
+		b = appendUint16(b, p.id)
+	}
+	b = append(b, p.Payload...)
+	return appendPacket(nil, typePublish, flags, b)
+}
+
+func (p *publishPacket) decode(pkt packet) error {
+	p.Dup = pkt.flags&0x08 != 0
+	p.QoS = pkt.flags >> 1 & 0x03
+	p.Retain = pkt.flags&0x01 != 0
+	if p.QoS == 3 {
+		return ErrMalformedPacket
+	}
+	d := decoder{b: pkt.body}
+	p.Topic = d.string()
+	if p.QoS > 0 {
+		p.id = d.uint16()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	p.Payload = d.rest()
+	return nil
+}
+
+// encodeID encodes a packet which body is the packet identifier only.
+func encodeID(typ, flags byte, id uint16) []byte {
+	return appendPacket(nil, typ, flags, appendUint16(nil, id))
+}
+
+// decodeID decodes a packet which body is the packet identifier only.
+func decodeID(p packet) (uint16, error) {
+	d := decoder{b: p.body}
+	id := d.uint16()
+	if d.err == nil && !d.empty() {
+		return 0, ErrMalformedPacket
+	}
+	return id, d.err
+}
+
+// subscription is a topic filter with the requested QoS.
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+func encodeSubscribe(id uint16, subs []subscription) []byte {
+	b := appendUint16(nil, id)
+	for _, s := range subs {
+		b = appendString(b, s.filter)
+		b = append(b, s.qos)
+	}
+	return appendPacket(nil, typeSubscribe, 0x02, b)
+}
+
+func decodeSubscribe(p packet) (id uint16, subs []subscription, err error) {
+	if p.flags != 0x02 {
+		return 0, nil, ErrMalformedPacket
+	}
+	d := decoder{b: p.body}
+	id = d.uint16()
+	for d.err == nil && !d.empty() {
+		s := subscription{filter: d.string()}
+		s.qos = d.byte()
+		subs = append(subs, s)
+	}
+	if d.err == nil && len(subs) == 0 {
+		return 0, nil, ErrMalformedPacket
+	}
+	return id, subs, d.err
+}
+
+func encodeUnsubscribe(id uint16, filters []string) []byte {
+	b := appendUint16(nil, id)
+	for _, f := range filters {
+		b = appendString(b, f)
+	}
+	return appendPacket(nil, typeUnsubscribe, 0x02, b)
+}
+
+func decodeUnsubscribe(p packet) (id uint16, filters []string, err error) {
+	if p.flags != 0x02 {
+		return 0, nil, ErrMalformedPacket
+	}
+	d := decoder{b: p.body}
+	id = d.uint16()
+	for d.err == nil && !d.empty() {
+		filters = append(filters, d.string())
+	}
+	if d.err == nil && len(filters) == 0 {
+		return 0, nil, ErrMalformedPacket
+	}
+	return id, filters, d.err
+}
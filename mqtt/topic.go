@@ -0,0 +1,67 @@
+package mqtt
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// validTopic reports whether name could be used as a topic of published
+// message: it must be non-empty and must not contain wildcards.
+func validTopic(name string) bool {
+	return validString(name) && !strings.ContainsAny(name, "+#")
+}
+
+// validFilter reports whether f is a valid topic filter. The multi-level
+// wildcard "#" must be the last level and the single-level wildcard "+" must
+// occupy the whole level.
+func validFilter(f string) bool {
+	if !validString(f) {
+		return false
+	}
+	levels := strings.Split(f, "/")
+	for i, l := range levels {
+		switch {
+		case l == "#":
+			if i != len(levels)-1 {
+				return false
+			}
+		case l == "+":
+		case strings.ContainsAny(l, "+#"):
+			return false
+		}
+	}
+	return true
+}
+
+func validString(s string) bool {
+	return s != "" && len(s) <= 65535 && utf8.ValidString(s) && strings.IndexByte(s, 0) == -1
+}
+
+// Match reports whether topic name matches topic filter. Filters starting
+// with a wildcard do not match topics starting with "$", such as "$SYS".
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	for {
+		f, frest, fmore := strings.Cut(filter, "/")
+		if f == "#" {
+			return true
+		}
+		t, trest, tmore := strings.Cut(topic, "/")
+		if f != "+" && f != t {
+			return false
+		}
+		switch {
+		case fmore && tmore:
+			filter, topic = frest, trest
+		case !fmore && !tmore:
+			return true
+		case fmore && !tmore:
+			// "sport/#" matches "sport" too.
+			return frest == "#"
+		default:
+			return false
+		}
+	}
+}
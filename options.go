@@ -0,0 +1,44 @@
+package easyws
+
+// ServerOption configures NetHandler created by NewNetHandler or NewEasyWs.
+type ServerOption func(*NetHandler)
+
+// WithUpgrader returns an option that makes NetHandler to use given Upgrader
+// for incoming connections.
+func WithUpgrader(u Upgrader) ServerOption {
+	return func(h *NetHandler) {
+		h.Upgrader = u
+	}
+}
+
+// WithValidator returns an option that makes NetHandler to check received
+// frames with given Validator.
+func WithValidator(v Validator) ServerOption {
+	return func(h *NetHandler) {
+		h.Validator = v
+	}
+}
+
+// WithMaxFrameSize returns an option that limits the payload length of
+// received frames. See NetHandler.MaxFrameSize.
+func WithMaxFrameSize(n int64) ServerOption {
+	return func(h *NetHandler) {
+		h.MaxFrameSize = n
+	}
+}
+
+// WithMaxMessageSize returns an option that limits the length of received
+// messages. See NetHandler.MaxMessageSize.
+func WithMaxMessageSize(n int64) ServerOption {
+	return func(h *NetHandler) {
+		h.MaxMessageSize = n
+	}
+}
+
+// WithReuseBuffers returns an option that makes NetHandler to reuse buffers
+// of received frames. See NetHandler.ReuseBuffers.
+func WithReuseBuffers() ServerOption {
+	return func(h *NetHandler) {
+		h.ReuseBuffers = true
+	}
+}
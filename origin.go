@@ -0,0 +1,202 @@
+package easyws
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/EternalVow/easyws/httphead"
+)
+
+// Errors returned by Upgrader when request does not pass the origin or CSRF
+// checks.
+var (
+	ErrHandshakeBadOrigin = RejectConnectionError(
+		RejectionStatus(http.StatusForbidden),
+		RejectionReason("handshake error: origin not allowed"),
+	)
+	ErrHandshakeBadCSRFToken = RejectConnectionError(
+		RejectionStatus(http.StatusForbidden),
+		RejectionReason("handshake error: bad csrf token"),
+	)
+)
+
+// Headers checked by Upgrader to protect from cross-site WebSocket hijacking.
+const (
+	headerOriginCanonical = "Origin"
+	headerCookieCanonical = "Cookie"
+)
+
+// SameOrigin is the origin check which allows requests whose Origin host
+// equals to the Host header, ignoring default ports. It is used by Upgrader
+// when CheckOrigin is nil.
+func SameOrigin(origin, host []byte) bool {
+	scheme, h, ok := splitOrigin(origin)
+	if !ok {
+		return false
+	}
+	return strings.EqualFold(
+		stripDefaultPort(scheme, h),
+		stripDefaultPort(scheme, string(host)),
+	)
+}
+
+// AnyOrigin is the origin check which allows requests from any origin. It
+// disables protection from cross-site WebSocket hijacking, so it should be
+// used only for endpoints which do not rely on browser credentials such as
+// cookies.
+func AnyOrigin(origin, host []byte) bool {
+	return true
+}
+
+// AllowOrigins returns the origin check which allows requests whose Origin
+// matches one of the given patterns.
+//
+// Patterns containing "://" are matched against the whole origin, like
+// "https://*.example.com"; others are matched against origin host and port
+// only, like "example.com:8080". Asterisk matches any sequence of characters
+// within single host label or port. Single "*" pattern matches any origin.
+// Matching is case insensitive.
+func AllowOrigins(patterns ...string) func(origin, host []byte) bool {
+	ps := make([]string, len(patterns))
+	for i, p := range patterns {
+		ps[i] = strings.ToLower(p)
+	}
+	return func(origin, _ []byte) bool {
+		scheme, host, ok := splitOrigin(origin)
+		if !ok {
+			return false
+		}
+		full := strings.ToLower(scheme + "://" + host)
+		host = strings.ToLower(host)
+		for _, p := range ps {
+			if p == "*" {
+				return true
+			}
+			s := host
+			if strings.Contains(p, "://") {
+				s = full
+			}
+			if matchWildcard(p, s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// splitOrigin splits origin serialization like "https://example.com:8080"
+// into scheme and host with optional port. It returns false for opaque
+// origins like "null".
+func splitOrigin(origin []byte) (scheme, host string, ok bool) {
+	i := bytes.Index(origin, []byte("://"))
+	if i <= 0 {
+		return "", "", false
+	}
+	scheme, host = string(origin[:i]), string(origin[i+3:])
+	if host == "" || strings.ContainsAny(host, "/?#@") {
+		return "", "", false
+	}
+	return scheme, host, true
+}
+
+func stripDefaultPort(scheme, host string) string {
+	var port string
+	switch strings.ToLower(scheme) {
+	case "http", "ws":
+		port = ":80"
+	case "https", "wss":
+		port = ":443"
+	}
+	if port != "" && strings.HasSuffix(host, port) {
+		return host[:len(host)-len(port)]
+	}
+	return host
+}
+
+// matchWildcard reports whether s matches pattern p where asterisk matches
+// any sequence of characters except '.', ':' and '/'.
+func matchWildcard(p, s string) bool {
+	for len(p) > 0 {
+		if p[0] != '*' {
+			if len(s) == 0 || s[0] != p[0] {
+				return false
+			}
+			p, s = p[1:], s[1:]
+			continue
+		}
+		p = p[1:]
+		for i := 0; ; i++ {
+			if matchWildcard(p, s[i:]) {
+				return true
+			}
+			if i == len(s) || strings.IndexByte(".:/", s[i]) != -1 {
+				return false
+			}
+		}
+	}
+	return len(s) == 0
+}
+
+// CSRFCheck contains options of the CSRF token verification made by
+// Upgrader.
+//
+// Browsers send cookies with cross-site WebSocket handshakes, so endpoints
+// which rely on cookie authentication could additionally require a token
+// that can not be known by a third party site.
+type CSRFCheck struct {
+	// Param and Cookie are the names of the query parameter and the cookie
+	// holding the token. If both are set, then the query parameter is
+	// checked first.
+	Param, Cookie string
+
+	// Verify reports whether the token is valid. If Verify is nil, then no
+	// check is made.
+	//
+	// The argument is only valid until the callback returns.
+	Verify func(token []byte) bool
+}
+
+// check reports whether the request with given uri and Cookie header value
+// contains valid token.
+func (c CSRFCheck) check(uri, cookie []byte) bool {
+	if c.Verify == nil {
+		return true
+	}
+	if c.Param != "" {
+		if i := bytes.IndexByte(uri, '?'); i != -1 {
+			q, err := url.ParseQuery(string(uri[i+1:]))
+			if err == nil && q.Has(c.Param) {
+				return c.Verify([]byte(q.Get(c.Param)))
+			}
+		}
+	}
+	if c.Cookie != "" {
+		if v, ok := cookieValue(cookie, c.Cookie); ok {
+			return c.Verify(v)
+		}
+	}
+	return false
+}
+
+// cookieValue returns the value of cookie with given name from the Cookie
+// header value.
+func cookieValue(header []byte, name string) (value []byte, ok bool) {
+	for len(header) > 0 {
+		var pair []byte
+		if i := bytes.IndexByte(header, ';'); i != -1 {
+			pair, header = header[:i], header[i+1:]
+		} else {
+			pair, header = header, nil
+		}
+		k, v, _ := bytes.Cut(btrim(pair), []byte{'='})
+		if httphead.BtsToString(k) == name {
+			if n := len(v); n >= 2 && v[0] == '"' && v[n-1] == '"' {
+				v = v[1 : n-1]
+			}
+			return v, true
+		}
+	}
+	return nil, false
+}
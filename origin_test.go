@@ -0,0 +1,100 @@
+package easyws
+
+import (
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+func TestOriginChecks(t *testing.T) {
+	for _, test := range []struct {
+		check  func(origin, host []byte) bool
+		origin string
+		host   string
+		ok     bool
+	}{
+		{SameOrigin, "http://localhost:9001", "localhost:9001", true},
+		{SameOrigin, "https://Example.com", "example.com:443", true},
+		{SameOrigin, "http://example.com:80", "example.com", true},
+		{SameOrigin, "https://example.com", "example.com:80", false},
+		{SameOrigin, "https://evil.com", "example.com", false},
+		{SameOrigin, "null", "example.com", false},
+		{SameOrigin, "https://example.com/path", "example.com", false},
+
+		{AnyOrigin, "null", "example.com", true},
+
+		{AllowOrigins("*"), "https://evil.com", "example.com", true},
+		{AllowOrigins("https://*.example.com"), "https://app.example.com", "", true},
+		{AllowOrigins("https://*.example.com"), "http://app.example.com", "", false},
+		{AllowOrigins("https://*.example.com"), "https://a.b.example.com", "", false},
+		{AllowOrigins("https://*.example.com"), "https://example.com", "", false},
+		{AllowOrigins("example.com:*"), "http://example.com:8080", "", true},
+		{AllowOrigins("example.com:*"), "http://example.com", "", false},
+		{AllowOrigins("foo.com", "BAR.com"), "wss://bar.com", "", true},
+		{AllowOrigins("foo.com"), "null", "", false},
+	} {
+		if ok := test.check([]byte(test.origin), []byte(test.host)); ok != test.ok {
+			t.Errorf("check(%q, %q) = %v; want %v", test.origin, test.host, ok, test.ok)
+		}
+	}
+}
+
+func TestUpgraderOrigin(t *testing.T) {
+	token := func(v []byte) bool { return string(v) == "secret" }
+	for _, test := range []struct {
+		name   string
+		u      Upgrader
+		uri    string
+		header string
+		err    error
+	}{
+		{"no origin", Upgrader{}, "/", "", nil},
+		{"same origin", Upgrader{}, "/", "Origin: http://localhost\r\n", nil},
+		{"cross origin", Upgrader{}, "/", "Origin: http://evil.com\r\n", ErrHandshakeBadOrigin},
+		{
+			"allowed origin",
+			Upgrader{CheckOrigin: AllowOrigins("evil.com")},
+			"/", "Origin: http://evil.com\r\n", nil,
+		},
+		{
+			"csrf param",
+			Upgrader{CSRF: CSRFCheck{Param: "csrf", Verify: token}},
+			"/ws?a=b&csrf=secret", "", nil,
+		},
+		{
+			"csrf bad param",
+			Upgrader{CSRF: CSRFCheck{Param: "csrf", Cookie: "csrf", Verify: token}},
+			"/ws?csrf=guess", "Cookie: csrf=secret\r\n", ErrHandshakeBadCSRFToken,
+		},
+		{
+			"csrf cookie",
+			Upgrader{CSRF: CSRFCheck{Param: "csrf", Cookie: "csrf", Verify: token}},
+			"/ws", "Cookie: session=1; csrf=\"secret\"\r\n", nil,
+		},
+		{
+			"csrf missing",
+			Upgrader{CSRF: CSRFCheck{Cookie: "csrf", Verify: token}},
+			"/ws", "Cookie: session=1\r\n", ErrHandshakeBadCSRFToken,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			stream := &base.InputStream{}
+			stream.Begin([]byte("GET " + test.uri + " HTTP/1.1\r\n" +
+				"Host: localhost\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Version: 13\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+				test.header +
+				"\r\n",
+			))
+			_, out, err := test.u.Upgrade(stream)
+			if err != test.err {
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			}
+			if test.err != nil && string(out[:len("HTTP/1.1 403")]) != "HTTP/1.1 403" {
+				t.Fatalf("unexpected response: %q", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package easyws
+
+import (
+	"bytes"
+	"math/bits"
+	"sync"
+)
+
+// Bounds of byte slices capacity which are reused with pools.
+const (
+	minPooledBits = 6
+	maxPooledBits = 16
+
+	minPooledSize = 1 << minPooledBits
+	maxPooledSize = 1 << maxPooledBits
+)
+
+// bytesPools holds pools of slices with capacity of the power of two, from
+// minPooledSize up to maxPooledSize.
+var bytesPools [maxPooledBits - minPooledBits + 1]sync.Pool
+
+// getBytes returns slice of n bytes. Its capacity may be greater than n.
+//
+// It returns pointer to the slice so it could be put back to the pool
+// without allocation. Slice should be returned with putBytes when it is not
+// needed anymore.
+func getBytes(n int) *[]byte {
+	i := poolIndex(n)
+	if i == -1 {
+		p := make([]byte, n)
+		return &p
+	}
+	if p, _ := bytesPools[i].Get().(*[]byte); p != nil {
+		*p = (*p)[:n]
+		return p
+	}
+	p := make([]byte, n, minPooledSize<<i)
+	return &p
+}
+
+// putBytes returns slice obtained with getBytes to the pool.
+func putBytes(p *[]byte) {
+	c := cap(*p)
+	if c < minPooledSize || c&(c-1) != 0 {
+		// Not allocated by getBytes.
+		return
+	}
+	if i := poolIndex(c); i != -1 {
+		bytesPools[i].Put(p)
+	}
+}
+
+// poolIndex returns index of the pool holding slices which are able to hold
+// n bytes. It returns -1 if such slices are not pooled.
+func poolIndex(n int) int {
+	switch {
+	case n > maxPooledSize:
+		return -1
+	case n <= minPooledSize:
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minPooledBits
+}
+
+// bufferPool holds buffers used to write handshake responses.
+var bufferPool sync.Pool
+
+// getBuffer returns empty buffer with at least n bytes of capacity.
+func getBuffer(n int) *bytes.Buffer {
+	buf, _ := bufferPool.Get().(*bytes.Buffer)
+	if buf == nil {
+		buf = new(bytes.Buffer)
+	}
+	buf.Grow(n)
+	return buf
+}
+
+// putBuffer returns buffer obtained with getBuffer to the pool.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledSize {
+		// Do not hold memory of huge responses.
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
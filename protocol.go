@@ -0,0 +1,250 @@
+package easyws
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/EternalVow/easyws/httphead"
+)
+
+// ErrHandshakeNoProtocol is returned by ProtocolMux when the client does not
+// offer any of the registered subprotocols and there is no default handler.
+var ErrHandshakeNoProtocol = RejectConnectionError(
+	RejectionStatus(http.StatusBadRequest),
+	RejectionReason("handshake error: no supported subprotocol"),
+)
+
+// ProtocolMux is an IEasyWs which dispatches connections to handlers
+// registered for the subprotocol negotiated during the handshake.
+//
+// When ProtocolMux is served by NetHandler, it makes Upgrader to select one
+// of the registered subprotocols offered by the client and to echo it in the
+// Sec-WebSocket-Protocol response header. Upgrader.Protocol and
+// Upgrader.ProtocolCustom must not be set in this case; NewNetHandler panics
+// otherwise, as well as the handshake if they are set later. Upgrader.OnProtocol, if set, is called with the selected
+// subprotocol after the mux accepted it, so it could reject the handshake as
+// well.
+//
+// Registered handlers receive OnUpgraded, OnReceive and OnClose calls only
+// for the connections of their subprotocol; optional IEasyWsMessage and
+// IEasyWsConn interfaces are supported as well. OnStart and OnShutdown are
+// called for every handler. OnConnect is never called for registered
+// handlers since subprotocol is not known at that time.
+//
+// Handlers must be registered before serving connections.
+type ProtocolMux struct {
+	// Default is the handler of connections for which no subprotocol was
+	// selected, that is, clients which offered none of the registered
+	// subprotocols or did not offer any. If Default is nil, such
+	// handshakes are rejected with ErrHandshakeNoProtocol.
+	Default IEasyWs
+
+	// ServerPreference makes mux to select the subprotocol in the order of
+	// registration rather than in the order of client's preference.
+	//
+	// Note that if the client sends multiple Sec-WebSocket-Protocol headers,
+	// the order is applied within the first one having registered
+	// subprotocols. Browsers always send a single header.
+	ServerPreference bool
+
+	protocols []string
+	handlers  map[string]IEasyWs
+}
+
+// NewProtocolMux creates empty ProtocolMux.
+func NewProtocolMux() *ProtocolMux {
+	return &ProtocolMux{
+		handlers: map[string]IEasyWs{},
+	}
+}
+
+// Handle registers handler h for the given subprotocol. It panics if
+// protocol is already registered.
+func (m *ProtocolMux) Handle(protocol string, h IEasyWs) {
+	if m.handlers == nil {
+		m.handlers = map[string]IEasyWs{}
+	}
+	if _, ok := m.handlers[protocol]; ok {
+		panic("easyws: multiple registrations for protocol " + protocol)
+	}
+	m.protocols = append(m.protocols, protocol)
+	m.handlers[protocol] = h
+}
+
+// Protocols returns registered subprotocols in the order of registration.
+func (m *ProtocolMux) Protocols() []string {
+	return append([]string(nil), m.protocols...)
+}
+
+// Handler returns the handler for the given subprotocol. Empty protocol
+// refers to the Default handler.
+func (m *ProtocolMux) Handler(protocol string) IEasyWs {
+	if protocol == "" {
+		return m.Default
+	}
+	return m.handlers[protocol]
+}
+
+// setup configures u to negotiate subprotocols registered in m. It panics if
+// u selects subprotocols on its own. It is called for every handshake, so
+// that Upgrader and handler of NetHandler could be changed after
+// NewNetHandler.
+func (m *ProtocolMux) setup(u *Upgrader) {
+	if u.Protocol != nil || u.ProtocolCustom != nil {
+		panic("easyws: Upgrader.Protocol and Upgrader.ProtocolCustom must not be set for ProtocolMux")
+	}
+	u.ProtocolCustom = m.selectProtocol
+	u.OnProtocol = m.checkProtocol(u.OnProtocol)
+}
+
+// selectProtocol selects the subprotocol from the Sec-WebSocket-Protocol
+// header value.
+func (m *ProtocolMux) selectProtocol(v []byte) (string, bool) {
+	var (
+		selected = ""
+		rank     = len(m.protocols)
+	)
+	ok := ScanTokens(v, func(p []byte) bool {
+		if _, has := m.handlers[httphead.BtsToString(p)]; !has {
+			return true
+		}
+		if !m.ServerPreference {
+			selected = string(p)
+			return false
+		}
+		for i := 0; i < rank; i++ {
+			if m.protocols[i] == httphead.BtsToString(p) {
+				selected, rank = m.protocols[i], i
+				break
+			}
+		}
+		return rank > 0
+	})
+	return selected, ok
+}
+
+// checkProtocol returns Upgrader.OnProtocol callback which rejects
+// handshakes without subprotocol if there is no Default handler and then
+// calls next, if any.
+func (m *ProtocolMux) checkProtocol(next func(string) error) func(string) error {
+	return func(protocol string) error {
+		if protocol == "" && m.Default == nil {
+			return ErrHandshakeNoProtocol
+		}
+		if next != nil {
+			return next(protocol)
+		}
+		return nil
+	}
+}
+
+// handler returns the handler of c.
+func (m *ProtocolMux) handler(c *Conn) IEasyWs {
+	return m.Handler(c.Handshake().Protocol)
+}
+
+// each calls fn for every distinct handler. It returns the first error.
+func (m *ProtocolMux) each(fn func(IEasyWs) error) (err error) {
+	seen := make(map[IEasyWs]bool, len(m.handlers)+1)
+	call := func(h IEasyWs) {
+		if h == nil {
+			return
+		}
+		if reflect.TypeOf(h).Comparable() {
+			if seen[h] {
+				return
+			}
+			seen[h] = true
+		}
+		if e := fn(h); e != nil && err == nil {
+			err = e
+		}
+	}
+	for _, p := range m.protocols {
+		call(m.handlers[p])
+	}
+	call(m.Default)
+	return err
+}
+
+// OnStart implements IEasyWs by calling OnStart of every handler.
+func (m *ProtocolMux) OnStart() (OpCode, error) {
+	return 0, m.each(func(h IEasyWs) error {
+		_, err := h.OnStart()
+		return err
+	})
+}
+
+// OnShutdown implements IEasyWs by calling OnShutdown of every handler.
+func (m *ProtocolMux) OnShutdown() (OpCode, error) {
+	return 0, m.each(func(h IEasyWs) error {
+		_, err := h.OnShutdown()
+		return err
+	})
+}
+
+// OnConnect implements IEasyWs. It does nothing.
+func (m *ProtocolMux) OnConnect() (OpCode, error) { return 0, nil }
+
+// OnUpgraded implements IEasyWs. It does nothing; handlers are notified by
+// OnOpen.
+func (m *ProtocolMux) OnUpgraded() (OpCode, error) { return 0, nil }
+
+// OnClose implements IEasyWs. It does nothing; handlers are notified by
+// OnDisconnect.
+func (m *ProtocolMux) OnClose(err error) (OpCode, error) { return 0, nil }
+
+// OnReceive implements IEasyWs. It passes msg to the Default handler, since
+// the connection is unknown.
+func (m *ProtocolMux) OnReceive(msg []byte) ([]byte, OpCode, error) {
+	if m.Default == nil {
+		return nil, 0, nil
+	}
+	return m.Default.OnReceive(msg)
+}
+
+// OnMessage implements IEasyWsMessage by passing msg to the handler of conn.
+func (m *ProtocolMux) OnMessage(conn *Conn, op OpCode, msg []byte) ([]byte, OpCode, error) {
+	h := m.handler(conn)
+	if h == nil {
+		return nil, 0, nil
+	}
+	if mh, ok := h.(IEasyWsMessage); ok {
+		return mh.OnMessage(conn, op, msg)
+	}
+	return h.OnReceive(msg)
+}
+
+// OnOpen implements IEasyWsConn by calling OnUpgraded and OnOpen of the
+// handler of conn. If either of them fails, OnClose of the handler is called,
+// since OnDisconnect is not called for such connections.
+func (m *ProtocolMux) OnOpen(conn *Conn) error {
+	h := m.handler(conn)
+	if h == nil {
+		return nil
+	}
+	if _, err := h.OnUpgraded(); err != nil {
+		h.OnClose(err)
+		return err
+	}
+	if ch, ok := h.(IEasyWsConn); ok {
+		if err := ch.OnOpen(conn); err != nil {
+			h.OnClose(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// OnDisconnect implements IEasyWsConn by calling OnDisconnect and OnClose of
+// the handler of conn.
+func (m *ProtocolMux) OnDisconnect(conn *Conn, err error) {
+	h := m.handler(conn)
+	if h == nil {
+		return
+	}
+	if ch, ok := h.(IEasyWsConn); ok {
+		ch.OnDisconnect(conn, err)
+	}
+	h.OnClose(err)
+}
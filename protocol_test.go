@@ -0,0 +1,219 @@
+package easyws
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+// protocolHandler replies with its name to every message and counts
+// connections. OnUpgraded fails with err, if set.
+type protocolHandler struct {
+	name  string
+	conns int
+	err   error
+}
+
+func (h *protocolHandler) OnStart() (OpCode, error)      { return 0, nil }
+func (h *protocolHandler) OnConnect() (OpCode, error)    { return 0, nil }
+func (h *protocolHandler) OnShutdown() (OpCode, error)   { return 0, nil }
+func (h *protocolHandler) OnUpgraded() (OpCode, error)   { h.conns++; return 0, h.err }
+func (h *protocolHandler) OnClose(error) (OpCode, error) { h.conns--; return 0, nil }
+
+func (h *protocolHandler) OnReceive(msg []byte) ([]byte, OpCode, error) {
+	return []byte(h.name), OpText, nil
+}
+
+func TestProtocolMux(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		serverPref bool
+		withDef    bool
+		offer      []string
+		onProtocol func(string) error
+		protocol   string
+		err        error
+	}{
+		{
+			name:     "client preference",
+			offer:    []string{"v1.json", "v2.json"},
+			protocol: "v1.json",
+		},
+		{
+			name:       "server preference",
+			serverPref: true,
+			offer:      []string{"mqtt, v1.json, v2.json"},
+			protocol:   "v2.json",
+		},
+		{
+			name:     "unknown offered",
+			offer:    []string{"chat"},
+			protocol: "",
+			err:      ErrHandshakeNoProtocol,
+		},
+		{
+			name: "none offered",
+			err:  ErrHandshakeNoProtocol,
+		},
+		{
+			name:     "default",
+			withDef:  true,
+			offer:    []string{"chat"},
+			protocol: "",
+		},
+		{
+			name:  "upgrader check",
+			offer: []string{"v1.json"},
+			onProtocol: func(p string) error {
+				if p != "v1.json" {
+					return ErrHandshakeBadProtocol
+				}
+				return nil
+			},
+			protocol: "v1.json",
+		},
+		{
+			name:  "upgrader reject",
+			offer: []string{"v2.json"},
+			onProtocol: func(p string) error {
+				if p != "v1.json" {
+					return ErrHandshakeBadProtocol
+				}
+				return nil
+			},
+			err: ErrHandshakeBadProtocol,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				v1  = &protocolHandler{name: "v1"}
+				v2  = &protocolHandler{name: "v2"}
+				def = &protocolHandler{name: "default"}
+			)
+			m := NewProtocolMux()
+			m.Handle("v2.json", v2)
+			m.Handle("v1.json", v1)
+			m.ServerPreference = test.serverPref
+			if test.withDef {
+				m.Default = def
+			}
+			handlers := map[string]*protocolHandler{
+				"v1.json": v1, "v2.json": v2, "": def,
+			}
+			h := NewNetHandler(m, WithUpgrader(Upgrader{OnProtocol: test.onProtocol}))
+
+			req := "GET / HTTP/1.1\r\n" +
+				"Host: localhost\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Version: 13\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+			for _, p := range test.offer {
+				req += "Sec-WebSocket-Protocol: " + p + "\r\n"
+			}
+			conn := &recordConn{addr: "192.0.2.1:1"}
+			h.OnConnect(conn)
+			stream := &base.InputStream{}
+			stream.Begin([]byte(req + "\r\n"))
+			resp, err := h.OnReceive(conn, stream)
+			sent, _ := conn.take()
+			resp = append(sent, resp...)
+			if err != test.err {
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			hdr := "\r\nSec-WebSocket-Protocol: " + test.protocol + "\r\n"
+			if has := strings.Contains(string(resp), hdr); has != (test.protocol != "") {
+				t.Fatalf("unexpected response:\n%s", resp)
+			}
+			handler := handlers[test.protocol]
+			if handler.conns != 1 {
+				t.Fatalf("handler %s is not notified about connection", handler.name)
+			}
+
+			stream.Begin(maskedFrame(OpText, []byte("hello")))
+			out, err := h.OnReceive(conn, stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasSuffix(out, []byte(handler.name)) {
+				t.Fatalf("message is not handled by %s: %q", handler.name, out)
+			}
+
+			h.OnClose(conn, nil)
+			if handler.conns != 0 {
+				t.Fatalf("handler %s is not notified about close", handler.name)
+			}
+		})
+	}
+}
+
+func TestProtocolMuxSetup(t *testing.T) {
+	for _, u := range []Upgrader{
+		{Protocol: func([]byte) bool { return true }},
+		{ProtocolCustom: func([]byte) (string, bool) { return "", true }},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewNetHandler() did not panic")
+				}
+			}()
+			NewNetHandler(NewProtocolMux(), WithUpgrader(u))
+		}()
+	}
+}
+
+func TestProtocolMuxHandshake(t *testing.T) {
+	const req = "GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Protocol: v1.json\r\n" +
+		"\r\n"
+	handshake := func(h *NetHandler) ([]byte, error) {
+		conn := &recordConn{addr: "192.0.2.1:1"}
+		h.OnConnect(conn)
+		stream := &base.InputStream{}
+		stream.Begin([]byte(req))
+		out, err := h.OnReceive(conn, stream)
+		sent, _ := conn.take()
+		return append(sent, out...), err
+	}
+
+	t.Run("late", func(t *testing.T) {
+		// Mux is set up even if it is assigned after NewNetHandler.
+		v1 := &protocolHandler{name: "v1"}
+		m := NewProtocolMux()
+		m.Handle("v1.json", v1)
+		h := NewNetHandler(&protocolHandler{})
+		h.EasyWsHandler = m
+		h.Upgrader = Upgrader{}
+		out, err := handshake(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), "\r\nSec-WebSocket-Protocol: v1.json\r\n") || v1.conns != 1 {
+			t.Fatalf("protocol is not selected:\n%s", out)
+		}
+	})
+
+	t.Run("upgraded error", func(t *testing.T) {
+		v1 := &protocolHandler{name: "v1", err: errors.New("no way")}
+		m := NewProtocolMux()
+		m.Handle("v1.json", v1)
+		if _, err := handshake(NewNetHandler(m)); err == nil {
+			t.Fatal("handshake is not rejected")
+		}
+		if v1.conns != 0 {
+			t.Fatalf("handler is not notified about close")
+		}
+	})
+}
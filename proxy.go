@@ -0,0 +1,264 @@
+package easyws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/EternalVow/easyws/httphead"
+)
+
+// ProxyConfig describes how NetHandler finds out the real address of the
+// client when it is served behind proxies or load balancers.
+type ProxyConfig struct {
+	// ProxyProtocol makes NetHandler to expect PROXY protocol header of
+	// version 1 or 2 before the handshake request on every connection.
+	// Connections without the header are closed.
+	//
+	// See https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+	ProxyProtocol bool
+
+	// TrustedProxies is the list of networks of the trusted proxies.
+	//
+	// If it is not empty, then PROXY protocol header is accepted only from
+	// the trusted peers. Also, if the peer is trusted, then the client
+	// address is taken from the ForwardedHeader of the handshake request: it
+	// is the rightmost address which is not trusted.
+	TrustedProxies []netip.Prefix
+
+	// ForwardedHeader is the name of the header which trusted proxies set or
+	// append the client address to. It is either "Forwarded" or a header
+	// with comma separated list of addresses, like "X-Real-IP". If empty,
+	// "X-Forwarded-For" is used.
+	//
+	// Other forwarding headers are ignored, since proxies pass them through
+	// from the client as is.
+	ForwardedHeader string
+}
+
+// WithProxy returns an option that makes NetHandler to find out the real
+// client address as configured by config.
+func WithProxy(config ProxyConfig) ServerOption {
+	return func(h *NetHandler) {
+		h.Proxy = config
+	}
+}
+
+// Errors used by NetHandler to close connections sending bad PROXY
+// protocol headers.
+var (
+	ErrProxyHeader    = errors.New("malformed PROXY protocol header")
+	ErrProxyUntrusted = errors.New("PROXY protocol header from untrusted peer")
+)
+
+// trusted reports whether addr belongs to the trusted proxies.
+func (p ProxyConfig) trusted(addr string) bool {
+	ip, ok := parseIP(addr)
+	if !ok {
+		return false
+	}
+	for _, n := range p.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxy reports whether addr is the address of a proxy which reports client
+// addresses.
+func (p ProxyConfig) proxy(addr string) bool {
+	if len(p.TrustedProxies) > 0 {
+		return p.trusted(addr)
+	}
+	return p.ProxyProtocol
+}
+
+// forwardedFor returns the client address from the ForwardedHeader of the
+// complete HTTP request in data. It returns empty string if there is no such
+// header.
+func (p ProxyConfig) forwardedFor(data []byte) string {
+	name := "X-Forwarded-For"
+	if p.ForwardedHeader != "" {
+		name = http.CanonicalHeaderKey(p.ForwardedHeader)
+	}
+	var hops []string
+	httpScanHeaders(data, func(k, v []byte) {
+		switch {
+		case httphead.BtsToString(k) != name:
+		case name == "Forwarded":
+			hops = appendForwarded(hops, v)
+		default:
+			for _, s := range strings.Split(string(v), ",") {
+				hops = append(hops, strings.TrimSpace(s))
+			}
+		}
+	})
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, ok := parseIP(hops[i]); !ok {
+			// Obfuscated or malformed address; the rest of the list can not
+			// be trusted.
+			if i < len(hops)-1 {
+				return hops[i+1]
+			}
+			return ""
+		}
+		if i == 0 || !p.trusted(hops[i]) {
+			return hops[i]
+		}
+	}
+	return ""
+}
+
+// appendForwarded appends "for" parameters of the Forwarded header value v
+// to hops.
+func appendForwarded(hops []string, v []byte) []string {
+	for _, elem := range strings.Split(string(v), ",") {
+		for _, pair := range strings.Split(elem, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !strings.EqualFold(k, "for") {
+				continue
+			}
+			hops = append(hops, strings.Trim(v, `"`))
+		}
+	}
+	return hops
+}
+
+// parseIP parses IP from the address which may contain port, like
+// "192.0.2.1:80", "[2001:db8::1]:80" or "2001:db8::1".
+func parseIP(addr string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	ip, err := netip.ParseAddr(strings.Trim(addr, "[]"))
+	return ip.Unmap(), err == nil
+}
+
+// PROXY protocol constants.
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	proxyV1MaxLength = 107
+	proxyV2HeaderLen = 16
+)
+
+// parseProxyHeader parses PROXY protocol header of version 1 or 2 from the
+// beginning of data. It returns the source address of the proxied connection
+// and the length of the header. Address is empty if header does not
+// describe the proxied connection, for example for health checks.
+//
+// It returns io.ErrUnexpectedEOF if data does not contain the whole header.
+func parseProxyHeader(data []byte) (addr string, n int, err error) {
+	switch {
+	case isPrefix(data, proxyV1Prefix):
+		return parseProxyV1(data)
+	case isPrefix(data, proxyV2Signature):
+		return parseProxyV2(data)
+	}
+	return "", 0, ErrProxyHeader
+}
+
+// isPrefix reports whether data has given prefix or is a prefix of it.
+func isPrefix(data, prefix []byte) bool {
+	if len(data) < len(prefix) {
+		return bytes.HasPrefix(prefix, data)
+	}
+	return bytes.HasPrefix(data, prefix)
+}
+
+func parseProxyV1(data []byte) (addr string, n int, err error) {
+	if len(data) < len(proxyV1Prefix) {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	end := bytes.Index(data, []byte("\r\n"))
+	if end == -1 {
+		if len(data) >= proxyV1MaxLength {
+			return "", 0, ErrProxyHeader
+		}
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	if end+2 > proxyV1MaxLength {
+		return "", 0, ErrProxyHeader
+	}
+	n = end + 2
+
+	// PROXY TCP4 192.0.2.1 192.0.2.2 56324 443
+	fields := strings.Split(string(data[len(proxyV1Prefix):end]), " ")
+	switch fields[0] {
+	case "UNKNOWN":
+		return "", n, nil
+	case "TCP4", "TCP6":
+	default:
+		return "", 0, ErrProxyHeader
+	}
+	if len(fields) != 5 {
+		return "", 0, ErrProxyHeader
+	}
+	ip, err := netip.ParseAddr(fields[1])
+	if err != nil || ip.Is4() != (fields[0] == "TCP4") {
+		return "", 0, ErrProxyHeader
+	}
+	if _, err := netip.ParseAddr(fields[2]); err != nil {
+		return "", 0, ErrProxyHeader
+	}
+	for _, port := range fields[3:] {
+		if p, err := strconv.ParseUint(port, 10, 16); err != nil || strconv.FormatUint(p, 10) != port {
+			return "", 0, ErrProxyHeader
+		}
+	}
+	return net.JoinHostPort(fields[1], fields[3]), n, nil
+}
+
+func parseProxyV2(data []byte) (addr string, n int, err error) {
+	if len(data) < proxyV2HeaderLen {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	var (
+		version = data[12] >> 4
+		command = data[12] & 0xf
+		family  = data[13]
+	)
+	if version != 2 || command > 1 {
+		return "", 0, ErrProxyHeader
+	}
+	n = proxyV2HeaderLen + int(binary.BigEndian.Uint16(data[14:16]))
+	if len(data) < n {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	if command == 0 {
+		// LOCAL command, connection was established by the proxy itself.
+		return "", n, nil
+	}
+	payload := data[proxyV2HeaderLen:n]
+	switch family >> 4 {
+	case 1: // AF_INET.
+		if len(payload) < 12 {
+			return "", 0, ErrProxyHeader
+		}
+		ip := netip.AddrFrom4(*(*[4]byte)(payload[0:4]))
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return netip.AddrPortFrom(ip, port).String(), n, nil
+
+	case 2: // AF_INET6.
+		if len(payload) < 36 {
+			return "", 0, ErrProxyHeader
+		}
+		ip := netip.AddrFrom16(*(*[16]byte)(payload[0:16]))
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return netip.AddrPortFrom(ip, port).String(), n, nil
+
+	default:
+		// AF_UNSPEC or AF_UNIX, address is not useful.
+		return "", n, nil
+	}
+}
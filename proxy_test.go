@@ -0,0 +1,325 @@
+package easyws
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"testing"
+
+	"github.com/EternalVow/easynet/base"
+)
+
+func proxyV2(command, family byte, addrs ...byte) []byte {
+	p := append([]byte{}, proxyV2Signature...)
+	p = append(p, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(p[14:], uint16(len(addrs)))
+	return append(p, addrs...)
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	v2tcp4 := proxyV2(1, 0x11,
+		192, 0, 2, 1, // Source address.
+		192, 0, 2, 2, // Destination address.
+		0xdc, 0x04, // Source port.
+		0x01, 0xbb, // Destination port.
+	)
+	v2tcp6 := proxyV2(1, 0x21, append(append(
+		netip.MustParseAddr("2001:db8::1").AsSlice(),
+		netip.MustParseAddr("2001:db8::2").AsSlice()...),
+		0xdc, 0x04, 0x01, 0xbb,
+	)...)
+	for _, test := range []struct {
+		name string
+		data string
+		addr string
+		n    int
+		err  error
+	}{
+		{
+			name: "v1 tcp4",
+			data: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET",
+			addr: "192.0.2.1:56324",
+			n:    42,
+		},
+		{
+			name: "v1 tcp6",
+			data: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+			addr: "[2001:db8::1]:56324",
+			n:    46,
+		},
+		{
+			name: "v1 unknown",
+			data: "PROXY UNKNOWN\r\n",
+			n:    15,
+		},
+		{
+			name: "v1 partial",
+			data: "PROXY TCP4 192.0.2.1",
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "v1 partial prefix",
+			data: "PRO",
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "v1 bad family",
+			data: "PROXY TCP6 192.0.2.1 192.0.2.2 56324 443\r\n",
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v1 bad port",
+			data: "PROXY TCP4 192.0.2.1 192.0.2.2 056324 443\r\n",
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 tcp4",
+			data: string(v2tcp4) + "GET",
+			addr: "192.0.2.1:56324",
+			n:    len(v2tcp4),
+		},
+		{
+			name: "v2 tcp6",
+			data: string(v2tcp6),
+			addr: "[2001:db8::1]:56324",
+			n:    len(v2tcp6),
+		},
+		{
+			name: "v2 local",
+			data: string(proxyV2(0, 0)),
+			n:    16,
+		},
+		{
+			name: "v2 partial",
+			data: string(v2tcp4[:20]),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "v2 short address",
+			data: string(proxyV2(1, 0x11, 192, 0, 2, 1)),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "no header",
+			data: "GET / HTTP/1.1\r\n",
+			err:  ErrProxyHeader,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			addr, n, err := parseProxyHeader([]byte(test.data))
+			if err != test.err {
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			}
+			if addr != test.addr || n != test.n {
+				t.Fatalf("parseProxyHeader() = %q, %d; want %q, %d", addr, n, test.addr, test.n)
+			}
+		})
+	}
+}
+
+func TestProxyForwardedFor(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for _, test := range []struct {
+		name    string
+		header  string
+		headers string
+		addr    string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:    "xff",
+			headers: "X-Forwarded-For: 192.0.2.1, 10.0.0.2\r\n",
+			addr:    "192.0.2.1",
+		},
+		{
+			name:    "xff spoofed",
+			headers: "X-Forwarded-For: 198.51.100.1, 192.0.2.1, 10.0.0.2\r\n",
+			addr:    "192.0.2.1",
+		},
+		{
+			name: "xff multiple headers",
+			headers: "X-Forwarded-For: 198.51.100.1\r\n" +
+				"X-Forwarded-For: 10.0.0.3, 10.0.0.2\r\n",
+			addr: "198.51.100.1",
+		},
+		{
+			name:    "xff garbage",
+			headers: "X-Forwarded-For: 192.0.2.1, garbage, 10.0.0.2\r\n",
+			addr:    "10.0.0.2",
+		},
+		{
+			name: "xff forwarded spoofed",
+			headers: "Forwarded: for=198.51.100.1\r\n" +
+				"X-Forwarded-For: 192.0.2.1, 10.0.0.2\r\n",
+			addr: "192.0.2.1",
+		},
+		{
+			name:    "xff forwarded only",
+			headers: "Forwarded: for=198.51.100.1\r\n",
+		},
+		{
+			name:   "forwarded",
+			header: "forwarded",
+			headers: `Forwarded: for=192.0.2.1;proto=https, for="[2001:db8::1]:4711"` + "\r\n" +
+				"X-Forwarded-For: 198.51.100.1\r\n",
+			addr: "[2001:db8::1]:4711",
+		},
+		{
+			name:   "forwarded xff spoofed",
+			header: "Forwarded",
+			headers: "X-Forwarded-For: 198.51.100.1\r\n" +
+				"Forwarded: for=192.0.2.1\r\n",
+			addr: "192.0.2.1",
+		},
+		{
+			name:    "forwarded obfuscated",
+			header:  "Forwarded",
+			headers: "Forwarded: for=_hidden, for=10.0.0.2\r\n",
+			addr:    "10.0.0.2",
+		},
+		{
+			name:   "custom",
+			header: "X-Real-IP",
+			headers: "X-Forwarded-For: 198.51.100.1\r\n" +
+				"X-Real-Ip: 192.0.2.1\r\n",
+			addr: "192.0.2.1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := ProxyConfig{TrustedProxies: trusted, ForwardedHeader: test.header}
+			req := "GET / HTTP/1.1\r\nHost: localhost\r\n" + test.headers + "\r\n"
+			if addr := p.forwardedFor([]byte(req)); addr != test.addr {
+				t.Fatalf("forwardedFor() = %q; want %q", addr, test.addr)
+			}
+		})
+	}
+}
+
+func TestNetHandlerProxy(t *testing.T) {
+	const (
+		balancer = "10.0.0.1:5000"
+		request  = "GET / HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Version: 13\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	)
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for _, test := range []struct {
+		name   string
+		config ProxyConfig
+		peer   string
+		chunks []string
+		addr   string
+		err    error
+	}{
+		{
+			name:   "proxy protocol",
+			config: ProxyConfig{ProxyProtocol: true},
+			peer:   balancer,
+			chunks: []string{
+				"PROXY TCP4 192.0.2.1 ",
+				"192.0.2.2 56324 443\r\n" + request,
+				"\r\n",
+			},
+			addr: "192.0.2.1:56324",
+		},
+		{
+			name:   "proxy protocol required",
+			config: ProxyConfig{ProxyProtocol: true},
+			peer:   balancer,
+			chunks: []string{request + "\r\n"},
+			addr:   balancer,
+			err:    ErrProxyHeader,
+		},
+		{
+			name:   "proxy protocol untrusted",
+			config: ProxyConfig{ProxyProtocol: true, TrustedProxies: trusted},
+			peer:   "192.0.2.9:5000",
+			chunks: []string{"PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"},
+			addr:   "192.0.2.9:5000",
+			err:    ErrProxyUntrusted,
+		},
+		{
+			name:   "forwarded",
+			config: ProxyConfig{TrustedProxies: trusted},
+			peer:   balancer,
+			chunks: []string{request + "X-Forwarded-For: 192.0.2.1\r\n\r\n"},
+			addr:   "192.0.2.1",
+		},
+		{
+			name:   "forwarded spoofed",
+			config: ProxyConfig{TrustedProxies: trusted},
+			peer:   balancer,
+			chunks: []string{request +
+				"Forwarded: for=198.51.100.1\r\n" +
+				"X-Forwarded-For: 192.0.2.1\r\n\r\n",
+			},
+			addr: "192.0.2.1",
+		},
+		{
+			name:   "forwarded untrusted",
+			config: ProxyConfig{TrustedProxies: trusted},
+			peer:   "192.0.2.9:5000",
+			chunks: []string{request + "X-Forwarded-For: 192.0.2.1\r\n\r\n"},
+			addr:   "192.0.2.9:5000",
+		},
+		{
+			name:   "both",
+			config: ProxyConfig{ProxyProtocol: true, TrustedProxies: trusted},
+			peer:   balancer,
+			chunks: []string{
+				"PROXY TCP4 10.0.0.2 10.0.0.3 56324 443\r\n" + request,
+				"X-Forwarded-For: 192.0.2.1\r\n\r\n",
+			},
+			addr: "192.0.2.1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := NewLimiter(Limits{MaxConnsPerIP: 1})
+			h := NewNetHandler(benchEcho{}, WithProxy(test.config), WithLimiter(l))
+			conn := testConn(test.peer)
+			if err := h.OnConnect(conn); err != nil {
+				t.Fatal(err)
+			}
+			stream := &base.InputStream{}
+			var err error
+			for _, chunk := range test.chunks {
+				stream.Begin([]byte(chunk))
+				if _, err = h.OnReceive(conn, stream); err != nil {
+					break
+				}
+			}
+			if err != test.err {
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			}
+			c := h.conn(conn)
+			if c.RemoteAddr() != test.addr {
+				t.Fatalf("RemoteAddr() = %q; want %q", c.RemoteAddr(), test.addr)
+			}
+			if c.PeerAddr() != test.peer {
+				t.Fatalf("PeerAddr() = %q; want %q", c.PeerAddr(), test.peer)
+			}
+			if test.err == nil && !c.upgraded {
+				t.Fatalf("connection is not upgraded")
+			}
+
+			// Connection is accounted to the client address by Limiter, so
+			// other clients behind the same balancer are not limited.
+			if test.err == nil {
+				other := testConn("10.0.0.1:5001")
+				if err := h.OnConnect(other); err != nil {
+					t.Fatalf("OnConnect() from balancer error is %v", err)
+				}
+				h.OnClose(other, nil)
+			}
+			h.OnClose(conn, nil)
+			if n := l.Conns(); n != 0 {
+				t.Fatalf("Conns() = %d after close; want 0", n)
+			}
+		})
+	}
+}
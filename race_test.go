@@ -0,0 +1,5 @@
+//go:build race
+
+package easyws
+
+const raceEnabled = true
@@ -3,6 +3,8 @@ package easyws
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
+
 	_interface "github.com/EternalVow/easynet/interface"
 	"github.com/EternalVow/easyws/httphead"
 )
@@ -13,82 +15,105 @@ var (
 	ErrHeaderLengthUnexpected = fmt.Errorf("header error: unexpected payload length bits")
 )
 
-// ReadHeader reads a frame header from r.
+// ReadHeader reads a frame header from stream.
+//
+// If stream does not contain the whole header yet, it returns
+// io.ErrUnexpectedEOF and leaves stream untouched, so it could be called
+// again when more data is received.
 func ReadHeader(stream _interface.IInputStream) (h Header, err error) {
-	// Make slice of bytes with capacity 12 that could hold any header.
-	//
-	// The maximum header size is 14, but due to the 2 hop reads,
-	// after first hop that reads first 2 constant bytes, we could reuse 2 bytes.
-	// So 14 - 2 = 12.
-	bts := make([]byte, 2, MaxHeaderSize-2)
-
-	// Prepare to hold first 2 bytes to choose size of next read.
 	data := stream.Begin(nil)
-	copy(bts, data[:len(bts)])
-	stream.End(data[len(bts):])
+	h, n, err := parseHeader(data)
+	if err != nil {
+		stream.End(data)
+		return h, err
+	}
+	stream.End(data[n:])
+	return h, nil
+}
+
+// parseHeader parses a frame header placed at the beginning of bts. It returns
+// parsed header and the number of bytes it occupies.
+//
+// If bts does not contain the whole header yet, it returns
+// io.ErrUnexpectedEOF. It never modifies bts.
+func parseHeader(bts []byte) (h Header, n int, err error) {
+	if len(bts) < MinHeaderSize {
+		return h, 0, io.ErrUnexpectedEOF
+	}
 
 	h.Fin = bts[0]&bit0 != 0
 	h.Rsv = (bts[0] & 0x70) >> 4
 	h.OpCode = OpCode(bts[0] & 0x0f)
 
-	var extra int
-
+	n = MinHeaderSize
 	if bts[1]&bit0 != 0 {
 		h.Masked = true
-		extra += 4
+		n += 4
 	}
 
 	length := bts[1] & 0x7f
 	switch {
 	case length < 126:
 		h.Length = int64(length)
-
 	case length == 126:
-		extra += 2
-
-	case length == 127:
-		extra += 8
-
+		n += 2
 	default:
-		err = ErrHeaderLengthUnexpected
-		return h, err
-	}
-
-	if extra == 0 {
-		return h, err
+		n += 8
 	}
-
-	// Increase len of bts to extra bytes need to read.
-	// Overwrite first 2 bytes that was read before.
-	bts = bts[:extra]
-
-	data = stream.Begin(nil)
-	copy(bts, data[:len(bts)])
-	stream.End(data[len(bts):])
-
-	if err != nil {
-		return h, err
+	if len(bts) < n {
+		return h, 0, io.ErrUnexpectedEOF
 	}
 
+	extra := bts[MinHeaderSize:n]
 	switch {
 	case length == 126:
-		h.Length = int64(binary.BigEndian.Uint16(bts[:2]))
-		bts = bts[2:]
+		h.Length = int64(binary.BigEndian.Uint16(extra[:2]))
+		extra = extra[2:]
 
 	case length == 127:
-		if bts[0]&0x80 != 0 {
-			err = ErrHeaderLengthMSB
-			return h, err
+		if extra[0]&0x80 != 0 {
+			return h, 0, ErrHeaderLengthMSB
 		}
-		h.Length = int64(binary.BigEndian.Uint64(bts[:8]))
-		bts = bts[8:]
+		h.Length = int64(binary.BigEndian.Uint64(extra[:8]))
+		extra = extra[8:]
 	}
 
 	if h.Masked {
-		copy(h.Mask[:], bts)
+		copy(h.Mask[:], extra)
 	}
 
-	return h, nil
+	return h, n, nil
+}
+
+// ReadHeaderFrom reads a frame header from r. Unlike ReadHeader it blocks
+// until the whole header is read.
+func ReadHeaderFrom(r io.Reader) (h Header, err error) {
+	// Make slice of bytes with capacity 14 that could hold any header.
+	bts := make([]byte, MinHeaderSize, MaxHeaderSize)
+	if _, err = io.ReadFull(r, bts); err != nil {
+		return h, err
+	}
+
+	n := MinHeaderSize
+	if bts[1]&bit0 != 0 {
+		n += 4
+	}
+	switch bts[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	bts = bts[:n]
+	if _, err = io.ReadFull(r, bts[MinHeaderSize:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return h, err
+	}
+
+	h, _, err = parseHeader(bts)
+	return h, err
 }
 
 // ReadFrame reads a frame from r.
@@ -96,30 +121,30 @@ func ReadHeader(stream _interface.IInputStream) (h Header, err error) {
 // for frame.Header.Length size inside to read frame payload into.
 //
 // Note that ReadFrame does not unmask payload.
-//func ReadFrame(r io.Reader) (f Frame, err error) {
-//	f.Header, err = ReadHeader(r)
-//	if err != nil {
-//		return f, err
-//	}
-//
-//	if f.Header.Length > 0 {
-//		// int(f.Header.Length) is safe here cause we have
-//		// checked it for overflow above in ReadHeader.
-//		f.Payload = make([]byte, int(f.Header.Length))
-//		_, err = io.ReadFull(r, f.Payload)
-//	}
-//
-//	return f, err
-//}
+func ReadFrame(r io.Reader) (f Frame, err error) {
+	f.Header, err = ReadHeaderFrom(r)
+	if err != nil {
+		return f, err
+	}
+
+	if f.Header.Length > 0 {
+		// int(f.Header.Length) is safe here cause we have
+		// checked it for overflow above in ReadHeaderFrom.
+		f.Payload = make([]byte, int(f.Header.Length))
+		_, err = io.ReadFull(r, f.Payload)
+	}
+
+	return f, err
+}
 
 // MustReadFrame is like ReadFrame but panics if frame can not be read.
-//func MustReadFrame(r io.Reader) Frame {
-//	f, err := ReadFrame(r)
-//	if err != nil {
-//		panic(err)
-//	}
-//	return f
-//}
+func MustReadFrame(r io.Reader) Frame {
+	f, err := ReadFrame(r)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
 
 // ParseCloseFrameData parses close frame status code and closure reason if any provided.
 // If there is no status code in the payload
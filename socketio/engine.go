@@ -0,0 +1,442 @@
+package socketio
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+)
+
+// ErrProtocolVersion is returned by OnOpen for clients which do not speak
+// Engine.IO protocol version 4 over WebSocket transport. Such handshakes are
+// rejected with 400 Bad Request.
+var ErrProtocolVersion = easyws.RejectConnectionError(
+	easyws.RejectionStatus(http.StatusBadRequest),
+	easyws.RejectionReason("socketio: unsupported engine.io protocol version or transport"),
+)
+
+// errEngineClose is returned by receive for the close packet.
+var errEngineClose = errors.New("socketio: closed by client")
+
+// errTooBig is returned by receive if a packet with its attachments exceeds
+// MaxPayload.
+var errTooBig = errors.New("socketio: packet too big")
+
+// engineConn is the state of an Engine.IO connection.
+type engineConn struct {
+	server *Server
+	conn   *easyws.Conn
+	sid    string
+	pong   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+
+	// wmu keeps the frames of a packet with attachments contiguous.
+	wmu sync.Mutex
+
+	// sockets maps namespace names to the sockets connected to them. It is
+	// guarded by server.mu.
+	sockets map[string]*Socket
+
+	// binary is the packet waiting for its attachments, which are
+	// collected in buffers; size is the total size of the packet and the
+	// buffers. They are accessed only by OnMessage.
+	binary  *packet
+	buffers [][]byte
+	size    int
+}
+
+// OnOpen implements easyws.IEasyWsConn. It sends the Engine.IO open packet
+// and starts the heartbeat of the connection.
+func (s *Server) OnOpen(conn *easyws.Conn) error {
+	uri := conn.RequestURI()
+	var query string
+	if i := strings.IndexByte(uri, '?'); i != -1 {
+		query = uri[i+1:]
+	}
+	q, _ := url.ParseQuery(query)
+	if q.Get("EIO") != "4" || (q.Has("transport") && q.Get("transport") != "websocket") {
+		return ErrProtocolVersion
+	}
+
+	ec := &engineConn{
+		server:  s,
+		conn:    conn,
+		sid:     newID(),
+		pong:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		sockets: make(map[string]*Socket),
+	}
+	s.mu.Lock()
+	s.init()
+	s.conns[conn] = ec
+	s.mu.Unlock()
+
+	open, _ := json.Marshal(struct {
+		SID          string   `json:"sid"`
+		Upgrades     []string `json:"upgrades"`
+		PingInterval int64    `json:"pingInterval"`
+		PingTimeout  int64    `json:"pingTimeout"`
+		MaxPayload   int      `json:"maxPayload"`
+	}{
+		SID:          ec.sid,
+		Upgrades:     []string{},
+		PingInterval: s.pingInterval().Milliseconds(),
+		PingTimeout:  s.pingTimeout().Milliseconds(),
+		MaxPayload:   s.maxPayload(),
+	})
+	ec.send(easyws.OpText, append([]byte{engineOpen}, open...))
+	go ec.heartbeat()
+	return nil
+}
+
+// OnDisconnect implements easyws.IEasyWsConn. Sockets of the connection are
+// disconnected from their namespaces.
+func (s *Server) OnDisconnect(conn *easyws.Conn, err error) {
+	s.mu.Lock()
+	ec := s.conns[conn]
+	s.mu.Unlock()
+	if ec == nil {
+		return
+	}
+	if err != nil {
+		ec.drop(ReasonTransportError)
+	} else {
+		ec.drop(ReasonTransportClose)
+	}
+}
+
+// OnMessage implements easyws.IEasyWsMessage. Clients violating the protocol
+// are disconnected.
+func (s *Server) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	s.mu.Lock()
+	ec := s.conns[conn]
+	s.mu.Unlock()
+	if ec == nil {
+		return easyws.CloseReply(easyws.StatusInternalServerError, "socketio: connection is not open")
+	}
+	if len(msg) > s.maxPayload() {
+		ec.drop(ReasonTransportError)
+		return easyws.CloseReply(easyws.StatusMessageTooBig, "")
+	}
+	switch err := ec.receive(op, msg); err {
+	case nil:
+	case errEngineClose:
+		ec.drop(ReasonTransportClose)
+		return easyws.CloseReply(easyws.StatusNormalClosure, "")
+	case errTooBig:
+		ec.drop(ReasonTransportError)
+		return easyws.CloseReply(easyws.StatusMessageTooBig, "")
+	default:
+		ec.drop(ReasonTransportError)
+		return easyws.CloseReply(easyws.StatusProtocolError, err.Error())
+	}
+	return nil, 0, nil
+}
+
+// OnReceive implements easyws.IEasyWs. Server is served by OnMessage only;
+// see easyws.IEasyWsMessage.
+func (s *Server) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return easyws.CloseReply(easyws.StatusInternalServerError, "socketio: connection is not open")
+}
+
+func (s *Server) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (s *Server) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (s *Server) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (s *Server) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (s *Server) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
+
+func (s *Server) pingInterval() time.Duration {
+	if s.PingInterval > 0 {
+		return s.PingInterval
+	}
+	return DefaultPingInterval
+}
+
+func (s *Server) pingTimeout() time.Duration {
+	if s.PingTimeout > 0 {
+		return s.PingTimeout
+	}
+	return DefaultPingTimeout
+}
+
+func (s *Server) maxPayload() int {
+	if s.MaxPayload > 0 {
+		return s.MaxPayload
+	}
+	return DefaultMaxPayload
+}
+
+func (s *Server) maxAttachments() int {
+	if s.MaxAttachments > 0 {
+		return s.MaxAttachments
+	}
+	return DefaultMaxAttachments
+}
+
+// heartbeat pings the client and closes the connection if it does not
+// respond in time.
+func (ec *engineConn) heartbeat() {
+	interval := time.NewTimer(ec.server.pingInterval())
+	defer interval.Stop()
+	for {
+		select {
+		case <-interval.C:
+		case <-ec.done:
+			return
+		}
+		select {
+		case <-ec.pong:
+		default:
+		}
+		ec.send(easyws.OpText, []byte{enginePing})
+
+		timeout := time.NewTimer(ec.server.pingTimeout())
+		select {
+		case <-ec.pong:
+			timeout.Stop()
+		case <-timeout.C:
+			ec.drop(ReasonPingTimeout)
+			ec.conn.Close(easyws.StatusGoingAway, "ping timeout")
+			return
+		case <-ec.done:
+			timeout.Stop()
+			return
+		}
+		interval.Reset(ec.server.pingInterval())
+	}
+}
+
+// receive handles an Engine.IO message.
+func (ec *engineConn) receive(op easyws.OpCode, msg []byte) error {
+	if op == easyws.OpBinary {
+		if ec.binary == nil {
+			return errors.New("unexpected attachment")
+		}
+		if ec.size += len(msg); ec.size > ec.server.maxPayload() {
+			return errTooBig
+		}
+		// Msg is reused by the caller, so it is copied.
+		ec.buffers = append(ec.buffers, append([]byte(nil), msg...))
+		if len(ec.buffers) < ec.binary.attachments {
+			return nil
+		}
+		p, buffers := ec.binary, ec.buffers
+		ec.binary, ec.buffers = nil, nil
+		return ec.dispatch(p, buffers)
+	}
+	if len(msg) == 0 {
+		return ErrMalformedPacket
+	}
+	if ec.binary != nil {
+		return errors.New("attachments expected")
+	}
+	switch msg[0] {
+	case enginePing:
+		ec.send(easyws.OpText, append([]byte{enginePong}, msg[1:]...))
+
+	case enginePong:
+		select {
+		case ec.pong <- struct{}{}:
+		default:
+		}
+
+	case engineMessage:
+		p, err := parsePacket(string(msg[1:]))
+		if err != nil {
+			return err
+		}
+		if p.attachments > ec.server.maxAttachments() {
+			return errors.New("too many attachments")
+		}
+		if p.attachments > 0 {
+			ec.binary, ec.size = p, len(msg)
+			return nil
+		}
+		return ec.dispatch(p, nil)
+
+	case engineClose:
+		return errEngineClose
+
+	case engineNoop:
+
+	default:
+		return ErrMalformedPacket
+	}
+	return nil
+}
+
+// dispatch handles a Socket.IO packet with its attachments.
+func (ec *engineConn) dispatch(p *packet, attachments [][]byte) error {
+	switch p.typ {
+	case packetConnect:
+		ec.connect(p)
+		return nil
+	case packetConnectError:
+		return ErrMalformedPacket
+	}
+
+	ec.server.mu.Lock()
+	s := ec.sockets[p.nsp]
+	ec.server.mu.Unlock()
+	if s == nil {
+		// Packets sent before the client is notified about disconnection
+		// from the namespace are ignored.
+		return nil
+	}
+	if p.typ == packetDisconnect {
+		ec.remove(s, ReasonClientDisconnect)
+		return nil
+	}
+
+	args, err := decodeArgs(p.data, attachments)
+	if err != nil {
+		return err
+	}
+	switch p.typ {
+	case packetEvent, packetBinaryEvent:
+		name, ok := "", len(args) > 0
+		if ok {
+			name, ok = args[0].(string)
+		}
+		if !ok {
+			return ErrMalformedPacket
+		}
+		ec.server.mu.Lock()
+		h := s.ns.handlers[name]
+		ec.server.mu.Unlock()
+		if h != nil {
+			h(s, &Event{Name: name, Args: args[1:], socket: s, id: p.id, hasID: p.hasID})
+		}
+
+	case packetAck, packetBinaryAck:
+		if !p.hasID {
+			return ErrMalformedPacket
+		}
+		s.acks.Resolve(uint64(p.id), args)
+	}
+	return nil
+}
+
+// connect connects the client to the namespace of the CONNECT packet p.
+func (ec *engineConn) connect(p *packet) {
+	n := ec.server.namespace(p.nsp)
+	if n == nil {
+		ec.connectError(p.nsp, "Invalid namespace")
+		return
+	}
+	s := &Socket{
+		id:    newID(),
+		ns:    n,
+		ec:    ec,
+		auth:  p.data,
+		rooms: make(map[string]struct{}),
+	}
+
+	ec.server.mu.Lock()
+	_, dup := ec.sockets[n.name]
+	middleware := n.middleware
+	ec.server.mu.Unlock()
+	if dup {
+		ec.connectError(n.name, "Already connected")
+		return
+	}
+	for _, f := range middleware {
+		if err := f(s); err != nil {
+			ec.connectError(n.name, err.Error())
+			return
+		}
+	}
+
+	ec.server.mu.Lock()
+	select {
+	case <-ec.done:
+		ec.server.mu.Unlock()
+		return
+	default:
+	}
+	ec.sockets[n.name] = s
+	n.sockets[s.id] = s
+	n.join(s, s.id)
+	onConnect := n.onConnect
+	ec.server.mu.Unlock()
+
+	data, _ := json.Marshal(map[string]string{"sid": s.id})
+	ec.write(&packet{typ: packetConnect, nsp: n.name, data: data}, nil)
+	for _, f := range onConnect {
+		f(s)
+	}
+}
+
+func (ec *engineConn) connectError(nsp, msg string) {
+	data, _ := json.Marshal(map[string]string{"message": msg})
+	ec.write(&packet{typ: packetConnectError, nsp: nsp, data: data}, nil)
+}
+
+// remove disconnects the socket from its namespace. Handlers set by
+// OnDisconnect are called if the socket was connected.
+func (ec *engineConn) remove(s *Socket, reason string) {
+	n := s.ns
+	ec.server.mu.Lock()
+	if ec.sockets[n.name] != s {
+		ec.server.mu.Unlock()
+		return
+	}
+	delete(ec.sockets, n.name)
+	delete(n.sockets, s.id)
+	for r := range s.rooms {
+		n.leave(s, r)
+	}
+	onDisconnect := n.onDisconnect
+	ec.server.mu.Unlock()
+
+	s.acks.Close()
+	for _, f := range onDisconnect {
+		f(s, reason)
+	}
+}
+
+// drop disconnects the sockets of the connection and stops its heartbeat.
+// Only the first call has effect.
+func (ec *engineConn) drop(reason string) {
+	ec.once.Do(func() {
+		ec.server.mu.Lock()
+		close(ec.done)
+		delete(ec.server.conns, ec.conn)
+		sockets := make([]*Socket, 0, len(ec.sockets))
+		for _, s := range ec.sockets {
+			sockets = append(sockets, s)
+		}
+		ec.server.mu.Unlock()
+		for _, s := range sockets {
+			ec.remove(s, reason)
+		}
+	})
+}
+
+// write sends Socket.IO packet p followed by its attachments.
+func (ec *engineConn) write(p *packet, attachments [][]byte) error {
+	ec.wmu.Lock()
+	defer ec.wmu.Unlock()
+	if err := ec.conn.WriteMessage(easyws.OpText, p.encode()); err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if err := ec.conn.WriteMessage(easyws.OpBinary, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends a single Engine.IO packet.
+func (ec *engineConn) send(op easyws.OpCode, p []byte) error {
+	ec.wmu.Lock()
+	defer ec.wmu.Unlock()
+	return ec.conn.WriteMessage(op, p)
+}
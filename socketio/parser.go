@@ -0,0 +1,187 @@
+package socketio
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Socket.IO packet types.
+const (
+	packetConnect      = '0'
+	packetDisconnect   = '1'
+	packetEvent        = '2'
+	packetAck          = '3'
+	packetConnectError = '4'
+	packetBinaryEvent  = '5'
+	packetBinaryAck    = '6'
+)
+
+// Engine.IO packet types.
+const (
+	engineOpen    = '0'
+	engineClose   = '1'
+	enginePing    = '2'
+	enginePong    = '3'
+	engineMessage = '4'
+	engineUpgrade = '5'
+	engineNoop    = '6'
+)
+
+// ErrMalformedPacket is returned for packets which could not be decoded.
+var ErrMalformedPacket = errors.New("socketio: malformed packet")
+
+// packet is a Socket.IO packet.
+type packet struct {
+	typ         byte
+	nsp         string
+	id          int64
+	hasID       bool
+	attachments int
+	data        json.RawMessage
+}
+
+// parsePacket parses Socket.IO packet s, which is the payload of Engine.IO
+// message packet.
+func parsePacket(s string) (*packet, error) {
+	if s == "" || s[0] < packetConnect || s[0] > packetBinaryAck {
+		return nil, ErrMalformedPacket
+	}
+	p := &packet{typ: s[0], nsp: "/"}
+	s = s[1:]
+	if p.typ == packetBinaryEvent || p.typ == packetBinaryAck {
+		i := strings.IndexByte(s, '-')
+		if i <= 0 {
+			return nil, ErrMalformedPacket
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n < 0 {
+			return nil, ErrMalformedPacket
+		}
+		p.attachments, s = n, s[i+1:]
+	}
+	if strings.HasPrefix(s, "/") {
+		i := strings.IndexByte(s, ',')
+		if i == -1 {
+			p.nsp, s = s, ""
+		} else {
+			p.nsp, s = s[:i], s[i+1:]
+		}
+	}
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		id, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return nil, ErrMalformedPacket
+		}
+		p.id, p.hasID, s = id, true, s[i:]
+	}
+	if s != "" {
+		if !json.Valid([]byte(s)) {
+			return nil, ErrMalformedPacket
+		}
+		p.data = json.RawMessage(s)
+	}
+	return p, nil
+}
+
+// encode returns Engine.IO message packet carrying p.
+func (p *packet) encode() []byte {
+	b := []byte{engineMessage, p.typ}
+	if p.attachments > 0 {
+		b = strconv.AppendInt(b, int64(p.attachments), 10)
+		b = append(b, '-')
+	}
+	if p.nsp != "/" && p.nsp != "" {
+		b = append(b, p.nsp...)
+		b = append(b, ',')
+	}
+	if p.hasID {
+		b = strconv.AppendInt(b, p.id, 10)
+	}
+	return append(b, p.data...)
+}
+
+// placeholder replaces binary attachment within packet data.
+type placeholder struct {
+	Placeholder bool `json:"_placeholder"`
+	Num         int  `json:"num"`
+}
+
+// deconstruct replaces byte slices within v with placeholders, appending
+// them to attachments. Only slices, maps with string keys and byte slices
+// are walked; other values are left as is.
+func deconstruct(v interface{}, attachments *[][]byte) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		*attachments = append(*attachments, v)
+		return placeholder{true, len(*attachments) - 1}
+	case []interface{}:
+		r := make([]interface{}, len(v))
+		for i, x := range v {
+			r[i] = deconstruct(x, attachments)
+		}
+		return r
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			r[k] = deconstruct(x, attachments)
+		}
+		return r
+	}
+	return v
+}
+
+// reconstruct replaces placeholders within decoded v with attachments.
+func reconstruct(v interface{}, attachments [][]byte) (interface{}, error) {
+	switch x := v.(type) {
+	case []interface{}:
+		for i := range x {
+			r, err := reconstruct(x[i], attachments)
+			if err != nil {
+				return nil, err
+			}
+			x[i] = r
+		}
+	case map[string]interface{}:
+		if ph, _ := x["_placeholder"].(bool); ph {
+			n, ok := x["num"].(float64)
+			if !ok || n < 0 || int(n) >= len(attachments) {
+				return nil, ErrMalformedPacket
+			}
+			return attachments[int(n)], nil
+		}
+		for k := range x {
+			r, err := reconstruct(x[k], attachments)
+			if err != nil {
+				return nil, err
+			}
+			x[k] = r
+		}
+	}
+	return v, nil
+}
+
+// encodeArgs encodes args as packet data, extracting binary attachments.
+func encodeArgs(args []interface{}) (json.RawMessage, [][]byte, error) {
+	var attachments [][]byte
+	data, err := json.Marshal(deconstruct(args, &attachments))
+	return data, attachments, err
+}
+
+// decodeArgs decodes packet data as an array of arguments, replacing
+// placeholders with attachments.
+func decodeArgs(data json.RawMessage, attachments [][]byte) ([]interface{}, error) {
+	var args []interface{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return nil, ErrMalformedPacket
+	}
+	if _, err := reconstruct(args, attachments); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
@@ -0,0 +1,297 @@
+// Package socketio implements Socket.IO v4 servers over easyws.
+//
+// Server serves Engine.IO protocol version 4 over WebSocket transport, which
+// is what socket.io-client connects with when its transports option is set
+// to ["websocket"]. HTTP long-polling transport is not supported. On top of
+// it, Server implements Socket.IO protocol version 5 with namespaces, rooms,
+// events, binary attachments and acknowledgements.
+//
+// The specifications: https://socket.io/docs/v4/engine-io-protocol/ and
+// https://socket.io/docs/v4/socket-io-protocol/
+package socketio
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Default settings of the Server.
+const (
+	DefaultPingInterval   = 25 * time.Second
+	DefaultPingTimeout    = 20 * time.Second
+	DefaultMaxPayload     = 1000000
+	DefaultMaxAttachments = 100
+)
+
+// Disconnect reasons passed to the handlers set by Namespace.OnDisconnect.
+const (
+	ReasonClientDisconnect = "client namespace disconnect"
+	ReasonServerDisconnect = "server namespace disconnect"
+	ReasonTransportClose   = "transport close"
+	ReasonTransportError   = "transport error"
+	ReasonPingTimeout      = "ping timeout"
+)
+
+// Server is a Socket.IO server. It implements easyws.IEasyWs and must be
+// served by NetHandler, which passes connections to its callbacks. Clients
+// usually connect to the "/socket.io/" path; Server does not check it.
+//
+// The zero value is ready to use. Server is safe for concurrent use.
+type Server struct {
+	// PingInterval is the interval of Engine.IO pings sent by the server.
+	// If zero, DefaultPingInterval is used.
+	PingInterval time.Duration
+
+	// PingTimeout limits the time the server waits for the pong. The
+	// connection is closed if the pong is not received in time. If zero,
+	// DefaultPingTimeout is used.
+	PingTimeout time.Duration
+
+	// MaxPayload limits the size of received messages. The size of a packet
+	// with binary attachments includes the size of the attachments. Clients
+	// sending larger messages are disconnected. If zero, DefaultMaxPayload
+	// is used.
+	MaxPayload int
+
+	// MaxAttachments limits the number of binary attachments of received
+	// packets. Clients sending packets with more attachments are
+	// disconnected. If zero, DefaultMaxAttachments is used.
+	MaxAttachments int
+
+	mu    sync.Mutex
+	nsps  map[string]*Namespace
+	conns map[*easyws.Conn]*engineConn
+}
+
+// Of returns the namespace with given name, creating it if needed. Clients
+// could connect only to the namespaces created with Of; the main namespace
+// "/" is created on the first use.
+func (s *Server) Of(name string) *Namespace {
+	if name == "" {
+		name = "/"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	n := s.nsps[name]
+	if n == nil {
+		n = &Namespace{
+			name:     name,
+			server:   s,
+			handlers: make(map[string]HandlerFunc),
+			sockets:  make(map[string]*Socket),
+			rooms:    make(map[string]map[*Socket]struct{}),
+		}
+		s.nsps[name] = n
+	}
+	return n
+}
+
+func (s *Server) init() {
+	if s.nsps == nil {
+		s.nsps = make(map[string]*Namespace)
+		s.conns = make(map[*easyws.Conn]*engineConn)
+	}
+}
+
+func (s *Server) namespace(name string) *Namespace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nsps[name]
+}
+
+// HandlerFunc handles an event received by the socket.
+type HandlerFunc func(s *Socket, e *Event)
+
+// Namespace is a communication channel which sockets connect to. Sockets of
+// a namespace could be grouped into rooms to broadcast events to them.
+type Namespace struct {
+	name   string
+	server *Server
+
+	// Fields below are guarded by server.mu.
+	middleware   []func(*Socket) error
+	onConnect    []func(*Socket)
+	onDisconnect []func(*Socket, string)
+	handlers     map[string]HandlerFunc
+	sockets      map[string]*Socket
+	rooms        map[string]map[*Socket]struct{}
+}
+
+// Name returns the name of the namespace.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Use adds middleware which is called for every socket connecting to the
+// namespace before it is connected, usually to check the authentication
+// payload returned by Socket.Auth. If middleware returns an error, the
+// connection is refused with its message.
+func (n *Namespace) Use(f func(s *Socket) error) {
+	n.server.mu.Lock()
+	defer n.server.mu.Unlock()
+	n.middleware = append(n.middleware, f)
+}
+
+// OnConnect adds a handler called when a socket is connected to the
+// namespace.
+func (n *Namespace) OnConnect(f func(s *Socket)) {
+	n.server.mu.Lock()
+	defer n.server.mu.Unlock()
+	n.onConnect = append(n.onConnect, f)
+}
+
+// OnDisconnect adds a handler called when a socket is disconnected from the
+// namespace with one of the Reason constants.
+func (n *Namespace) OnDisconnect(f func(s *Socket, reason string)) {
+	n.server.mu.Lock()
+	defer n.server.mu.Unlock()
+	n.onDisconnect = append(n.onDisconnect, f)
+}
+
+// On registers handler of the event received by sockets of the namespace.
+// Events which have no handler are ignored.
+//
+// Handlers are called sequentially for events of a connection, so they must
+// not wait for acknowledgement of the events they emit to the same
+// connection.
+func (n *Namespace) On(event string, h HandlerFunc) {
+	n.server.mu.Lock()
+	defer n.server.mu.Unlock()
+	n.handlers[event] = h
+}
+
+// Socket returns the socket with given id connected to the namespace.
+func (n *Namespace) Socket(id string) *Socket {
+	n.server.mu.Lock()
+	defer n.server.mu.Unlock()
+	return n.sockets[id]
+}
+
+// Sockets returns the number of sockets connected to the namespace.
+func (n *Namespace) Sockets() int {
+	n.server.mu.Lock()
+	defer n.server.mu.Unlock()
+	return len(n.sockets)
+}
+
+// Rooms returns sorted names of the rooms which have sockets.
+func (n *Namespace) Rooms() []string {
+	n.server.mu.Lock()
+	defer n.server.mu.Unlock()
+	rooms := make([]string, 0, len(n.rooms))
+	for r := range n.rooms {
+		rooms = append(rooms, r)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// To returns Broadcast to the sockets in given rooms.
+func (n *Namespace) To(rooms ...string) *Broadcast {
+	return &Broadcast{ns: n, rooms: rooms}
+}
+
+// Emit emits the event to all sockets of the namespace.
+func (n *Namespace) Emit(event string, args ...interface{}) error {
+	return n.To().Emit(event, args...)
+}
+
+// Broadcast emits events to a set of sockets of a namespace.
+type Broadcast struct {
+	ns     *Namespace
+	rooms  []string
+	except *Socket
+}
+
+// To returns Broadcast to the sockets in given rooms in addition to the
+// rooms of b.
+func (b *Broadcast) To(rooms ...string) *Broadcast {
+	return &Broadcast{
+		ns:     b.ns,
+		rooms:  append(append([]string(nil), b.rooms...), rooms...),
+		except: b.except,
+	}
+}
+
+// Emit emits the event to the sockets in the rooms of b, or to all sockets
+// of the namespace if b has no rooms. Every socket receives the event once,
+// even if it is in several rooms.
+func (b *Broadcast) Emit(event string, args ...interface{}) error {
+	pkt, attachments, err := eventPacket(b.ns.name, event, args)
+	if err != nil {
+		return err
+	}
+	for _, s := range b.sockets() {
+		s.ec.write(pkt, attachments)
+	}
+	return nil
+}
+
+func (b *Broadcast) sockets() []*Socket {
+	n := b.ns
+	n.server.mu.Lock()
+	defer n.server.mu.Unlock()
+	var ss []*Socket
+	if len(b.rooms) == 0 {
+		for _, s := range n.sockets {
+			if s != b.except {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	seen := make(map[*Socket]struct{})
+	for _, r := range b.rooms {
+		for s := range n.rooms[r] {
+			if _, dup := seen[s]; dup || s == b.except {
+				continue
+			}
+			seen[s] = struct{}{}
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+// eventPacket encodes event packet with given args.
+func eventPacket(nsp, event string, args []interface{}) (*packet, [][]byte, error) {
+	if reserved[event] {
+		return nil, nil, errors.New("socketio: event name " + event + " is reserved")
+	}
+	data, attachments, err := encodeArgs(append([]interface{}{event}, args...))
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &packet{typ: packetEvent, nsp: nsp, data: data}
+	if len(attachments) > 0 {
+		p.typ = packetBinaryEvent
+		p.attachments = len(attachments)
+	}
+	return p, attachments, nil
+}
+
+// reserved contains event names which could not be emitted.
+var reserved = map[string]bool{
+	"connect":        true,
+	"connect_error":  true,
+	"disconnect":     true,
+	"disconnecting":  true,
+	"newListener":    true,
+	"removeListener": true,
+}
+
+// newID returns random identifier of a connection or a socket.
+func newID() string {
+	var b [15]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
@@ -0,0 +1,235 @@
+package socketio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wscorr"
+)
+
+// ErrAcked is returned by Event.Ack if the event is already acknowledged or
+// the client did not request the acknowledgement.
+var ErrAcked = errors.New("socketio: event is already acknowledged or not acknowledgeable")
+
+// Socket is a client connected to a namespace. A client connected to
+// several namespaces over the same connection has a Socket for each of them.
+//
+// Every socket is in the room named by its ID, so events could be sent to
+// it by the ID with Namespace.To.
+type Socket struct {
+	id   string
+	ns   *Namespace
+	ec   *engineConn
+	auth json.RawMessage
+	acks wscorr.Correlator[[]interface{}]
+
+	// rooms is guarded by server.mu.
+	rooms map[string]struct{}
+
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+// ID returns the identifier of the socket.
+func (s *Socket) ID() string {
+	return s.id
+}
+
+// Namespace returns the namespace the socket is connected to.
+func (s *Socket) Namespace() *Namespace {
+	return s.ns
+}
+
+// Conn returns the underlying WebSocket connection.
+func (s *Socket) Conn() *easyws.Conn {
+	return s.ec.conn
+}
+
+// Auth returns the payload of the CONNECT packet, which is the auth option
+// of the client. It is nil if the client sent no payload.
+func (s *Socket) Auth() json.RawMessage {
+	return s.auth
+}
+
+// Set stores the value associated with key within the socket.
+func (s *Socket) Set(key string, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]interface{})
+	}
+	s.values[key] = v
+}
+
+// Get returns the value stored with Set.
+func (s *Socket) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Emit emits the event to the client. Args are encoded with encoding/json;
+// byte slices within them, including the ones nested in []interface{} and
+// map[string]interface{}, are sent as binary attachments.
+func (s *Socket) Emit(event string, args ...interface{}) error {
+	p, attachments, err := eventPacket(s.ns.name, event, args)
+	if err != nil {
+		return err
+	}
+	return s.ec.write(p, attachments)
+}
+
+// EmitWithAck emits the event to the client and waits for its
+// acknowledgement, returning its arguments. It fails with wscorr.ErrClosed
+// if the socket is disconnected meanwhile.
+//
+// EmitWithAck must not be called from the event handlers of the same
+// connection, which are called sequentially with the acknowledgements.
+func (s *Socket) EmitWithAck(ctx context.Context, event string, args ...interface{}) ([]interface{}, error) {
+	p, attachments, err := eventPacket(s.ns.name, event, args)
+	if err != nil {
+		return nil, err
+	}
+	return s.acks.Do(ctx, func(id uint64) error {
+		p.id, p.hasID = int64(id), true
+		return s.ec.write(p, attachments)
+	})
+}
+
+// Join adds the socket to the rooms.
+func (s *Socket) Join(rooms ...string) {
+	s.ns.server.mu.Lock()
+	defer s.ns.server.mu.Unlock()
+	if s.ec.sockets[s.ns.name] != s {
+		return
+	}
+	for _, r := range rooms {
+		s.ns.join(s, r)
+	}
+}
+
+// Leave removes the socket from the rooms.
+func (s *Socket) Leave(rooms ...string) {
+	s.ns.server.mu.Lock()
+	defer s.ns.server.mu.Unlock()
+	for _, r := range rooms {
+		s.ns.leave(s, r)
+	}
+}
+
+// Rooms returns sorted names of the rooms the socket is in.
+func (s *Socket) Rooms() []string {
+	s.ns.server.mu.Lock()
+	defer s.ns.server.mu.Unlock()
+	rooms := make([]string, 0, len(s.rooms))
+	for r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// To returns Broadcast to the sockets in given rooms except s. With no
+// rooms, it broadcasts to all other sockets of the namespace.
+func (s *Socket) To(rooms ...string) *Broadcast {
+	return &Broadcast{ns: s.ns, rooms: rooms, except: s}
+}
+
+// Disconnect disconnects the socket from its namespace. The underlying
+// connection stays open.
+func (s *Socket) Disconnect() error {
+	err := s.ec.write(&packet{typ: packetDisconnect, nsp: s.ns.name}, nil)
+	s.ec.remove(s, ReasonServerDisconnect)
+	return err
+}
+
+// join adds s to room r. It must be called with server.mu held.
+func (n *Namespace) join(s *Socket, r string) {
+	m := n.rooms[r]
+	if m == nil {
+		m = make(map[*Socket]struct{})
+		n.rooms[r] = m
+	}
+	m[s] = struct{}{}
+	s.rooms[r] = struct{}{}
+}
+
+// leave removes s from room r. It must be called with server.mu held.
+func (n *Namespace) leave(s *Socket, r string) {
+	delete(s.rooms, r)
+	if m := n.rooms[r]; m != nil {
+		delete(m, s)
+		if len(m) == 0 {
+			delete(n.rooms, r)
+		}
+	}
+}
+
+// Event is an event received from the client.
+type Event struct {
+	// Name is the name of the event.
+	Name string
+
+	// Args are the arguments of the event decoded into interface{} values
+	// by encoding/json. Binary attachments are []byte.
+	Args []interface{}
+
+	socket *Socket
+	id     int64
+	hasID  bool
+	mu     sync.Mutex
+	acked  bool
+}
+
+// Socket returns the socket the event is received by.
+func (e *Event) Socket() *Socket {
+	return e.socket
+}
+
+// WantsAck reports whether the client requested the acknowledgement of the
+// event.
+func (e *Event) WantsAck() bool {
+	return e.hasID
+}
+
+// Ack acknowledges the event with given arguments, which are encoded like
+// the ones of Socket.Emit. It could be called once, from any goroutine.
+func (e *Event) Ack(args ...interface{}) error {
+	e.mu.Lock()
+	acked := e.acked || !e.hasID
+	e.acked = true
+	e.mu.Unlock()
+	if acked {
+		return ErrAcked
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	data, attachments, err := encodeArgs(args)
+	if err != nil {
+		return err
+	}
+	p := &packet{typ: packetAck, nsp: e.socket.ns.name, id: e.id, hasID: true, data: data}
+	if len(attachments) > 0 {
+		p.typ = packetBinaryAck
+		p.attachments = len(attachments)
+	}
+	return e.socket.ec.write(p, attachments)
+}
+
+// Bind decodes the i-th argument into v. Binary attachments could be bound
+// to *[]byte.
+func (e *Event) Bind(i int, v interface{}) error {
+	if i < 0 || i >= len(e.Args) {
+		return errors.New("socketio: event has no such argument")
+	}
+	p, err := json.Marshal(e.Args[i])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
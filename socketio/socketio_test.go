@@ -0,0 +1,450 @@
+package socketio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wscorr"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+const path = "/socket.io/?EIO=4&transport=websocket"
+
+type client struct {
+	*wstest.Harness
+}
+
+func dial(t *testing.T, s *Server) client {
+	c := client{wstest.Dial(t, s, wstest.Request{Path: path})}
+	c.expectPrefix(`0{"sid":"`)
+	return c
+}
+
+func (c client) expect(s string) {
+	c.T().Helper()
+	if act := c.WaitText(); act != s {
+		c.T().Fatalf("unexpected message: %q; want %q", act, s)
+	}
+}
+
+func (c client) expectPrefix(s string) string {
+	c.T().Helper()
+	act := c.WaitText()
+	if !strings.HasPrefix(act, s) {
+		c.T().Fatalf("unexpected message: %q; want %q...", act, s)
+	}
+	return act
+}
+
+func (c client) expectBinary(p string) {
+	c.T().Helper()
+	if f := c.WaitFrame(); f.Header.OpCode != easyws.OpBinary || string(f.Payload) != p {
+		c.T().Fatalf("unexpected frame: %v %q; want binary %q", f.Header.OpCode, f.Payload, p)
+	}
+}
+
+// connect connects the client to the namespace and returns the socket ID.
+func (c client) connect(nsp, auth string) string {
+	c.T().Helper()
+	prefix := "40"
+	if nsp != "/" {
+		prefix += nsp + ","
+	}
+	c.SendText(prefix + auth)
+	var data struct{ SID string }
+	if err := json.Unmarshal([]byte(c.expectPrefix(prefix)[len(prefix):]), &data); err != nil || data.SID == "" {
+		c.T().Fatalf("unexpected connect packet data: %v", err)
+	}
+	return data.SID
+}
+
+func TestParsePacket(t *testing.T) {
+	for _, test := range []struct {
+		in  string
+		exp packet
+		err bool
+	}{
+		{in: "0", exp: packet{typ: packetConnect, nsp: "/"}},
+		{in: `0/admin,{"token":"x"}`, exp: packet{typ: packetConnect, nsp: "/admin", data: json.RawMessage(`{"token":"x"}`)}},
+		{in: "1/admin,", exp: packet{typ: packetDisconnect, nsp: "/admin"}},
+		{in: `2["hello",1]`, exp: packet{typ: packetEvent, nsp: "/", data: json.RawMessage(`["hello",1]`)}},
+		{in: `2/admin,13["hello"]`, exp: packet{typ: packetEvent, nsp: "/admin", id: 13, hasID: true, data: json.RawMessage(`["hello"]`)}},
+		{in: `31[]`, exp: packet{typ: packetAck, nsp: "/", id: 1, hasID: true, data: json.RawMessage(`[]`)}},
+		{in: `52-["a",{"_placeholder":true,"num":0}]`, exp: packet{typ: packetBinaryEvent, nsp: "/", attachments: 2, data: json.RawMessage(`["a",{"_placeholder":true,"num":0}]`)}},
+		{in: "", err: true},
+		{in: "7", err: true},
+		{in: `5["a"]`, err: true},
+		{in: `2["a"`, err: true},
+	} {
+		p, err := parsePacket(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("parsePacket(%q): expected error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePacket(%q): unexpected error: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(*p, test.exp) {
+			t.Errorf("parsePacket(%q) = %+v; want %+v", test.in, *p, test.exp)
+		}
+		if enc := string(p.encode()); enc != "4"+test.in {
+			t.Errorf("encode() = %q; want %q", enc, "4"+test.in)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	data, attachments, err := encodeArgs([]interface{}{
+		"file",
+		map[string]interface{}{"name": "a", "body": []byte("abc")},
+		[]interface{}{[]byte("x"), 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const exp = `["file",{"body":{"_placeholder":true,"num":0},"name":"a"},[{"_placeholder":true,"num":1},1]]`
+	if string(data) != exp {
+		t.Fatalf("unexpected data: %s; want %s", data, exp)
+	}
+	args, err := decodeArgs(data, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	act := []interface{}{
+		"file",
+		map[string]interface{}{"name": "a", "body": []byte("abc")},
+		[]interface{}{[]byte("x"), float64(1)},
+	}
+	if !reflect.DeepEqual(args, act) {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	if _, err := decodeArgs(data, attachments[:1]); err != ErrMalformedPacket {
+		t.Fatalf("unexpected error for missing attachment: %v", err)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	t.Run("version", func(t *testing.T) {
+		for _, uri := range []string{"/socket.io/?EIO=3&transport=websocket", "/socket.io/?EIO=4&transport=polling"} {
+			ws := wstest.New(t, &Server{})
+			ws.Write(wstest.Request{Path: uri}.Bytes())
+			if resp := ws.Response(); resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("unexpected response status for %s: %s", uri, resp.Status)
+			}
+			ws.ExpectClosed()
+		}
+	})
+
+	t.Run("open", func(t *testing.T) {
+		c := client{wstest.Dial(t, &Server{PingInterval: time.Minute, PingTimeout: time.Second, MaxPayload: 100}, wstest.Request{Path: path})}
+		var open struct {
+			SID          string
+			Upgrades     []string
+			PingInterval int
+			PingTimeout  int
+			MaxPayload   int
+		}
+		if err := json.Unmarshal([]byte(c.expectPrefix("0")[1:]), &open); err != nil {
+			t.Fatal(err)
+		}
+		if open.SID == "" || open.Upgrades == nil || open.PingInterval != 60000 || open.PingTimeout != 1000 || open.MaxPayload != 100 {
+			t.Fatalf("unexpected open packet: %+v", open)
+		}
+
+		c.SendText("2probe")
+		c.expect("3probe")
+		c.SendText("6")
+		c.ExpectNothing()
+		c.SendText("4" + strings.Repeat("x", 100))
+		c.ExpectClose(easyws.StatusMessageTooBig)
+	})
+
+	t.Run("heartbeat", func(t *testing.T) {
+		disconnected := make(chan string, 1)
+		s := &Server{PingInterval: 20 * time.Millisecond, PingTimeout: 50 * time.Millisecond}
+		s.Of("/").OnDisconnect(func(_ *Socket, reason string) {
+			disconnected <- reason
+		})
+		c := dial(t, s)
+		c.connect("/", "")
+		for i := 0; i < 3; i++ {
+			c.expect("2")
+			c.SendText("3")
+		}
+		c.expect("2")
+		if reason := <-disconnected; reason != ReasonPingTimeout {
+			t.Fatalf("unexpected disconnect reason: %q", reason)
+		}
+		if f := c.WaitFrame(); f.Header.OpCode != easyws.OpClose {
+			t.Fatalf("unexpected frame: %+v; want close", f.Header)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		c := dial(t, &Server{})
+		c.SendText("1")
+		c.ExpectClose(easyws.StatusNormalClosure)
+
+		for _, msg := range []string{"9", "4x", "40/nope", "44{}"} {
+			c = dial(t, &Server{})
+			c.Write(wstest.Text(msg))
+			if msg == "40/nope" {
+				c.expect(`44/nope,{"message":"Invalid namespace"}`)
+				continue
+			}
+			c.ExpectClose(easyws.StatusProtocolError)
+		}
+
+		c = dial(t, &Server{})
+		c.Write(wstest.Binary([]byte{1}))
+		c.ExpectClose(easyws.StatusProtocolError)
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		s := &Server{MaxPayload: 100, MaxAttachments: 2}
+		c := dial(t, s)
+		c.SendText(`453-["a"]`)
+		c.ExpectClose(easyws.StatusProtocolError)
+
+		c = dial(t, s)
+		c.SendText(`452-["a"]`)
+		c.Write(wstest.Binary(make([]byte, 60)))
+		c.ExpectNothing()
+		c.Write(wstest.Binary(make([]byte, 60)))
+		c.ExpectClose(easyws.StatusMessageTooBig)
+	})
+}
+
+func TestNamespace(t *testing.T) {
+	var s Server
+	events := make(chan string, 10)
+	main := s.Of("/")
+	main.OnConnect(func(so *Socket) {
+		so.Set("user", "gopher")
+	})
+	main.OnDisconnect(func(so *Socket, reason string) {
+		events <- "disconnect " + reason
+	})
+	main.On("echo", func(so *Socket, e *Event) {
+		if e.WantsAck() {
+			e.Ack(e.Args...)
+			if err := e.Ack(); err != ErrAcked {
+				t.Errorf("unexpected error of the second ack: %v", err)
+			}
+			return
+		}
+		so.Emit("echo", e.Args...)
+	})
+	main.On("whoami", func(so *Socket, e *Event) {
+		e.Ack(so.Get("user"))
+	})
+	main.On("upload", func(so *Socket, e *Event) {
+		var p []byte
+		if err := e.Bind(0, &p); err != nil {
+			t.Errorf("can not bind upload: %v", err)
+		}
+		events <- "upload " + string(p)
+		e.Ack(len(p))
+	})
+	main.On("join", func(so *Socket, e *Event) {
+		var room string
+		e.Bind(0, &room)
+		so.Join(room)
+		e.Ack(so.Rooms())
+	})
+	main.On("shout", func(so *Socket, e *Event) {
+		so.To(so.Rooms()...).Emit("shout", e.Args...)
+	})
+	main.On("kick", func(so *Socket, e *Event) {
+		so.Disconnect()
+	})
+
+	admin := s.Of("/admin")
+	admin.Use(func(so *Socket) error {
+		var auth struct{ Token string }
+		if json.Unmarshal(so.Auth(), &auth) != nil || auth.Token != "secret" {
+			return errors.New("forbidden")
+		}
+		return nil
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		c := dial(t, &s)
+		sid := c.connect("/", "")
+		if so := main.Socket(sid); so == nil || so.Namespace() != main || so.Conn() == nil {
+			t.Fatalf("socket %q is not found", sid)
+		}
+		c.SendText("40")
+		c.expect(`44{"message":"Already connected"}`)
+
+		c.SendText(`40/admin,{"token":"wrong"}`)
+		c.expect(`44/admin,{"message":"forbidden"}`)
+		adminSID := c.connect("/admin", `{"token":"secret"}`)
+		if adminSID == sid || admin.Socket(adminSID) == nil {
+			t.Fatalf("admin socket %q is not found", adminSID)
+		}
+
+		c.SendText("41/admin,")
+		c.SendText(`42/admin,["echo"]`)
+		c.ExpectNothing()
+		if n := admin.Sockets(); n != 0 {
+			t.Fatalf("unexpected number of admin sockets: %d", n)
+		}
+
+		c.SendText("41")
+		if e := <-events; e != "disconnect "+ReasonClientDisconnect {
+			t.Fatalf("unexpected event: %q", e)
+		}
+		c.connect("/", "")
+		c.SendText(`42["kick"]`)
+		c.expect("41")
+		if e := <-events; e != "disconnect "+ReasonServerDisconnect {
+			t.Fatalf("unexpected event: %q", e)
+		}
+
+		c.connect("/", "")
+		c.Close()
+		if e := <-events; e != "disconnect "+ReasonTransportClose {
+			t.Fatalf("unexpected event: %q", e)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		c := dial(t, &s)
+		c.connect("/", "")
+		c.SendText(`42["echo","hello",{"n":1}]`)
+		c.expect(`42["echo","hello",{"n":1}]`)
+		c.SendText(`4213["echo","hello"]`)
+		c.expect(`4313["hello"]`)
+		c.SendText(`421["whoami"]`)
+		c.expect(`431["gopher"]`)
+		c.SendText(`42["unknown"]`)
+		c.ExpectNothing()
+
+		c.SendText(`451-1["upload",{"_placeholder":true,"num":0}]`)
+		c.ExpectNothing()
+		c.Write(wstest.Binary([]byte("file")))
+		c.expect(`431[4]`)
+		if e := <-events; e != "upload file" {
+			t.Fatalf("unexpected event: %q", e)
+		}
+
+		c.SendText(`452-["echo",{"_placeholder":true,"num":1},{"_placeholder":true,"num":0}]`)
+		c.Write(wstest.Binary([]byte("a")))
+		c.Write(wstest.Binary([]byte("b")))
+		c.expect(`452-["echo",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`)
+		c.expectBinary("b")
+		c.expectBinary("a")
+	})
+
+	t.Run("rooms", func(t *testing.T) {
+		a, b, o := dial(t, &s), dial(t, &s), dial(t, &s)
+		aid := a.connect("/", "")
+		b.connect("/", "")
+		o.connect("/", "")
+
+		a.SendText(`421["join","room"]`)
+		a.expect(`431[["` + strings.Join(sorted(aid, "room"), `","`) + `"]]`)
+		b.SendText(`421["join","room"]`)
+		b.expectPrefix(`431[[`)
+
+		main.To("room").Emit("news", []byte{1, 2})
+		a.expect(`451-["news",{"_placeholder":true,"num":0}]`)
+		a.expectBinary("\x01\x02")
+		b.expect(`451-["news",{"_placeholder":true,"num":0}]`)
+		b.expectBinary("\x01\x02")
+		o.ExpectNothing()
+
+		a.SendText(`42["shout","hi"]`)
+		b.expect(`42["shout","hi"]`)
+		a.ExpectNothing()
+		o.ExpectNothing()
+
+		main.To(aid).To("room").Emit("once")
+		a.expect(`42["once"]`)
+		b.expect(`42["once"]`)
+		o.ExpectNothing()
+
+		main.Emit("all")
+		for _, c := range []client{a, b, o} {
+			c.expect(`42["all"]`)
+		}
+		if err := main.Emit("connect"); err == nil {
+			t.Fatalf("expected error for reserved event")
+		}
+
+		a.Close()
+		<-events
+		if rooms := main.Rooms(); len(rooms) != 3 || !contains(rooms, "room") || contains(rooms, aid) {
+			t.Fatalf("unexpected rooms: %v", rooms)
+		}
+	})
+
+	t.Run("ack", func(t *testing.T) {
+		c := dial(t, &s)
+		so := main.Socket(c.connect("/", ""))
+
+		type reply struct {
+			args []interface{}
+			err  error
+		}
+		replies := make(chan reply, 1)
+		go func() {
+			args, err := so.EmitWithAck(context.Background(), "ask", "why")
+			replies <- reply{args, err}
+		}()
+		c.expect(`421["ask","why"]`)
+		c.SendText(`431["because",42]`)
+		if r := <-replies; r.err != nil || !reflect.DeepEqual(r.args, []interface{}{"because", float64(42)}) {
+			t.Fatalf("unexpected reply: %+v", r)
+		}
+
+		go func() {
+			args, err := so.EmitWithAck(context.Background(), "upload")
+			replies <- reply{args, err}
+		}()
+		c.expect(`422["upload"]`)
+		c.SendText(`461-2[{"_placeholder":true,"num":0}]`)
+		c.Write(wstest.Binary([]byte("data")))
+		if r := <-replies; r.err != nil || !reflect.DeepEqual(r.args, []interface{}{[]byte("data")}) {
+			t.Fatalf("unexpected reply: %+v", r)
+		}
+
+		go func() {
+			args, err := so.EmitWithAck(context.Background(), "ask")
+			replies <- reply{args, err}
+		}()
+		c.expect(`423["ask"]`)
+		c.Close()
+		if r := <-replies; r.err != wscorr.ErrClosed {
+			t.Fatalf("unexpected reply: %+v", r)
+		}
+		<-events
+	})
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sorted(rooms ...string) []string {
+	if rooms[0] > rooms[1] {
+		rooms[0], rooms[1] = rooms[1], rooms[0]
+	}
+	return rooms
+}
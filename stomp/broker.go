@@ -0,0 +1,594 @@
+package stomp
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Default settings of the Broker.
+const (
+	DefaultQueuePrefix = "/queue/"
+	DefaultMaxQueued   = 1000
+)
+
+// Broker is an in-memory STOMP 1.2 broker serving clients connected over
+// easyws. It implements easyws.IEasyWs and must be served by NetHandler,
+// which passes connections to its callbacks.
+//
+// Messages sent to a destination are delivered to every subscription of it,
+// except queue destinations, whose messages are delivered to one of the
+// subscriptions in turn and are kept until a subscription appears. Messages
+// which are not acknowledged or are rejected with NACK are delivered again
+// for queues and dropped for other destinations.
+//
+// Transactions are not supported: frames within them are answered with
+// ERROR.
+//
+// The zero value is ready to use. Broker is safe for concurrent use.
+type Broker struct {
+	// Authenticate is called for every CONNECT frame with its login and
+	// passcode headers. If it returns an error, the client receives ERROR
+	// frame with its message and is disconnected. If nil, all clients are
+	// accepted.
+	Authenticate func(conn *easyws.Conn, login, passcode string) error
+
+	// HeartBeat is the interval of heart-beats the broker offers to send
+	// and wants to receive. Zero disables heart-beats.
+	//
+	// Negotiated interval of heart-beats sent by the broker is the greatest
+	// of HeartBeat and the interval the client wants to receive. Within the
+	// interval of client heart-beats the broker also accepts any WebSocket
+	// frame, including pongs: if nothing is received within it, the client
+	// is pinged, and if nothing is received within two intervals, it is
+	// disconnected.
+	HeartBeat time.Duration
+
+	// QueuePrefix is the prefix of queue destinations. If empty,
+	// DefaultQueuePrefix is used.
+	QueuePrefix string
+
+	// MaxQueued limits the number of messages kept for a queue without
+	// subscriptions. When the limit is reached, the oldest messages are
+	// dropped. If zero, DefaultMaxQueued is used.
+	MaxQueued int
+
+	// MaxFrameSize limits the size of frames received from the clients.
+	// Clients sending larger frames are disconnected. Zero means no limit.
+	MaxFrameSize int
+
+	mu      sync.Mutex
+	seq     uint64
+	clients map[*easyws.Conn]*client
+	dests   map[string]*destination
+}
+
+// client is a client connection.
+type client struct {
+	conn *easyws.Conn
+
+	// buf holds received bytes of incomplete frame.
+	buf []byte
+
+	connected bool
+	session   string
+	subs      map[string]*subscription
+	ackSeq    uint64
+	unacked   []delivery
+	done      chan struct{}
+}
+
+// destination holds subscriptions of a destination.
+type destination struct {
+	name  string
+	queue bool
+	subs  []*subscription
+	next  int
+
+	// pending holds messages of a queue without subscriptions.
+	pending []*message
+}
+
+type subscription struct {
+	id     string
+	client *client
+	dest   *destination
+	ack    string
+}
+
+type message struct {
+	id     string
+	header Header
+	body   []byte
+}
+
+// delivery is a message delivered and not acknowledged yet.
+type delivery struct {
+	ack string
+	sub *subscription
+	msg *message
+}
+
+// Register registers b within m for the "v12.stomp" subprotocol.
+func (b *Broker) Register(m *easyws.ProtocolMux) {
+	m.Handle(Subprotocol, b)
+}
+
+// Send sends a message with given headers and body to the destination as
+// if it was sent by a client.
+func (b *Broker) Send(dest string, header Header, body []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.send(dest, header, body)
+}
+
+func (b *Broker) init() {
+	if b.clients == nil {
+		b.clients = make(map[*easyws.Conn]*client)
+		b.dests = make(map[string]*destination)
+	}
+}
+
+// OnOpen implements easyws.IEasyWsConn.
+func (b *Broker) OnOpen(conn *easyws.Conn) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.clients[conn] = &client{
+		conn: conn,
+		subs: make(map[string]*subscription),
+		done: make(chan struct{}),
+	}
+	return nil
+}
+
+// OnDisconnect implements easyws.IEasyWsConn. Messages delivered to the
+// client and not acknowledged are delivered again if they were sent to
+// queues.
+func (b *Broker) OnDisconnect(conn *easyws.Conn, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.clients[conn]; c != nil {
+		b.drop(c)
+	}
+}
+
+// OnMessage implements easyws.IEasyWsMessage. Clients violating the protocol
+// receive ERROR frame and are disconnected with StatusProtocolError.
+func (b *Broker) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.clients[conn]
+	if c == nil {
+		return easyws.CloseReply(easyws.StatusInternalServerError, "stomp: connection is not open")
+	}
+	c.buf = append(c.buf, msg...)
+	var off int
+	for {
+		f, n, err := ParseFrame(c.buf[off:])
+		if err == io.ErrUnexpectedEOF {
+			if b.MaxFrameSize > 0 && len(c.buf)-off > b.MaxFrameSize {
+				err = ErrFrameTooLarge
+			} else {
+				break
+			}
+		}
+		if err == nil && b.MaxFrameSize > 0 && n > b.MaxFrameSize {
+			err = ErrFrameTooLarge
+		}
+		if err == nil && !f.IsHeartBeat() {
+			err = b.handle(c, f)
+		}
+		if err == errDisconnect {
+			b.drop(c)
+			return easyws.CloseReply(easyws.StatusNormalClosure, "")
+		}
+		if err != nil {
+			b.fail(c, f, err)
+			b.drop(c)
+			return easyws.CloseReply(easyws.StatusProtocolError, err.Error())
+		}
+		off += n
+	}
+	c.buf = append(c.buf[:0], c.buf[off:]...)
+	return nil, 0, nil
+}
+
+// OnReceive implements easyws.IEasyWs. Broker is served by OnMessage only;
+// see easyws.IEasyWsMessage.
+func (b *Broker) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return easyws.CloseReply(easyws.StatusInternalServerError, "stomp: connection is not open")
+}
+
+func (b *Broker) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (b *Broker) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (b *Broker) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (b *Broker) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (b *Broker) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
+
+// errDisconnect is returned by Broker.handle for DISCONNECT frame.
+var errDisconnect = errors.New("stomp: disconnect")
+
+// handle handles a frame received from c.
+func (b *Broker) handle(c *client, f Frame) error {
+	if !c.connected {
+		if f.Command != "CONNECT" && f.Command != "STOMP" {
+			return errors.New("stomp: first frame must be CONNECT")
+		}
+		return b.connect(c, f)
+	}
+	if _, ok := f.Header["transaction"]; ok {
+		return errors.New("stomp: transactions are not supported")
+	}
+	switch f.Command {
+	case "SEND":
+		dest := f.Header["destination"]
+		if dest == "" {
+			return errors.New("stomp: destination header is required")
+		}
+		b.send(dest, f.Header, f.Body)
+
+	case "SUBSCRIBE":
+		id, dest := f.Header["id"], f.Header["destination"]
+		if id == "" || dest == "" {
+			return errors.New("stomp: id and destination headers are required")
+		}
+		if _, dup := c.subs[id]; dup {
+			return errors.New("stomp: duplicate subscription " + strconv.Quote(id))
+		}
+		ack := f.Header["ack"]
+		switch ack {
+		case "":
+			ack = "auto"
+		case "auto", "client", "client-individual":
+		default:
+			return errors.New("stomp: unknown ack mode " + strconv.Quote(ack))
+		}
+		d := b.dest(dest)
+		s := &subscription{id: id, client: c, dest: d, ack: ack}
+		c.subs[id] = s
+		d.subs = append(d.subs, s)
+		// Receipt is sent before the messages kept for the queue.
+		b.receipt(c, f)
+		pending := d.pending
+		d.pending = nil
+		for _, m := range pending {
+			b.route(d, m)
+		}
+		return nil
+
+	case "UNSUBSCRIBE":
+		s := c.subs[f.Header["id"]]
+		if s == nil {
+			return errors.New("stomp: unknown subscription " + strconv.Quote(f.Header["id"]))
+		}
+		b.unsubscribe(s)
+
+	case "ACK", "NACK":
+		id := f.Header["id"]
+		i := -1
+		for j, d := range c.unacked {
+			if d.ack == id {
+				i = j
+				break
+			}
+		}
+		if i == -1 {
+			return errors.New("stomp: unknown ack id " + strconv.Quote(id))
+		}
+		d := c.unacked[i]
+		var done []delivery
+		if d.sub.ack == "client" {
+			// Cumulative acknowledgement of the subscription messages.
+			rest := c.unacked[:0]
+			for j, u := range c.unacked {
+				if u.sub == d.sub && j <= i {
+					done = append(done, u)
+				} else {
+					rest = append(rest, u)
+				}
+			}
+			c.unacked = rest
+		} else {
+			done = []delivery{d}
+			c.unacked = append(c.unacked[:i], c.unacked[i+1:]...)
+		}
+		if f.Command == "NACK" {
+			b.redeliver(done)
+		}
+
+	case "DISCONNECT":
+		b.receipt(c, f)
+		return errDisconnect
+
+	case "BEGIN", "COMMIT", "ABORT":
+		return errors.New("stomp: transactions are not supported")
+
+	case "CONNECT", "STOMP":
+		return errors.New("stomp: already connected")
+
+	default:
+		return errors.New("stomp: unknown command " + strconv.Quote(f.Command))
+	}
+	b.receipt(c, f)
+	return nil
+}
+
+// connect handles CONNECT or STOMP frame received from c.
+func (b *Broker) connect(c *client, f Frame) error {
+	versions := strings.Split(f.Header["accept-version"], ",")
+	supported := false
+	for _, v := range versions {
+		supported = supported || strings.TrimSpace(v) == "1.2"
+	}
+	if !supported {
+		return errors.New("stomp: supported protocol version is 1.2")
+	}
+	if b.Authenticate != nil {
+		if err := b.Authenticate(c.conn, f.Header["login"], f.Header["passcode"]); err != nil {
+			return err
+		}
+	}
+	cx, cy, err := parseHeartBeat(f.Header["heart-beat"])
+	if err != nil {
+		return err
+	}
+	b.seq++
+	c.session = "session-" + strconv.FormatUint(b.seq, 10)
+	c.connected = true
+
+	hb := b.HeartBeat.Milliseconds()
+	b.write(c, Frame{
+		Command: "CONNECTED",
+		Header: Header{
+			"version":    "1.2",
+			"session":    c.session,
+			"server":     "easyws",
+			"heart-beat": strconv.FormatInt(hb, 10) + "," + strconv.FormatInt(hb, 10),
+		},
+	})
+	send, recv := negotiate(hb, cy), negotiate(cx, hb)
+	if send > 0 || recv > 0 {
+		go b.heartBeat(c, send, recv)
+	}
+	return nil
+}
+
+// parseHeartBeat parses the heart-beat header value "cx,cy". Empty value
+// means no heart-beats.
+func parseHeartBeat(s string) (cx, cy int64, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	x, y, ok := strings.Cut(s, ",")
+	if ok {
+		cx, err = strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+	}
+	if ok && err == nil {
+		cy, err = strconv.ParseInt(strings.TrimSpace(y), 10, 64)
+	}
+	if !ok || err != nil || cx < 0 || cy < 0 {
+		return 0, 0, errors.New("stomp: malformed heart-beat header")
+	}
+	return cx, cy, nil
+}
+
+// negotiate returns the interval of heart-beats between the sender able to
+// send them every x milliseconds and the receiver wanting to receive them
+// every y milliseconds.
+func negotiate(x, y int64) time.Duration {
+	if x == 0 || y == 0 {
+		return 0
+	}
+	if y > x {
+		x = y
+	}
+	return time.Duration(x) * time.Millisecond
+}
+
+// heartBeat sends heart-beats to c every send interval and checks that
+// something is received from c every recv interval, until c is dropped.
+func (b *Broker) heartBeat(c *client, send, recv time.Duration) {
+	var sendC, recvC <-chan time.Time
+	if send > 0 {
+		t := time.NewTicker(send)
+		defer t.Stop()
+		sendC = t.C
+	}
+	if recv > 0 {
+		t := time.NewTicker(recv)
+		defer t.Stop()
+		recvC = t.C
+	}
+	eol := []byte{'\n'}
+	for {
+		select {
+		case <-sendC:
+			c.conn.WriteMessage(easyws.OpText, eol)
+		case <-recvC:
+			idle := time.Since(c.conn.LastFrame())
+			switch {
+			case idle > 2*recv:
+				b.mu.Lock()
+				b.drop(c)
+				b.mu.Unlock()
+				c.conn.Close(easyws.StatusPolicyViolation, "stomp: heart-beat timeout")
+				return
+			case idle > recv:
+				c.conn.Ping(nil)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// drop forgets c, delivering its unacknowledged queue messages again.
+func (b *Broker) drop(c *client) {
+	if b.clients[c.conn] != c {
+		return
+	}
+	delete(b.clients, c.conn)
+	close(c.done)
+	for _, s := range c.subs {
+		b.detach(s)
+	}
+	unacked := c.unacked
+	c.unacked = nil
+	b.redeliver(unacked)
+}
+
+// unsubscribe removes s, delivering its unacknowledged queue messages
+// again.
+func (b *Broker) unsubscribe(s *subscription) {
+	b.detach(s)
+	c := s.client
+	var done []delivery
+	rest := c.unacked[:0]
+	for _, u := range c.unacked {
+		if u.sub == s {
+			done = append(done, u)
+		} else {
+			rest = append(rest, u)
+		}
+	}
+	c.unacked = rest
+	b.redeliver(done)
+}
+
+// detach removes s from its client and destination.
+func (b *Broker) detach(s *subscription) {
+	d := s.dest
+	delete(s.client.subs, s.id)
+	for i, x := range d.subs {
+		if x == s {
+			d.subs = append(d.subs[:i], d.subs[i+1:]...)
+			break
+		}
+	}
+	if len(d.subs) == 0 && len(d.pending) == 0 {
+		delete(b.dests, d.name)
+	}
+}
+
+// redeliver delivers queue messages again. Other messages are dropped.
+func (b *Broker) redeliver(ds []delivery) {
+	for _, d := range ds {
+		if d.sub.dest.queue {
+			b.route(b.dest(d.sub.dest.name), d.msg)
+		}
+	}
+}
+
+func (b *Broker) dest(name string) *destination {
+	d := b.dests[name]
+	if d == nil {
+		prefix := b.QueuePrefix
+		if prefix == "" {
+			prefix = DefaultQueuePrefix
+		}
+		d = &destination{
+			name:  name,
+			queue: strings.HasPrefix(name, prefix),
+		}
+		b.dests[name] = d
+	}
+	return d
+}
+
+// send makes a message and routes it to the destination.
+func (b *Broker) send(dest string, header Header, body []byte) {
+	b.seq++
+	m := &message{
+		id:     "message-" + strconv.FormatUint(b.seq, 10),
+		header: make(Header, len(header)),
+		body:   body,
+	}
+	for k, v := range header {
+		switch k {
+		case "receipt", "transaction", "content-length", "message-id", "subscription", "ack":
+		default:
+			m.header[k] = v
+		}
+	}
+	m.header["destination"] = dest
+	b.route(b.dest(dest), m)
+}
+
+// route delivers m to the subscriptions of d.
+func (b *Broker) route(d *destination, m *message) {
+	switch {
+	case !d.queue:
+		for _, s := range d.subs {
+			b.deliver(s, m)
+		}
+		if len(d.subs) == 0 {
+			delete(b.dests, d.name)
+		}
+	case len(d.subs) == 0:
+		max := b.MaxQueued
+		if max <= 0 {
+			max = DefaultMaxQueued
+		}
+		if len(d.pending) >= max {
+			d.pending = d.pending[1:]
+		}
+		d.pending = append(d.pending, m)
+	default:
+		d.next %= len(d.subs)
+		b.deliver(d.subs[d.next], m)
+		d.next++
+	}
+}
+
+// deliver sends m to the subscription s.
+func (b *Broker) deliver(s *subscription, m *message) {
+	h := make(Header, len(m.header)+3)
+	for k, v := range m.header {
+		h[k] = v
+	}
+	h["message-id"] = m.id
+	h["subscription"] = s.id
+	if s.ack != "auto" {
+		c := s.client
+		c.ackSeq++
+		ack := strconv.FormatUint(c.ackSeq, 10)
+		h["ack"] = ack
+		c.unacked = append(c.unacked, delivery{ack: ack, sub: s, msg: m})
+	}
+	b.write(s.client, Frame{Command: "MESSAGE", Header: h, Body: m.body})
+}
+
+// receipt sends RECEIPT frame to c if f requests it.
+func (b *Broker) receipt(c *client, f Frame) {
+	if id, ok := f.Header["receipt"]; ok {
+		b.write(c, Frame{Command: "RECEIPT", Header: Header{"receipt-id": id}})
+	}
+}
+
+// fail sends ERROR frame describing err which happened while f was handled.
+func (b *Broker) fail(c *client, f Frame, err error) {
+	h := Header{"message": strings.TrimPrefix(err.Error(), "stomp: ")}
+	if id, ok := f.Header["receipt"]; ok {
+		h["receipt-id"] = id
+	}
+	b.write(c, Frame{Command: "ERROR", Header: h})
+}
+
+// write sends f to c as a text message, or a binary one if the body is not
+// valid UTF-8. Errors are ignored: the connection is closed and will be
+// dropped.
+func (b *Broker) write(c *client, f Frame) {
+	op := easyws.OpText
+	if !utf8.Valid(f.Body) {
+		op = easyws.OpBinary
+	}
+	c.conn.WriteMessage(op, AppendFrame(nil, f))
+}
@@ -0,0 +1,208 @@
+// Package stomp implements STOMP 1.2 over WebSocket.
+//
+// Frame, AppendFrame and ParseFrame encode and decode STOMP frames. Broker is
+// an easyws.IEasyWs serving STOMP clients connected with the "v12.stomp"
+// subprotocol, such as Spring or stomp.js clients. It routes messages by
+// destination in memory, supports acknowledgements, receipts and heart-beats.
+//
+// The specification: https://stomp.github.io/stomp-specification-1.2.html
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Subprotocol is the WebSocket subprotocol of STOMP 1.2.
+const Subprotocol = "v12.stomp"
+
+// Errors returned by ParseFrame.
+var (
+	ErrMalformedFrame = errors.New("stomp: malformed frame")
+	ErrFrameTooLarge  = errors.New("stomp: frame too large")
+)
+
+// Header contains frame headers. If a header is repeated within a received
+// frame, only the first value is kept, as the specification requires.
+type Header map[string]string
+
+// Frame is a STOMP frame.
+type Frame struct {
+	Command string
+	Header  Header
+	Body    []byte
+}
+
+// IsHeartBeat reports whether f is a heart-beat, which is an end of line
+// received between frames.
+func (f Frame) IsHeartBeat() bool {
+	return f.Command == ""
+}
+
+// AppendFrame appends encoded f to dst. Headers are written in sorted order
+// and content-length header is added for frames with body.
+func AppendFrame(dst []byte, f Frame) []byte {
+	dst = append(dst, f.Command...)
+	dst = append(dst, '\n')
+	escape := f.Command != "CONNECT" && f.Command != "CONNECTED"
+	keys := make([]string, 0, len(f.Header)+1)
+	for k := range f.Header {
+		if k != "content-length" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		dst = appendHeader(dst, k, f.Header[k], escape)
+	}
+	if len(f.Body) > 0 {
+		dst = appendHeader(dst, "content-length", strconv.Itoa(len(f.Body)), false)
+	}
+	dst = append(dst, '\n')
+	dst = append(dst, f.Body...)
+	return append(dst, 0)
+}
+
+func appendHeader(dst []byte, k, v string, escape bool) []byte {
+	if escape {
+		k, v = escaper.Replace(k), escaper.Replace(v)
+	}
+	dst = append(dst, k...)
+	dst = append(dst, ':')
+	dst = append(dst, v...)
+	return append(dst, '\n')
+}
+
+var escaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\r", "\\r",
+	"\n", "\\n",
+	":", "\\c",
+)
+
+// ParseFrame parses a frame placed at the beginning of data and returns the
+// number of bytes it takes. End of line placed instead of a frame is
+// returned as a heart-beat frame with empty command. If data does not hold
+// the whole frame, io.ErrUnexpectedEOF is returned.
+func ParseFrame(data []byte) (f Frame, n int, err error) {
+	if len(data) == 0 {
+		return f, 0, io.ErrUnexpectedEOF
+	}
+	if data[0] == '\n' {
+		return f, 1, nil
+	}
+	if data[0] == '\r' {
+		if len(data) < 2 {
+			return f, 0, io.ErrUnexpectedEOF
+		}
+		if data[1] != '\n' {
+			return f, 0, ErrMalformedFrame
+		}
+		return f, 2, nil
+	}
+
+	line, rest, ok := cutLine(data)
+	if !ok {
+		return f, 0, io.ErrUnexpectedEOF
+	}
+	if len(line) == 0 {
+		return f, 0, ErrMalformedFrame
+	}
+	f.Command = string(line)
+	escape := f.Command != "CONNECT" && f.Command != "CONNECTED"
+	f.Header = make(Header)
+	for {
+		line, rest, ok = cutLine(rest)
+		if !ok {
+			return f, 0, io.ErrUnexpectedEOF
+		}
+		if len(line) == 0 {
+			break
+		}
+		k, v, ok := bytes.Cut(line, []byte{':'})
+		if !ok {
+			return f, 0, ErrMalformedFrame
+		}
+		key, value := string(k), string(v)
+		if escape {
+			if key, ok = unescape(key); !ok {
+				return f, 0, ErrMalformedFrame
+			}
+			if value, ok = unescape(value); !ok {
+				return f, 0, ErrMalformedFrame
+			}
+		}
+		if _, has := f.Header[key]; !has {
+			f.Header[key] = value
+		}
+	}
+
+	var end int
+	if s, ok := f.Header["content-length"]; ok {
+		length, err := strconv.Atoi(s)
+		if err != nil || length < 0 {
+			return f, 0, ErrMalformedFrame
+		}
+		if len(rest) <= length {
+			return f, 0, io.ErrUnexpectedEOF
+		}
+		if rest[length] != 0 {
+			return f, 0, ErrMalformedFrame
+		}
+		end = length
+	} else if end = bytes.IndexByte(rest, 0); end == -1 {
+		return f, 0, io.ErrUnexpectedEOF
+	}
+	f.Body = append([]byte(nil), rest[:end]...)
+	n = len(data) - len(rest) + end + 1
+	return f, n, nil
+}
+
+// cutLine cuts the line ended with LF or CRLF from data.
+func cutLine(data []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i == -1 {
+		return nil, data, false
+	}
+	line, rest = data[:i], data[i+1:]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, rest, true
+}
+
+// unescape decodes escaped header key or value. It reports false for
+// undefined escape sequences.
+func unescape(s string) (string, bool) {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s, true
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i++; i == len(s) {
+			return "", false
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
@@ -0,0 +1,247 @@
+package stomp
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+func TestFrame(t *testing.T) {
+	f := Frame{
+		Command: "SEND",
+		Header:  Header{"destination": "/topic/a:b", "note": "line\nbreak\\"},
+		Body:    []byte("with\x00nul"),
+	}
+	data := AppendFrame(nil, f)
+	exp := "SEND\ndestination:/topic/a\\cb\nnote:line\\nbreak\\\\\ncontent-length:8\n\nwith\x00nul\x00"
+	if string(data) != exp {
+		t.Fatalf("AppendFrame() = %q; want %q", data, exp)
+	}
+	for i := 0; i < len(data); i++ {
+		if _, _, err := ParseFrame(data[:i]); err != io.ErrUnexpectedEOF {
+			t.Fatalf("ParseFrame(%d bytes) error = %v", i, err)
+		}
+	}
+	act, n, err := ParseFrame(append(data, '\n'))
+	f.Header["content-length"] = "8"
+	if err != nil || n != len(data) || !reflect.DeepEqual(act, f) {
+		t.Fatalf("ParseFrame() = %+v, %d, %v", act, n, err)
+	}
+
+	for _, test := range []struct {
+		data  string
+		frame Frame
+		n     int
+		err   error
+	}{
+		{data: "\n", n: 1},
+		{data: "\r\nSEND", n: 2},
+		{
+			data:  "CONNECT\r\nhost:a\\c\r\nhost:b\r\n\r\n\x00",
+			frame: Frame{Command: "CONNECT", Header: Header{"host": "a\\c"}},
+			n:     30,
+		},
+		{data: "SEND\nbad\\t:x\n\n\x00", err: ErrMalformedFrame},
+		{data: "SEND\nno colon\n\n\x00", err: ErrMalformedFrame},
+		{data: "SEND\ncontent-length:1\n\nab\x00", err: ErrMalformedFrame},
+	} {
+		f, n, err := ParseFrame([]byte(test.data))
+		if err != test.err || n != test.n || err == nil && !reflect.DeepEqual(f, test.frame) {
+			t.Errorf("ParseFrame(%q) = %+v, %d, %v", test.data, f, n, err)
+		}
+	}
+}
+
+// stomp is a client connection to the broker.
+type stomp struct {
+	*wstest.Harness
+}
+
+var request = wstest.Request{Protocols: []string{Subprotocol}}
+
+func dial(t *testing.T, b *Broker, header Header) stomp {
+	s := stomp{wstest.Dial(t, b, request)}
+	if header == nil {
+		header = Header{"accept-version": "1.1,1.2", "host": "test"}
+	}
+	s.send("CONNECT", header, "")
+	return s
+}
+
+func (s stomp) send(cmd string, h Header, body string) {
+	s.Write(wstest.ClientFrame(easyws.OpText, true, AppendFrame(nil, Frame{Command: cmd, Header: h, Body: []byte(body)})))
+}
+
+// expect checks that the next frame sent by the broker has given command
+// and headers, and returns it.
+func (s stomp) expect(cmd string, h Header) Frame {
+	s.T().Helper()
+	wf, ok := s.NextFrame()
+	if !ok {
+		s.T().Fatalf("no %s frame sent", cmd)
+	}
+	f, _, err := ParseFrame(wf.Payload)
+	if err != nil {
+		s.T().Fatalf("can not parse frame %q: %v", wf.Payload, err)
+	}
+	if f.Command != cmd {
+		s.T().Fatalf("unexpected frame: %+v; want %s", f, cmd)
+	}
+	for k, v := range h {
+		if f.Header[k] != v {
+			s.T().Fatalf("unexpected %s header %s: %q; want %q", cmd, k, f.Header[k], v)
+		}
+	}
+	return f
+}
+
+func (s stomp) expectMessage(sub, body string) Frame {
+	s.T().Helper()
+	f := s.expect("MESSAGE", Header{"subscription": sub})
+	if string(f.Body) != body {
+		s.T().Fatalf("unexpected message body: %q; want %q", f.Body, body)
+	}
+	return f
+}
+
+func TestBroker(t *testing.T) {
+	b := &Broker{
+		Authenticate: func(_ *easyws.Conn, login, passcode string) error {
+			if login == "bad" {
+				return errors.New("access denied")
+			}
+			return nil
+		},
+	}
+
+	t.Run("connect", func(t *testing.T) {
+		s := dial(t, b, Header{"accept-version": "1.0,1.1"})
+		s.expect("ERROR", Header{"message": "supported protocol version is 1.2"})
+		s.ExpectClose(easyws.StatusProtocolError)
+
+		s = dial(t, b, Header{"accept-version": "1.2", "login": "bad"})
+		s.expect("ERROR", Header{"message": "access denied"})
+		s.ExpectClose(easyws.StatusProtocolError)
+
+		s = stomp{wstest.Dial(t, b, request)}
+		s.send("SEND", Header{"destination": "/topic/a", "receipt": "r"}, "")
+		s.expect("ERROR", Header{"receipt-id": "r"})
+		s.ExpectClose(easyws.StatusProtocolError)
+
+		s = dial(t, b, nil)
+		s.expect("CONNECTED", Header{"version": "1.2", "heart-beat": "0,0"})
+		s.send("DISCONNECT", Header{"receipt": "bye"}, "")
+		s.expect("RECEIPT", Header{"receipt-id": "bye"})
+		s.ExpectClose(easyws.StatusNormalClosure)
+	})
+
+	t.Run("topic", func(t *testing.T) {
+		a, c := dial(t, b, nil), dial(t, b, nil)
+		a.expect("CONNECTED", nil)
+		c.expect("CONNECTED", nil)
+		a.send("SUBSCRIBE", Header{"id": "0", "destination": "/topic/news", "receipt": "1"}, "")
+		a.expect("RECEIPT", Header{"receipt-id": "1"})
+		c.send("SUBSCRIBE", Header{"id": "sub", "destination": "/topic/news"}, "")
+		c.send("SUBSCRIBE", Header{"id": "sub", "destination": "/topic/other"}, "")
+		c.expect("ERROR", Header{"message": `duplicate subscription "sub"`})
+		c.Close()
+
+		c = dial(t, b, nil)
+		c.expect("CONNECTED", nil)
+		c.send("SUBSCRIBE", Header{"id": "sub", "destination": "/topic/news"}, "")
+		c.send("SEND", Header{"destination": "/topic/news", "content-type": "text/plain", "receipt": "2"}, "hello")
+		f := a.expectMessage("0", "hello")
+		if f.Header["destination"] != "/topic/news" || f.Header["content-type"] != "text/plain" || f.Header["message-id"] == "" {
+			t.Fatalf("unexpected message headers: %v", f.Header)
+		}
+		if _, ok := f.Header["receipt"]; ok {
+			t.Fatalf("receipt header is forwarded")
+		}
+		c.expectMessage("sub", "hello")
+		c.expect("RECEIPT", Header{"receipt-id": "2"})
+
+		a.send("UNSUBSCRIBE", Header{"id": "0"}, "")
+		b.Send("/topic/news", nil, []byte{0xff})
+		a.ExpectNothing()
+		wf, _ := c.NextFrame()
+		if wf.Header.OpCode != easyws.OpBinary {
+			t.Fatalf("message with binary body is sent as %v", wf.Header.OpCode)
+		}
+		a.Close()
+		c.Close()
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		b.Send("/queue/jobs", nil, []byte("1"))
+		b.Send("/queue/jobs", nil, []byte("2"))
+
+		a := dial(t, b, nil)
+		a.expect("CONNECTED", nil)
+		a.send("SUBSCRIBE", Header{"id": "a", "destination": "/queue/jobs", "ack": "client", "receipt": "r"}, "")
+		a.expect("RECEIPT", nil)
+		a.expectMessage("a", "1")
+		m2 := a.expectMessage("a", "2")
+
+		c := dial(t, b, nil)
+		c.expect("CONNECTED", nil)
+		c.send("SUBSCRIBE", Header{"id": "c", "destination": "/queue/jobs", "ack": "client-individual"}, "")
+		b.Send("/queue/jobs", nil, []byte("3"))
+		b.Send("/queue/jobs", nil, []byte("4"))
+		m3 := c.expectMessage("c", "3")
+		a.expectMessage("a", "4")
+
+		// Cumulative acknowledgement of client mode.
+		a.send("ACK", Header{"id": m2.Header["ack"], "receipt": "ack"}, "")
+		a.expect("RECEIPT", nil)
+		a.send("NACK", Header{"id": m2.Header["ack"]}, "")
+		a.expect("ERROR", nil)
+		a.Close()
+		// Unacknowledged message of the closed connection is delivered
+		// again.
+		c.expectMessage("c", "4")
+
+		c.send("NACK", Header{"id": m3.Header["ack"]}, "")
+		c.expectMessage("c", "3")
+		c.ExpectNothing()
+		c.Close()
+	})
+}
+
+func TestBrokerHeartBeat(t *testing.T) {
+	b := &Broker{HeartBeat: 20 * time.Millisecond}
+	s := dial(t, b, Header{"accept-version": "1.2", "heart-beat": "10,30"})
+	s.expect("CONNECTED", Header{"heart-beat": "20,20"})
+
+	s.WaitClosed()
+	var beats, pings int
+	for {
+		f, ok := s.NextFrame()
+		if !ok {
+			t.Fatalf("no close frame sent")
+		}
+		switch {
+		case f.Header.OpCode == easyws.OpText && string(f.Payload) == "\n":
+			beats++
+			continue
+		case f.Header.OpCode == easyws.OpPing:
+			pings++
+			continue
+		case f.Header.OpCode == easyws.OpClose:
+		default:
+			t.Fatalf("unexpected frame: %+v", f.Header)
+		}
+		if code, _ := easyws.ParseCloseFrameData(f.Payload); code != easyws.StatusPolicyViolation {
+			t.Fatalf("unexpected close code: %d", code)
+		}
+		break
+	}
+	// Heart-beats are sent every 30ms, the client is checked every 20ms.
+	if beats == 0 || pings == 0 {
+		t.Fatalf("unexpected heart-beats: %d, pings: %d", beats, pings)
+	}
+}
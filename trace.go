@@ -0,0 +1,171 @@
+package easyws
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/EternalVow/easyws/httphead"
+)
+
+// Names of the spans started by NetHandler.
+const (
+	SpanHandshake = "websocket.handshake"
+	SpanMessage   = "websocket.message"
+	SpanWrite     = "websocket.write"
+)
+
+// Tracer is the interface used by NetHandler to trace handshakes, received
+// messages and outbound writes.
+//
+// Start starts a span with given name as a child of the span or the remote
+// trace context held by ctx (see TraceContextFromContext). It returns the
+// context holding started span and the span itself. Fields passed to Start
+// are valid only until Start returns.
+type Tracer interface {
+	Start(ctx context.Context, name string, fields ...Field) (context.Context, Span)
+}
+
+// Span represents a traced operation started by Tracer.
+type Span interface {
+	// End finishes the span. Err is the result of the traced operation.
+	End(err error)
+}
+
+// WithTracer returns an option that makes NetHandler to trace connections
+// with t.
+func WithTracer(t Tracer) ServerOption {
+	return func(h *NetHandler) {
+		h.Tracer = t
+	}
+}
+
+// startSpan starts a span if tracer is configured. Returned span is nil
+// otherwise.
+func (h *NetHandler) startSpan(ctx context.Context, name string, fields ...Field) (context.Context, Span) {
+	if h.Tracer == nil {
+		return ctx, nil
+	}
+	return h.Tracer.Start(ctx, name, fields...)
+}
+
+func endSpan(s Span, err error) {
+	if s != nil {
+		s.End(err)
+	}
+}
+
+// Headers holding the trace context as defined by W3C Trace Context.
+const (
+	headerTraceParentCanonical = "Traceparent"
+	headerTraceStateCanonical  = "Tracestate"
+)
+
+// TraceContext represents W3C Trace Context propagated by the client within
+// the handshake request.
+//
+// See https://www.w3.org/TR/trace-context/
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+
+	// State is the value of the "tracestate" header, if any.
+	State string
+}
+
+// IsValid reports whether tc has non-zero trace and span identifiers.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// Sampled reports whether the caller may have recorded trace data.
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&1 != 0
+}
+
+// String returns tc formatted as the "traceparent" header value.
+func (tc TraceContext) String() string {
+	var b [55]byte
+	copy(b[:], "00-")
+	hex.Encode(b[3:35], tc.TraceID[:])
+	b[35] = '-'
+	hex.Encode(b[36:52], tc.SpanID[:])
+	b[52] = '-'
+	hex.Encode(b[53:], []byte{tc.Flags})
+	return string(b[:])
+}
+
+// ParseTraceParent parses the value of "traceparent" header. It reports
+// whether the value is well-formed.
+func ParseTraceParent(s string) (tc TraceContext, ok bool) {
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, false
+	}
+	var version [1]byte
+	if !hexDecodeLower(version[:], s[:2]) || version[0] == 0xff {
+		return tc, false
+	}
+	// Future versions may append fields, which we are not aware of.
+	if version[0] == 0 && len(s) != 55 || version[0] != 0 && len(s) > 55 && s[55] != '-' {
+		return tc, false
+	}
+	var flags [1]byte
+	if !hexDecodeLower(tc.TraceID[:], s[3:35]) ||
+		!hexDecodeLower(tc.SpanID[:], s[36:52]) ||
+		!hexDecodeLower(flags[:], s[53:55]) {
+		return tc, false
+	}
+	tc.Flags = flags[0]
+	return tc, tc.IsValid()
+}
+
+// hexDecodeLower decodes lowercase hex string s into dst of exactly
+// len(s)/2 bytes.
+func hexDecodeLower(dst []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; 'A' <= c && c <= 'F' {
+			return false
+		}
+	}
+	n, err := hex.Decode(dst, []byte(s))
+	return err == nil && n == len(dst)
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns a copy of ctx holding tc.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns TraceContext held by ctx. NetHandler puts
+// into the connection context the trace context received within the
+// handshake request.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// requestTraceContext returns context with the trace context sent within
+// the complete HTTP request in data.
+func requestTraceContext(data []byte) context.Context {
+	var (
+		parent TraceContext
+		state  string
+		ok     bool
+	)
+	httpScanHeaders(data, func(k, v []byte) {
+		switch httphead.BtsToString(k) {
+		case headerTraceParentCanonical:
+			parent, ok = ParseTraceParent(string(v))
+		case headerTraceStateCanonical:
+			state = string(v)
+		}
+	})
+	if !ok {
+		return context.Background()
+	}
+	parent.State = state
+	return ContextWithTraceContext(context.Background(), parent)
+}
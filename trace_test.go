@@ -0,0 +1,30 @@
+package easyws
+
+import "testing"
+
+func TestParseTraceParent(t *testing.T) {
+	for _, test := range []struct {
+		in string
+		ok bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", true},
+		{"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-what-the-future", true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false},
+		{"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01", false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331", false},
+		{"00_0af7651916cd43dd8448eb211c80319c_b7ad6b7169203331_01", false},
+	} {
+		tc, ok := ParseTraceParent(test.in)
+		if ok != test.ok {
+			t.Errorf("ParseTraceParent(%q) = %v; want %v", test.in, ok, test.ok)
+			continue
+		}
+		if ok && len(test.in) == 55 && tc.String() != test.in {
+			t.Errorf("String() = %q; want %q", tc.String(), test.in)
+		}
+	}
+}
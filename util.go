@@ -1,10 +1,10 @@
 package easyws
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"github.com/gobwas/httphead"
-	_interface "github.com/EternalVow/easynet/interface"
 	httphead2 "github.com/EternalVow/easyws/httphead"
 	//"github.com/gobwas/httphead"
 )
@@ -42,34 +42,23 @@ func SelectEqual(v string) func(string) bool {
 
 // asciiToInt converts bytes to int.
 func asciiToInt(bts []byte) (ret int, err error) {
-	// ASCII numbers all start with the high-order bits 0011.
-	// If you see that, and the next bits are 0-9 (0000 - 1001) you can grab those
-	// bits and interpret them directly as an integer.
-	var n int
-	if n = len(bts); n < 1 {
+	if len(bts) < 1 {
 		return 0, fmt.Errorf("converting empty bytes to int")
 	}
-	for i := 0; i < n; i++ {
-		if bts[i]&0xf0 != 0x30 {
-			return 0, fmt.Errorf("%s is not a numeric character", string(bts[i]))
+	const cutoff = int(^uint(0)>>1) / 10
+	for _, c := range bts {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("%s is not a numeric character", string(c))
 		}
-		ret += int(bts[i]&0xf) * pow(10, n-i-1)
-	}
-	return ret, nil
-}
-
-// pow for integers implementation.
-// See Donald Knuth, The Art of Computer Programming, Volume 2, Section 4.6.3.
-func pow(a, b int) int {
-	p := 1
-	for b > 0 {
-		if b&1 != 0 {
-			p *= a
+		if ret > cutoff {
+			return 0, fmt.Errorf("%s overflows int", bts)
+		}
+		ret = ret*10 + int(c-'0')
+		if ret < 0 {
+			return 0, fmt.Errorf("%s overflows int", bts)
 		}
-		b >>= 1
-		a *= a
 	}
-	return p
+	return ret, nil
 }
 
 func bsplit3(bts []byte, sep byte) (b1, b2, b3 []byte) {
@@ -132,38 +121,51 @@ func canonicalizeHeaderKey(k []byte) {
 	}
 }
 
-// readLine reads line from br. It reads until '\n' and returns bytes without
-// '\n' or '\r\n' at the end.
-// It returns err if and only if line does not end in '\n'. Note that read
-// bytes returned in any case of error.
+// nextLine returns the first line of bts without '\n' or '\r\n' at the end
+// and the bytes following it. If bts has no '\n', the whole bts is returned
+// as a line.
 //
 // It is much like the textproto/Reader.ReadLine() except the thing that it
-// returns raw bytes, instead of string. That is, it avoids copying bytes read
-// from br.
-//
-// textproto/Reader.ReadLineBytes() is also makes copy of resulting bytes to be
-// safe with future I/O operations on br.
-//
-// We could control I/O operations on br and do not need to make additional
-// copy for safety.
+// returns raw bytes, instead of string. That is, it avoids copying bytes.
+func nextLine(bts []byte) (line, rest []byte) {
+	i := bytes.IndexByte(bts, '\n')
+	if i == -1 {
+		return bts, nil
+	}
+	line, rest = bts[:i], bts[i+1:]
+	// Cut '\r' for '\r\n'.
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, rest
+}
+
+// readLineBuffered is like nextLine but reads line from br. Unlike nextLine
+// it returns an error if br has no complete line.
 //
-// NOTE: it may return copied flag to notify that returned buffer is safe to
-// use.
-func readLine(stream _interface.IInputStream) ([]byte, error) {
-	dataBytes := stream.Begin(nil)
-	index := bytes.IndexByte(dataBytes, '\n')
-	var line = make([]byte, index)
-	if index >= 0 {
-		// "\n" is present
-		copy(line, dataBytes[:index])
-		stream.End(dataBytes[index+1:])
-		n := len(line)
-		// Cut '\r' for '\r\n'.
-		if n > 1 && line[n-1] == '\r' {
-			line = line[:n-1]
+// Returned bytes are copied and are safe to use after next reads from br.
+func readLineBuffered(br *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		bts, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Copy bytes because next read will discard them.
+			line = append(line, bts...)
+			continue
 		}
+		line = append(line, bts...)
+		if err != nil {
+			return line, err
+		}
+		break
+	}
+
+	// Cut '\n' and '\r' for '\r\n'.
+	n := len(line) - 1
+	if n > 0 && line[n-1] == '\r' {
+		n--
 	}
-	return line, nil
+	return line[:n], nil
 }
 
 func min(a, b int) int {
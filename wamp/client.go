@@ -0,0 +1,512 @@
+package wamp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wscorr"
+	"github.com/EternalVow/easyws/wsutil"
+)
+
+// Dialer contains options for joining a realm of WAMP router.
+type Dialer struct {
+	// Dialer is used to establish WebSocket connection. The "wamp.2.json"
+	// subprotocol is appended to its Protocols.
+	Dialer easyws.Dialer
+
+	// Realm is the realm to join.
+	Realm string
+
+	// Details are sent with HELLO message in addition to the roles of the
+	// client, for example to authenticate it.
+	Details Dict
+
+	// Timeout limits the time Client methods wait for the router replies
+	// if the context passed to them has no deadline. Zero means no limit.
+	Timeout time.Duration
+}
+
+// HandlerFunc handles a call of the procedure registered by the client. It
+// returns the result of the call or an error, which is sent to the caller as
+// is if it is *Error, or with ErrRuntimeError URI and the error message.
+type HandlerFunc func(ctx context.Context, inv *Invocation) (*Result, error)
+
+// Dial connects to WAMP router at given WebSocket url and joins the realm.
+// If the router aborts the session, *AbortError is returned.
+func (d Dialer) Dial(ctx context.Context, urlstr string) (*Client, error) {
+	wd := d.Dialer
+	wd.Protocols = append(append([]string(nil), wd.Protocols...), Subprotocol)
+	conn, br, _, err := wd.Dial(ctx, urlstr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		subs:    make(map[ID]func(*Event)),
+		regs:    make(map[ID]HandlerFunc),
+		pending: make(map[uint64]interface{}),
+		goodbye: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	c.corr.Timeout = d.Timeout
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.rd = wsutil.Reader{
+		Source:         conn,
+		State:          easyws.StateClientSide,
+		CheckUTF8:      true,
+		OnIntermediate: c.control,
+	}
+	if br != nil {
+		c.rd.Source = br
+	}
+
+	details := Dict{}
+	for k, v := range d.Details {
+		details[k] = v
+	}
+	details["roles"] = Dict{
+		"publisher":  Dict{},
+		"subscriber": Dict{},
+		"caller":     Dict{},
+		"callee":     Dict{},
+	}
+	if err := c.write(encode(nil, nil, typeHello, d.Realm, details)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	m, err := c.next()
+	conn.SetReadDeadline(time.Time{})
+	if err == nil {
+		switch m.typ() {
+		case typeWelcome:
+			var ok bool
+			if c.id, ok = m.id(1); !ok {
+				err = ErrMalformedMessage
+			}
+		case typeAbort:
+			details, _ := m.dict(1)
+			reason, _ := m.str(2)
+			err = &AbortError{Reason: reason, Details: details}
+		default:
+			err = ErrMalformedMessage
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.read()
+	return c, nil
+}
+
+// Client is a WAMP session joined to a realm of the router. It has all
+// client roles: publisher, subscriber, caller and callee.
+//
+// Client methods are safe for concurrent use.
+type Client struct {
+	id     ID
+	conn   net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	corr   wscorr.Correlator[message]
+
+	// rd is used by the reading goroutine only.
+	rd wsutil.Reader
+
+	wmu sync.Mutex
+
+	// pending holds handlers of SUBSCRIBE and REGISTER requests, which are
+	// moved to subs and regs when the requests are acknowledged.
+	mu       sync.Mutex
+	subs     map[ID]func(*Event)
+	regs     map[ID]HandlerFunc
+	pending  map[uint64]interface{}
+	leaving  bool
+	goodbye  chan struct{}
+	done     chan struct{}
+	err      error
+	once     sync.Once
+	closeErr error
+}
+
+// ID returns the session ID assigned by the router.
+func (c *Client) ID() ID {
+	return c.id
+}
+
+// Subscribe subscribes the client to the topic. Handler is called for every
+// event published to the topic by other sessions and returns the
+// subscription ID. Subscribing to the same topic again replaces its
+// handler.
+//
+// Handlers are called sequentially by the goroutine reading the connection,
+// so they must not make requests to the router synchronously.
+func (c *Client) Subscribe(ctx context.Context, topic string, handler func(*Event)) (ID, error) {
+	if !validURI(topic) {
+		return 0, &Error{URI: ErrInvalidURI}
+	}
+	m, err := c.request(ctx, handler, func(req uint64) []byte {
+		return encode(nil, nil, typeSubscribe, req, Dict{}, topic)
+	})
+	if err != nil {
+		return 0, err
+	}
+	id, _ := m.id(2)
+	return id, nil
+}
+
+// Unsubscribe cancels the subscription with given ID.
+func (c *Client) Unsubscribe(ctx context.Context, id ID) error {
+	_, err := c.request(ctx, nil, func(req uint64) []byte {
+		return encode(nil, nil, typeUnsubscribe, req, id)
+	})
+	if err == nil {
+		c.mu.Lock()
+		delete(c.subs, id)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Publish publishes an event to the topic and waits for the acknowledgement
+// of the router. The event is not delivered to the client itself.
+func (c *Client) Publish(ctx context.Context, topic string, args List, kwargs Dict) error {
+	if !validURI(topic) {
+		return &Error{URI: ErrInvalidURI}
+	}
+	a, kw, err := encodePayload(args, kwargs)
+	if err != nil {
+		return err
+	}
+	_, err = c.request(ctx, nil, func(req uint64) []byte {
+		return encode(a, kw, typePublish, req, Dict{"acknowledge": true}, topic)
+	})
+	return err
+}
+
+// Register registers the procedure served by handler and returns the
+// registration ID. Handler is called in a separate goroutine for every call,
+// so it could make requests to the router; its context is cancelled when
+// the session is closed.
+func (c *Client) Register(ctx context.Context, procedure string, handler HandlerFunc) (ID, error) {
+	if !validURI(procedure) {
+		return 0, &Error{URI: ErrInvalidURI}
+	}
+	m, err := c.request(ctx, handler, func(req uint64) []byte {
+		return encode(nil, nil, typeRegister, req, Dict{}, procedure)
+	})
+	if err != nil {
+		return 0, err
+	}
+	id, _ := m.id(2)
+	return id, nil
+}
+
+// Unregister cancels the registration with given ID.
+func (c *Client) Unregister(ctx context.Context, id ID) error {
+	_, err := c.request(ctx, nil, func(req uint64) []byte {
+		return encode(nil, nil, typeUnregister, req, id)
+	})
+	if err == nil {
+		c.mu.Lock()
+		delete(c.regs, id)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Call calls the procedure with given arguments and waits for the result.
+// Errors returned by the callee or the router are returned as *Error.
+func (c *Client) Call(ctx context.Context, procedure string, args List, kwargs Dict) (*Result, error) {
+	if !validURI(procedure) {
+		return nil, &Error{URI: ErrInvalidURI}
+	}
+	a, kw, err := encodePayload(args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	m, err := c.request(ctx, nil, func(req uint64) []byte {
+		return encode(a, kw, typeCall, req, Dict{}, procedure)
+	})
+	if err != nil {
+		return nil, err
+	}
+	details, _ := m.dict(2)
+	args, kwargs, err = decodePayload(m, 3)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Details: details, Args: args, Kwargs: kwargs}, nil
+}
+
+// request sends the message made by encode with a new request ID and waits
+// for the reply. Handler of SUBSCRIBE or REGISTER request is installed
+// before the reply is returned.
+func (c *Client) request(ctx context.Context, handler interface{}, encode func(req uint64) []byte) (message, error) {
+	var id uint64
+	m, err := c.corr.Do(ctx, func(req uint64) error {
+		id = req
+		if handler != nil {
+			c.mu.Lock()
+			c.pending[req] = handler
+			c.mu.Unlock()
+		}
+		return c.write(encode(req))
+	})
+	if handler != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+	if err == wscorr.ErrClosed {
+		return nil, ErrClosed
+	}
+	return m, err
+}
+
+// Leave leaves the realm with GOODBYE message, waits for the reply of the
+// router and closes the connection.
+func (c *Client) Leave() error {
+	c.mu.Lock()
+	c.leaving = true
+	c.mu.Unlock()
+	err := c.write(encode(nil, nil, typeGoodbye, Dict{}, ErrCloseRealm))
+	if err == nil {
+		t := time.NewTimer(leaveTimeout)
+		select {
+		case <-c.goodbye:
+		case <-c.done:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// leaveTimeout limits the time Leave waits for the router.
+const leaveTimeout = 5 * time.Second
+
+// Close closes the connection without leaving the realm. Pending requests
+// fail with ErrClosed.
+func (c *Client) Close() error {
+	err := c.shutdown()
+	<-c.done
+	return err
+}
+
+func (c *Client) shutdown() error {
+	c.once.Do(func() {
+		c.wmu.Lock()
+		wsutil.WriteClientMessage(c.conn, easyws.OpClose, easyws.NewCloseFrameBody(easyws.StatusNormalClosure, ""))
+		c.wmu.Unlock()
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
+
+// Done returns a channel which is closed when the connection is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error the connection was closed with. It must be called
+// after Done is closed.
+func (c *Client) Err() error {
+	return c.err
+}
+
+func (c *Client) write(p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return wsutil.WriteClientText(c.conn, p)
+}
+
+func (c *Client) read() {
+	defer close(c.done)
+	for {
+		m, err := c.next()
+		if err == nil {
+			err = c.handle(m)
+		}
+		if err != nil {
+			if err != errGoodbye {
+				c.err = err
+			}
+			c.corr.Close()
+			c.cancel()
+			c.shutdown()
+			return
+		}
+	}
+}
+
+// errGoodbye is returned by handle when the session is closed with GOODBYE,
+// so the connection is closed without an error.
+var errGoodbye = errors.New("wamp: goodbye")
+
+// handle handles a message received from the router.
+func (c *Client) handle(m message) error {
+	switch m.typ() {
+	case typeEvent:
+		sub, ok := m.id(1)
+		pub, pok := m.id(2)
+		details, dok := m.dict(3)
+		args, kwargs, err := decodePayload(m, 4)
+		if !ok || !pok || !dok || err != nil {
+			return ErrMalformedMessage
+		}
+		c.mu.Lock()
+		h := c.subs[sub]
+		c.mu.Unlock()
+		if h != nil {
+			h(&Event{Subscription: sub, Publication: pub, Details: details, Args: args, Kwargs: kwargs})
+		}
+
+	case typeInvocation:
+		req, ok := m.id(1)
+		reg, rok := m.id(2)
+		details, dok := m.dict(3)
+		args, kwargs, err := decodePayload(m, 4)
+		if !ok || !rok || !dok || err != nil {
+			return ErrMalformedMessage
+		}
+		c.mu.Lock()
+		h := c.regs[reg]
+		c.mu.Unlock()
+		go c.serve(req, h, &Invocation{Registration: reg, Details: details, Args: args, Kwargs: kwargs})
+
+	case typeSubscribed, typeRegistered:
+		req, ok := m.id(1)
+		id, iok := m.id(2)
+		if !ok || !iok {
+			return ErrMalformedMessage
+		}
+		c.mu.Lock()
+		switch h := c.pending[uint64(req)].(type) {
+		case func(*Event):
+			c.subs[id] = h
+		case HandlerFunc:
+			c.regs[id] = h
+		}
+		delete(c.pending, uint64(req))
+		c.mu.Unlock()
+		c.corr.Resolve(uint64(req), m)
+
+	case typeUnsubscribed, typePublished, typeUnregistered, typeResult:
+		req, ok := m.id(1)
+		if !ok {
+			return ErrMalformedMessage
+		}
+		c.corr.Resolve(uint64(req), m)
+
+	case typeError:
+		req, ok := m.id(2)
+		_, dok := m.dict(3)
+		uri, uok := m.str(4)
+		args, kwargs, err := decodePayload(m, 5)
+		if !ok || !dok || !uok || err != nil {
+			return ErrMalformedMessage
+		}
+		c.corr.Reject(uint64(req), &Error{URI: uri, Args: args, Kwargs: kwargs})
+
+	case typeGoodbye:
+		c.mu.Lock()
+		leaving := c.leaving
+		c.mu.Unlock()
+		if leaving {
+			close(c.goodbye)
+		} else {
+			c.write(encode(nil, nil, typeGoodbye, Dict{}, ErrGoodbyeAndOut))
+		}
+		return errGoodbye
+
+	case typeAbort:
+		details, _ := m.dict(1)
+		reason, _ := m.str(2)
+		return &AbortError{Reason: reason, Details: details}
+
+	default:
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+// serve calls the handler of the invocation and sends its result.
+func (c *Client) serve(req ID, h HandlerFunc, inv *Invocation) {
+	var (
+		res *Result
+		err error
+	)
+	if h != nil {
+		res, err = h(c.ctx, inv)
+	} else {
+		err = &Error{URI: ErrNoSuchProcedure}
+	}
+	if res == nil {
+		res = &Result{}
+	}
+	var a, kw json.RawMessage
+	if err == nil {
+		if a, kw, err = encodePayload(res.Args, res.Kwargs); err == nil {
+			c.write(encode(a, kw, typeYield, req, Dict{}))
+			return
+		}
+	}
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{URI: ErrRuntimeError, Args: List{err.Error()}}
+	}
+	// Error details which could not be encoded are omitted.
+	a, kw, _ = encodePayload(e.Args, e.Kwargs)
+	c.write(encode(a, kw, typeError, typeInvocation, req, Dict{}, e.URI))
+}
+
+// next returns the next message received from the router.
+func (c *Client) next() (message, error) {
+	for {
+		hdr, err := c.rd.NextFrame()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := c.control(hdr, &c.rd); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p, err := io.ReadAll(&c.rd)
+		if err != nil {
+			return nil, err
+		}
+		return parseMessage(p)
+	}
+}
+
+// control handles control frame, sending the reply frame at once.
+func (c *Client) control(hdr easyws.Header, r io.Reader) error {
+	var buf bytes.Buffer
+	err := wsutil.ControlHandler{
+		Src:   r,
+		Dst:   &buf,
+		State: easyws.StateClientSide,
+	}.Handle(hdr)
+	if buf.Len() > 0 {
+		c.wmu.Lock()
+		c.conn.Write(buf.Bytes())
+		c.wmu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,508 @@
+package wamp
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Router is an in-memory WAMP router serving sessions connected over
+// easyws. It implements easyws.IEasyWs and must be served by NetHandler,
+// which passes connections to its callbacks.
+//
+// Sessions joined to a realm could interact only with the sessions of the
+// same realm. Events are not delivered to their publishers, and a procedure
+// could be registered by a single callee at a time.
+//
+// The zero value is ready to use and accepts sessions to any realm. Router
+// is safe for concurrent use.
+type Router struct {
+	// Realms lists the realms the router serves. Sessions joining other
+	// realms are aborted with ErrNoSuchRealm. If empty, realms are created
+	// when the first session joins them.
+	Realms []string
+
+	// Authenticate is called for every HELLO message with the realm and the
+	// details sent by the client. If it returns an error, the session is
+	// aborted with the URI of *Error or with ErrNotAuthorized for other
+	// errors. If nil, all sessions are accepted.
+	Authenticate func(conn *easyws.Conn, realm string, details Dict) error
+
+	mu       sync.Mutex
+	seq      ID
+	sessions map[*easyws.Conn]*session
+	realms   map[string]*realm
+}
+
+// realm is a routing domain.
+type realm struct {
+	name          string
+	sessions      map[ID]*session
+	topics        map[string]*subscription
+	subscriptions map[ID]*subscription
+	procedures    map[string]*registration
+	registrations map[ID]*registration
+}
+
+// subscription is a topic subscribed by one or more sessions.
+type subscription struct {
+	id          ID
+	topic       string
+	subscribers map[*session]struct{}
+}
+
+// registration is a procedure registered by a callee.
+type registration struct {
+	id        ID
+	procedure string
+	callee    *session
+}
+
+// invocation is a call being handled by a callee.
+type invocation struct {
+	caller  *session
+	request ID
+}
+
+// session is the state of a connection. Realm is nil until the session is
+// established and after it is closed.
+type session struct {
+	conn  *easyws.Conn
+	id    ID
+	realm *realm
+
+	subscriptions map[*subscription]struct{}
+	registrations map[*registration]struct{}
+
+	// invocations maps request IDs of INVOCATION messages sent to the
+	// session, which is a callee, to the calls.
+	lastInvocation ID
+	invocations    map[ID]invocation
+}
+
+// Register registers r within m for the "wamp.2.json" subprotocol.
+func (r *Router) Register(m *easyws.ProtocolMux) {
+	m.Handle(Subprotocol, r)
+}
+
+// Publish publishes an event to the topic of the realm as if it was
+// published by a client. Events published to the realms which have no
+// sessions are discarded.
+func (r *Router) Publish(realm, topic string, args List, kwargs Dict) error {
+	if !validURI(topic) {
+		return &Error{URI: ErrInvalidURI}
+	}
+	a, kw, err := encodePayload(args, kwargs)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rm := r.realms[realm]; rm != nil {
+		rm.publish(nil, topic, a, kw)
+	}
+	return nil
+}
+
+// Sessions returns sorted IDs of the sessions joined to the realm.
+func (r *Router) Sessions(realm string) []ID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rm := r.realms[realm]
+	if rm == nil {
+		return nil
+	}
+	ids := make([]ID, 0, len(rm.sessions))
+	for id := range rm.sessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// OnOpen implements easyws.IEasyWsConn.
+func (r *Router) OnOpen(conn *easyws.Conn) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = make(map[*easyws.Conn]*session)
+	}
+	r.sessions[conn] = &session{conn: conn}
+	return nil
+}
+
+// OnDisconnect implements easyws.IEasyWsConn. The session of the connection
+// leaves its realm.
+func (r *Router) OnDisconnect(conn *easyws.Conn, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s := r.sessions[conn]; s != nil {
+		delete(r.sessions, conn)
+		r.leave(s)
+	}
+}
+
+// OnMessage implements easyws.IEasyWsMessage. Sessions violating the
+// protocol are aborted with ErrProtocolViolation.
+func (r *Router) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	r.mu.Lock()
+	s := r.sessions[conn]
+	r.mu.Unlock()
+	if s == nil {
+		return easyws.CloseReply(easyws.StatusInternalServerError, "wamp: connection is not open")
+	}
+	m, err := parseMessage(msg)
+	if err == nil && op != easyws.OpText {
+		err = ErrMalformedMessage
+	}
+	if err == nil && m.typ() == typeHello {
+		err = r.hello(s, m)
+	} else if err == nil {
+		r.mu.Lock()
+		err = r.handle(s, m)
+		r.mu.Unlock()
+	}
+	if err != nil {
+		reason, details, code := ErrProtocolViolation, Dict{"message": err.Error()}, easyws.StatusProtocolError
+		if e, ok := err.(*Error); ok {
+			reason, details = e.URI, Dict{}
+			if reason != ErrProtocolViolation {
+				code = easyws.StatusNormalClosure
+			}
+		}
+		r.mu.Lock()
+		r.leave(s)
+		r.mu.Unlock()
+		s.write(encode(nil, nil, typeAbort, details, reason))
+		return easyws.CloseReply(code, "")
+	}
+	return nil, 0, nil
+}
+
+// OnReceive implements easyws.IEasyWs. Router is served by OnMessage only;
+// see easyws.IEasyWsMessage.
+func (r *Router) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return easyws.CloseReply(easyws.StatusInternalServerError, "wamp: connection is not open")
+}
+
+func (r *Router) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (r *Router) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (r *Router) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (r *Router) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (r *Router) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
+
+// hello handles HELLO message. Authenticate is called without the lock.
+func (r *Router) hello(s *session, m message) error {
+	name, ok := m.str(1)
+	details, dok := m.dict(2)
+	if !ok || !dok || !validURI(name) || len(m) != 3 {
+		return ErrMalformedMessage
+	}
+	r.mu.Lock()
+	joined := s.realm != nil
+	r.mu.Unlock()
+	if joined {
+		return &Error{URI: ErrProtocolViolation}
+	}
+	if roles, _ := details["roles"].(map[string]interface{}); len(roles) == 0 {
+		return &Error{URI: ErrNoSuchRole}
+	}
+	if len(r.Realms) > 0 && !contains(r.Realms, name) {
+		return &Error{URI: ErrNoSuchRealm}
+	}
+	if f := r.Authenticate; f != nil {
+		if err := f(s.conn, name, details); err != nil {
+			if e, ok := err.(*Error); ok {
+				return e
+			}
+			return &Error{URI: ErrNotAuthorized}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions[s.conn] != s {
+		// Disconnected meanwhile.
+		return nil
+	}
+	if r.realms == nil {
+		r.realms = make(map[string]*realm)
+	}
+	rm := r.realms[name]
+	if rm == nil {
+		rm = &realm{
+			name:          name,
+			sessions:      make(map[ID]*session),
+			topics:        make(map[string]*subscription),
+			subscriptions: make(map[ID]*subscription),
+			procedures:    make(map[string]*registration),
+			registrations: make(map[ID]*registration),
+		}
+		r.realms[name] = rm
+	}
+	s.id = globalID()
+	for rm.sessions[s.id] != nil {
+		s.id = globalID()
+	}
+	s.realm = rm
+	s.subscriptions = make(map[*subscription]struct{})
+	s.registrations = make(map[*registration]struct{})
+	s.invocations = make(map[ID]invocation)
+	rm.sessions[s.id] = s
+	s.write(encode(nil, nil, typeWelcome, s.id, Dict{
+		"roles": Dict{
+			"broker": Dict{},
+			"dealer": Dict{},
+		},
+	}))
+	return nil
+}
+
+// handle handles a message of the established session. It must be called
+// with r.mu held.
+func (r *Router) handle(s *session, m message) error {
+	rm := s.realm
+	if rm == nil {
+		// Only HELLO is allowed before the session is established.
+		return &Error{URI: ErrProtocolViolation}
+	}
+	switch m.typ() {
+	case typeGoodbye:
+		if _, ok := m.dict(1); !ok {
+			return ErrMalformedMessage
+		}
+		r.leave(s)
+		s.write(encode(nil, nil, typeGoodbye, Dict{}, ErrGoodbyeAndOut))
+
+	case typeSubscribe:
+		req, ok := m.id(1)
+		topic, tok := m.str(3)
+		if _, dok := m.dict(2); !ok || !tok || !dok || len(m) != 4 {
+			return ErrMalformedMessage
+		}
+		if !validURI(topic) {
+			s.error(typeSubscribe, req, ErrInvalidURI)
+			return nil
+		}
+		sub := rm.topics[topic]
+		if sub == nil {
+			r.seq++
+			sub = &subscription{
+				id:          r.seq,
+				topic:       topic,
+				subscribers: make(map[*session]struct{}),
+			}
+			rm.topics[topic] = sub
+			rm.subscriptions[sub.id] = sub
+		}
+		sub.subscribers[s] = struct{}{}
+		s.subscriptions[sub] = struct{}{}
+		s.write(encode(nil, nil, typeSubscribed, req, sub.id))
+
+	case typeUnsubscribe:
+		req, ok := m.id(1)
+		id, iok := m.id(2)
+		if !ok || !iok || len(m) != 3 {
+			return ErrMalformedMessage
+		}
+		sub := rm.subscriptions[id]
+		if _, subscribed := s.subscriptions[sub]; sub == nil || !subscribed {
+			s.error(typeUnsubscribe, req, ErrNoSuchSubscription)
+			return nil
+		}
+		rm.unsubscribe(s, sub)
+		s.write(encode(nil, nil, typeUnsubscribed, req))
+
+	case typePublish:
+		req, ok := m.id(1)
+		opts, ook := m.dict(2)
+		topic, tok := m.str(3)
+		args, kwargs, pok := m.payload(4)
+		if !ok || !ook || !tok || !pok {
+			return ErrMalformedMessage
+		}
+		ack, _ := opts["acknowledge"].(bool)
+		if !validURI(topic) {
+			if ack {
+				s.error(typePublish, req, ErrInvalidURI)
+			}
+			return nil
+		}
+		pub := rm.publish(s, topic, args, kwargs)
+		if ack {
+			s.write(encode(nil, nil, typePublished, req, pub))
+		}
+
+	case typeRegister:
+		req, ok := m.id(1)
+		proc, pok := m.str(3)
+		if _, ook := m.dict(2); !ok || !ook || !pok || len(m) != 4 {
+			return ErrMalformedMessage
+		}
+		if !validURI(proc) {
+			s.error(typeRegister, req, ErrInvalidURI)
+			return nil
+		}
+		if rm.procedures[proc] != nil {
+			s.error(typeRegister, req, ErrProcedureAlreadyExists)
+			return nil
+		}
+		r.seq++
+		reg := &registration{id: r.seq, procedure: proc, callee: s}
+		rm.procedures[proc] = reg
+		rm.registrations[reg.id] = reg
+		s.registrations[reg] = struct{}{}
+		s.write(encode(nil, nil, typeRegistered, req, reg.id))
+
+	case typeUnregister:
+		req, ok := m.id(1)
+		id, iok := m.id(2)
+		if !ok || !iok || len(m) != 3 {
+			return ErrMalformedMessage
+		}
+		reg := rm.registrations[id]
+		if reg == nil || reg.callee != s {
+			s.error(typeUnregister, req, ErrNoSuchRegistration)
+			return nil
+		}
+		rm.unregister(reg)
+		s.write(encode(nil, nil, typeUnregistered, req))
+
+	case typeCall:
+		req, ok := m.id(1)
+		proc, pok := m.str(3)
+		args, kwargs, aok := m.payload(4)
+		if _, ook := m.dict(2); !ok || !ook || !pok || !aok {
+			return ErrMalformedMessage
+		}
+		if !validURI(proc) {
+			s.error(typeCall, req, ErrInvalidURI)
+			return nil
+		}
+		reg := rm.procedures[proc]
+		if reg == nil {
+			s.error(typeCall, req, ErrNoSuchProcedure)
+			return nil
+		}
+		callee := reg.callee
+		callee.lastInvocation++
+		inv := callee.lastInvocation
+		callee.invocations[inv] = invocation{caller: s, request: req}
+		callee.write(encode(args, kwargs, typeInvocation, inv, reg.id, Dict{}))
+
+	case typeYield:
+		inv, ok := m.id(1)
+		args, kwargs, aok := m.payload(3)
+		if _, ook := m.dict(2); !ok || !ook || !aok {
+			return ErrMalformedMessage
+		}
+		if call, ok := s.takeInvocation(inv); ok {
+			call.caller.write(encode(args, kwargs, typeResult, call.request, Dict{}))
+		}
+
+	case typeError:
+		typ, ok := m.int(1)
+		inv, iok := m.id(2)
+		details, dok := m.dict(3)
+		uri, uok := m.str(4)
+		args, kwargs, aok := m.payload(5)
+		if !ok || typ != typeInvocation || !iok || !dok || !uok || !aok {
+			return ErrMalformedMessage
+		}
+		if call, ok := s.takeInvocation(inv); ok {
+			call.caller.write(encode(args, kwargs, typeError, typeCall, call.request, details, uri))
+		}
+
+	default:
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+// leave removes the session from its realm. Pending calls handled by the
+// session fail with ErrCanceled. It must be called with r.mu held.
+func (r *Router) leave(s *session) {
+	rm := s.realm
+	if rm == nil {
+		return
+	}
+	s.realm = nil
+	delete(rm.sessions, s.id)
+	for sub := range s.subscriptions {
+		rm.unsubscribe(s, sub)
+	}
+	for reg := range s.registrations {
+		rm.unregister(reg)
+	}
+	for id, call := range s.invocations {
+		delete(s.invocations, id)
+		if call.caller.realm == rm {
+			call.caller.error(typeCall, call.request, ErrCanceled)
+		}
+	}
+	if len(rm.sessions) == 0 && len(r.Realms) == 0 {
+		delete(r.realms, rm.name)
+	}
+}
+
+// publish sends the event to the subscribers of the topic except the
+// publisher and returns the publication ID.
+func (rm *realm) publish(publisher *session, topic string, args, kwargs json.RawMessage) ID {
+	pub := globalID()
+	sub := rm.topics[topic]
+	if sub == nil {
+		return pub
+	}
+	event := encode(args, kwargs, typeEvent, sub.id, pub, Dict{})
+	for s := range sub.subscribers {
+		if s != publisher {
+			s.write(event)
+		}
+	}
+	return pub
+}
+
+func (rm *realm) unsubscribe(s *session, sub *subscription) {
+	delete(s.subscriptions, sub)
+	delete(sub.subscribers, s)
+	if len(sub.subscribers) == 0 {
+		delete(rm.topics, sub.topic)
+		delete(rm.subscriptions, sub.id)
+	}
+}
+
+func (rm *realm) unregister(reg *registration) {
+	delete(reg.callee.registrations, reg)
+	delete(rm.procedures, reg.procedure)
+	delete(rm.registrations, reg.id)
+}
+
+// takeInvocation returns and forgets the call of the invocation.
+func (s *session) takeInvocation(id ID) (invocation, bool) {
+	call, ok := s.invocations[id]
+	delete(s.invocations, id)
+	// Caller could leave the realm while the call is handled.
+	return call, ok && call.caller.realm == s.realm
+}
+
+// error sends ERROR message for the request of given type.
+func (s *session) error(typ int, req ID, uri string) {
+	s.write(encode(nil, nil, typeError, typ, req, Dict{}, uri))
+}
+
+// write sends the message to the session. Errors are ignored: the
+// connection is closed and the session will leave its realm.
+func (s *session) write(p []byte) {
+	s.conn.WriteMessage(easyws.OpText, p)
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
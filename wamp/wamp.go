@@ -0,0 +1,300 @@
+// Package wamp implements the Basic Profile of the Web Application Messaging
+// Protocol (WAMP) over easyws.
+//
+// Router is an easyws.IEasyWs which serves WAMP sessions connected with the
+// "wamp.2.json" subprotocol. It acts as the broker and the dealer of its
+// realms: it routes events published to topics to their subscribers and
+// calls of procedures to their callees. Client is a WAMP session made with
+// easyws.Dialer, which could be a publisher, subscriber, caller and callee.
+//
+// Only exact matching of topics and procedures is supported, and the router
+// does not implement any Advanced Profile features.
+//
+// The specification: https://wamp-proto.org/wamp_bp_latest_ietf.html
+package wamp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Subprotocol is the WebSocket subprotocol of WAMP with JSON serialization.
+const Subprotocol = "wamp.2.json"
+
+// Message types of the Basic Profile.
+const (
+	typeHello        = 1
+	typeWelcome      = 2
+	typeAbort        = 3
+	typeGoodbye      = 6
+	typeError        = 8
+	typePublish      = 16
+	typePublished    = 17
+	typeSubscribe    = 32
+	typeSubscribed   = 33
+	typeUnsubscribe  = 34
+	typeUnsubscribed = 35
+	typeEvent        = 36
+	typeCall         = 48
+	typeResult       = 50
+	typeRegister     = 64
+	typeRegistered   = 65
+	typeUnregister   = 66
+	typeUnregistered = 67
+	typeInvocation   = 68
+	typeYield        = 70
+)
+
+// Error and close reason URIs predefined by the specification.
+const (
+	ErrInvalidURI             = "wamp.error.invalid_uri"
+	ErrNoSuchProcedure        = "wamp.error.no_such_procedure"
+	ErrProcedureAlreadyExists = "wamp.error.procedure_already_exists"
+	ErrNoSuchRegistration     = "wamp.error.no_such_registration"
+	ErrNoSuchSubscription     = "wamp.error.no_such_subscription"
+	ErrInvalidArgument        = "wamp.error.invalid_argument"
+	ErrSystemShutdown         = "wamp.close.system_shutdown"
+	ErrCloseRealm             = "wamp.close.close_realm"
+	ErrGoodbyeAndOut          = "wamp.close.goodbye_and_out"
+	ErrProtocolViolation      = "wamp.error.protocol_violation"
+	ErrNotAuthorized          = "wamp.error.not_authorized"
+	ErrNoSuchRealm            = "wamp.error.no_such_realm"
+	ErrNoSuchRole             = "wamp.error.no_such_role"
+	ErrCanceled               = "wamp.error.canceled"
+	ErrRuntimeError           = "wamp.error.runtime_error"
+)
+
+// ErrClosed is returned by Client methods when the session is closed.
+var ErrClosed = errors.New("wamp: session closed")
+
+// ErrMalformedMessage is returned for messages which could not be decoded.
+var ErrMalformedMessage = errors.New("wamp: malformed message")
+
+// ID identifies sessions, publications, subscriptions, registrations and
+// requests. IDs are integers in range [1, 2^53].
+type ID uint64
+
+// maxID is the maximum value of ID, which could be represented by IEEE 754
+// double precision number.
+const maxID = 1 << 53
+
+// globalID returns random ID of global scope, used for sessions and
+// publications.
+func globalID() ID {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return ID(binary.BigEndian.Uint64(b[:])%maxID) + 1
+}
+
+// Dict is a WAMP dictionary.
+type Dict map[string]interface{}
+
+// List is a WAMP list.
+type List []interface{}
+
+// Error is an error returned by a callee or the router for a request. It is
+// sent with ERROR message.
+type Error struct {
+	// URI identifies the error, for example ErrNoSuchProcedure.
+	URI string
+
+	// Args and Kwargs are optional error details.
+	Args   List
+	Kwargs Dict
+}
+
+func (e *Error) Error() string {
+	return "wamp: " + e.URI
+}
+
+// AbortError is returned by Dialer.Dial when the router aborts the session.
+type AbortError struct {
+	Reason  string
+	Details Dict
+}
+
+func (e *AbortError) Error() string {
+	return "wamp: session aborted: " + e.Reason
+}
+
+// Event is an event received by a subscriber.
+type Event struct {
+	Subscription ID
+	Publication  ID
+	Details      Dict
+	Args         List
+	Kwargs       Dict
+}
+
+// Invocation is a call of a procedure received by its callee.
+type Invocation struct {
+	Registration ID
+	Details      Dict
+	Args         List
+	Kwargs       Dict
+}
+
+// Result is the result of a call.
+type Result struct {
+	Details Dict
+	Args    List
+	Kwargs  Dict
+}
+
+// message is a decoded WAMP message. Its elements are kept encoded, so
+// payloads are routed as is.
+type message []json.RawMessage
+
+func parseMessage(p []byte) (message, error) {
+	var m message
+	if err := json.Unmarshal(p, &m); err != nil || len(m) == 0 {
+		return nil, ErrMalformedMessage
+	}
+	if _, ok := m.int(0); !ok {
+		return nil, ErrMalformedMessage
+	}
+	return m, nil
+}
+
+// typ returns the type of the message.
+func (m message) typ() int {
+	n, _ := m.int(0)
+	return int(n)
+}
+
+// int returns i-th element of the message, which must be a non-negative
+// integer.
+func (m message) int(i int) (uint64, bool) {
+	if i >= len(m) {
+		return 0, false
+	}
+	var n uint64
+	if err := json.Unmarshal(m[i], &n); err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// id returns i-th element of the message, which must be a valid ID.
+func (m message) id(i int) (ID, bool) {
+	n, ok := m.int(i)
+	if !ok || n == 0 || n > maxID {
+		return 0, false
+	}
+	return ID(n), true
+}
+
+// str returns i-th element of the message, which must be a string.
+func (m message) str(i int) (string, bool) {
+	if i >= len(m) {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(m[i], &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// dict returns i-th element of the message, which must be a dictionary.
+func (m message) dict(i int) (Dict, bool) {
+	if i >= len(m) {
+		return nil, false
+	}
+	var d Dict
+	if err := json.Unmarshal(m[i], &d); err != nil || d == nil {
+		return nil, false
+	}
+	return d, true
+}
+
+// payload returns encoded Args and Kwargs elements starting at i, checking
+// that they are a list and a dictionary.
+func (m message) payload(i int) (args, kwargs json.RawMessage, ok bool) {
+	if i < len(m) {
+		args = m[i]
+		if !strings.HasPrefix(string(args), "[") {
+			return nil, nil, false
+		}
+	}
+	if i+1 < len(m) {
+		kwargs = m[i+1]
+		if !strings.HasPrefix(string(kwargs), "{") {
+			return nil, nil, false
+		}
+	}
+	return args, kwargs, len(m) <= i+2
+}
+
+// encode encodes a message with given elements followed by optional args
+// and kwargs.
+func encode(args, kwargs json.RawMessage, elems ...interface{}) []byte {
+	if len(kwargs) > 0 {
+		if len(args) == 0 {
+			args = json.RawMessage("[]")
+		}
+		elems = append(elems, args, kwargs)
+	} else if len(args) > 0 {
+		elems = append(elems, args)
+	}
+	p, err := json.Marshal(elems)
+	if err != nil {
+		// Elements are either encoded or made by the package.
+		panic(err)
+	}
+	return p
+}
+
+// encodePayload encodes args and kwargs given by the application.
+func encodePayload(args List, kwargs Dict) (a, kw json.RawMessage, err error) {
+	if len(args) > 0 {
+		if a, err = json.Marshal(args); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(kwargs) > 0 {
+		if kw, err = json.Marshal(kwargs); err != nil {
+			return nil, nil, err
+		}
+	}
+	return a, kw, nil
+}
+
+// decodePayload decodes args and kwargs received from the peer.
+func decodePayload(m message, i int) (args List, kwargs Dict, err error) {
+	a, kw, ok := m.payload(i)
+	if !ok {
+		return nil, nil, ErrMalformedMessage
+	}
+	if a != nil {
+		if err := json.Unmarshal(a, &args); err != nil {
+			return nil, nil, ErrMalformedMessage
+		}
+	}
+	if kw != nil {
+		if err := json.Unmarshal(kw, &kwargs); err != nil {
+			return nil, nil, ErrMalformedMessage
+		}
+	}
+	return args, kwargs, nil
+}
+
+// validURI reports whether s is a valid URI by the loose rules: it consists
+// of non-empty components separated by dots, which contain no whitespace
+// and no '#'.
+func validURI(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range strings.Split(s, ".") {
+		if c == "" || strings.ContainsAny(c, " \t\r\n#") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,376 @@
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+func TestValidURI(t *testing.T) {
+	for _, test := range []struct {
+		uri string
+		exp bool
+	}{
+		{"com.example.topic", true},
+		{"topic", true},
+		{"com.example-1.топик", true},
+		{"", false},
+		{"com..topic", false},
+		{".com.topic", false},
+		{"com.topic.", false},
+		{"com.my topic", false},
+		{"com.#topic", false},
+	} {
+		if act := validURI(test.uri); act != test.exp {
+			t.Errorf("validURI(%q) = %v; want %v", test.uri, act, test.exp)
+		}
+	}
+}
+
+type peer struct {
+	*wstest.Harness
+}
+
+func dial(t *testing.T, r *Router) peer {
+	return peer{wstest.Dial(t, r, wstest.Request{Protocols: []string{Subprotocol}})}
+}
+
+func join(t *testing.T, r *Router, realm string) (peer, ID) {
+	s := dial(t, r)
+	s.SendText(`[1,"` + realm + `",{"roles":{"subscriber":{},"publisher":{},"caller":{},"callee":{}}}]`)
+	m := s.next()
+	id, ok := m.id(1)
+	if m.typ() != typeWelcome || !ok {
+		t.Fatalf("unexpected reply to HELLO: %q", m)
+	}
+	return s, id
+}
+
+func (s peer) next() message {
+	s.T().Helper()
+	p := s.WaitText()
+	m, err := parseMessage([]byte(p))
+	if err != nil {
+		s.T().Fatalf("can not parse message %q: %v", p, err)
+	}
+	return m
+}
+
+// expect checks the next message, which must be equal to msg with "$" in
+// place of random IDs.
+func (s peer) expect(msg string) {
+	s.T().Helper()
+	m := s.next()
+	var exp message
+	if err := json.Unmarshal([]byte(msg), &exp); err != nil {
+		s.T().Fatal(err)
+	}
+	if len(m) != len(exp) {
+		s.T().Fatalf("unexpected message: %q; want %s", m, msg)
+	}
+	for i := range exp {
+		if string(exp[i]) == `"$"` {
+			if _, ok := m.id(i); ok {
+				continue
+			}
+		}
+		if string(m[i]) != string(exp[i]) {
+			s.T().Fatalf("unexpected message: %q; want %s", m, msg)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := &Router{
+		Realms: []string{"realm1", "realm2"},
+		Authenticate: func(_ *easyws.Conn, realm string, details Dict) error {
+			if realm == "realm2" && details["authid"] != "admin" {
+				return errors.New("denied")
+			}
+			return nil
+		},
+	}
+
+	t.Run("hello", func(t *testing.T) {
+		for _, test := range []struct {
+			hello  string
+			reason string
+			code   easyws.StatusCode
+		}{
+			{`[1,"nope",{"roles":{"caller":{}}}]`, ErrNoSuchRealm, easyws.StatusNormalClosure},
+			{`[1,"realm2",{"roles":{"caller":{}}}]`, ErrNotAuthorized, easyws.StatusNormalClosure},
+			{`[1,"realm1",{}]`, ErrNoSuchRole, easyws.StatusNormalClosure},
+			{`[1,"realm1"]`, ErrProtocolViolation, easyws.StatusProtocolError},
+			{`[32,1,{},"com.topic"]`, ErrProtocolViolation, easyws.StatusProtocolError},
+			{`{"hello":1}`, ErrProtocolViolation, easyws.StatusProtocolError},
+		} {
+			s := dial(t, r)
+			s.SendText(test.hello)
+			m := s.next()
+			if reason, _ := m.str(2); m.typ() != typeAbort || reason != test.reason {
+				t.Errorf("unexpected reply to %s: %q; want ABORT %s", test.hello, m, test.reason)
+			}
+			s.ExpectClose(test.code)
+		}
+
+		s, _ := join(t, r, "realm1")
+		s.SendText(`[1,"realm1",{"roles":{"caller":{}}}]`)
+		s.expect(`[3,{},"wamp.error.protocol_violation"]`)
+		s.ExpectClose(easyws.StatusProtocolError)
+
+		s = dial(t, r)
+		s.SendText(`[1,"realm2",{"authid":"admin","roles":{"caller":{}}}]`)
+		m := s.next()
+		if id, _ := m.id(1); m.typ() != typeWelcome || !reflect.DeepEqual(r.Sessions("realm2"), []ID{id}) {
+			t.Fatalf("unexpected reply to HELLO: %q; sessions: %v", m, r.Sessions("realm2"))
+		}
+	})
+
+	t.Run("pubsub", func(t *testing.T) {
+		a, aid := join(t, r, "realm1")
+		b, bid := join(t, r, "realm1")
+		if ids := r.Sessions("realm1"); len(ids) != 2 || !(ids[0] == aid && ids[1] == bid || ids[0] == bid && ids[1] == aid) {
+			t.Fatalf("unexpected realm1 sessions: %v", ids)
+		}
+
+		a.SendText(`[32,1,{},"com.topic"]`)
+		m := a.next()
+		sub, _ := m.id(2)
+		if m.typ() != typeSubscribed || sub == 0 {
+			t.Fatalf("unexpected reply to SUBSCRIBE: %q", m)
+		}
+		b.SendText(`[32,7,{},"com.topic"]`)
+		b.expect(`[33,7,` + strconv.FormatUint(uint64(sub), 10) + `]`)
+		a.SendText(`[32,2,{},"com..topic"]`)
+		a.expect(`[8,32,2,{},"wamp.error.invalid_uri"]`)
+
+		// Publisher is excluded.
+		b.SendText(`[16,8,{"acknowledge":true},"com.topic",[1,2],{"k":"v"}]`)
+		b.expect(`[17,8,"$"]`)
+		a.expect(`[36,` + strconv.FormatUint(uint64(sub), 10) + `,"$",{},[1,2],{"k":"v"}]`)
+		b.ExpectNothing()
+
+		b.SendText(`[16,9,{},"com.topic",[],{"k":1}]`)
+		a.expect(`[36,` + strconv.FormatUint(uint64(sub), 10) + `,"$",{},[],{"k":1}]`)
+		b.ExpectNothing()
+
+		r.Publish("realm1", "com.topic", nil, nil)
+		a.expect(`[36,` + strconv.FormatUint(uint64(sub), 10) + `,"$",{}]`)
+		b.expect(`[36,` + strconv.FormatUint(uint64(sub), 10) + `,"$",{}]`)
+
+		a.SendText(`[34,3,` + strconv.FormatUint(uint64(sub), 10) + `]`)
+		a.expect(`[35,3]`)
+		a.SendText(`[34,4,` + strconv.FormatUint(uint64(sub), 10) + `]`)
+		a.expect(`[8,34,4,{},"wamp.error.no_such_subscription"]`)
+		r.Publish("realm1", "com.topic", List{"x"}, nil)
+		a.ExpectNothing()
+		b.expect(`[36,` + strconv.FormatUint(uint64(sub), 10) + `,"$",{},["x"]]`)
+
+		// Realms are isolated.
+		open := &Router{}
+		c, _ := join(t, open, "realm1")
+		d, _ := join(t, open, "other")
+		d.SendText(`[32,1,{},"com.topic"]`)
+		d.next()
+		c.SendText(`[16,1,{"acknowledge":true},"com.topic",["y"]]`)
+		c.expect(`[17,1,"$"]`)
+		d.ExpectNothing()
+
+		b.SendText(`[6,{},"wamp.close.close_realm"]`)
+		b.expect(`[6,{},"wamp.close.goodbye_and_out"]`)
+		r.Publish("realm1", "com.topic", nil, nil)
+		b.ExpectNothing()
+		b.SendText(`[16,1,{},"com.topic"]`)
+		b.expect(`[3,{},"wamp.error.protocol_violation"]`)
+		b.ExpectClose(easyws.StatusProtocolError)
+	})
+
+	t.Run("rpc", func(t *testing.T) {
+		callee, _ := join(t, r, "realm1")
+		caller, _ := join(t, r, "realm1")
+
+		callee.SendText(`[64,1,{},"com.add"]`)
+		m := callee.next()
+		reg, _ := m.id(2)
+		if m.typ() != typeRegistered || reg == 0 {
+			t.Fatalf("unexpected reply to REGISTER: %q", m)
+		}
+		regs := strconv.FormatUint(uint64(reg), 10)
+		caller.SendText(`[64,1,{},"com.add"]`)
+		caller.expect(`[8,64,1,{},"wamp.error.procedure_already_exists"]`)
+
+		caller.SendText(`[48,5,{},"com.add",[1,2]]`)
+		callee.expect(`[68,1,` + regs + `,{},[1,2]]`)
+		caller.SendText(`[48,6,{},"com.add",[],{"a":1}]`)
+		callee.expect(`[68,2,` + regs + `,{},[],{"a":1}]`)
+		callee.SendText(`[8,68,2,{},"com.error.bad",["no b"]]`)
+		caller.expect(`[8,48,6,{},"com.error.bad",["no b"]]`)
+		callee.SendText(`[70,1,{},[3]]`)
+		caller.expect(`[50,5,{},[3]]`)
+		callee.SendText(`[70,1,{},[3]]`)
+		caller.ExpectNothing()
+
+		caller.SendText(`[48,7,{},"com.sub"]`)
+		caller.expect(`[8,48,7,{},"wamp.error.no_such_procedure"]`)
+
+		// Calls handled by the callee which leaves are canceled.
+		caller.SendText(`[48,8,{},"com.add"]`)
+		callee.expect(`[68,3,` + regs + `,{}]`)
+		callee.Close()
+		caller.expect(`[8,48,8,{},"wamp.error.canceled"]`)
+		caller.SendText(`[48,9,{},"com.add"]`)
+		caller.expect(`[8,48,9,{},"wamp.error.no_such_procedure"]`)
+
+		callee, _ = join(t, r, "realm1")
+		callee.SendText(`[64,2,{},"com.add"]`)
+		m = callee.next()
+		reg, _ = m.id(2)
+		callee.SendText(`[66,3,` + strconv.FormatUint(uint64(reg), 10) + `]`)
+		callee.expect(`[67,3]`)
+		callee.SendText(`[66,4,` + strconv.FormatUint(uint64(reg), 10) + `]`)
+		callee.expect(`[8,66,4,{},"wamp.error.no_such_registration"]`)
+	})
+}
+
+func TestClient(t *testing.T) {
+	r := &Router{
+		Authenticate: func(_ *easyws.Conn, realm string, details Dict) error {
+			if details["authid"] == "mallory" {
+				return &Error{URI: "com.error.banned"}
+			}
+			return nil
+		},
+	}
+	mux := easyws.NewProtocolMux()
+	r.Register(mux)
+	url := wstest.Serve(t, mux)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := Dialer{Realm: "realm1", Details: Dict{"authid": "mallory"}}.Dial(ctx, url)
+	var ae *AbortError
+	if !errors.As(err, &ae) || ae.Reason != "com.error.banned" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a, err := Dialer{Realm: "realm1", Timeout: 5 * time.Second}.Dial(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Dialer{Realm: "realm1", Timeout: 5 * time.Second}.Dial(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if a.ID() == 0 || a.ID() == b.ID() {
+		t.Fatalf("unexpected session IDs: %d, %d", a.ID(), b.ID())
+	}
+
+	events := make(chan *Event, 10)
+	sub, err := a.Subscribe(ctx, "com.news", func(e *Event) { events <- e })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(ctx, "com.news", List{"hello"}, Dict{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Publish(ctx, "com.news", List{"self"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	r.Publish("realm1", "com.news", List{"router"}, nil)
+	e := <-events
+	if e.Subscription != sub || e.Publication == 0 || !reflect.DeepEqual(e.Args, List{"hello"}) || !reflect.DeepEqual(e.Kwargs, Dict{"n": float64(1)}) {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	if e = <-events; !reflect.DeepEqual(e.Args, List{"router"}) {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	if err := a.Unsubscribe(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Unsubscribe(ctx, sub); !isError(err, ErrNoSuchSubscription) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Callee could call other procedures from the handler.
+	if _, err := a.Register(ctx, "com.add", func(ctx context.Context, inv *Invocation) (*Result, error) {
+		var sum float64
+		for _, x := range inv.Args {
+			n, ok := x.(float64)
+			if !ok {
+				return nil, &Error{URI: ErrInvalidArgument, Args: List{"not a number"}}
+			}
+			sum += n
+		}
+		return &Result{Args: List{sum}}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Register(ctx, "com.double", func(ctx context.Context, inv *Invocation) (*Result, error) {
+		return b.Call(ctx, "com.add", List{inv.Args[0], inv.Args[0]}, nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fail, err := b.Register(ctx, "com.fail", func(ctx context.Context, inv *Invocation) (*Result, error) {
+		return nil, errors.New("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Register(ctx, "com.add", nil); !isError(err, ErrProcedureAlreadyExists) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res, err := b.Call(ctx, "com.add", List{1, 2, 3}, nil)
+	if err != nil || !reflect.DeepEqual(res.Args, List{float64(6)}) {
+		t.Fatalf("Call() = %+v, %v", res, err)
+	}
+	res, err = a.Call(ctx, "com.double", List{21}, nil)
+	if err != nil || !reflect.DeepEqual(res.Args, List{float64(42)}) {
+		t.Fatalf("Call() = %+v, %v", res, err)
+	}
+	_, err = b.Call(ctx, "com.add", List{"x"}, nil)
+	var we *Error
+	if !errors.As(err, &we) || we.URI != ErrInvalidArgument || !reflect.DeepEqual(we.Args, List{"not a number"}) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = a.Call(ctx, "com.fail", nil, nil); !isError(err, ErrRuntimeError) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Unregister(ctx, fail); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Call(ctx, "com.fail", nil, nil); !isError(err, ErrNoSuchProcedure) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := a.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Err() != nil {
+		t.Fatalf("unexpected error: %v", a.Err())
+	}
+	if _, err := a.Call(ctx, "com.add", nil, nil); err != ErrClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = b.Call(ctx, "com.add", nil, nil); !isError(err, ErrNoSuchProcedure) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func isError(err error, uri string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.URI == uri
+}
@@ -30,6 +30,8 @@ const (
 // It returns -1 if header is malformed.
 func HeaderSize(h Header) (n int) {
 	switch {
+	case h.Length < 0:
+		return -1
 	case h.Length < 126:
 		n = 2
 	case h.Length <= len16:
@@ -45,51 +47,75 @@ func HeaderSize(h Header) (n int) {
 	return n
 }
 
-// WriteHeader writes header binary representation into w.
+// WriteHeader returns header binary representation.
 func WriteHeader(h Header) ([]byte, error) {
 	// Make slice of bytes with capacity 14 that could hold any header.
-	bts := make([]byte, MaxHeaderSize)
+	return AppendHeader(make([]byte, 0, MaxHeaderSize), h)
+}
 
+// AppendHeader appends header binary representation to b and returns the
+// extended buffer. It does not allocate if b has HeaderSize(h) bytes of spare
+// capacity.
+func AppendHeader(b []byte, h Header) ([]byte, error) {
+	var b0, b1 byte
 	if h.Fin {
-		bts[0] |= bit0
+		b0 |= bit0
+	}
+	b0 |= h.Rsv << 4
+	b0 |= byte(h.OpCode)
+	if h.Masked {
+		b1 |= bit0
 	}
-	bts[0] |= h.Rsv << 4
-	bts[0] |= byte(h.OpCode)
 
-	var n int
 	switch {
+	case h.Length < 0:
+		return b, ErrHeaderLengthUnexpected
+
 	case h.Length <= len7:
-		bts[1] = byte(h.Length)
-		n = 2
+		b = append(b, b0, b1|byte(h.Length))
 
 	case h.Length <= len16:
-		bts[1] = 126
-		binary.BigEndian.PutUint16(bts[2:4], uint16(h.Length))
-		n = 4
+		b = append(b, b0, b1|126, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(h.Length))
 
 	case h.Length <= len64:
-		bts[1] = 127
-		binary.BigEndian.PutUint64(bts[2:10], uint64(h.Length))
-		n = 10
+		b = append(b, b0, b1|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b[len(b)-8:], uint64(h.Length))
 
 	default:
-		return nil, ErrHeaderLengthUnexpected
+		return b, ErrHeaderLengthUnexpected
 	}
 
 	if h.Masked {
-		bts[1] |= bit0
-		n += copy(bts[n:], h.Mask[:])
+		b = append(b, h.Mask[:]...)
 	}
 
-	return bts[:n], nil
+	return b, nil
+}
+
+// appendFrame appends binary representation of f to b and returns the
+// extended buffer.
+func appendFrame(b []byte, f Frame) []byte {
+	b, err := AppendHeader(b, f.Header)
+	if err != nil {
+		// Headers are always built from len() of a slice here.
+		panic(err)
+	}
+	return append(b, f.Payload...)
 }
 
 // WriteFrame writes frame binary representation into w.
 func WriteFrame(w io.Writer, f Frame) error {
-	_, err := WriteHeader(f.Header)
+	p := getBytes(MaxHeaderSize)
+	defer putBytes(p)
+
+	header, err := AppendHeader((*p)[:0], f.Header)
 	if err != nil {
 		return err
 	}
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
 	_, err = w.Write(f.Payload)
 	return err
 }
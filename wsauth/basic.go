@@ -0,0 +1,52 @@
+package wsauth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Basic authenticates clients with HTTP Basic authentication scheme.
+//
+// Note that browsers do not allow to set Authorization header of WebSocket
+// handshake, but send credentials cached for the origin after the
+// authentication on the same host.
+type Basic struct {
+	// Realm is the realm sent within WWW-Authenticate challenge.
+	Realm string
+
+	// Verify reports whether given credentials are valid. If nil, all
+	// clients are rejected.
+	Verify func(user, password string) bool
+}
+
+// Authenticate implements easyws.Authenticator. User name becomes the
+// Subject of returned identity.
+func (b *Basic) Authenticate(r *easyws.HandshakeRequest) (*easyws.Identity, error) {
+	req := http.Request{Header: r.Header}
+	user, password, ok := req.BasicAuth()
+	if !ok || b.Verify == nil || !b.Verify(user, password) {
+		return nil, easyws.Unauthorized("invalid credentials", `Basic realm=`+quote(b.Realm)+`, charset="UTF-8"`)
+	}
+	return &easyws.Identity{Subject: user}, nil
+}
+
+// quote returns s as a quoted-string of WWW-Authenticate challenge. Control
+// characters, which could not be sent within header value, are dropped.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' && c != '\t', c == 0x7f:
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
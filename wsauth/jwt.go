@@ -0,0 +1,256 @@
+// Package wsauth provides built-in easyws.Authenticator implementations.
+package wsauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Errors describing rejected tokens.
+var (
+	ErrTokenMissing   = errors.New("token is missing")
+	ErrTokenMalformed = errors.New("token is malformed")
+	ErrTokenAlgorithm = errors.New("token signing algorithm is not supported")
+	ErrTokenSignature = errors.New("token signature is invalid")
+	ErrTokenExpired   = errors.New("token is expired")
+	ErrTokenNotValid  = errors.New("token is not valid yet")
+	ErrTokenIssuer    = errors.New("token issuer is not accepted")
+	ErrTokenAudience  = errors.New("token audience is not accepted")
+	ErrTokenSecret    = errors.New("token secret is not configured")
+)
+
+// JWT authenticates clients by JSON Web Tokens signed with HMAC SHA-256
+// (HS256). Tokens are verified locally with the shared Secret.
+//
+// Token is taken from the "Authorization: Bearer" header, and then from the
+// query parameter, the cookie and the Sec-WebSocket-Protocol header, if
+// configured.
+//
+// The "sub" claim becomes the Subject of returned identity. All claims are
+// available as identity Claims.
+type JWT struct {
+	// Secret is the key used to verify the token signature. If empty, all
+	// tokens are rejected with ErrTokenSecret.
+	Secret []byte
+
+	// Param and Cookie are the names of the query parameter and the cookie
+	// holding the token.
+	Param, Cookie string
+
+	// Protocol is the marker of the token passed within
+	// Sec-WebSocket-Protocol header. If offered protocol equals to Protocol,
+	// the next offered protocol is the token, like "access_token, <token>".
+	// If offered protocol has Protocol as a prefix, the rest of it is the
+	// token, like "bearer.<token>".
+	Protocol string
+
+	// Issuer and Audience are the required values of "iss" and "aud"
+	// claims, if not empty.
+	Issuer, Audience string
+
+	// Leeway is the allowed clock skew when checking "exp" and "nbf" claims.
+	Leeway time.Duration
+
+	// Realm is the realm sent within WWW-Authenticate challenge.
+	Realm string
+
+	// Now returns current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Authenticate implements easyws.Authenticator.
+func (j *JWT) Authenticate(r *easyws.HandshakeRequest) (*easyws.Identity, error) {
+	token := j.token(r)
+	if token == "" {
+		return nil, easyws.Unauthorized(ErrTokenMissing.Error(), j.challenge(nil))
+	}
+	claims, err := j.Verify(token)
+	if err != nil {
+		return nil, easyws.Unauthorized(err.Error(), j.challenge(err))
+	}
+	sub, _ := claims["sub"].(string)
+	return &easyws.Identity{
+		Subject: sub,
+		Claims:  claims,
+	}, nil
+}
+
+func (j *JWT) token(r *easyws.HandshakeRequest) string {
+	const bearer = "bearer "
+	if h := r.Header.Get("Authorization"); len(h) > len(bearer) && strings.EqualFold(h[:len(bearer)], bearer) {
+		return strings.TrimSpace(h[len(bearer):])
+	}
+	if j.Param != "" {
+		if t := r.Query().Get(j.Param); t != "" {
+			return t
+		}
+	}
+	if j.Cookie != "" {
+		if t, ok := r.Cookie(j.Cookie); ok && t != "" {
+			return t
+		}
+	}
+	if j.Protocol != "" {
+		ps := r.Protocols()
+		for i, p := range ps {
+			switch {
+			case p == j.Protocol && i+1 < len(ps):
+				return ps[i+1]
+			case p != j.Protocol && strings.HasPrefix(p, j.Protocol):
+				return p[len(j.Protocol):]
+			}
+		}
+	}
+	return ""
+}
+
+func (j *JWT) challenge(err error) string {
+	c := "Bearer"
+	if j.Realm != "" {
+		c += " realm=" + quote(j.Realm)
+	}
+	if err != nil {
+		if j.Realm != "" {
+			c += ","
+		}
+		c += ` error="invalid_token", error_description=` + quote(err.Error())
+	}
+	return c
+}
+
+var jwtHeaderHS256 = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Verify verifies the token signature and registered claims. It returns
+// decoded claims of the valid token.
+func (j *JWT) Verify(token string) (map[string]interface{}, error) {
+	if len(j.Secret) == 0 {
+		// Anyone could sign a token with the empty key.
+		return nil, ErrTokenSecret
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, ErrTokenAlgorithm
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !hmac.Equal(sig, sign(j.Secret, token[:len(parts[0])+1+len(parts[1])])) {
+		return nil, ErrTokenSignature
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := j.check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (j *JWT) check(claims map[string]interface{}) error {
+	now := time.Now
+	if j.Now != nil {
+		now = j.Now
+	}
+	t := now()
+	exp, ok, err := numericDate(claims["exp"])
+	if err != nil {
+		return err
+	}
+	if ok && !t.Before(exp.Add(j.Leeway)) {
+		return ErrTokenExpired
+	}
+	nbf, ok, err := numericDate(claims["nbf"])
+	if err != nil {
+		return err
+	}
+	if ok && t.Add(j.Leeway).Before(nbf) {
+		return ErrTokenNotValid
+	}
+	if j.Issuer != "" && claims["iss"] != j.Issuer {
+		return ErrTokenIssuer
+	}
+	if j.Audience != "" && !hasAudience(claims["aud"], j.Audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+// SignJWT returns the token with given claims signed with HS256 algorithm.
+func SignJWT(secret []byte, claims interface{}) (string, error) {
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	s := jwtHeaderHS256 + "." + base64.RawURLEncoding.EncodeToString(p)
+	return s + "." + base64.RawURLEncoding.EncodeToString(sign(secret, s)), nil
+}
+
+func sign(secret []byte, s string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+func decodeSegment(s string, v interface{}) error {
+	p, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return ErrTokenMalformed
+	}
+	return nil
+}
+
+// numericDate decodes the NumericDate claim v. It reports false if the claim
+// is absent and returns ErrTokenMalformed if it is not a number.
+func numericDate(v interface{}) (time.Time, bool, error) {
+	if v == nil {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, ErrTokenMalformed
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, ErrTokenMalformed
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true, nil
+}
+
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package wsauth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+// whoami replies to every message with the subject of the client identity.
+type whoami struct{}
+
+func (whoami) OnStart() (easyws.OpCode, error)                 { return 0, nil }
+func (whoami) OnConnect() (easyws.OpCode, error)               { return 0, nil }
+func (whoami) OnUpgraded() (easyws.OpCode, error)              { return 0, nil }
+func (whoami) OnShutdown() (easyws.OpCode, error)              { return 0, nil }
+func (whoami) OnClose(error) (easyws.OpCode, error)            { return 0, nil }
+func (whoami) OnReceive([]byte) ([]byte, easyws.OpCode, error) { return nil, 0, nil }
+
+func (whoami) OnMessage(c *easyws.Conn, _ easyws.OpCode, _ []byte) ([]byte, easyws.OpCode, error) {
+	return []byte(c.Identity().Subject), easyws.OpText, nil
+}
+
+var (
+	secret = []byte("secret")
+	now    = time.Unix(1700000000, 0)
+)
+
+func mustSign(t *testing.T, claims map[string]interface{}) string {
+	token, err := SignJWT(secret, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWT(t *testing.T) {
+	valid := mustSign(t, map[string]interface{}{
+		"sub": "alice",
+		"iss": "auth.example.com",
+		"aud": []string{"chat", "feed"},
+		"exp": now.Add(time.Minute).Unix(),
+	})
+	auth := &JWT{
+		Secret:   secret,
+		Param:    "access_token",
+		Cookie:   "jwt",
+		Protocol: "access_token",
+		Issuer:   "auth.example.com",
+		Audience: "chat",
+		Now:      func() time.Time { return now },
+	}
+	for _, test := range []struct {
+		name   string
+		req    wstest.Request
+		status int
+	}{
+		{
+			name: "header",
+			req:  wstest.Request{Header: http.Header{"Authorization": {"Bearer " + valid}}},
+		},
+		{
+			name: "query",
+			req:  wstest.Request{Path: "/ws?access_token=" + valid},
+		},
+		{
+			name: "cookie",
+			req:  wstest.Request{Header: http.Header{"Cookie": {"a=b; jwt=" + valid}}},
+		},
+		{
+			name: "protocol",
+			req:  wstest.Request{Protocols: []string{"chat.v1", "access_token", valid}},
+		},
+		{
+			name:   "missing",
+			req:    wstest.Request{},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "bad signature",
+			req:    wstest.Request{Path: "/ws?access_token=" + valid[:len(valid)-2] + "AA"},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "expired",
+			req: wstest.Request{Path: "/ws?access_token=" + mustSign(t, map[string]interface{}{
+				"sub": "alice",
+				"iss": "auth.example.com",
+				"aud": "chat",
+				"exp": now.Unix(),
+			})},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "audience",
+			req: wstest.Request{Path: "/ws?access_token=" + mustSign(t, map[string]interface{}{
+				"sub": "alice",
+				"iss": "auth.example.com",
+				"aud": "feed",
+			})},
+			status: http.StatusUnauthorized,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := wstest.New(t, whoami{}, wstest.WithServerOptions(
+				easyws.WithUpgrader(easyws.Upgrader{Authenticator: auth}),
+			))
+			if test.status == 0 {
+				h.Upgrade(test.req)
+				h.Write(wstest.Text("?"))
+				h.ExpectText("alice")
+				return
+			}
+			h.Write(test.req.Bytes())
+			resp := h.Response()
+			if resp.StatusCode != test.status {
+				t.Fatalf("unexpected status: %s", resp.Status)
+			}
+			if resp.Header.Get("WWW-Authenticate") == "" {
+				t.Fatalf("no challenge in response")
+			}
+			h.ExpectClosed()
+		})
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		secret []byte
+		claims map[string]interface{}
+		err    error
+	}{
+		{
+			name:   "valid",
+			secret: secret,
+			claims: map[string]interface{}{"exp": now.Add(time.Minute).Unix(), "nbf": now.Unix()},
+		},
+		{
+			name:   "no secret",
+			claims: map[string]interface{}{"sub": "mallory"},
+			err:    ErrTokenSecret,
+		},
+		{
+			name:   "exp string",
+			secret: secret,
+			claims: map[string]interface{}{"exp": "never"},
+			err:    ErrTokenMalformed,
+		},
+		{
+			name:   "nbf bool",
+			secret: secret,
+			claims: map[string]interface{}{"nbf": false},
+			err:    ErrTokenMalformed,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := SignJWT(test.secret, test.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j := &JWT{Secret: test.secret, Now: func() time.Time { return now }}
+			if _, err := j.Verify(token); err != test.err {
+				t.Fatalf("Verify() error is %v; want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestBasic(t *testing.T) {
+	auth := &Basic{
+		Realm: "chat",
+		Verify: func(user, password string) bool {
+			return user == "bob" && password == "hunter2"
+		},
+	}
+	h := wstest.New(t, whoami{}, wstest.WithServerOptions(
+		easyws.WithUpgrader(easyws.Upgrader{Authenticator: auth}),
+	))
+	h.Write(wstest.Request{Header: http.Header{"Authorization": {"Basic Ym9iOmh1bnRlcjE="}}}.Bytes())
+	resp := h.Response()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	if exp := `Basic realm="chat", charset="UTF-8"`; resp.Header.Get("WWW-Authenticate") != exp {
+		t.Fatalf("unexpected challenge: %q", resp.Header.Get("WWW-Authenticate"))
+	}
+
+	h = wstest.New(t, whoami{}, wstest.WithServerOptions(
+		easyws.WithUpgrader(easyws.Upgrader{Authenticator: auth}),
+	))
+	h.Upgrade(wstest.Request{Header: http.Header{"Authorization": {"Basic Ym9iOmh1bnRlcjI="}}})
+	h.Write(wstest.Text("?"))
+	h.ExpectText("bob")
+
+	// Clients are rejected without Verify; realm is quoted.
+	h = wstest.New(t, whoami{}, wstest.WithServerOptions(
+		easyws.WithUpgrader(easyws.Upgrader{Authenticator: &Basic{Realm: "a \"b\" \\c\r\n"}}),
+	))
+	h.Write(wstest.Request{Header: http.Header{"Authorization": {"Basic Ym9iOmh1bnRlcjI="}}}.Bytes())
+	resp = h.Response()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	if exp := `Basic realm="a \"b\" \\c", charset="UTF-8"`; resp.Header.Get("WWW-Authenticate") != exp {
+		t.Fatalf("unexpected challenge: %q", resp.Header.Get("WWW-Authenticate"))
+	}
+	h.ExpectClosed()
+}
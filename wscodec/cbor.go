@@ -0,0 +1,290 @@
+package wscodec
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/EternalVow/easyws"
+)
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string          { return "cbor" }
+func (cborCodec) OpCode() easyws.OpCode { return easyws.OpBinary }
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	w := &cborWriter{}
+	if err := marshal(w, v); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return unmarshal(&cborReader{data: data}, v)
+}
+
+// CBOR major types.
+const (
+	cborUint = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// cborWriter writes data items in CBOR format.
+//
+// See https://www.rfc-editor.org/rfc/rfc8949.html
+type cborWriter struct {
+	buf []byte
+}
+
+func (w *cborWriter) tag() string { return "cbor" }
+
+func (w *cborWriter) writeHead(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		w.buf = append(w.buf, m|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, m|24, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, m|26), uint32(n))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, m|27), n)
+	}
+}
+
+func (w *cborWriter) writeRaw(p []byte) { w.buf = append(w.buf, p...) }
+
+func (w *cborWriter) writeNil() { w.buf = append(w.buf, 0xf6) }
+
+func (w *cborWriter) writeBool(b bool) {
+	if b {
+		w.buf = append(w.buf, 0xf5)
+	} else {
+		w.buf = append(w.buf, 0xf4)
+	}
+}
+
+func (w *cborWriter) writeUint(u uint64) { w.writeHead(cborUint, u) }
+
+func (w *cborWriter) writeInt(i int64) {
+	if i >= 0 {
+		w.writeHead(cborUint, uint64(i))
+	} else {
+		// Negative integer n is encoded as -1-n.
+		w.writeHead(cborNegInt, uint64(^i))
+	}
+}
+
+func (w *cborWriter) writeFloat32(f float32) {
+	w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xfa), math.Float32bits(f))
+}
+
+func (w *cborWriter) writeFloat64(f float64) {
+	w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xfb), math.Float64bits(f))
+}
+
+func (w *cborWriter) writeString(s string) {
+	w.writeHead(cborText, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *cborWriter) writeBytes(p []byte) {
+	w.writeHead(cborBytes, uint64(len(p)))
+	w.buf = append(w.buf, p...)
+}
+
+func (w *cborWriter) writeArray(n int) { w.writeHead(cborArray, uint64(n)) }
+func (w *cborWriter) writeMap(n int)   { w.writeHead(cborMap, uint64(n)) }
+
+// cborReader reads data items in CBOR format. Tags are skipped, so tagged
+// items are decoded as their content.
+type cborReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *cborReader) tag() string    { return "cbor" }
+func (r *cborReader) remaining() int { return len(r.data) - r.pos }
+
+func (r *cborReader) errorf(msg string) error {
+	return &SyntaxError{Format: "cbor", Offset: r.pos, Msg: msg}
+}
+
+func (r *cborReader) read(n uint64) ([]byte, error) {
+	if uint64(r.remaining()) < n {
+		return nil, r.errorf("unexpected end of data")
+	}
+	p := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return p, nil
+}
+
+// readHead reads the initial byte and the argument of a data item. Argument
+// is -1 for indefinite length items.
+func (r *cborReader) readHead() (major, info byte, arg uint64, err error) {
+	p, err := r.read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = p[0]>>5, p[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		p, err := r.read(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, b := range p {
+			arg = arg<<8 | uint64(b)
+		}
+		return major, info, arg, nil
+	case info == 31:
+		return major, info, math.MaxUint64, nil
+	}
+	r.pos--
+	return 0, 0, 0, r.errorf("reserved additional information")
+}
+
+func (r *cborReader) from(start int) []byte { return r.data[start:r.pos] }
+
+func (r *cborReader) next() (item, error) {
+	start := r.pos
+	it, err := r.readItem()
+	it.start = start
+	return it, err
+}
+
+func (r *cborReader) readItem() (item, error) {
+	for depth := 0; ; depth++ {
+		if depth > maxDepth {
+			return item{}, errDepth
+		}
+		major, info, arg, err := r.readHead()
+		if err != nil {
+			return item{}, err
+		}
+		indefinite := info == 31
+		switch major {
+		case cborUint:
+			if indefinite {
+				break
+			}
+			return item{kind: kindUint, u: arg}, nil
+
+		case cborNegInt:
+			if indefinite {
+				break
+			}
+			if arg > math.MaxInt64 {
+				return item{}, r.errorf("negative integer overflows int64")
+			}
+			return item{kind: kindInt, i: -1 - int64(arg)}, nil
+
+		case cborBytes, cborText:
+			k := kindBytes
+			if major == cborText {
+				k = kindString
+			}
+			if !indefinite {
+				p, err := r.read(arg)
+				return item{kind: k, s: p}, err
+			}
+			s, err := r.readChunks(major)
+			return item{kind: k, s: s}, err
+
+		case cborArray, cborMap:
+			k := kindArray
+			if major == cborMap {
+				k = kindMap
+			}
+			if indefinite {
+				return item{kind: k, n: -1}, nil
+			}
+			if arg > uint64(r.remaining()) {
+				return item{}, r.errorf("length exceeds data size")
+			}
+			return item{kind: k, n: int(arg)}, nil
+
+		case cborTag:
+			if indefinite {
+				break
+			}
+			// Skip the tag and read the tagged item.
+			continue
+
+		case cborSimple:
+			switch info {
+			case 20, 21:
+				return item{kind: kindBool, b: info == 21}, nil
+			case 22, 23:
+				// Null and undefined.
+				return item{kind: kindNil}, nil
+			case 25:
+				return item{kind: kindFloat, f: float64(halfToFloat32(uint16(arg)))}, nil
+			case 26:
+				return item{kind: kindFloat, f: float64(math.Float32frombits(uint32(arg)))}, nil
+			case 27:
+				return item{kind: kindFloat, f: math.Float64frombits(arg)}, nil
+			case 31:
+				return item{kind: kindBreak}, nil
+			}
+			return item{}, r.errorf("unsupported simple value")
+		}
+		return item{}, r.errorf("unexpected indefinite length")
+	}
+}
+
+// readChunks reads chunks of indefinite length string of given major type
+// and returns their concatenation.
+func (r *cborReader) readChunks(major byte) ([]byte, error) {
+	s := []byte{}
+	for {
+		m, info, arg, err := r.readHead()
+		if err != nil {
+			return nil, err
+		}
+		if m == cborSimple && info == 31 {
+			return s, nil
+		}
+		if m != major || info == 31 {
+			return nil, r.errorf("malformed indefinite length string")
+		}
+		p, err := r.read(arg)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, p...)
+	}
+}
+
+// halfToFloat32 converts IEEE 754 half precision float to float32.
+func halfToFloat32(h uint16) float32 {
+	var (
+		sign = uint32(h>>15) << 31
+		exp  = uint32(h>>10) & 0x1f
+		frac = uint32(h) & 0x3ff
+	)
+	switch exp {
+	case 0:
+		// Zero or subnormal number.
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		// Infinity or NaN.
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+}
@@ -0,0 +1,126 @@
+// Package wscodec provides message codecs and typed handlers for easyws.
+//
+// JSON codec uses encoding/json. MessagePack and CBOR codecs are implemented
+// in this package. They encode Go values the way encoding/json does: structs
+// are encoded as maps keyed by field names, which could be changed with the
+// "msgpack" or "cbor" struct tags respectively, falling back to the "json"
+// tag. Tag options "omitempty" and "-" are supported.
+package wscodec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Codec encodes and decodes messages.
+type Codec interface {
+	// Name returns the short name of the codec, like "json".
+	Name() string
+
+	// OpCode returns the op code of the messages produced by Marshal, which
+	// is either easyws.OpText or easyws.OpBinary.
+	OpCode() easyws.OpCode
+
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Built-in codecs.
+var (
+	// JSON encodes messages with encoding/json.
+	JSON Codec = jsonCodec{}
+
+	// MsgPack encodes messages in MessagePack format.
+	MsgPack Codec = msgpackCodec{}
+
+	// CBOR encodes messages in CBOR format defined by RFC 8949.
+	CBOR Codec = cborCodec{}
+
+	// Raw passes messages as is. It marshals []byte and string values and
+	// unmarshals into *[]byte and *string values.
+	Raw Codec = rawCodec{}
+)
+
+// Subprotocols returns a mapping of subprotocols named as the base followed
+// by a dot and the codec name, like "v2.json", to the given codecs. It is
+// useful as Handler.Codecs.
+func Subprotocols(base string, codecs ...Codec) map[string]Codec {
+	m := make(map[string]Codec, len(codecs))
+	for _, c := range codecs {
+		m[base+"."+c.Name()] = c
+	}
+	return m
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) OpCode() easyws.OpCode                      { return easyws.OpText }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// ErrRawType is returned by Raw codec for unsupported types.
+var ErrRawType = errors.New("wscodec: raw codec supports only []byte and string")
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string          { return "raw" }
+func (rawCodec) OpCode() easyws.OpCode { return easyws.OpBinary }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case []byte:
+		return x, nil
+	case string:
+		return []byte(x), nil
+	}
+	return nil, ErrRawType
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch x := v.(type) {
+	case *[]byte:
+		*x = append((*x)[:0], data...)
+	case *string:
+		*x = string(data)
+	default:
+		return ErrRawType
+	}
+	return nil
+}
+
+// RawMessage is a raw encoded value. It could be used to delay decoding of
+// a part of the message or to embed already encoded value. RawMessage must
+// be used with the same codec it was produced by.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// MarshalJSON returns m as the JSON encoding of m.
+func (m RawMessage) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	return m, nil
+}
+
+// UnmarshalJSON sets *m to a copy of data.
+func (m *RawMessage) UnmarshalJSON(data []byte) error {
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+// SyntaxError describes malformed MessagePack or CBOR data.
+type SyntaxError struct {
+	Format string
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("wscodec: %s: %s at offset %d", e.Format, e.Msg, e.Offset)
+}
@@ -0,0 +1,101 @@
+package wscodec
+
+import (
+	"errors"
+
+	"github.com/EternalVow/easyws"
+)
+
+// ErrNoReply could be returned by Handler.Handle to send nothing in reply.
+var ErrNoReply = errors.New("no reply")
+
+// Handler is an easyws.IEasyWs which decodes received messages into values
+// of type In and encodes replies of type Out.
+//
+// Codec of the connection is selected by the subprotocol negotiated during
+// the handshake, so Handler is usually registered within
+// easyws.ProtocolMux with Register.
+type Handler[In, Out any] struct {
+	// Handle is called for every decoded message. Returned value is encoded
+	// and sent in reply, unless the error is ErrNoReply. Other errors are
+	// returned to NetHandler, which closes the connection.
+	Handle func(conn *easyws.Conn, in In) (Out, error)
+
+	// Codecs maps subprotocols to the codecs used for their connections.
+	Codecs map[string]Codec
+
+	// Codec is used for connections whose subprotocol is not in Codecs. If
+	// nil, JSON is used.
+	Codec Codec
+
+	// OnDecodeError is called when received message could not be decoded.
+	// Returned value is encoded and sent in reply. If OnDecodeError is nil or
+	// returns nil, the connection is closed with StatusUnsupportedData; err
+	// is not sent to the client, since it could be longer than close reason
+	// allows.
+	OnDecodeError func(conn *easyws.Conn, err error) interface{}
+}
+
+// Register registers h within m for every subprotocol of h.Codecs.
+func (h *Handler[In, Out]) Register(m *easyws.ProtocolMux) {
+	for p := range h.Codecs {
+		m.Handle(p, h)
+	}
+}
+
+// CodecOf returns the codec used for conn.
+func (h *Handler[In, Out]) CodecOf(conn *easyws.Conn) Codec {
+	if conn != nil {
+		if c, ok := h.Codecs[conn.Handshake().Protocol]; ok {
+			return c
+		}
+	}
+	if h.Codec != nil {
+		return h.Codec
+	}
+	return JSON
+}
+
+// OnMessage implements easyws.IEasyWsMessage.
+func (h *Handler[In, Out]) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	codec := h.CodecOf(conn)
+	var in In
+	if err := codec.Unmarshal(msg, &in); err != nil {
+		var reply interface{}
+		if h.OnDecodeError != nil {
+			reply = h.OnDecodeError(conn, err)
+		}
+		if reply == nil {
+			return easyws.CloseReply(easyws.StatusUnsupportedData, "invalid message")
+		}
+		return encode(codec, reply)
+	}
+	out, err := h.Handle(conn, in)
+	if err == ErrNoReply {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return encode(codec, out)
+}
+
+func encode(codec Codec, v interface{}) ([]byte, easyws.OpCode, error) {
+	p, err := codec.Marshal(v)
+	if err != nil {
+		return nil, 0, err
+	}
+	return p, codec.OpCode(), nil
+}
+
+// OnReceive implements easyws.IEasyWs by decoding msg with the default codec
+// and calling Handle with nil connection.
+func (h *Handler[In, Out]) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return h.OnMessage(nil, 0, msg)
+}
+
+func (h *Handler[In, Out]) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (h *Handler[In, Out]) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (h *Handler[In, Out]) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (h *Handler[In, Out]) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (h *Handler[In, Out]) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
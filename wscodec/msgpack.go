@@ -0,0 +1,236 @@
+package wscodec
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/EternalVow/easyws"
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string          { return "msgpack" }
+func (msgpackCodec) OpCode() easyws.OpCode { return easyws.OpBinary }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	w := &msgpackWriter{}
+	if err := marshal(w, v); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return unmarshal(&msgpackReader{data: data}, v)
+}
+
+// msgpackWriter writes data items in MessagePack format.
+//
+// See https://github.com/msgpack/msgpack/blob/master/spec.md
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) tag() string { return "msgpack" }
+
+func (w *msgpackWriter) writeRaw(p []byte) { w.buf = append(w.buf, p...) }
+
+func (w *msgpackWriter) writeNil() { w.buf = append(w.buf, 0xc0) }
+
+func (w *msgpackWriter) writeBool(b bool) {
+	if b {
+		w.buf = append(w.buf, 0xc3)
+	} else {
+		w.buf = append(w.buf, 0xc2)
+	}
+}
+
+func (w *msgpackWriter) writeUint(u uint64) {
+	switch {
+	case u < 1<<7:
+		w.buf = append(w.buf, byte(u))
+	case u <= math.MaxUint8:
+		w.buf = append(w.buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xce), uint32(u))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xcf), u)
+	}
+}
+
+func (w *msgpackWriter) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		w.writeUint(uint64(i))
+	case i >= -32:
+		w.buf = append(w.buf, byte(i))
+	case i >= math.MinInt8:
+		w.buf = append(w.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xd2), uint32(i))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xd3), uint64(i))
+	}
+}
+
+func (w *msgpackWriter) writeFloat32(f float32) {
+	w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xca), math.Float32bits(f))
+}
+
+func (w *msgpackWriter) writeFloat64(f float64) {
+	w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xcb), math.Float64bits(f))
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	w.writeHead(len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) writeBytes(p []byte) {
+	w.writeHead(len(p), 0, 0, 0xc4, 0xc5, 0xc6)
+	w.buf = append(w.buf, p...)
+}
+
+func (w *msgpackWriter) writeArray(n int) { w.writeHead(n, 0x90, 16, 0, 0xdc, 0xdd) }
+func (w *msgpackWriter) writeMap(n int)   { w.writeHead(n, 0x80, 16, 0, 0xde, 0xdf) }
+
+// writeHead writes the header of a string, bytes, array or map of length n.
+// If n is less than fixMax, it is written within the fix prefix. Otherwise
+// one of the prefixes for 8, 16 or 32 bit length is used; zero p8 means that
+// format has no 8 bit variant.
+func (w *msgpackWriter) writeHead(n int, fix byte, fixMax int, p8, p16, p32 byte) {
+	switch {
+	case n < fixMax:
+		w.buf = append(w.buf, fix|byte(n))
+	case n <= math.MaxUint8 && p8 != 0:
+		w.buf = append(w.buf, p8, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, p16), uint16(n))
+	default:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, p32), uint32(n))
+	}
+}
+
+// msgpackReader reads data items in MessagePack format.
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *msgpackReader) tag() string    { return "msgpack" }
+func (r *msgpackReader) remaining() int { return len(r.data) - r.pos }
+
+func (r *msgpackReader) errorf(msg string) error {
+	return &SyntaxError{Format: "msgpack", Offset: r.pos, Msg: msg}
+}
+
+// read returns next n bytes.
+func (r *msgpackReader) read(n int) ([]byte, error) {
+	if n < 0 || r.remaining() < n {
+		return nil, r.errorf("unexpected end of data")
+	}
+	p := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return p, nil
+}
+
+// readUint reads big endian unsigned integer of n bytes.
+func (r *msgpackReader) readUint(n int) (uint64, error) {
+	p, err := r.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, b := range p {
+		u = u<<8 | uint64(b)
+	}
+	return u, nil
+}
+
+func (r *msgpackReader) from(start int) []byte { return r.data[start:r.pos] }
+
+func (r *msgpackReader) next() (item, error) {
+	start := r.pos
+	it, err := r.readItem()
+	it.start = start
+	return it, err
+}
+
+func (r *msgpackReader) readItem() (it item, err error) {
+	p, err := r.read(1)
+	if err != nil {
+		return it, err
+	}
+	b := p[0]
+	switch {
+	case b <= 0x7f:
+		return item{kind: kindUint, u: uint64(b)}, nil
+	case b >= 0xe0:
+		return item{kind: kindInt, i: int64(int8(b))}, nil
+	case b&0xf0 == 0x80:
+		return item{kind: kindMap, n: int(b & 0x0f)}, nil
+	case b&0xf0 == 0x90:
+		return item{kind: kindArray, n: int(b & 0x0f)}, nil
+	case b&0xe0 == 0xa0:
+		return r.readData(kindString, int(b&0x1f))
+	}
+	switch b {
+	case 0xc0:
+		return item{kind: kindNil}, nil
+	case 0xc2, 0xc3:
+		return item{kind: kindBool, b: b == 0xc3}, nil
+
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := r.readUint(1 << (b - 0xcc))
+		return item{kind: kindUint, u: u}, err
+
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (b - 0xd0)
+		u, err := r.readUint(n)
+		// Sign extend.
+		i := int64(u<<(64-8*n)) >> (64 - 8*n)
+		if i >= 0 {
+			return item{kind: kindUint, u: uint64(i)}, err
+		}
+		return item{kind: kindInt, i: i}, err
+
+	case 0xca:
+		u, err := r.readUint(4)
+		return item{kind: kindFloat, f: float64(math.Float32frombits(uint32(u)))}, err
+	case 0xcb:
+		u, err := r.readUint(8)
+		return item{kind: kindFloat, f: math.Float64frombits(u)}, err
+
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.readUint(1 << (b - 0xd9))
+		if err != nil {
+			return it, err
+		}
+		return r.readData(kindString, int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.readUint(1 << (b - 0xc4))
+		if err != nil {
+			return it, err
+		}
+		return r.readData(kindBytes, int(n))
+
+	case 0xdc, 0xdd:
+		n, err := r.readUint(2 << (b - 0xdc))
+		return item{kind: kindArray, n: int(n)}, err
+	case 0xde, 0xdf:
+		n, err := r.readUint(2 << (b - 0xde))
+		return item{kind: kindMap, n: int(n)}, err
+	}
+	// Extension types and the never used 0xc1 byte.
+	r.pos--
+	return it, r.errorf("unsupported type")
+}
+
+func (r *msgpackReader) readData(k kind, n int) (item, error) {
+	p, err := r.read(n)
+	return item{kind: k, s: p}, err
+}
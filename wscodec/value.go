@@ -0,0 +1,669 @@
+package wscodec
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxDepth limits nesting of decoded and encoded values.
+const maxDepth = 1000
+
+// kind enumerates kinds of data items of the MessagePack and CBOR data models.
+type kind uint8
+
+const (
+	kindNil kind = iota
+	kindBool
+	kindInt  // Negative integer.
+	kindUint // Non-negative integer.
+	kindFloat
+	kindString
+	kindBytes
+	kindArray
+	kindMap
+	kindBreak // End of indefinite length array or map.
+)
+
+var kindNames = [...]string{
+	kindNil:    "nil",
+	kindBool:   "bool",
+	kindInt:    "negative integer",
+	kindUint:   "integer",
+	kindFloat:  "float",
+	kindString: "string",
+	kindBytes:  "bytes",
+	kindArray:  "array",
+	kindMap:    "map",
+	kindBreak:  "break",
+}
+
+func (k kind) String() string {
+	return kindNames[k]
+}
+
+// item is a single data item read by reader.
+type item struct {
+	kind kind
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	// s holds string or bytes. It is valid only until the next read.
+	s []byte
+	// n is the number of array items or map pairs, or -1 if the length is
+	// indefinite.
+	n int
+	// start is the offset of the item within the data.
+	start int
+}
+
+// writer writes data items in a binary format.
+type writer interface {
+	writeNil()
+	writeBool(bool)
+	writeInt(int64)
+	writeUint(uint64)
+	writeFloat32(float32)
+	writeFloat64(float64)
+	writeString(string)
+	writeBytes([]byte)
+	writeArray(n int)
+	writeMap(n int)
+	// writeRaw writes already encoded item.
+	writeRaw([]byte)
+}
+
+// reader reads data items in a binary format.
+type reader interface {
+	next() (item, error)
+	// remaining returns the number of unread bytes.
+	remaining() int
+	// from returns the data read since given offset.
+	from(start int) []byte
+}
+
+// UnsupportedTypeError is returned when encoding or decoding of a type is
+// not supported.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "wscodec: unsupported type " + e.Type.String()
+}
+
+// TypeError describes a data item which could not be decoded into a Go
+// value.
+type TypeError struct {
+	Item string
+	Type reflect.Type
+}
+
+func (e *TypeError) Error() string {
+	return "wscodec: cannot decode " + e.Item + " into " + e.Type.String()
+}
+
+var errDepth = fmt.Errorf("wscodec: exceeded max depth of %d", maxDepth)
+
+func marshal(w writer, v interface{}) error {
+	return encodeValue(w, reflect.ValueOf(v), 0)
+}
+
+func encodeValue(w writer, v reflect.Value, depth int) error {
+	if depth > maxDepth {
+		return errDepth
+	}
+	if v.IsValid() && v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			w.writeNil()
+		} else {
+			w.writeRaw(v.Bytes())
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		w.writeNil()
+
+	case reflect.Bool:
+		w.writeBool(v.Bool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.writeInt(v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.writeUint(v.Uint())
+
+	case reflect.Float32:
+		w.writeFloat32(float32(v.Float()))
+
+	case reflect.Float64:
+		w.writeFloat64(v.Float())
+
+	case reflect.String:
+		w.writeString(v.String())
+
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		return encodeValue(w, v.Elem(), depth+1)
+
+	case reflect.Slice:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			w.writeBytes(v.Bytes())
+			return nil
+		}
+		return encodeArray(w, v, depth)
+
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			p := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(p), v)
+			w.writeBytes(p)
+			return nil
+		}
+		return encodeArray(w, v, depth)
+
+	case reflect.Map:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		return encodeMap(w, v, depth)
+
+	case reflect.Struct:
+		return encodeStruct(w, v, depth)
+
+	default:
+		return &UnsupportedTypeError{v.Type()}
+	}
+	return nil
+}
+
+func encodeArray(w writer, v reflect.Value, depth int) error {
+	n := v.Len()
+	w.writeArray(n)
+	for i := 0; i < n; i++ {
+		if err := encodeValue(w, v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeMap(w writer, v reflect.Value, depth int) error {
+	keys := v.MapKeys()
+	if v.Type().Key().Kind() == reflect.String {
+		// Make the output deterministic, as encoding/json does.
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+	}
+	w.writeMap(len(keys))
+	for _, k := range keys {
+		if err := encodeValue(w, k, depth+1); err != nil {
+			return err
+		}
+		if err := encodeValue(w, v.MapIndex(k), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeStruct(w writer, v reflect.Value, depth int) error {
+	fields := structFields(v.Type(), w.(tagger).tag())
+	var n int
+	for i := range fields {
+		if !fields[i].omit(v) {
+			n++
+		}
+	}
+	w.writeMap(n)
+	for i := range fields {
+		f := &fields[i]
+		if f.omit(v) {
+			continue
+		}
+		w.writeString(f.name)
+		if err := encodeValue(w, v.FieldByIndex(f.index), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagger is implemented by writers and readers to report the name of the
+// struct tag of their format.
+type tagger interface {
+	tag() string
+}
+
+// field describes encoded struct field.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+func (f *field) omit(v reflect.Value) bool {
+	return f.omitEmpty && isEmpty(v.FieldByIndex(f.index))
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Struct:
+		return false
+	}
+	return v.IsZero()
+}
+
+type fieldsKey struct {
+	t   reflect.Type
+	tag string
+}
+
+var fieldsCache sync.Map // fieldsKey -> []field
+
+// structFields returns encoded fields of struct type t using given tag name.
+func structFields(t reflect.Type, tag string) []field {
+	key := fieldsKey{t, tag}
+	if fs, ok := fieldsCache.Load(key); ok {
+		return fs.([]field)
+	}
+	var (
+		fs   []field
+		seen = map[string]bool{}
+	)
+	appendFields(&fs, seen, t, tag, nil)
+	fieldsCache.Store(key, fs)
+	return fs
+}
+
+func appendFields(fs *[]field, seen map[string]bool, t reflect.Type, tag string, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		s, ok := sf.Tag.Lookup(tag)
+		if !ok {
+			s = sf.Tag.Get("json")
+		}
+		if s == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(s, ",")
+		idx := append(append([]int(nil), index...), i)
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			appendFields(fs, seen, sf.Type, tag, idx)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		*fs = append(*fs, field{
+			name:      name,
+			index:     idx,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+}
+
+// lookupField returns the field with given name. Exact match is preferred
+// over case insensitive one.
+func lookupField(fs []field, name []byte) *field {
+	for i := range fs {
+		if fs[i].name == string(name) {
+			return &fs[i]
+		}
+	}
+	for i := range fs {
+		if strings.EqualFold(fs[i].name, string(name)) {
+			return &fs[i]
+		}
+	}
+	return nil
+}
+
+func unmarshal(r reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("wscodec: unmarshal into non-pointer %T", v)
+	}
+	it, err := r.next()
+	if err != nil {
+		return err
+	}
+	if err := decodeItem(r, it, rv.Elem(), 0); err != nil {
+		return err
+	}
+	if n := r.remaining(); n > 0 {
+		return fmt.Errorf("wscodec: %d bytes of trailing data", n)
+	}
+	return nil
+}
+
+// elems calls fn for every item of an array or for every key of a map, which
+// has n items or is of indefinite length if n is negative. For maps, fn must
+// read the value itself.
+func elems(r reader, n int, fn func(item) error) error {
+	if n > r.remaining() {
+		return fmt.Errorf("wscodec: length %d exceeds data size", n)
+	}
+	for i := 0; n < 0 || i < n; i++ {
+		it, err := r.next()
+		if err != nil {
+			return err
+		}
+		if it.kind == kindBreak {
+			if n >= 0 {
+				return fmt.Errorf("wscodec: unexpected break")
+			}
+			return nil
+		}
+		if err := fn(it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeItem(r reader, it item, v reflect.Value, depth int) error {
+	if depth > maxDepth {
+		return errDepth
+	}
+	if it.kind == kindBreak {
+		return fmt.Errorf("wscodec: unexpected break")
+	}
+	if v.Type() == rawMessageType {
+		if err := skipItem(r, it, depth); err != nil {
+			return err
+		}
+		v.SetBytes(append([]byte{}, r.from(it.start)...))
+		return nil
+	}
+	if it.kind == kindNil {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeItem(r, it, v.Elem(), depth+1)
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return &TypeError{it.kind.String(), v.Type()}
+		}
+		x, err := decodeAny(r, it, depth)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+
+	mismatch := func() error {
+		return &TypeError{it.kind.String(), v.Type()}
+	}
+	switch it.kind {
+	case kindBool:
+		if v.Kind() != reflect.Bool {
+			return mismatch()
+		}
+		v.SetBool(it.b)
+
+	case kindInt, kindUint:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			x := it.i
+			if it.kind == kindUint {
+				if it.u > math.MaxInt64 {
+					return mismatch()
+				}
+				x = int64(it.u)
+			}
+			if v.OverflowInt(x) {
+				return mismatch()
+			}
+			v.SetInt(x)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if it.kind == kindInt || v.OverflowUint(it.u) {
+				return mismatch()
+			}
+			v.SetUint(it.u)
+		case reflect.Float32, reflect.Float64:
+			if it.kind == kindInt {
+				v.SetFloat(float64(it.i))
+			} else {
+				v.SetFloat(float64(it.u))
+			}
+		default:
+			return mismatch()
+		}
+
+	case kindFloat:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(it.f)
+		default:
+			return mismatch()
+		}
+
+	case kindString, kindBytes:
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(it.s))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte{}, it.s...))
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 && v.Len() == len(it.s):
+			reflect.Copy(v, reflect.ValueOf(it.s))
+		default:
+			return mismatch()
+		}
+
+	case kindArray:
+		switch v.Kind() {
+		case reflect.Slice:
+			if it.n >= 0 && it.n <= r.remaining() {
+				v.Set(reflect.MakeSlice(v.Type(), 0, it.n))
+			} else {
+				v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			}
+			elem := v.Type().Elem()
+			return elems(r, it.n, func(x item) error {
+				e := reflect.New(elem).Elem()
+				if err := decodeItem(r, x, e, depth+1); err != nil {
+					return err
+				}
+				v.Set(reflect.Append(v, e))
+				return nil
+			})
+		case reflect.Array:
+			var i int
+			err := elems(r, it.n, func(x item) error {
+				if i >= v.Len() {
+					return mismatch()
+				}
+				i++
+				return decodeItem(r, x, v.Index(i-1), depth+1)
+			})
+			for ; err == nil && i < v.Len(); i++ {
+				v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+			}
+			return err
+		default:
+			return mismatch()
+		}
+
+	case kindMap:
+		switch v.Kind() {
+		case reflect.Map:
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			kt, vt := v.Type().Key(), v.Type().Elem()
+			return elems(r, it.n, func(key item) error {
+				k := reflect.New(kt).Elem()
+				if err := decodeItem(r, key, k, depth+1); err != nil {
+					return err
+				}
+				x := reflect.New(vt).Elem()
+				if err := decodeNext(r, x, depth+1); err != nil {
+					return err
+				}
+				v.SetMapIndex(k, x)
+				return nil
+			})
+		case reflect.Struct:
+			fs := structFields(v.Type(), r.(tagger).tag())
+			return elems(r, it.n, func(key item) error {
+				if key.kind != kindString && key.kind != kindBytes {
+					return &TypeError{key.kind.String() + " key", v.Type()}
+				}
+				f := lookupField(fs, key.s)
+				if f == nil {
+					return skipNext(r, depth+1)
+				}
+				return decodeNext(r, v.FieldByIndex(f.index), depth+1)
+			})
+		default:
+			return mismatch()
+		}
+	}
+	return nil
+}
+
+func decodeNext(r reader, v reflect.Value, depth int) error {
+	it, err := r.next()
+	if err != nil {
+		return err
+	}
+	return decodeItem(r, it, v, depth)
+}
+
+func skipNext(r reader, depth int) error {
+	it, err := r.next()
+	if err != nil {
+		return err
+	}
+	return skipItem(r, it, depth)
+}
+
+func skipItem(r reader, it item, depth int) error {
+	_, err := decodeAny(r, it, depth)
+	return err
+}
+
+// decodeAny decodes item into a value of the generic type: nil, bool,
+// int64, uint64 (only if the value does not fit int64), float64, string,
+// []byte, []interface{}, map[string]interface{} or map[interface{}]interface{}
+// (if not all keys are strings).
+func decodeAny(r reader, it item, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errDepth
+	}
+	switch it.kind {
+	case kindNil:
+		return nil, nil
+	case kindBool:
+		return it.b, nil
+	case kindInt:
+		return it.i, nil
+	case kindUint:
+		if it.u <= math.MaxInt64 {
+			return int64(it.u), nil
+		}
+		return it.u, nil
+	case kindFloat:
+		return it.f, nil
+	case kindString:
+		return string(it.s), nil
+	case kindBytes:
+		return append([]byte{}, it.s...), nil
+
+	case kindArray:
+		var a []interface{}
+		if it.n >= 0 && it.n <= r.remaining() {
+			a = make([]interface{}, 0, it.n)
+		}
+		err := elems(r, it.n, func(x item) error {
+			v, err := decodeAny(r, x, depth+1)
+			a = append(a, v)
+			return err
+		})
+		if a == nil {
+			a = []interface{}{}
+		}
+		return a, err
+
+	case kindMap:
+		var (
+			keys   []interface{}
+			values []interface{}
+			str    = true
+		)
+		err := elems(r, it.n, func(key item) error {
+			k, err := decodeAny(r, key, depth+1)
+			if err != nil {
+				return err
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return fmt.Errorf("wscodec: map key of type %T", k)
+			}
+			_, isStr := k.(string)
+			str = str && isStr
+			x, err := r.next()
+			if err != nil {
+				return err
+			}
+			v, err := decodeAny(r, x, depth+1)
+			keys, values = append(keys, k), append(values, v)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if str {
+			m := make(map[string]interface{}, len(keys))
+			for i, k := range keys {
+				m[k.(string)] = values[i]
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, len(keys))
+		for i, k := range keys {
+			m[k] = values[i]
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("wscodec: unexpected %s", it.kind)
+}
@@ -0,0 +1,349 @@
+package wscodec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+func unhex(s string) []byte {
+	p, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestVectors(t *testing.T) {
+	for _, test := range []struct {
+		codec Codec
+		value interface{}
+		data  string
+	}{
+		// Examples from RFC 8949, Appendix A.
+		{CBOR, int64(0), "00"},
+		{CBOR, int64(23), "17"},
+		{CBOR, int64(24), "1818"},
+		{CBOR, int64(1000), "1903e8"},
+		{CBOR, int64(1000000), "1a000f4240"},
+		{CBOR, int64(1000000000000), "1b000000e8d4a51000"},
+		{CBOR, uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{CBOR, int64(-1), "20"},
+		{CBOR, int64(-1000), "3903e7"},
+		{CBOR, 1.1, "fb3ff199999999999a"},
+		{CBOR, false, "f4"},
+		{CBOR, nil, "f6"},
+		{CBOR, []byte{1, 2, 3, 4}, "4401020304"},
+		{CBOR, "IETF", "6449455446"},
+		{CBOR, "ü", "62c3bc"},
+		{CBOR, []interface{}{}, "80"},
+		{CBOR, []interface{}{int64(1), []interface{}{int64(2), int64(3)}}, "8201820203"},
+		{CBOR, map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}, "a26161016162820203"},
+
+		// Examples from MessagePack specification and its test suite.
+		{MsgPack, map[string]interface{}{"compact": true, "schema": int64(0)}, "82 a7 636f6d70616374 c3 a6 736368656d61 00"},
+		{MsgPack, int64(127), "7f"},
+		{MsgPack, int64(128), "cc80"},
+		{MsgPack, int64(65536), "ce00010000"},
+		{MsgPack, int64(-32), "e0"},
+		{MsgPack, int64(-33), "d0df"},
+		{MsgPack, int64(-32769), "d2ffff7fff"},
+		{MsgPack, uint64(math.MaxUint64), "cfffffffffffffffff"},
+		{MsgPack, 0.5, "cb3fe0000000000000"},
+		{MsgPack, nil, "c0"},
+		{MsgPack, "a", "a161"},
+		{MsgPack, []byte{1}, "c40101"},
+		{MsgPack, []interface{}{int64(1), "a"}, "9201a161"},
+		{MsgPack, strings.Repeat("x", 32), "d920" + strings.Repeat("78", 32)},
+	} {
+		name := test.codec.Name() + " " + test.data
+		data, err := test.codec.Marshal(test.value)
+		if err != nil {
+			t.Errorf("%s: Marshal() error: %v", name, err)
+			continue
+		}
+		if exp := unhex(test.data); !bytes.Equal(data, exp) {
+			t.Errorf("%s: Marshal(%#v) = %x", name, test.value, data)
+		}
+		var v interface{}
+		if err := test.codec.Unmarshal(unhex(test.data), &v); err != nil {
+			t.Errorf("%s: Unmarshal() error: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.value) {
+			t.Errorf("%s: Unmarshal() = %#v; want %#v", name, v, test.value)
+		}
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	for _, test := range []struct {
+		data  string
+		value interface{}
+	}{
+		// Half precision floats.
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"f9c400", -4.0},
+		{"f97c00", math.Inf(1)},
+		{"fa47c35000", 100000.0},
+
+		// Indefinite length items.
+		{"9f018202039f0405ffff", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+
+		// Tags are skipped.
+		{"c11a514b67b0", int64(1363896240)},
+
+		// Map with non-string keys.
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+	} {
+		var v interface{}
+		if err := CBOR.Unmarshal(unhex(test.data), &v); err != nil {
+			t.Errorf("%s: Unmarshal() error: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.value) {
+			t.Errorf("%s: Unmarshal() = %#v; want %#v", test.data, v, test.value)
+		}
+	}
+}
+
+type Base struct {
+	ID uint32 `json:"id"`
+}
+
+type message struct {
+	Base
+	Type     string            `json:"type"`
+	Text     string            `json:"text,omitempty" msgpack:"t,omitempty" cbor:"x,omitempty"`
+	Score    float64           `json:"score"`
+	Delta    int8              `json:"delta"`
+	Tags     []string          `json:"tags"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Payload  []byte            `json:"payload"`
+	Digest   [4]byte           `json:"digest"`
+	Reply    *message          `json:"reply,omitempty"`
+	Any      interface{}       `json:"any"`
+	Internal string            `json:"-"`
+	private  int
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := message{
+		Base:    Base{ID: 42},
+		Type:    "chat",
+		Text:    "hello",
+		Score:   0.25,
+		Delta:   -3,
+		Tags:    []string{"a", "b"},
+		Meta:    map[string]string{"k": "v"},
+		Payload: []byte{0, 1, 2},
+		Digest:  [4]byte{1, 2, 3, 4},
+		Reply:   &message{Type: "ack", Tags: []string{}},
+		Any:     []interface{}{"x", int64(1), map[string]interface{}{"y": true}},
+	}
+	for _, codec := range []Codec{JSON, MsgPack, CBOR} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			data, err := codec.Marshal(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out message
+			if err := codec.Unmarshal(data, &out); err != nil {
+				t.Fatal(err)
+			}
+			exp := in
+			if codec == JSON {
+				// JSON does not distinguish integers.
+				exp.Any = []interface{}{"x", float64(1), map[string]interface{}{"y": true}}
+			}
+			if !reflect.DeepEqual(out, exp) {
+				t.Fatalf("round trip mismatch:\n%+v\n%+v", out, exp)
+			}
+		})
+	}
+}
+
+func TestStructTags(t *testing.T) {
+	data, err := MsgPack.Marshal(message{Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := MsgPack.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["t"] != "hi" || m["id"] != int64(0) {
+		t.Fatalf("unexpected fields: %v", m)
+	}
+	for _, k := range []string{"Internal", "private", "meta", "reply", "text"} {
+		if _, has := m[k]; has {
+			t.Fatalf("unexpected field %q: %v", k, m)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var deep []byte
+	for i := 0; i < 2*maxDepth; i++ {
+		deep = append(deep, 0x81)
+	}
+	deep = append(deep, 0x00)
+
+	for _, test := range []struct {
+		name  string
+		codec Codec
+		data  []byte
+		into  interface{}
+	}{
+		{"truncated string", MsgPack, unhex("a3 6162"), new(string)},
+		{"truncated array", CBOR, unhex("83 01 02"), new([]int)},
+		{"huge array", MsgPack, unhex("dd ffffffff"), new([]int)},
+		{"trailing data", CBOR, unhex("01 02"), new(int)},
+		{"extension", MsgPack, unhex("d4 01 00"), new(interface{})},
+		{"overflow", MsgPack, unhex("cd 0100"), new(uint8)},
+		{"negative into uint", CBOR, unhex("20"), new(uint)},
+		{"type mismatch", CBOR, unhex("6161"), new(int)},
+		{"unexpected break", CBOR, unhex("ff"), new(interface{})},
+		{"too deep", CBOR, deep, new(interface{})},
+		{"non-pointer", MsgPack, unhex("00"), 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.codec.Unmarshal(test.data, test.into); err == nil {
+				t.Fatalf("no error")
+			}
+		})
+	}
+}
+
+func TestRawMessage(t *testing.T) {
+	type envelope struct {
+		Type    string     `json:"type"`
+		Payload RawMessage `json:"payload,omitempty"`
+	}
+	for _, codec := range []Codec{JSON, MsgPack, CBOR} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			payload, err := codec.Marshal(message{Type: "inner", Tags: []string{"x"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := codec.Marshal(envelope{"outer", payload})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var env envelope
+			if err := codec.Unmarshal(data, &env); err != nil {
+				t.Fatal(err)
+			}
+			if env.Type != "outer" || !bytes.Equal(env.Payload, payload) {
+				t.Fatalf("unexpected envelope: %s %x; want payload %x", env.Type, env.Payload, payload)
+			}
+			var m message
+			if err := codec.Unmarshal(env.Payload, &m); err != nil {
+				t.Fatal(err)
+			}
+			if m.Type != "inner" || len(m.Tags) != 1 {
+				t.Fatalf("unexpected payload: %+v", m)
+			}
+
+			if data, err = codec.Marshal(envelope{Type: "empty"}); err != nil {
+				t.Fatal(err)
+			}
+			env = envelope{}
+			if err := codec.Unmarshal(data, &env); err != nil {
+				t.Fatal(err)
+			}
+			if env.Payload != nil {
+				t.Fatalf("unexpected payload %x", env.Payload)
+			}
+		})
+	}
+}
+
+func TestRaw(t *testing.T) {
+	data, err := Raw.Marshal("hello")
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Marshal() = %q, %v", data, err)
+	}
+	var p []byte
+	if err := Raw.Unmarshal(data, &p); err != nil || string(p) != "hello" {
+		t.Fatalf("Unmarshal() = %q, %v", p, err)
+	}
+	if _, err := Raw.Marshal(42); err != ErrRawType {
+		t.Fatalf("Marshal(42) error is %v", err)
+	}
+}
+
+type request struct {
+	Op   string `json:"op"`
+	A, B int
+}
+
+type response struct {
+	Result int `json:"result"`
+}
+
+func TestHandler(t *testing.T) {
+	h := &Handler[request, response]{
+		Handle: func(conn *easyws.Conn, in request) (response, error) {
+			switch in.Op {
+			case "add":
+				return response{in.A + in.B}, nil
+			case "noop":
+				return response{}, ErrNoReply
+			}
+			return response{}, errors.New("unknown op")
+		},
+		Codecs: Subprotocols("calc", JSON, MsgPack, CBOR),
+	}
+	mux := easyws.NewProtocolMux()
+	h.Register(mux)
+
+	for _, codec := range []Codec{JSON, MsgPack, CBOR} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			ws := wstest.New(t, mux)
+			resp := ws.Upgrade(wstest.Request{Protocols: []string{"calc." + codec.Name()}})
+			if p := resp.Header.Get("Sec-WebSocket-Protocol"); p != "calc."+codec.Name() {
+				t.Fatalf("unexpected protocol %q", p)
+			}
+			send := func(v interface{}) {
+				p, err := codec.Marshal(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ws.Write(wstest.ClientFrame(codec.OpCode(), true, p))
+			}
+
+			send(request{Op: "noop"})
+			send(request{Op: "add", A: 2, B: 3})
+			exp, _ := codec.Marshal(response{5})
+			ws.ExpectFrame(codec.OpCode(), exp)
+
+			ws.Write(wstest.ClientFrame(codec.OpCode(), true, []byte("{")))
+			ws.ExpectFrame(easyws.OpClose, easyws.NewCloseFrameBody(easyws.StatusUnsupportedData, "invalid message"))
+			ws.ExpectClosed()
+		})
+	}
+
+	t.Run("decode error reply", func(t *testing.T) {
+		h := *h
+		h.OnDecodeError = func(_ *easyws.Conn, err error) interface{} {
+			return map[string]string{"error": "bad request"}
+		}
+		ws := wstest.New(t, &h)
+		ws.Upgrade(wstest.Request{})
+		ws.Write(wstest.Text("{"))
+		ws.ExpectText(`{"error":"bad request"}`)
+	})
+}
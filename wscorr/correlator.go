@@ -0,0 +1,137 @@
+// Package wscorr correlates requests sent over WebSocket connections with
+// their replies.
+//
+// Correlator is the transport agnostic core: it assigns request IDs, tracks
+// pending requests until replies or deadlines and fails them when the
+// connection is closed. Peer layers a simple envelope protocol encoded with
+// any wscodec.Codec on top of it, and Handler serves Peers to the clients of
+// easyws server.
+package wscorr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned for requests which were pending when the connection
+// was closed, and for requests made after that.
+var ErrClosed = errors.New("wscorr: connection closed")
+
+type result[T any] struct {
+	reply T
+	err   error
+}
+
+// Correlator tracks pending requests and matches them with replies of type
+// T by request ID. IDs are assigned sequentially starting from 1, so zero
+// could be used to mark messages which are not requests.
+//
+// The zero value is ready to use. Correlator is safe for concurrent use.
+type Correlator[T any] struct {
+	// Timeout limits the time Do waits for the reply if the context passed
+	// to it has no deadline. Zero means no limit.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan result[T]
+	closed  bool
+}
+
+// Do assigns an ID to the new request, calls send with it and waits for the
+// reply passed to Resolve or the error passed to Reject. If ctx is done
+// first, its error is returned. If the correlator is closed, ErrClosed is
+// returned.
+func (c *Correlator[T]) Do(ctx context.Context, send func(id uint64) error) (reply T, err error) {
+	if _, has := ctx.Deadline(); !has && c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return reply, ErrClosed
+	}
+	if c.pending == nil {
+		c.pending = make(map[uint64]chan result[T])
+	}
+	c.seq++
+	id := c.seq
+	ch := make(chan result[T], 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := send(id); err != nil {
+		c.forget(id)
+		return reply, err
+	}
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			return reply, ErrClosed
+		}
+		return r.reply, r.err
+	case <-ctx.Done():
+		c.forget(id)
+		return reply, ctx.Err()
+	}
+}
+
+func (c *Correlator[T]) forget(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// Resolve passes the reply to the pending request with given ID. It returns
+// false if there is no such request, for example if it is timed out.
+func (c *Correlator[T]) Resolve(id uint64, reply T) bool {
+	return c.complete(id, result[T]{reply: reply})
+}
+
+// Reject makes the pending request with given ID fail with err. It returns
+// false if there is no such request.
+func (c *Correlator[T]) Reject(id uint64, err error) bool {
+	return c.complete(id, result[T]{err: err})
+}
+
+func (c *Correlator[T]) complete(id uint64, r result[T]) bool {
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if ok {
+		ch <- r
+	}
+	return ok
+}
+
+// Pending returns the number of pending requests.
+func (c *Correlator[T]) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Close makes pending and subsequent requests fail with ErrClosed. It is
+// safe to call Close multiple times.
+func (c *Correlator[T]) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// Closed reports whether Close was called.
+func (c *Correlator[T]) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
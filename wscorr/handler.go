@@ -0,0 +1,131 @@
+package wscorr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wscodec"
+)
+
+// Handler is an easyws.IEasyWs which serves a Peer for every client
+// connection. Peers could be used to make requests to the clients.
+type Handler struct {
+	// Handle serves requests and one-way messages of the clients. If nil,
+	// requests are replied with an error.
+	Handle HandlerFunc
+
+	// Codecs maps subprotocols to the codecs used for their connections.
+	Codecs map[string]wscodec.Codec
+
+	// Codec is used for connections whose subprotocol is not in Codecs. If
+	// nil, wscodec.JSON is used.
+	Codec wscodec.Codec
+
+	// Timeout is passed to Peer.SetTimeout of the client peers.
+	Timeout time.Duration
+
+	// Connected and Disconnected are called when a client connection is
+	// opened and closed.
+	Connected    func(conn *easyws.Conn, p *Peer)
+	Disconnected func(conn *easyws.Conn, p *Peer)
+
+	mu    sync.Mutex
+	peers map[*easyws.Conn]*Peer
+}
+
+// Register registers h within m for every subprotocol of h.Codecs.
+func (h *Handler) Register(m *easyws.ProtocolMux) {
+	for p := range h.Codecs {
+		m.Handle(p, h)
+	}
+}
+
+// CodecOf returns the codec used for conn.
+func (h *Handler) CodecOf(conn *easyws.Conn) wscodec.Codec {
+	if conn != nil {
+		if c, ok := h.Codecs[conn.Handshake().Protocol]; ok {
+			return c
+		}
+	}
+	if h.Codec != nil {
+		return h.Codec
+	}
+	return wscodec.JSON
+}
+
+// Peer returns the peer of the client connected with conn. It returns nil if
+// conn is not served by h or is closed.
+func (h *Handler) Peer(conn *easyws.Conn) *Peer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.peers[conn]
+}
+
+// OnOpen implements easyws.IEasyWsConn.
+func (h *Handler) OnOpen(conn *easyws.Conn) error {
+	p := NewPeer(h.CodecOf(conn), conn.WriteMessage, h.Handle)
+	p.SetTimeout(h.Timeout)
+	h.mu.Lock()
+	if h.peers == nil {
+		h.peers = make(map[*easyws.Conn]*Peer)
+	}
+	h.peers[conn] = p
+	h.mu.Unlock()
+	if h.Connected != nil {
+		h.Connected(conn, p)
+	}
+	return nil
+}
+
+// OnDisconnect implements easyws.IEasyWsConn.
+func (h *Handler) OnDisconnect(conn *easyws.Conn, err error) {
+	h.mu.Lock()
+	p := h.peers[conn]
+	delete(h.peers, conn)
+	h.mu.Unlock()
+	if p == nil {
+		return
+	}
+	p.Close()
+	if h.Disconnected != nil {
+		h.Disconnected(conn, p)
+	}
+}
+
+// OnMessage implements easyws.IEasyWsMessage. Messages which could not be
+// decoded close the connection with StatusUnsupportedData.
+func (h *Handler) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	p := h.Peer(conn)
+	if p == nil {
+		// Not served by NetHandler, so requests to the client are not
+		// possible.
+		p = NewPeer(h.CodecOf(conn), func(easyws.OpCode, []byte) error { return ErrClosed }, h.Handle)
+		p.Close()
+	}
+	ctx := context.Background()
+	if conn != nil {
+		ctx = conn.Context()
+	}
+	reply, err := p.Receive(ctx, msg)
+	if err != nil {
+		return easyws.CloseReply(easyws.StatusUnsupportedData, err.Error())
+	}
+	if reply == nil {
+		return nil, 0, nil
+	}
+	return reply, p.codec.OpCode(), nil
+}
+
+// OnReceive implements easyws.IEasyWs with the default codec and no peer, so
+// Handle can not send requests back.
+func (h *Handler) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return h.OnMessage(nil, 0, msg)
+}
+
+func (h *Handler) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (h *Handler) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (h *Handler) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (h *Handler) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (h *Handler) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
@@ -0,0 +1,225 @@
+package wscorr
+
+import (
+	"context"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wscodec"
+)
+
+// Message is the envelope exchanged by Peers.
+type Message struct {
+	// ID is the identifier of the request. It is zero for one-way messages
+	// and replies.
+	ID uint64 `json:"id,omitempty"`
+
+	// ReplyTo is the ID of the request the message is the reply for.
+	ReplyTo uint64 `json:"re,omitempty"`
+
+	// Type is the type of the request.
+	Type string `json:"type,omitempty"`
+
+	// Payload is the encoded body of the message.
+	Payload wscodec.RawMessage `json:"payload,omitempty"`
+
+	// Error is the text of the error the request failed with.
+	Error string `json:"error,omitempty"`
+}
+
+// RemoteError is returned by Peer.Request when the remote side fails to
+// handle the request.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "wscorr: remote error: " + e.Message
+}
+
+// Request is a request or a one-way message received by Peer.
+type Request struct {
+	// Peer is the peer which received the request.
+	Peer *Peer
+
+	// Type and Payload are fields of the received Message.
+	Type    string
+	Payload wscodec.RawMessage
+
+	// OneWay reports whether the remote side does not wait for the reply.
+	OneWay bool
+}
+
+// Bind decodes the payload into v. It does nothing if the payload is empty.
+func (r *Request) Bind(v interface{}) error {
+	if len(r.Payload) == 0 {
+		return nil
+	}
+	return r.Peer.codec.Unmarshal(r.Payload, v)
+}
+
+// HandlerFunc handles the request received by Peer. Returned value is
+// encoded as the payload of the reply; nil value is replied with an empty
+// acknowledgement. Returned error text is sent to the remote side. Result of
+// handling one-way messages is ignored.
+type HandlerFunc func(ctx context.Context, r *Request) (interface{}, error)
+
+// Peer is one side of a connection exchanging Messages. It sends requests
+// and waits for their replies, and serves requests of the remote side with
+// its HandlerFunc.
+//
+// Peer works on top of any connection: Send passes encoded messages to the
+// connection and Receive handles the messages read from it.
+type Peer struct {
+	codec   wscodec.Codec
+	send    func(op easyws.OpCode, p []byte) error
+	handler HandlerFunc
+	corr    Correlator[*Message]
+}
+
+// NewPeer returns Peer which encodes messages with codec and sends them
+// with send. Requests of the remote side are served by h, which could be
+// nil if the remote side makes no requests.
+func NewPeer(codec wscodec.Codec, send func(op easyws.OpCode, p []byte) error, h HandlerFunc) *Peer {
+	if codec == nil {
+		codec = wscodec.JSON
+	}
+	return &Peer{
+		codec:   codec,
+		send:    send,
+		handler: h,
+	}
+}
+
+// SetTimeout sets the time Request waits for the reply if the context
+// passed to it has no deadline. Zero means no limit.
+func (p *Peer) SetTimeout(d time.Duration) {
+	p.corr.Timeout = d
+}
+
+// Codec returns the codec of the peer.
+func (p *Peer) Codec() wscodec.Codec {
+	return p.codec
+}
+
+// Request sends the request of given type with payload encoded from req and
+// waits for the reply. Payload of the reply is decoded into resp, unless it
+// is nil; thus nil resp just waits for the acknowledgement.
+//
+// Request fails with ctx error if the reply is not received in time, with
+// ErrClosed if the peer is closed, and with RemoteError if the remote side
+// failed to handle the request.
+//
+// Request must not be made synchronously from HandlerFunc of the same peer
+// if the connection is read by the goroutine calling the HandlerFunc.
+func (p *Peer) Request(ctx context.Context, typ string, req, resp interface{}) error {
+	payload, err := p.encode(req)
+	if err != nil {
+		return err
+	}
+	reply, err := p.corr.Do(ctx, func(id uint64) error {
+		return p.write(&Message{
+			ID:      id,
+			Type:    typ,
+			Payload: payload,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if reply.Error != "" {
+		return &RemoteError{Message: reply.Error}
+	}
+	if resp == nil || len(reply.Payload) == 0 {
+		return nil
+	}
+	return p.codec.Unmarshal(reply.Payload, resp)
+}
+
+// Send sends one-way message of given type with payload encoded from v.
+func (p *Peer) Send(typ string, v interface{}) error {
+	payload, err := p.encode(v)
+	if err != nil {
+		return err
+	}
+	return p.write(&Message{
+		Type:    typ,
+		Payload: payload,
+	})
+}
+
+func (p *Peer) encode(v interface{}) (wscodec.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return p.codec.Marshal(v)
+}
+
+func (p *Peer) write(m *Message) error {
+	if p.Closed() {
+		return ErrClosed
+	}
+	data, err := p.codec.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return p.send(p.codec.OpCode(), data)
+}
+
+// Receive handles message data read from the connection. If it is a reply,
+// it is passed to the pending request; replies to unknown requests are
+// ignored. Otherwise the request is passed to the HandlerFunc and the
+// encoded reply is returned, which must be sent to the connection by the
+// caller. Returned error is non-nil only if the message could not be
+// decoded.
+func (p *Peer) Receive(ctx context.Context, data []byte) ([]byte, error) {
+	m := new(Message)
+	if err := p.codec.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.ReplyTo != 0 {
+		p.corr.Resolve(m.ReplyTo, m)
+		return nil, nil
+	}
+	if p.handler == nil {
+		if m.ID == 0 {
+			return nil, nil
+		}
+		return p.codec.Marshal(&Message{ReplyTo: m.ID, Error: "no handler"})
+	}
+
+	v, err := p.handler(ctx, &Request{
+		Peer:    p,
+		Type:    m.Type,
+		Payload: m.Payload,
+		OneWay:  m.ID == 0,
+	})
+	if m.ID == 0 {
+		return nil, nil
+	}
+	reply := &Message{ReplyTo: m.ID}
+	if err == nil {
+		reply.Payload, err = p.encode(v)
+	}
+	if err != nil {
+		reply.Payload = nil
+		reply.Error = err.Error()
+	}
+	return p.codec.Marshal(reply)
+}
+
+// Pending returns the number of requests waiting for the reply.
+func (p *Peer) Pending() int {
+	return p.corr.Pending()
+}
+
+// Close makes pending and subsequent requests fail with ErrClosed. It must
+// be called when the connection is closed.
+func (p *Peer) Close() {
+	p.corr.Close()
+}
+
+// Closed reports whether the peer is closed.
+func (p *Peer) Closed() bool {
+	return p.corr.Closed()
+}
@@ -0,0 +1,217 @@
+package wscorr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wscodec"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+func TestCorrelator(t *testing.T) {
+	var c Correlator[string]
+	ids := make(chan uint64, 1)
+	send := func(id uint64) error {
+		ids <- id
+		return nil
+	}
+	type result struct {
+		reply string
+		err   error
+	}
+	do := func(ctx context.Context) <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			r, err := c.Do(ctx, send)
+			ch <- result{r, err}
+		}()
+		return ch
+	}
+
+	res := do(context.Background())
+	id := <-ids
+	if c.Resolve(id+1, "wrong") {
+		t.Fatalf("unknown request resolved")
+	}
+	if !c.Resolve(id, "pong") {
+		t.Fatalf("pending request is not resolved")
+	}
+	if r := <-res; r.reply != "pong" || r.err != nil {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+
+	errFailed := errors.New("failed")
+	res = do(context.Background())
+	c.Reject(<-ids, errFailed)
+	if r := <-res; r.err != errFailed {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+
+	c.Timeout = 10 * time.Millisecond
+	res = do(context.Background())
+	id = <-ids
+	if r := <-res; r.err != context.DeadlineExceeded {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if c.Resolve(id, "late") {
+		t.Fatalf("timed out request resolved")
+	}
+
+	errSend := errors.New("send failed")
+	if _, err := c.Do(context.Background(), func(uint64) error { return errSend }); err != errSend {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := c.Pending(); n != 0 {
+		t.Fatalf("unexpected pending requests: %d", n)
+	}
+
+	c.Timeout = 0
+	res = do(context.Background())
+	<-ids
+	c.Close()
+	if r := <-res; r.err != ErrClosed {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if _, err := c.Do(context.Background(), send); err != ErrClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+type sum struct {
+	A, B int
+}
+
+func serve(ctx context.Context, r *Request) (interface{}, error) {
+	switch r.Type {
+	case "sum":
+		var s sum
+		if err := r.Bind(&s); err != nil {
+			return nil, err
+		}
+		return s.A + s.B, nil
+	case "ack":
+		return nil, nil
+	}
+	return nil, errors.New("unknown type " + r.Type)
+}
+
+func TestHandler(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		peers = make(chan *Peer, 1)
+		got   []string
+	)
+	h := &Handler{
+		Handle: func(ctx context.Context, r *Request) (interface{}, error) {
+			mu.Lock()
+			got = append(got, r.Type)
+			mu.Unlock()
+			return serve(ctx, r)
+		},
+		Codecs:    wscodec.Subprotocols("corr", wscodec.JSON, wscodec.MsgPack),
+		Connected: func(_ *easyws.Conn, p *Peer) { peers <- p },
+	}
+	mux := easyws.NewProtocolMux()
+	h.Register(mux)
+
+	t.Run("client requests", func(t *testing.T) {
+		ws := wstest.Dial(t, mux, wstest.Request{Protocols: []string{"corr.json"}})
+		<-peers
+
+		ws.Write(wstest.Text(`{"id":7,"type":"sum","payload":{"A":1,"B":2}}`))
+		ws.ExpectText(`{"re":7,"payload":3}`)
+		ws.Write(wstest.Text(`{"id":8,"type":"ack"}`))
+		ws.ExpectText(`{"re":8}`)
+		ws.Write(wstest.Text(`{"id":9,"type":"nope"}`))
+		ws.ExpectText(`{"re":9,"error":"unknown type nope"}`)
+		ws.Write(wstest.Text(`{"type":"ack"}`))
+		ws.ExpectNothing()
+		ws.Write(wstest.Text(`{"re":100,"payload":1}`))
+		ws.ExpectNothing()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) != 4 {
+			t.Fatalf("unexpected handled requests: %q", got)
+		}
+
+		ws.Write(wstest.Text(`[`))
+		ws.ExpectClose(easyws.StatusUnsupportedData)
+	})
+
+	t.Run("server requests", func(t *testing.T) {
+		codec := wscodec.MsgPack
+		ws := wstest.Dial(t, mux, wstest.Request{Protocols: []string{"corr.msgpack"}})
+		p := <-peers
+
+		errs := make(chan error, 1)
+		var res int
+		go func() { errs <- p.Request(context.Background(), "sum", sum{20, 22}, &res) }()
+
+		var req Message
+		if err := codec.Unmarshal(ws.WaitFrame().Payload, &req); err != nil {
+			t.Fatal(err)
+		}
+		var s sum
+		if err := codec.Unmarshal(req.Payload, &s); err != nil || req.Type != "sum" || s != (sum{20, 22}) {
+			t.Fatalf("unexpected request: %+v %+v %v", req, s, err)
+		}
+		payload, _ := codec.Marshal(42)
+		reply, _ := codec.Marshal(Message{ReplyTo: req.ID, Payload: payload})
+		ws.Write(wstest.ClientFrame(easyws.OpBinary, true, reply))
+		if err := <-errs; err != nil || res != 42 {
+			t.Fatalf("Request() = %d, %v", res, err)
+		}
+
+		go func() { errs <- p.Request(context.Background(), "fail", nil, nil) }()
+		for p.Pending() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		ws.Close()
+		if err := <-errs; err != ErrClosed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if h.Peer(nil) != nil {
+			t.Fatalf("unexpected peer")
+		}
+		if err := p.Send("ack", nil); err != ErrClosed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPeers(t *testing.T) {
+	// Connect two peers directly to make requests in both directions.
+	var a, b *Peer
+	link := func(from, to **Peer) func(easyws.OpCode, []byte) error {
+		return func(_ easyws.OpCode, p []byte) error {
+			data := append([]byte(nil), p...)
+			go func() {
+				ctx := context.Background()
+				if reply, _ := (*to).Receive(ctx, data); reply != nil {
+					(*from).Receive(ctx, reply)
+				}
+			}()
+			return nil
+		}
+	}
+	a = NewPeer(wscodec.CBOR, link(&a, &b), serve)
+	b = NewPeer(wscodec.CBOR, link(&b, &a), serve)
+
+	var res int
+	if err := a.Request(context.Background(), "sum", sum{1, 2}, &res); err != nil || res != 3 {
+		t.Fatalf("a.Request() = %d, %v", res, err)
+	}
+	if err := b.Request(context.Background(), "sum", sum{3, 4}, &res); err != nil || res != 7 {
+		t.Fatalf("b.Request() = %d, %v", res, err)
+	}
+	err := b.Request(context.Background(), "nope", nil, nil)
+	var re *RemoteError
+	if !errors.As(err, &re) || re.Message != "unknown type nope" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build go1.21
+
+package wslog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Slog returns easyws.Logger which logs events to l.
+func Slog(l *slog.Logger) easyws.Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Log(level easyws.LogLevel, msg string, fields ...easyws.Field) {
+	ctx := context.Background()
+	lvl := slog.Level(level)
+	if !s.l.Enabled(ctx, lvl) {
+		return
+	}
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	s.l.LogAttrs(ctx, lvl, msg, attrs...)
+}
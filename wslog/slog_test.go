@@ -0,0 +1,29 @@
+//go:build go1.21
+
+package wslog
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/EternalVow/easyws"
+)
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := Slog(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+	l.Log(easyws.LevelDebug, "dropped")
+	l.Log(easyws.LevelError, "hello", easyws.Field{Key: easyws.FieldPath, Value: "/chat"})
+
+	if exp := "level=ERROR msg=hello path=/chat\n"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q; want %q", buf.String(), exp)
+	}
+}
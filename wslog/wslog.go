@@ -0,0 +1,47 @@
+// Package wslog provides adapters of popular logging libraries to the
+// easyws.Logger interface.
+package wslog
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Zap returns easyws.Logger which logs events to l.
+func Zap(l *zap.Logger) easyws.Logger {
+	return zapLogger{l}
+}
+
+type zapLogger struct {
+	l *zap.Logger
+}
+
+func (z zapLogger) Log(level easyws.LogLevel, msg string, fields ...easyws.Field) {
+	ce := z.l.Check(zapLevel(level), msg)
+	if ce == nil {
+		return
+	}
+	zf := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		if err, ok := f.Value.(error); ok {
+			zf[i] = zap.NamedError(f.Key, err)
+		} else {
+			zf[i] = zap.Any(f.Key, f.Value)
+		}
+	}
+	ce.Write(zf...)
+}
+
+func zapLevel(level easyws.LogLevel) zapcore.Level {
+	switch {
+	case level < easyws.LevelInfo:
+		return zapcore.DebugLevel
+	case level < easyws.LevelWarn:
+		return zapcore.InfoLevel
+	case level < easyws.LevelError:
+		return zapcore.WarnLevel
+	}
+	return zapcore.ErrorLevel
+}
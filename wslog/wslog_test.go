@@ -0,0 +1,34 @@
+package wslog
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/EternalVow/easyws"
+)
+
+func TestZap(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := Zap(zap.New(core))
+	l.Log(easyws.LevelDebug, "dropped")
+	l.Log(easyws.LevelWarn, "hello",
+		easyws.Field{Key: easyws.FieldConnID, Value: uint64(42)},
+		easyws.Field{Key: easyws.FieldError, Value: errors.New("oops")},
+	)
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	e := entries[0]
+	if e.Level != zapcore.WarnLevel || e.Message != "hello" {
+		t.Fatalf("unexpected entry: %v %q", e.Level, e.Message)
+	}
+	fields := e.ContextMap()
+	if fields[easyws.FieldConnID] != uint64(42) || fields[easyws.FieldError] != "oops" {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+}
@@ -0,0 +1,118 @@
+package wsrouter
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/EternalVow/easyws"
+)
+
+// PanicError is the error returned by Recover for recovered panics.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("wsrouter: panic: %v", e.Value)
+}
+
+// Recover returns middleware which recovers panics of the wrapped handlers
+// and returns them as PanicError. Thus the client receives CodeInternal
+// error and Router.OnError is called.
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (v interface{}, err error) {
+			defer func() {
+				if x := recover(); x != nil {
+					v, err = nil, &PanicError{
+						Value: x,
+						Stack: debug.Stack(),
+					}
+				}
+			}()
+			return next(c)
+		}
+	}
+}
+
+// Keys of the fields logged by Logger middleware in addition to the
+// easyws.FieldConnID and easyws.FieldError.
+const (
+	FieldEvent    = "event"
+	FieldType     = "type"
+	FieldID       = "id"
+	FieldDuration = "duration"
+)
+
+// Logger returns middleware which logs handled events to l. Events are
+// logged with easyws.LevelDebug, failed ones with easyws.LevelWarn.
+func Logger(l easyws.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (interface{}, error) {
+			start := time.Now()
+			v, err := next(c)
+
+			fields := make([]easyws.Field, 0, 6)
+			if c.Conn != nil {
+				fields = append(fields, easyws.Field{Key: easyws.FieldConnID, Value: c.Conn.ID()})
+			}
+			fields = append(fields, easyws.Field{Key: FieldEvent, Value: c.Event.String()})
+			if c.Type != "" {
+				fields = append(fields, easyws.Field{Key: FieldType, Value: c.Type})
+			}
+			if c.ID != "" {
+				fields = append(fields, easyws.Field{Key: FieldID, Value: c.ID})
+			}
+			fields = append(fields, easyws.Field{Key: FieldDuration, Value: time.Since(start)})
+			level := easyws.LevelDebug
+			if err != nil {
+				level = easyws.LevelWarn
+				fields = append(fields, easyws.Field{Key: easyws.FieldError, Value: err.Error()})
+			}
+			l.Log(level, "websocket event handled", fields...)
+
+			return v, err
+		}
+	}
+}
+
+// Timing returns middleware which calls observe with the duration of every
+// handled event and the error it was handled with.
+func Timing(observe func(c *Context, d time.Duration, err error)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (interface{}, error) {
+			start := time.Now()
+			v, err := next(c)
+			observe(c, time.Since(start), err)
+			return v, err
+		}
+	}
+}
+
+// Require returns middleware which calls the wrapped handler only if check
+// returns nil error. Otherwise the error is returned, so if it is used with
+// Router.Use, failed check on EventOpen closes the connection.
+func Require(check func(c *Context) error) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (interface{}, error) {
+			if err := check(c); err != nil {
+				return nil, err
+			}
+			return next(c)
+		}
+	}
+}
+
+// Authenticated returns middleware which requires the connection to have
+// the identity set by easyws.Authenticator. Anonymous clients receive
+// CodeUnauthorized error.
+func Authenticated() Middleware {
+	return Require(func(c *Context) error {
+		if c.Conn == nil || c.Conn.Identity() == nil {
+			return Errorf(CodeUnauthorized, "authentication required")
+		}
+		return nil
+	})
+}
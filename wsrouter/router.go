@@ -0,0 +1,398 @@
+// Package wsrouter dispatches easyws messages by their type.
+//
+// Messages are exchanged as envelopes, which carry the message type, an
+// optional request identifier and the payload. Router decodes envelopes with
+// wscodec codecs, calls the handler registered for the envelope type and
+// sends its result back within an envelope of the same type and identifier.
+// Errors are sent to the client as error envelopes.
+//
+// Handlers and connection lifecycle callbacks could be wrapped by
+// Middleware, like Recover, Logger, Timing or Require.
+package wsrouter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wscodec"
+)
+
+// Envelope is the message exchanged between Router and clients.
+type Envelope struct {
+	// Type is the type of the message which selects its handler. Replies
+	// have the type of the request.
+	Type string `json:"type"`
+
+	// ID is an optional identifier of the request, which is copied into the
+	// reply to match them.
+	ID string `json:"id,omitempty"`
+
+	// Payload is the encoded message body.
+	Payload wscodec.RawMessage `json:"payload,omitempty"`
+
+	// Error is set in replies for failed requests.
+	Error *Error `json:"error,omitempty"`
+}
+
+// Codes of the errors sent by Router and built-in middleware.
+const (
+	CodeBadRequest   = "bad_request"
+	CodeUnknownType  = "unknown_type"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeInternal     = "internal"
+)
+
+// TypeError is the type of the envelope sent in reply to a message which
+// could not be decoded as an envelope.
+const TypeError = "error"
+
+// Error is an error sent to the client within Envelope.
+//
+// Handlers return Error to make the client receive its code and message.
+// Other errors are reported to the client as CodeInternal errors without
+// details.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Errorf returns Error with given code and formatted message.
+func Errorf(code, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return "wsrouter: " + e.Code
+	}
+	return "wsrouter: " + e.Code + ": " + e.Message
+}
+
+// Event describes what Context is created for.
+type Event int
+
+// Events handled by Router.
+const (
+	EventOpen Event = iota
+	EventMessage
+	EventClose
+)
+
+// String returns textual representation of the event.
+func (e Event) String() string {
+	switch e {
+	case EventOpen:
+		return "open"
+	case EventMessage:
+		return "message"
+	case EventClose:
+		return "close"
+	}
+	return fmt.Sprintf("Event(%d)", int(e))
+}
+
+// Context holds the event being handled.
+type Context struct {
+	// Conn is the connection of the event. It is nil if Router is not served
+	// by NetHandler.
+	Conn *easyws.Conn
+
+	// Event is the kind of the event.
+	Event Event
+
+	// Type, ID and Payload are fields of the received envelope. They are
+	// empty for lifecycle events.
+	Type    string
+	ID      string
+	Payload wscodec.RawMessage
+
+	// Err is the error the connection was closed with. It is set only for
+	// EventClose.
+	Err error
+
+	// Codec is the codec of the connection.
+	Codec wscodec.Codec
+
+	values map[string]interface{}
+}
+
+// Bind decodes the payload into v. It does nothing if the payload is empty.
+// Returned error is CodeBadRequest Error.
+func (c *Context) Bind(v interface{}) error {
+	if len(c.Payload) == 0 {
+		return nil
+	}
+	if err := c.Codec.Unmarshal(c.Payload, v); err != nil {
+		return Errorf(CodeBadRequest, "invalid payload: %v", err)
+	}
+	return nil
+}
+
+// Set stores a value within the context. It could be used by middleware to
+// pass values to handlers.
+func (c *Context) Set(key string, v interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = v
+}
+
+// Get returns a value stored by Set.
+func (c *Context) Get(key string) (v interface{}, ok bool) {
+	v, ok = c.values[key]
+	return v, ok
+}
+
+// HandlerFunc handles an event. For EventMessage returned value is encoded
+// as the payload of the reply; nil value with nil error means no reply.
+// Returned error is sent to the client as an error envelope.
+//
+// For lifecycle events returned value is ignored. Error returned for
+// EventOpen closes the connection.
+type HandlerFunc func(c *Context) (interface{}, error)
+
+// Middleware wraps a HandlerFunc.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Typed returns HandlerFunc which decodes the payload into a value of type
+// In and passes it to fn.
+func Typed[In, Out any](fn func(c *Context, in In) (Out, error)) HandlerFunc {
+	return func(c *Context) (interface{}, error) {
+		var in In
+		if err := c.Bind(&in); err != nil {
+			return nil, err
+		}
+		return fn(c, in)
+	}
+}
+
+type route struct {
+	handler    HandlerFunc
+	middleware []Middleware
+}
+
+// Router is an easyws.IEasyWs which dispatches received envelopes to the
+// handlers registered for their types.
+//
+// Router must be configured before it is served.
+type Router struct {
+	// Codecs maps subprotocols to the codecs used for their connections.
+	Codecs map[string]wscodec.Codec
+
+	// Codec is used for connections whose subprotocol is not in Codecs. If
+	// nil, wscodec.JSON is used.
+	Codec wscodec.Codec
+
+	// OnError is called for errors which are not Error, including recovered
+	// panics and failures to encode replies. Such errors are sent to the
+	// client as CodeInternal errors without details.
+	OnError func(c *Context, err error)
+
+	middleware []Middleware
+	routes     map[string]route
+	open       route
+	close      route
+	notFound   route
+}
+
+// New returns a new Router.
+func New() *Router {
+	return &Router{
+		routes: make(map[string]route),
+	}
+}
+
+// Use appends middleware which wraps all handlers and lifecycle callbacks of
+// the router. Middleware is applied in the order it is added, so the first
+// one is the outermost.
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Handle registers the handler for messages of given type. Given middleware
+// wraps only this handler, inside the middleware added by Use.
+//
+// It panics if typ is empty or the handler for typ is already registered.
+func (r *Router) Handle(typ string, h HandlerFunc, mw ...Middleware) {
+	if typ == "" {
+		panic("wsrouter: empty message type")
+	}
+	if _, has := r.routes[typ]; has {
+		panic(fmt.Sprintf("wsrouter: multiple registrations for %q", typ))
+	}
+	if r.routes == nil {
+		r.routes = make(map[string]route)
+	}
+	r.routes[typ] = route{h, mw}
+}
+
+// HandleOpen registers the handler called when a connection is upgraded.
+func (r *Router) HandleOpen(h HandlerFunc, mw ...Middleware) {
+	r.open = route{h, mw}
+}
+
+// HandleClose registers the handler called when an upgraded connection is
+// closed.
+func (r *Router) HandleClose(h HandlerFunc, mw ...Middleware) {
+	r.close = route{h, mw}
+}
+
+// NotFound registers the handler for messages of unknown types. By default
+// CodeUnknownType error is sent.
+func (r *Router) NotFound(h HandlerFunc, mw ...Middleware) {
+	r.notFound = route{h, mw}
+}
+
+// Types returns the sorted list of registered message types.
+func (r *Router) Types() []string {
+	ts := make([]string, 0, len(r.routes))
+	for t := range r.routes {
+		ts = append(ts, t)
+	}
+	sort.Strings(ts)
+	return ts
+}
+
+// Register registers r within m for every subprotocol of r.Codecs.
+func (r *Router) Register(m *easyws.ProtocolMux) {
+	for p := range r.Codecs {
+		m.Handle(p, r)
+	}
+}
+
+// CodecOf returns the codec used for conn.
+func (r *Router) CodecOf(conn *easyws.Conn) wscodec.Codec {
+	if conn != nil {
+		if c, ok := r.Codecs[conn.Handshake().Protocol]; ok {
+			return c
+		}
+	}
+	if r.Codec != nil {
+		return r.Codec
+	}
+	return wscodec.JSON
+}
+
+func (r *Router) call(rt route, c *Context) (interface{}, error) {
+	h := rt.handler
+	if h == nil {
+		h = nop
+	}
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		h = rt.middleware[i](h)
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h(c)
+}
+
+func nop(*Context) (interface{}, error) { return nil, nil }
+
+func unknownType(c *Context) (interface{}, error) {
+	return nil, Errorf(CodeUnknownType, "unknown message type %q", c.Type)
+}
+
+// OnMessage implements easyws.IEasyWsMessage.
+func (r *Router) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	codec := r.CodecOf(conn)
+	c := &Context{
+		Conn:  conn,
+		Event: EventMessage,
+		Codec: codec,
+	}
+	var env Envelope
+	if err := codec.Unmarshal(msg, &env); err != nil {
+		c.Type = TypeError
+		return r.reply(c, nil, Errorf(CodeBadRequest, "invalid envelope: %v", err))
+	}
+	c.Type, c.ID, c.Payload = env.Type, env.ID, env.Payload
+	if env.Type == "" {
+		c.Type = TypeError
+		return r.reply(c, nil, Errorf(CodeBadRequest, "missing message type"))
+	}
+	rt, ok := r.routes[env.Type]
+	if !ok {
+		rt = r.notFound
+		if rt.handler == nil {
+			rt.handler = unknownType
+		}
+	}
+	v, err := r.call(rt, c)
+	return r.reply(c, v, err)
+}
+
+func (r *Router) reply(c *Context, v interface{}, err error) ([]byte, easyws.OpCode, error) {
+	if v == nil && err == nil {
+		return nil, 0, nil
+	}
+	env := Envelope{
+		Type: c.Type,
+		ID:   c.ID,
+	}
+	if err == nil {
+		env.Payload, err = c.Codec.Marshal(v)
+	}
+	if err != nil {
+		env.Payload = nil
+		env.Error = r.error(c, err)
+	}
+	p, err := c.Codec.Marshal(env)
+	if err != nil {
+		return nil, 0, err
+	}
+	return p, c.Codec.OpCode(), nil
+}
+
+func (r *Router) error(c *Context, err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if r.OnError != nil {
+		r.OnError(c, err)
+	}
+	return &Error{Code: CodeInternal, Message: "internal error"}
+}
+
+// OnOpen implements easyws.IEasyWsConn.
+func (r *Router) OnOpen(conn *easyws.Conn) error {
+	c := &Context{
+		Conn:  conn,
+		Event: EventOpen,
+		Codec: r.CodecOf(conn),
+	}
+	_, err := r.call(r.open, c)
+	return err
+}
+
+// OnDisconnect implements easyws.IEasyWsConn.
+func (r *Router) OnDisconnect(conn *easyws.Conn, err error) {
+	c := &Context{
+		Conn:  conn,
+		Event: EventClose,
+		Err:   err,
+		Codec: r.CodecOf(conn),
+	}
+	if _, err := r.call(r.close, c); err != nil {
+		r.error(c, err)
+	}
+}
+
+// OnReceive implements easyws.IEasyWs by routing msg decoded with the
+// default codec. Context.Conn is nil then.
+func (r *Router) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return r.OnMessage(nil, 0, msg)
+}
+
+func (r *Router) OnStart() (easyws.OpCode, error)          { return 0, nil }
+func (r *Router) OnConnect() (easyws.OpCode, error)        { return 0, nil }
+func (r *Router) OnUpgraded() (easyws.OpCode, error)       { return 0, nil }
+func (r *Router) OnShutdown() (easyws.OpCode, error)       { return 0, nil }
+func (r *Router) OnClose(err error) (easyws.OpCode, error) { return 0, nil }
@@ -0,0 +1,230 @@
+package wsrouter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wscodec"
+	"github.com/EternalVow/easyws/wstest"
+)
+
+type sum struct {
+	A, B int
+}
+
+func newRouter() *Router {
+	r := New()
+	r.Handle("sum", Typed(func(c *Context, in sum) (int, error) {
+		return in.A + in.B, nil
+	}))
+	r.Handle("fail", func(c *Context) (interface{}, error) {
+		return nil, Errorf(CodeForbidden, "not allowed")
+	})
+	r.Handle("crash", func(c *Context) (interface{}, error) {
+		return nil, errors.New("database is down")
+	})
+	r.Handle("notify", func(c *Context) (interface{}, error) {
+		return nil, nil
+	})
+	return r
+}
+
+func TestRouter(t *testing.T) {
+	var internal []error
+	r := newRouter()
+	r.OnError = func(c *Context, err error) {
+		internal = append(internal, err)
+	}
+	ws := wstest.New(t, r)
+	ws.Upgrade(wstest.Request{})
+
+	for _, test := range []struct {
+		in, out string
+	}{
+		{`{"type":"sum","id":"1","payload":{"A":2,"B":3}}`, `{"type":"sum","id":"1","payload":5}`},
+		{`{"type":"sum","payload":{"A":"x"}}`, `{"type":"sum","error":{"code":"bad_request","message":"invalid payload: json: cannot unmarshal string into Go struct field sum.A of type int"}}`},
+		{`{"type":"fail","id":"2"}`, `{"type":"fail","id":"2","error":{"code":"forbidden","message":"not allowed"}}`},
+		{`{"type":"crash","id":"3"}`, `{"type":"crash","id":"3","error":{"code":"internal","message":"internal error"}}`},
+		{`{"type":"nope"}`, `{"type":"nope","error":{"code":"unknown_type","message":"unknown message type \"nope\""}}`},
+		{`{"id":"4"}`, `{"type":"error","id":"4","error":{"code":"bad_request","message":"missing message type"}}`},
+		{`{"type":"notify"}`, ``},
+	} {
+		ws.Write(wstest.Text(test.in))
+		if test.out == "" {
+			ws.ExpectNothing()
+			continue
+		}
+		ws.ExpectText(test.out)
+	}
+	if len(internal) != 1 || internal[0].Error() != "database is down" {
+		t.Fatalf("unexpected OnError calls: %v", internal)
+	}
+
+	ws.Write(wstest.Text(`[`))
+	f, _ := ws.NextFrame()
+	if !strings.HasPrefix(string(f.Payload), `{"type":"error","error":{"code":"bad_request"`) {
+		t.Fatalf("unexpected reply to malformed envelope: %s", f.Payload)
+	}
+}
+
+func TestRouterCodecs(t *testing.T) {
+	r := newRouter()
+	r.Codecs = wscodec.Subprotocols("calc", wscodec.MsgPack, wscodec.CBOR)
+	mux := easyws.NewProtocolMux()
+	r.Register(mux)
+
+	for _, codec := range []wscodec.Codec{wscodec.MsgPack, wscodec.CBOR} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			ws := wstest.New(t, mux)
+			ws.Upgrade(wstest.Request{Protocols: []string{"calc." + codec.Name()}})
+
+			payload, _ := codec.Marshal(sum{A: 40, B: 2})
+			req, _ := codec.Marshal(Envelope{Type: "sum", ID: "x", Payload: payload})
+			ws.Write(wstest.ClientFrame(codec.OpCode(), true, req))
+
+			f, _ := ws.NextFrame()
+			if f.Header.OpCode != codec.OpCode() {
+				t.Fatalf("unexpected op code %v", f.Header.OpCode)
+			}
+			var env Envelope
+			if err := codec.Unmarshal(f.Payload, &env); err != nil {
+				t.Fatal(err)
+			}
+			var res int
+			if err := codec.Unmarshal(env.Payload, &res); err != nil {
+				t.Fatal(err)
+			}
+			if env.Type != "sum" || env.ID != "x" || env.Error != nil || res != 42 {
+				t.Fatalf("unexpected reply: %+v (%d)", env, res)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var trace []string
+	mark := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(c *Context) (interface{}, error) {
+				trace = append(trace, name+">"+c.Event.String())
+				v, err := next(c)
+				trace = append(trace, "<"+name)
+				return v, err
+			}
+		}
+	}
+	var timed []string
+	var logged []easyws.Field
+	var internal error
+
+	r := New()
+	r.Use(
+		Recover(),
+		mark("a"),
+		Logger(easyws.LoggerFunc(func(_ easyws.LogLevel, _ string, fields ...easyws.Field) {
+			logged = append(logged[:0], fields...)
+		})),
+		Timing(func(c *Context, d time.Duration, err error) {
+			timed = append(timed, c.Event.String())
+		}),
+	)
+	r.OnError = func(c *Context, err error) { internal = err }
+	r.HandleOpen(func(c *Context) (interface{}, error) {
+		trace = append(trace, "open")
+		return nil, nil
+	})
+	r.HandleClose(func(c *Context) (interface{}, error) {
+		trace = append(trace, "close")
+		return nil, nil
+	})
+	r.Handle("echo", func(c *Context) (interface{}, error) {
+		v, _ := c.Get("user")
+		trace = append(trace, "echo")
+		return v, nil
+	}, mark("b"), func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (interface{}, error) {
+			c.Set("user", "alice")
+			return next(c)
+		}
+	})
+	r.Handle("panic", func(c *Context) (interface{}, error) {
+		panic("boom")
+	})
+
+	ws := wstest.New(t, r)
+	ws.Upgrade(wstest.Request{})
+	ws.Write(wstest.Text(`{"type":"echo","id":"7"}`))
+	ws.ExpectText(`{"type":"echo","id":"7","payload":"alice"}`)
+	ws.Close()
+
+	exp := []string{
+		"a>open", "open", "<a",
+		"a>message", "b>message", "echo", "<b", "<a",
+		"a>close", "close", "<a",
+	}
+	if !reflect.DeepEqual(trace, exp) {
+		t.Fatalf("unexpected trace:\n%q\nwant\n%q", trace, exp)
+	}
+	if exp := []string{"open", "message", "close"}; !reflect.DeepEqual(timed, exp) {
+		t.Fatalf("unexpected timed events: %q", timed)
+	}
+	if len(logged) == 0 || logged[1] != (easyws.Field{Key: FieldEvent, Value: "close"}) {
+		t.Fatalf("unexpected logged fields: %v", logged)
+	}
+
+	ws = wstest.New(t, r)
+	ws.Upgrade(wstest.Request{})
+	ws.Write(wstest.Text(`{"type":"panic"}`))
+	ws.ExpectText(`{"type":"panic","error":{"code":"internal","message":"internal error"}}`)
+	var pe *PanicError
+	if !errors.As(internal, &pe) || pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Fatalf("unexpected error: %v", internal)
+	}
+}
+
+func TestAuthenticated(t *testing.T) {
+	auth := easyws.AuthenticatorFunc(func(r *easyws.HandshakeRequest) (*easyws.Identity, error) {
+		if user := r.Query().Get("user"); user != "" {
+			return &easyws.Identity{Subject: user}, nil
+		}
+		return nil, nil
+	})
+	r := New()
+	r.Handle("whoami", func(c *Context) (interface{}, error) {
+		return c.Conn.Identity().Subject, nil
+	}, Authenticated())
+	r.Handle("public", func(c *Context) (interface{}, error) {
+		return "ok", nil
+	})
+	newHarness := func() *wstest.Harness {
+		return wstest.New(t, r, wstest.WithServerOptions(
+			easyws.WithUpgrader(easyws.Upgrader{Authenticator: auth}),
+		))
+	}
+
+	ws := newHarness()
+	ws.Upgrade(wstest.Request{Path: "/?user=bob"})
+	ws.Write(wstest.Text(`{"type":"whoami"}`))
+	ws.ExpectText(`{"type":"whoami","payload":"bob"}`)
+
+	ws = newHarness()
+	ws.Upgrade(wstest.Request{})
+	ws.Write(wstest.Text(`{"type":"whoami"}`))
+	ws.ExpectText(`{"type":"whoami","error":{"code":"unauthorized","message":"authentication required"}}`)
+	ws.Write(wstest.Text(`{"type":"public"}`))
+	ws.ExpectText(`{"type":"public","payload":"ok"}`)
+
+	// Router-wide requirement rejects anonymous connections on open.
+	r.Use(Authenticated())
+	ws = newHarness()
+	err := ws.Write(wstest.Request{}.Bytes())
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeUnauthorized {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ws.ExpectClosed()
+}
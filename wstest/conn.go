@@ -0,0 +1,118 @@
+// Package wstest provides utilities for testing IEasyWs implementations
+// without real sockets and easynet event loop.
+//
+// Harness drives easyws.NetHandler end to end in memory: it writes handshake
+// request and client frames into a fake input stream, optionally splitting
+// them into chunks to simulate partial reads, and collects everything the
+// handler sends back to the fake connection, so it could be asserted as HTTP
+// response, frames and close codes.
+package wstest
+
+import (
+	"io"
+	"sync"
+)
+
+// Stream is an in-memory implementation of easynet IInputStream.
+//
+// Like easynet streams, it accumulates received packets until they are
+// consumed with End.
+type Stream struct {
+	b []byte
+}
+
+// Begin appends packet to the stream and returns all unprocessed bytes.
+func (s *Stream) Begin(packet []byte) []byte {
+	if len(packet) > 0 {
+		s.b = append(s.b, packet...)
+	}
+	return s.b
+}
+
+// End makes data to be the unprocessed bytes of the stream.
+func (s *Stream) End(data []byte) {
+	s.b = append(s.b[:0], data...)
+}
+
+// Len returns number of unprocessed bytes.
+func (s *Stream) Len() int {
+	return len(s.b)
+}
+
+// Conn is an in-memory implementation of easynet IConnection. It records
+// everything sent to it and could be read as io.Reader.
+//
+// It is safe to use Conn concurrently.
+type Conn struct {
+	addr string
+
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+}
+
+// NewConn creates Conn with given remote address.
+func NewConn(addr string) *Conn {
+	return &Conn{addr: addr}
+}
+
+// RemoteAddr returns the remote address given to NewConn.
+func (c *Conn) RemoteAddr() string {
+	return c.addr
+}
+
+// Send records p as sent data. It returns io.ErrClosedPipe if c is closed.
+func (c *Conn) Send(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.buf = append(c.buf, p...)
+	return len(p), nil
+}
+
+// Close marks c as closed. Data sent before close is still readable.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// Closed reports whether c was closed.
+func (c *Conn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Read reads sent data which was not read yet. It returns io.EOF if there is
+// no such data.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Bytes returns a copy of sent data which was not read yet.
+func (c *Conn) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.buf...)
+}
+
+// Discard skips n bytes of sent data.
+func (c *Conn) Discard(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > len(c.buf) {
+		n = len(c.buf)
+	}
+	c.buf = c.buf[n:]
+}
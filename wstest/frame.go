@@ -0,0 +1,126 @@
+package wstest
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/EternalVow/easyws"
+)
+
+// DefaultKey is the Sec-WebSocket-Key used by Request when no key is given.
+// It is the sample nonce from RFC6455.
+const DefaultKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// DefaultAccept is the Sec-WebSocket-Accept value server must respond with
+// to DefaultKey.
+const DefaultAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+
+// Request describes a client handshake request.
+type Request struct {
+	// Path is the request URI. If empty, "/" is used.
+	Path string
+
+	// Host is the Host header value. If empty, "localhost" is used.
+	Host string
+
+	// Key is the Sec-WebSocket-Key header value. If empty, DefaultKey is
+	// used.
+	Key string
+
+	// Protocols and Extensions are the values of Sec-WebSocket-Protocol and
+	// Sec-WebSocket-Extensions headers.
+	Protocols  []string
+	Extensions []string
+
+	// Header contains additional headers. Its values replace the ones
+	// generated from other fields.
+	Header http.Header
+}
+
+// Bytes returns binary representation of the request.
+func (r Request) Bytes() []byte {
+	header := http.Header{
+		"Host":                  {nonEmpty(r.Host, "localhost")},
+		"Upgrade":               {"websocket"},
+		"Connection":            {"Upgrade"},
+		"Sec-WebSocket-Version": {"13"},
+		"Sec-WebSocket-Key":     {nonEmpty(r.Key, DefaultKey)},
+	}
+	if len(r.Protocols) > 0 {
+		header.Set("Sec-WebSocket-Protocol", strings.Join(r.Protocols, ", "))
+	}
+	if len(r.Extensions) > 0 {
+		header.Set("Sec-WebSocket-Extensions", strings.Join(r.Extensions, ", "))
+	}
+	for k, v := range r.Header {
+		header[k] = v
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("GET " + nonEmpty(r.Path, "/") + " HTTP/1.1\r\n")
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// ClientFrame returns binary representation of a masked frame, as if it was
+// sent by a client.
+func ClientFrame(op easyws.OpCode, fin bool, p []byte) []byte {
+	return easyws.MustCompileFrame(easyws.MaskFrame(easyws.NewFrame(op, fin, p)))
+}
+
+// ServerFrame returns binary representation of a frame which is not masked,
+// as if it was sent by a server.
+func ServerFrame(op easyws.OpCode, fin bool, p []byte) []byte {
+	return easyws.MustCompileFrame(easyws.NewFrame(op, fin, p))
+}
+
+// Text returns masked text frame with s as payload.
+func Text(s string) []byte {
+	return ClientFrame(easyws.OpText, true, []byte(s))
+}
+
+// Binary returns masked binary frame with p as payload.
+func Binary(p []byte) []byte {
+	return ClientFrame(easyws.OpBinary, true, p)
+}
+
+// Ping returns masked ping frame with p as payload.
+func Ping(p []byte) []byte {
+	return ClientFrame(easyws.OpPing, true, p)
+}
+
+// Close returns masked close frame with given code and reason. If code is
+// empty, close frame has no body.
+func Close(code easyws.StatusCode, reason string) []byte {
+	var body []byte
+	if !code.Empty() {
+		body = easyws.NewCloseFrameBody(code, reason)
+	}
+	return ClientFrame(easyws.OpClose, true, body)
+}
+
+// Fragments returns masked frames of a message with given op code split into
+// fragments of n bytes.
+func Fragments(op easyws.OpCode, p []byte, n int) []byte {
+	var bts []byte
+	for {
+		m := n
+		if m > len(p) {
+			m = len(p)
+		}
+		bts = append(bts, ClientFrame(op, m == len(p), p[:m])...)
+		if m == len(p) {
+			return bts
+		}
+		op, p = easyws.OpContinuation, p[m:]
+	}
+}
+
+func nonEmpty(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,367 @@
+package wstest
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+)
+
+// DefaultAddr is the remote address of connections created by Harness.
+const DefaultAddr = "192.0.2.1:49152"
+
+// Option configures Harness created by New.
+type Option func(*Harness)
+
+// WithServerOptions returns an option that makes Harness to create
+// easyws.NetHandler with given options.
+func WithServerOptions(options ...easyws.ServerOption) Option {
+	return func(h *Harness) {
+		h.serverOptions = append(h.serverOptions, options...)
+	}
+}
+
+// WithAddr returns an option that sets the remote address of the connection.
+func WithAddr(addr string) Option {
+	return func(h *Harness) {
+		h.addr = addr
+	}
+}
+
+// WithSplit returns an option that makes Harness to split every written
+// chunk of bytes into parts of given sizes, passing each part to the handler
+// as a separate read. Sizes are used cyclically; the last part may be
+// shorter. For example, WithSplit(1) feeds handler byte by byte.
+func WithSplit(sizes ...int) Option {
+	return func(h *Harness) {
+		h.split = sizes
+	}
+}
+
+// WithDelay returns an option that makes Harness to wait for d between reads
+// of the split parts.
+func WithDelay(d time.Duration) Option {
+	return func(h *Harness) {
+		h.delay = d
+	}
+}
+
+// WithSleep returns an option that replaces time.Sleep used to wait between
+// reads. It is useful to make delays deterministic with a fake clock.
+func WithSleep(sleep func(time.Duration)) Option {
+	return func(h *Harness) {
+		h.sleep = sleep
+	}
+}
+
+// Harness drives easyws.NetHandler over in-memory connection the same way
+// easynet does: every read is appended to the connection stream, passed to
+// OnReceive and the returned bytes are sent to the connection. If OnReceive
+// returns an error, connection is closed and OnClose is called.
+//
+// Harness methods report failures with t.Fatalf and so must be called from
+// the goroutine running the test.
+type Harness struct {
+	t testing.TB
+
+	// Handler is the handler under test.
+	Handler *easyws.NetHandler
+
+	// Conn and Stream are the connection and its input stream passed to the
+	// Handler.
+	Conn   *Conn
+	Stream *Stream
+
+	serverOptions []easyws.ServerOption
+	addr          string
+	split         []int
+	delay         time.Duration
+	sleep         func(time.Duration)
+
+	err    error
+	closed bool
+}
+
+// New creates Harness serving h and connects it.
+func New(t testing.TB, h easyws.IEasyWs, options ...Option) *Harness {
+	t.Helper()
+	x := &Harness{
+		t:     t,
+		addr:  DefaultAddr,
+		sleep: time.Sleep,
+	}
+	for _, opt := range options {
+		opt(x)
+	}
+	x.Handler = easyws.NewNetHandler(h, x.serverOptions...)
+	x.Conn = NewConn(x.addr)
+	x.Stream = &Stream{}
+	if err := x.Handler.OnConnect(x.Conn); err != nil {
+		t.Fatalf("wstest: OnConnect() error: %v", err)
+	}
+	return x
+}
+
+// Dial creates Harness serving h and upgrades the connection with handshake
+// request req, usually the one which selects a subprotocol. The connection is
+// closed when the test finishes.
+func Dial(t testing.TB, h easyws.IEasyWs, req Request, options ...Option) *Harness {
+	t.Helper()
+	x := New(t, h, options...)
+	x.Upgrade(req)
+	t.Cleanup(x.Close)
+	return x
+}
+
+// T returns the test Harness reports failures to. It is useful for protocol
+// specific helpers built on top of Harness.
+func (h *Harness) T() testing.TB {
+	return h.t
+}
+
+// Write passes p to the handler, split into reads as configured by
+// WithSplit. It returns the error returned by OnReceive, if any.
+//
+// After the connection is closed, Write does nothing and returns the error
+// the connection was closed with.
+func (h *Harness) Write(p []byte) error {
+	for i := 0; !h.closed; i++ {
+		n := len(p)
+		if len(h.split) > 0 {
+			if s := h.split[i%len(h.split)]; s > 0 && s < n {
+				n = s
+			}
+		}
+		if i > 0 && h.delay > 0 {
+			h.sleep(h.delay)
+		}
+		h.receive(p[:n])
+		if p = p[n:]; len(p) == 0 {
+			break
+		}
+	}
+	return h.err
+}
+
+func (h *Harness) receive(p []byte) {
+	h.Stream.Begin(p)
+	out, err := h.Handler.OnReceive(h.Conn, h.Stream)
+	if err != nil {
+		h.err = err
+		h.Close()
+		return
+	}
+	if len(out) > 0 {
+		h.Conn.Send(out)
+	}
+}
+
+// Close closes the connection and notifies the handler about it. It is safe
+// to call Close multiple times.
+func (h *Harness) Close() {
+	if h.closed {
+		return
+	}
+	h.closed = true
+	h.Conn.Close()
+	h.Handler.OnClose(h.Conn, h.err)
+}
+
+// Err returns the error the connection was failed with by the handler.
+func (h *Harness) Err() error {
+	return h.err
+}
+
+// Upgrade writes handshake request req and checks that handler responds with
+// 101 status code and valid Sec-WebSocket-Accept header. It returns the
+// response.
+func (h *Harness) Upgrade(req Request) *http.Response {
+	h.t.Helper()
+	h.Write(req.Bytes())
+	resp := h.Response()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		h.t.Fatalf("wstest: unexpected handshake response status: %s", resp.Status)
+	}
+	if req.Key == "" {
+		if act := resp.Header.Get("Sec-WebSocket-Accept"); act != DefaultAccept {
+			h.t.Fatalf("wstest: unexpected Sec-WebSocket-Accept: %q; want %q", act, DefaultAccept)
+		}
+	}
+	return resp
+}
+
+// Response reads HTTP response sent by the handler.
+func (h *Harness) Response() *http.Response {
+	h.t.Helper()
+	sent := h.Conn.Bytes()
+	end := bytes.Index(sent, []byte("\r\n\r\n"))
+	if end == -1 {
+		h.t.Fatalf("wstest: no complete HTTP response sent; got %q (err: %v)", sent, h.err)
+	}
+	end += 4
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(sent[:end])), nil)
+	if err != nil {
+		h.t.Fatalf("wstest: can not read HTTP response: %v", err)
+	}
+	h.Conn.Discard(end)
+	return resp
+}
+
+// NextFrame reads next frame sent by the handler. It returns false if there
+// are no complete frames sent.
+func (h *Harness) NextFrame() (easyws.Frame, bool) {
+	h.t.Helper()
+	f, n, err := ParseFrame(h.Conn.Bytes())
+	if err == io.ErrUnexpectedEOF {
+		return f, false
+	}
+	if err != nil {
+		h.t.Fatalf("wstest: can not parse sent frame: %v", err)
+	}
+	h.Conn.Discard(n)
+	return f, true
+}
+
+// WaitTimeout is how long Harness waits for data which the handler sends
+// asynchronously.
+const WaitTimeout = 5 * time.Second
+
+// SendText writes a text message with payload s to the handler.
+func (h *Harness) SendText(s string) error {
+	return h.Write(Text(s))
+}
+
+// WaitFrame waits for the next frame sent by the handler. Unlike NextFrame,
+// it is suitable for frames sent from other goroutines, e.g. by timers.
+func (h *Harness) WaitFrame() easyws.Frame {
+	h.t.Helper()
+	deadline := time.Now().Add(WaitTimeout)
+	for {
+		if f, ok := h.NextFrame(); ok {
+			return f
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("wstest: no frame sent in %s (err: %v)", WaitTimeout, h.err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// WaitText waits for the next frame sent by the handler, checks that it is a
+// final text frame and returns its payload.
+func (h *Harness) WaitText() string {
+	h.t.Helper()
+	f := h.WaitFrame()
+	if f.Header.OpCode != easyws.OpText || !f.Header.Fin {
+		h.t.Fatalf("wstest: unexpected frame: %+v %q; want final text frame", f.Header, f.Payload)
+	}
+	return string(f.Payload)
+}
+
+// WaitClosed waits for the connection to be closed by the handler.
+func (h *Harness) WaitClosed() {
+	h.t.Helper()
+	deadline := time.Now().Add(WaitTimeout)
+	for !h.Conn.Closed() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("wstest: connection is not closed in %s", WaitTimeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// ExpectFrame checks that the next frame sent by the handler is a final one
+// with given op code and payload.
+func (h *Harness) ExpectFrame(op easyws.OpCode, p []byte) {
+	h.t.Helper()
+	f := h.frame()
+	if f.Header.OpCode != op || !f.Header.Fin {
+		h.t.Fatalf("wstest: unexpected frame: %+v; want final %v", f.Header, op)
+	}
+	if !bytes.Equal(f.Payload, p) {
+		h.t.Fatalf("wstest: unexpected %v frame payload: %q; want %q", op, f.Payload, p)
+	}
+}
+
+// ExpectText checks that the next frame sent by the handler is a text frame
+// with payload s.
+func (h *Harness) ExpectText(s string) {
+	h.t.Helper()
+	h.ExpectFrame(easyws.OpText, []byte(s))
+}
+
+// ExpectBinary checks that the next frame sent by the handler is a binary
+// frame with payload p.
+func (h *Harness) ExpectBinary(p []byte) {
+	h.t.Helper()
+	h.ExpectFrame(easyws.OpBinary, p)
+}
+
+// ExpectClose checks that the next frame sent by the handler is a close
+// frame with given status code and that connection is closed after it.
+// Empty code means close frame without body.
+func (h *Harness) ExpectClose(code easyws.StatusCode) {
+	h.t.Helper()
+	f := h.frame()
+	if f.Header.OpCode != easyws.OpClose {
+		h.t.Fatalf("wstest: unexpected frame: %+v; want close frame", f.Header)
+	}
+	act, reason := easyws.ParseCloseFrameData(f.Payload)
+	if act != code {
+		h.t.Fatalf("wstest: unexpected close code: %d (%q); want %d", act, reason, code)
+	}
+	h.ExpectClosed()
+}
+
+// ExpectClosed checks that connection was closed by the handler.
+func (h *Harness) ExpectClosed() {
+	h.t.Helper()
+	if !h.Conn.Closed() {
+		h.t.Fatalf("wstest: connection is not closed")
+	}
+}
+
+// ExpectNothing checks that handler has not sent anything which was not read
+// yet.
+func (h *Harness) ExpectNothing() {
+	h.t.Helper()
+	if sent := h.Conn.Bytes(); len(sent) > 0 {
+		h.t.Fatalf("wstest: unexpected data sent: %q", sent)
+	}
+}
+
+func (h *Harness) frame() easyws.Frame {
+	h.t.Helper()
+	f, ok := h.NextFrame()
+	if !ok {
+		h.t.Fatalf("wstest: no complete frame sent; got %q (err: %v)", h.Conn.Bytes(), h.err)
+	}
+	return f
+}
+
+// ParseFrame parses frame placed at the beginning of bts and unmasks its
+// payload if needed. It returns the frame and the number of bytes it
+// occupies. If bts does not contain the whole frame, it returns
+// io.ErrUnexpectedEOF.
+func ParseFrame(bts []byte) (f easyws.Frame, n int, err error) {
+	s := &Stream{}
+	s.Begin(bts)
+	f.Header, err = easyws.ReadHeader(s)
+	if err != nil {
+		return f, 0, err
+	}
+	n = len(bts) - s.Len()
+	if int64(s.Len()) < f.Header.Length {
+		return f, 0, io.ErrUnexpectedEOF
+	}
+	f.Payload = append([]byte(nil), bts[n:n+int(f.Header.Length)]...)
+	n += int(f.Header.Length)
+	if f.Header.Masked {
+		f = easyws.UnmaskFrameInPlace(f)
+	}
+	return f, n, nil
+}
@@ -0,0 +1,162 @@
+package wstest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EternalVow/easyws"
+	"github.com/EternalVow/easyws/wsutil"
+)
+
+type echo struct{}
+
+func (echo) OnStart() (easyws.OpCode, error)      { return 0, nil }
+func (echo) OnConnect() (easyws.OpCode, error)    { return 0, nil }
+func (echo) OnUpgraded() (easyws.OpCode, error)   { return 0, nil }
+func (echo) OnShutdown() (easyws.OpCode, error)   { return 0, nil }
+func (echo) OnClose(error) (easyws.OpCode, error) { return 0, nil }
+
+func (echo) OnReceive(msg []byte) ([]byte, easyws.OpCode, error) {
+	return msg, easyws.OpText, nil
+}
+
+func (echo) OnMessage(conn *easyws.Conn, op easyws.OpCode, msg []byte) ([]byte, easyws.OpCode, error) {
+	return msg, op, nil
+}
+
+func TestHarness(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		options []Option
+	}{
+		{"whole", nil},
+		{"bytes", []Option{WithSplit(1)}},
+		{"chunks", []Option{WithSplit(3, 7, 1)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(t, echo{}, test.options...)
+			h.Upgrade(Request{Path: "/chat"})
+
+			h.Write(Text("hello"))
+			h.ExpectText("hello")
+
+			h.Write(append(Binary([]byte{1, 2, 3}), Ping([]byte("ping"))...))
+			h.ExpectBinary([]byte{1, 2, 3})
+			h.ExpectFrame(easyws.OpPong, []byte("ping"))
+
+			h.Write(Fragments(easyws.OpText, []byte("fragmented message"), 4))
+			h.ExpectText("fragmented message")
+			h.ExpectNothing()
+
+			h.Write(Close(easyws.StatusGoingAway, "bye"))
+			h.ExpectClose(easyws.StatusGoingAway)
+		})
+	}
+}
+
+func TestHarnessProtocolError(t *testing.T) {
+	h := New(t, echo{}, WithSplit(2))
+	h.Upgrade(Request{})
+	h.Write(ServerFrame(easyws.OpText, true, []byte("not masked")))
+	h.ExpectClose(easyws.StatusProtocolError)
+	if h.Err() == nil {
+		t.Fatalf("no error after protocol violation")
+	}
+}
+
+func TestHarnessDelay(t *testing.T) {
+	var slept time.Duration
+	h := New(t, echo{},
+		WithSplit(10),
+		WithDelay(time.Second),
+		WithSleep(func(d time.Duration) { slept += d }),
+	)
+	req := Request{}.Bytes()
+	h.Write(req[:len(req)-1])
+	h.ExpectNothing()
+	h.Write(req[len(req)-1:])
+	h.Response()
+
+	if exp := time.Duration((len(req)-2)/10) * time.Second; slept != exp {
+		t.Fatalf("slept %s; want %s", slept, exp)
+	}
+}
+
+func TestHarnessTracer(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	parent, _ := easyws.ParseTraceParent(traceparent)
+
+	tracer := NewTracer()
+	h := New(t, echo{}, WithServerOptions(easyws.WithTracer(tracer)))
+	h.Upgrade(Request{Header: http.Header{"Traceparent": {traceparent}}})
+	h.Write(Text("hello"))
+	h.ExpectText("hello")
+
+	spans := tracer.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+	for i, exp := range []struct {
+		name   string
+		parent [8]byte
+	}{
+		{easyws.SpanHandshake, parent.SpanID},
+		{easyws.SpanMessage, spans[0].SpanID},
+		{easyws.SpanWrite, spans[1].SpanID},
+	} {
+		s := spans[i]
+		if s.Name != exp.name || s.ParentID != exp.parent || s.TraceID != parent.TraceID || !s.Ended {
+			t.Errorf("unexpected #%d span: %+v", i, s)
+		}
+	}
+}
+
+func TestHarnessDial(t *testing.T) {
+	h := Dial(t, echo{}, Request{Path: "/chat"})
+	h.SendText("hello")
+	if s := h.WaitText(); s != "hello" {
+		t.Fatalf("WaitText() = %q; want hello", s)
+	}
+
+	// Frames sent asynchronously are waited for.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		h.Conn.Send(ServerFrame(easyws.OpText, true, []byte("later")))
+	}()
+	if s := h.WaitText(); s != "later" {
+		t.Fatalf("WaitText() = %q; want later", s)
+	}
+
+	h.Write(Close(easyws.StatusNormalClosure, ""))
+	h.WaitClosed()
+}
+
+func TestHarnessRejected(t *testing.T) {
+	h := New(t, echo{})
+	h.Write(Request{Header: http.Header{"Origin": {"https://evil.com"}}}.Bytes())
+	if resp := h.Response(); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	if err := h.Err(); err != easyws.ErrHandshakeBadOrigin {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h.ExpectClosed()
+}
+
+func TestServe(t *testing.T) {
+	url := Serve(t, echo{})
+	conn, _, _, err := easyws.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := wsutil.WriteClientText(conn, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := wsutil.ReadServerText(conn)
+	if err != nil || string(msg) != "hello" {
+		t.Fatalf("ReadServerText() = %q, %v", msg, err)
+	}
+}
@@ -0,0 +1,78 @@
+package wstest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Serve starts a server of h on the loopback interface and returns its
+// WebSocket URL, like "ws://127.0.0.1:49152". It is useful to test clients
+// made with easyws.Dialer. The server is stopped when the test finishes.
+//
+// Server drives easyws.NetHandler the same way easynet does: every
+// connection is read by its own goroutine, which passes received bytes to
+// OnReceive and sends the returned bytes back.
+func Serve(t testing.TB, h easyws.IEasyWs, options ...easyws.ServerOption) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("wstest: can not listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	handler := easyws.NewNetHandler(h, options...)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(handler, conn)
+		}
+	}()
+	return "ws://" + ln.Addr().String()
+}
+
+// netConn adapts net.Conn to the easynet IConnection interface.
+type netConn struct {
+	net.Conn
+}
+
+func (c netConn) RemoteAddr() string         { return c.Conn.RemoteAddr().String() }
+func (c netConn) Send(p []byte) (int, error) { return c.Conn.Write(p) }
+
+func serveConn(h *easyws.NetHandler, conn net.Conn) {
+	var (
+		c      = netConn{conn}
+		stream = &Stream{}
+		err    error
+	)
+	defer func() {
+		conn.Close()
+		h.OnClose(c, err)
+	}()
+	if err = h.OnConnect(c); err != nil {
+		return
+	}
+	buf := make([]byte, 4096)
+	for {
+		var n int
+		if n, err = conn.Read(buf); err != nil {
+			return
+		}
+		stream.Begin(buf[:n])
+
+		var out []byte
+		out, err = h.OnReceive(c, stream)
+		if len(out) > 0 {
+			if _, werr := conn.Write(out); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
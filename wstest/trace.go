@@ -0,0 +1,103 @@
+package wstest
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Tracer is an easyws.Tracer which records spans in memory. It is intended
+// to check instrumentation in tests.
+//
+// Span identifiers are assigned sequentially, starting from 1.
+type Tracer struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+	seq   uint64
+}
+
+// NewTracer creates empty Tracer.
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+// RecordedSpan describes a span recorded by Tracer.
+type RecordedSpan struct {
+	Name     string
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte
+	Fields   []easyws.Field
+
+	// Ended reports whether span was ended, and Err is the error it was
+	// ended with.
+	Ended bool
+	Err   error
+}
+
+// Field returns value of the field with given key.
+func (s RecordedSpan) Field(key string) interface{} {
+	for _, f := range s.Fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+type spanKey struct{}
+
+// Start implements easyws.Tracer.
+func (t *Tracer) Start(ctx context.Context, name string, fields ...easyws.Field) (context.Context, easyws.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq++
+	s := &RecordedSpan{
+		Name:   name,
+		Fields: append([]easyws.Field(nil), fields...),
+	}
+	binary.BigEndian.PutUint64(s.SpanID[:], t.seq)
+	if parent, ok := ctx.Value(spanKey{}).(*RecordedSpan); ok {
+		s.TraceID, s.ParentID = parent.TraceID, parent.SpanID
+	} else if tc, ok := easyws.TraceContextFromContext(ctx); ok {
+		s.TraceID, s.ParentID = tc.TraceID, tc.SpanID
+	} else {
+		binary.BigEndian.PutUint64(s.TraceID[8:], t.seq)
+	}
+	t.spans = append(t.spans, s)
+
+	return context.WithValue(ctx, spanKey{}, s), span{t, s}
+}
+
+// Spans returns recorded spans in order they were started.
+func (t *Tracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ret := make([]RecordedSpan, len(t.spans))
+	for i, s := range t.spans {
+		ret[i] = *s
+	}
+	return ret
+}
+
+// Reset drops all recorded spans.
+func (t *Tracer) Reset() {
+	t.mu.Lock()
+	t.spans = nil
+	t.mu.Unlock()
+}
+
+type span struct {
+	t *Tracer
+	s *RecordedSpan
+}
+
+func (s span) End(err error) {
+	s.t.mu.Lock()
+	s.s.Ended = true
+	s.s.Err = err
+	s.t.mu.Unlock()
+}
@@ -0,0 +1,89 @@
+package wsutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+
+	"github.com/EternalVow/easyws"
+)
+
+// DebugDialer is a wrapper around easyws.Dialer. It tracks i/o of WebSocket
+// handshake. That is, it gives ability to receive copied HTTP request and
+// response bytes that made inside Dialer.Dial().
+//
+// Note that it must not be used in production applications that requires
+// Dial() to be efficient.
+type DebugDialer struct {
+	// Dialer contains WebSocket connection establishment options.
+	Dialer easyws.Dialer
+
+	// OnRequest and OnResponse are the callbacks that will be called with the
+	// HTTP request and response respectively.
+	OnRequest, OnResponse func([]byte)
+}
+
+// Dial connects to the url host and upgrades connection to WebSocket. It makes
+// it by calling d.Dialer.Dial().
+func (d *DebugDialer) Dial(ctx context.Context, urlstr string) (conn net.Conn, br *bufio.Reader, hs easyws.Handshake, err error) {
+	// Need to copy Dialer to prevent original object mutation.
+	dialer := d.Dialer
+	var (
+		reqBuf bytes.Buffer
+		resBuf bytes.Buffer
+
+		wrapConn = dialer.WrapConn
+		rwConn   *debugConn
+	)
+	dialer.WrapConn = func(c net.Conn) net.Conn {
+		if wrapConn != nil {
+			c = wrapConn(c)
+		}
+		// Save the pointer to the raw connection.
+		rwConn = &debugConn{
+			Conn: c,
+			r:    io.TeeReader(c, &resBuf),
+			w:    io.MultiWriter(c, &reqBuf),
+		}
+		return rwConn
+	}
+
+	_, br, hs, err = dialer.Dial(ctx, urlstr)
+	if rwConn == nil {
+		return nil, br, hs, err
+	}
+
+	// Restore the original connection.
+	rwConn.r = rwConn.Conn
+	rwConn.w = rwConn.Conn
+
+	if onRequest := d.OnRequest; onRequest != nil {
+		onRequest(reqBuf.Bytes())
+	}
+	if onResponse := d.OnResponse; onResponse != nil {
+		// Response could be followed by frames which were read along with it.
+		res := resBuf.Bytes()
+		if i := bytes.Index(res, []byte("\r\n\r\n")); i != -1 {
+			res = res[:i+4]
+		}
+		onResponse(res)
+	}
+
+	return rwConn, br, hs, err
+}
+
+type debugConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+func (c *debugConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *debugConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
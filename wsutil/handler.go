@@ -0,0 +1,134 @@
+package wsutil
+
+import (
+	"io"
+
+	"github.com/EternalVow/easyws"
+)
+
+// ControlHandler contains logic of handling control frames.
+//
+// The intentional way to use it is to read the next frame header from the
+// connection, optionally check its validity via easyws.CheckHeader() and if it
+// is not a easyws.OpText of easyws.OpBinary (or easyws.OpContinuation) – pass
+// it to Handle() method.
+//
+// That is, passed header should be checked to get rid of unexpected errors.
+//
+// The Handle() method will read out all control frame payload (if any) and
+// write necessary bytes as a rfc compatible response.
+type ControlHandler struct {
+	// Src is the reader of already unmasked control frame payload.
+	Src io.Reader
+
+	// Dst is the writer responses are written to.
+	Dst io.Writer
+
+	// State is the state of the endpoint. Client side endpoints mask
+	// responses.
+	State easyws.State
+}
+
+// Handle handles control frames regarding to the c.State and writes
+// responses to the c.Dst when needed.
+//
+// It returns easyws.ClosedError after successful handling of close frame.
+func (c ControlHandler) Handle(h easyws.Header) error {
+	switch h.OpCode {
+	case easyws.OpPing:
+		return c.HandlePing(h)
+	case easyws.OpPong:
+		return c.HandlePong(h)
+	case easyws.OpClose:
+		return c.HandleClose(h)
+	}
+	return easyws.ErrProtocolOpCodeReserved
+}
+
+// HandlePing handles ping frame and writes specification compatible response
+// to the c.Dst.
+func (c ControlHandler) HandlePing(h easyws.Header) error {
+	p, err := c.payload(h)
+	if err != nil {
+		return err
+	}
+	return c.write(easyws.NewPongFrame(p))
+}
+
+// HandlePong handles pong frame by discarding it.
+func (c ControlHandler) HandlePong(h easyws.Header) error {
+	_, err := io.CopyN(io.Discard, c.Src, h.Length)
+	return err
+}
+
+// HandleClose handles close frame, makes protocol validity checks and writes
+// specification compatible response to the c.Dst.
+func (c ControlHandler) HandleClose(h easyws.Header) error {
+	p, err := c.payload(h)
+	if err != nil {
+		return err
+	}
+
+	code, reason := easyws.ParseCloseFrameData(p)
+	switch {
+	case len(p) == 1:
+		err = easyws.ErrProtocolCloseBodyTooShort
+	case len(p) > 1:
+		err = easyws.CheckCloseFrameData(code, reason)
+	}
+	if err != nil {
+		// [RFC6455]: An endpoint MAY send a Close frame with status code
+		// 1002 (protocol error) if it receives a malformed close frame.
+		status := easyws.StatusProtocolError
+		if err == easyws.ErrProtocolInvalidUTF8 {
+			status = easyws.StatusInvalidFramePayloadData
+		}
+		c.write(easyws.NewCloseFrame(easyws.NewCloseFrameBody(status, err.Error())))
+		return err
+	}
+
+	// [RFC6455]: When sending a Close frame in response, the endpoint
+	// typically echos the status code it received.
+	var body []byte
+	if !code.Empty() {
+		body = easyws.NewCloseFrameBody(code, "")
+	}
+	if err := c.write(easyws.NewCloseFrame(body)); err != nil {
+		return err
+	}
+	return easyws.ClosedError{
+		Code:   code,
+		Reason: reason,
+	}
+}
+
+func (c ControlHandler) payload(h easyws.Header) ([]byte, error) {
+	if h.Length == 0 {
+		return nil, nil
+	}
+	if h.Length > easyws.MaxControlFramePayloadSize {
+		return nil, easyws.ErrProtocolControlPayloadOverflow
+	}
+	p := make([]byte, h.Length)
+	_, err := io.ReadFull(c.Src, p)
+	return p, err
+}
+
+func (c ControlHandler) write(f easyws.Frame) error {
+	if c.State.ClientSide() {
+		f = easyws.MaskFrameInPlace(f)
+	}
+	return easyws.WriteFrame(c.Dst, f)
+}
+
+// ControlFrameHandler returns FrameHandlerFunc for handling control frames.
+// For more info see ControlHandler docs.
+func ControlFrameHandler(w io.Writer, state easyws.State) FrameHandlerFunc {
+	return func(h easyws.Header, r io.Reader) error {
+		return ControlHandler{
+			Src:   r,
+			Dst:   w,
+			State: state,
+		}.Handle(h)
+	}
+}
@@ -0,0 +1,191 @@
+// Package wsutil provides utilities for reading and writing WebSocket frames
+// and messages over plain io.Reader and io.Writer, such as net.Conn returned
+// by easyws.Dialer.
+package wsutil
+
+import (
+	"io"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Message represents a message between client and server.
+type Message struct {
+	OpCode  easyws.OpCode
+	Payload []byte
+}
+
+// ReadClientData reads next data message from rw, considering that caller
+// represents server side.
+// It is a shortcut for ReadData(rw, easyws.StateServerSide).
+//
+// Note that it handles and writes necessary responses to the control frames.
+func ReadClientData(rw io.ReadWriter) ([]byte, easyws.OpCode, error) {
+	return ReadData(rw, easyws.StateServerSide)
+}
+
+// ReadClientText is the same as ReadClientData but discards any non-text
+// messages.
+func ReadClientText(rw io.ReadWriter) ([]byte, error) {
+	p, _, err := readData(rw, easyws.StateServerSide, easyws.OpText)
+	return p, err
+}
+
+// ReadClientBinary is the same as ReadClientData but discards any non-binary
+// messages.
+func ReadClientBinary(rw io.ReadWriter) ([]byte, error) {
+	p, _, err := readData(rw, easyws.StateServerSide, easyws.OpBinary)
+	return p, err
+}
+
+// ReadServerData reads next data message from rw, considering that caller
+// represents client side.
+// It is a shortcut for ReadData(rw, easyws.StateClientSide).
+//
+// Note that it handles and writes necessary responses to the control frames.
+func ReadServerData(rw io.ReadWriter) ([]byte, easyws.OpCode, error) {
+	return ReadData(rw, easyws.StateClientSide)
+}
+
+// ReadServerText is the same as ReadServerData but discards any non-text
+// messages.
+func ReadServerText(rw io.ReadWriter) ([]byte, error) {
+	p, _, err := readData(rw, easyws.StateClientSide, easyws.OpText)
+	return p, err
+}
+
+// ReadServerBinary is the same as ReadServerData but discards any non-binary
+// messages.
+func ReadServerBinary(rw io.ReadWriter) ([]byte, error) {
+	p, _, err := readData(rw, easyws.StateClientSide, easyws.OpBinary)
+	return p, err
+}
+
+// ReadData is a helper function that reads next data (non-control) message
+// from rw.
+// It takes care on handling all control frames. It will write response on
+// control frames to the write part of rw. It blocks until some data frame
+// will be received.
+//
+// After close frame is handled, easyws.ClosedError is returned.
+func ReadData(rw io.ReadWriter, s easyws.State) ([]byte, easyws.OpCode, error) {
+	return readData(rw, s, easyws.OpText|easyws.OpBinary)
+}
+
+// ReadMessage is a helper function that reads next message from r. It appends
+// received message(s) to the third argument and returns the result of it and
+// an error if some failure happened. That is, it probably could receive more
+// than one message when peer sending fragmented message in multiple frames
+// and want to send some control frame between fragments. Then returned slice
+// will contain those control frames at first, and then result of gluing
+// fragments.
+//
+// Note that it does not handle control frames in any way, it just appends
+// them to the result.
+func ReadMessage(r io.Reader, s easyws.State, m []Message) ([]Message, error) {
+	rd := Reader{
+		Source:    r,
+		State:     s,
+		CheckUTF8: true,
+		OnIntermediate: func(hdr easyws.Header, src io.Reader) error {
+			bts, err := io.ReadAll(src)
+			if err != nil {
+				return err
+			}
+			m = append(m, Message{hdr.OpCode, bts})
+			return nil
+		},
+	}
+	h, err := rd.NextFrame()
+	if err != nil {
+		return m, err
+	}
+	p, err := io.ReadAll(&rd)
+	if err != nil {
+		return m, err
+	}
+	return append(m, Message{h.OpCode, p}), nil
+}
+
+func readData(rw io.ReadWriter, s easyws.State, want easyws.OpCode) ([]byte, easyws.OpCode, error) {
+	controlHandler := ControlFrameHandler(rw, s)
+	rd := Reader{
+		Source:         rw,
+		State:          s,
+		CheckUTF8:      true,
+		OnIntermediate: controlHandler,
+	}
+	for {
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			return nil, 0, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := controlHandler(hdr, &rd); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if hdr.OpCode&want == 0 {
+			if err := rd.Discard(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+
+		bts, err := io.ReadAll(&rd)
+
+		return bts, hdr.OpCode, err
+	}
+}
+
+// WriteMessage is a helper function that writes message to the w. It
+// constructs single frame with given operation code and payload.
+// It uses given state to prepare side-dependent things, like cipher payload
+// bytes from client to server. It will not mutate p bytes if cipher must be
+// made.
+//
+// If you want to write message in fragmented frames, use Writer instead.
+func WriteMessage(w io.Writer, s easyws.State, op easyws.OpCode, p []byte) error {
+	f := easyws.NewFrame(op, true, p)
+	if s.ClientSide() {
+		f = easyws.MaskFrame(f)
+	}
+	return easyws.WriteFrame(w, f)
+}
+
+// WriteServerMessage writes message to w, considering that caller
+// represents server side.
+func WriteServerMessage(w io.Writer, op easyws.OpCode, p []byte) error {
+	return WriteMessage(w, easyws.StateServerSide, op, p)
+}
+
+// WriteServerText is the same as WriteServerMessage with
+// easyws.OpText.
+func WriteServerText(w io.Writer, p []byte) error {
+	return WriteServerMessage(w, easyws.OpText, p)
+}
+
+// WriteServerBinary is the same as WriteServerMessage with
+// easyws.OpBinary.
+func WriteServerBinary(w io.Writer, p []byte) error {
+	return WriteServerMessage(w, easyws.OpBinary, p)
+}
+
+// WriteClientMessage writes message to w, considering that caller
+// represents client side.
+func WriteClientMessage(w io.Writer, op easyws.OpCode, p []byte) error {
+	return WriteMessage(w, easyws.StateClientSide, op, p)
+}
+
+// WriteClientText is the same as WriteClientMessage with
+// easyws.OpText.
+func WriteClientText(w io.Writer, p []byte) error {
+	return WriteClientMessage(w, easyws.OpText, p)
+}
+
+// WriteClientBinary is the same as WriteClientMessage with
+// easyws.OpBinary.
+func WriteClientBinary(w io.Writer, p []byte) error {
+	return WriteClientMessage(w, easyws.OpBinary, p)
+}
@@ -0,0 +1,253 @@
+package wsutil
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/EternalVow/easyws"
+)
+
+// Errors used by Reader.
+var (
+	// ErrNoFrameAdvance means that Reader's Read() method was called without
+	// preceding NextFrame() call.
+	ErrNoFrameAdvance = fmt.Errorf("no frame advance")
+
+	// ErrFrameTooLarge indicates that a message of length higher than
+	// MaxFrameSize was being read.
+	ErrFrameTooLarge = fmt.Errorf("frame too large")
+)
+
+// FrameHandlerFunc handles parsed frame header and its body represented by
+// io.Reader.
+//
+// Note that reader represents already unmasked body.
+type FrameHandlerFunc func(easyws.Header, io.Reader) error
+
+// Reader is a wrapper around source io.Reader which represents WebSocket
+// connection. It contains options for reading messages from source.
+//
+// Reader implements io.Reader, which Read() method reads payload of incoming
+// WebSocket frames. It also takes care on fragmented frames and possibly
+// intermediate control frames between them.
+//
+// Note that Reader's methods are not goroutine safe.
+type Reader struct {
+	Source io.Reader
+	State  easyws.State
+
+	// SkipHeaderCheck disables checking header bits to be RFC6455 compliant.
+	SkipHeaderCheck bool
+
+	// CheckUTF8 enables UTF-8 checks for text frames payload. If incoming
+	// bytes are not valid UTF-8 sequence, ErrInvalidUTF8 returned.
+	CheckUTF8 bool
+
+	// MaxFrameSize controls the maximum frame size in bytes. If zero, frames
+	// of any size are allowed.
+	MaxFrameSize int64
+
+	// OnContinuation is called for every received continuation frame.
+	OnContinuation FrameHandlerFunc
+
+	// OnIntermediate is called for every control frame received between
+	// fragments of a message.
+	OnIntermediate FrameHandlerFunc
+
+	opCode easyws.OpCode    // Used to store message op code on fragmentation.
+	frame  io.Reader        // Used to as frame reader.
+	raw    io.LimitedReader // Used to discard frames without cipher.
+	cipher CipherReader     // Used to unmask frames payload.
+	utf8   UTF8Reader       // Used to check UTF8 sequences if CheckUTF8 is true.
+}
+
+// NewReader creates new frame reader that reads from r keeping given state to
+// make some protocol validity checks when it needed.
+func NewReader(r io.Reader, s easyws.State) *Reader {
+	return &Reader{
+		Source: r,
+		State:  s,
+	}
+}
+
+// NewClientSideReader is a helper function that calls NewReader with r and
+// easyws.StateClientSide.
+func NewClientSideReader(r io.Reader) *Reader {
+	return NewReader(r, easyws.StateClientSide)
+}
+
+// NewServerSideReader is a helper function that calls NewReader with r and
+// easyws.StateServerSide.
+func NewServerSideReader(r io.Reader) *Reader {
+	return NewReader(r, easyws.StateServerSide)
+}
+
+// Read implements io.Reader. It reads the next message payload into p.
+// It takes care on fragmented messages.
+//
+// The error is io.EOF only if all of message bytes were read.
+// If an io.EOF happens during reading some but not all the message bytes
+// Read() returns io.ErrUnexpectedEOF.
+//
+// The error is ErrNoFrameAdvance if no NextFrame() call was made before
+// reading next message bytes.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.frame == nil {
+		if !r.fragmented() {
+			// Every new Read() must be preceded by NextFrame() call.
+			return 0, ErrNoFrameAdvance
+		}
+		// Read next continuation or intermediate control frame.
+		_, err := r.NextFrame()
+		if err != nil {
+			return 0, err
+		}
+		if r.frame == nil {
+			// We handled intermediate control and now got nothing to read.
+			return 0, nil
+		}
+	}
+
+	n, err = r.frame.Read(p)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	if err == nil && r.raw.N != 0 {
+		return n, nil
+	}
+
+	switch {
+	case r.raw.N != 0:
+		err = io.ErrUnexpectedEOF
+
+	case r.fragmented():
+		err = nil
+		r.resetFragment()
+
+	case r.CheckUTF8 && !r.utf8.Valid():
+		n = r.utf8.Accepted()
+		err = ErrInvalidUTF8
+
+	default:
+		r.reset()
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Discard discards current message unread bytes.
+// It discards all frames of fragmented message.
+func (r *Reader) Discard() (err error) {
+	for {
+		_, err = io.Copy(io.Discard, &r.raw)
+		if err != nil {
+			break
+		}
+		if !r.fragmented() {
+			break
+		}
+		if _, err = r.NextFrame(); err != nil {
+			break
+		}
+	}
+	r.reset()
+	return err
+}
+
+// NextFrame prepares r to read next message. It returns received frame header
+// and non-nil error on failure.
+//
+// Note that next NextFrame() call must be done after receiving or discarding
+// all current message bytes.
+func (r *Reader) NextFrame() (hdr easyws.Header, err error) {
+	hdr, err = easyws.ReadHeaderFrom(r.Source)
+	if err == io.EOF && r.fragmented() {
+		// If we are in fragmented state EOF means that is was totally
+		// unexpected.
+		//
+		// NOTE: This is necessary to prevent callers such that
+		// ioutil.ReadAll to receive some amount of bytes without an error.
+		// ReadAll() ignores an io.EOF error, thus caller may think that
+		// whole message fetched, but actually only part of it.
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && !r.SkipHeaderCheck {
+		err = easyws.CheckHeader(hdr, r.State)
+	}
+	if err != nil {
+		return hdr, err
+	}
+
+	if n := r.MaxFrameSize; n > 0 && hdr.Length > n {
+		return hdr, ErrFrameTooLarge
+	}
+
+	// Save raw reader to use it on discarding frame without ciphering and
+	// other streaming checks.
+	r.raw = io.LimitedReader{
+		R: r.Source,
+		N: hdr.Length,
+	}
+
+	frame := io.Reader(&r.raw)
+	if hdr.Masked {
+		r.cipher.Reset(frame, hdr.Mask)
+		frame = &r.cipher
+	}
+
+	if r.fragmented() {
+		if hdr.OpCode.IsControl() {
+			if cb := r.OnIntermediate; cb != nil {
+				err = cb(hdr, frame)
+			}
+			if err == nil {
+				// Ensure that src is empty.
+				_, err = io.Copy(io.Discard, &r.raw)
+			}
+			return hdr, err
+		}
+	} else {
+		r.opCode = hdr.OpCode
+		r.utf8.Reset(nil)
+	}
+	if r.CheckUTF8 && (hdr.OpCode == easyws.OpText || (r.fragmented() && r.opCode == easyws.OpText)) {
+		r.utf8.Source = frame
+		frame = &r.utf8
+	}
+
+	// Save reader with ciphering and other streaming checks.
+	r.frame = frame
+
+	if hdr.OpCode == easyws.OpContinuation {
+		if cb := r.OnContinuation; cb != nil {
+			err = cb(hdr, frame)
+		}
+	}
+
+	if hdr.Fin {
+		r.State = r.State.Clear(easyws.StateFragmented)
+	} else {
+		r.State = r.State.Set(easyws.StateFragmented)
+	}
+
+	return hdr, err
+}
+
+func (r *Reader) fragmented() bool {
+	return r.State.Fragmented()
+}
+
+func (r *Reader) resetFragment() {
+	r.raw = io.LimitedReader{}
+	r.frame = nil
+	// Reset source of the UTF8Reader, but not the state.
+	r.utf8.Source = nil
+}
+
+func (r *Reader) reset() {
+	r.raw = io.LimitedReader{}
+	r.frame = nil
+	r.utf8 = UTF8Reader{}
+	r.opCode = 0
+}
@@ -0,0 +1,111 @@
+package wsutil
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned by UTF8Reader on invalid utf8 sequence.
+var ErrInvalidUTF8 = fmt.Errorf("invalid utf8")
+
+// UTF8Reader implements io.Reader that calculates utf8 validity state after
+// every read byte from Source.
+//
+// Note that in some cases client must call r.Valid() after all bytes are read
+// to ensure that all of them are valid utf8 sequences. That is, some io
+// helper functions such io.ReadAtLeast or io.ReadFull could discard the error
+// information returned by the reader when they receive all of requested
+// bytes. For example, the last read sequence is invalid and UTF8Reader
+// returns number of bytes read and an error. But helper function decides to
+// discard received error due to all requested bytes are completely read from
+// the source.
+//
+// Another possible case is when some valid sequence become split by the read
+// bound. Then UTF8Reader can not make decision about validity of the last
+// sequence cause it is not fully read yet. And if the read stops, Valid()
+// will return false, even if Read() by itself dit not.
+type UTF8Reader struct {
+	Source io.Reader
+
+	accepted int
+
+	// tail holds bytes of the last rune which is not completely read yet.
+	tail  [utf8.UTFMax]byte
+	ntail int
+	err   bool
+}
+
+// NewUTF8Reader creates utf8 reader that reads from r.
+func NewUTF8Reader(r io.Reader) *UTF8Reader {
+	return &UTF8Reader{
+		Source: r,
+	}
+}
+
+// Reset resets utf8 reader to read from r.
+func (u *UTF8Reader) Reset(r io.Reader) {
+	u.Source = r
+	u.accepted = 0
+	u.ntail = 0
+	u.err = false
+}
+
+// Read implements io.Reader.
+func (u *UTF8Reader) Read(p []byte) (n int, err error) {
+	n, err = u.Source.Read(p)
+	if u.err {
+		return n, ErrInvalidUTF8
+	}
+
+	bts := p[:n]
+	// Complete the rune started by the previous read, if any.
+	for u.ntail > 0 && len(bts) > 0 {
+		u.tail[u.ntail] = bts[0]
+		u.ntail++
+		bts = bts[1:]
+		if !utf8.FullRune(u.tail[:u.ntail]) {
+			continue
+		}
+		if !utf8.Valid(u.tail[:u.ntail]) {
+			u.err = true
+			return n, ErrInvalidUTF8
+		}
+		u.accepted += u.ntail
+		u.ntail = 0
+	}
+	if u.ntail > 0 {
+		// All read bytes belong to the rune which is still not complete.
+		return n, err
+	}
+
+	// Cut the last rune if it is not completely read yet.
+	end := len(bts)
+	for i := len(bts) - 1; i >= 0 && i >= len(bts)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(bts[i]) {
+			if !utf8.FullRune(bts[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(bts[:end]) {
+		u.err = true
+		return n, ErrInvalidUTF8
+	}
+	u.accepted += end
+	u.ntail = copy(u.tail[:], bts[end:])
+
+	return n, err
+}
+
+// Valid checks current reader state. It returns true if all read bytes are
+// valid UTF-8 sequences, and false if not.
+func (u *UTF8Reader) Valid() bool {
+	return !u.err && u.ntail == 0
+}
+
+// Accepted returns number of valid bytes in sequence.
+func (u *UTF8Reader) Accepted() int {
+	return u.accepted
+}
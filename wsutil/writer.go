@@ -0,0 +1,162 @@
+package wsutil
+
+import (
+	"io"
+
+	"github.com/EternalVow/easyws"
+)
+
+// DefaultWriteBuffer contains size of Writer's default buffer. It used by
+// Writer constructor functions.
+var DefaultWriteBuffer = 4096
+
+// Writer contains logic of buffering output data into a WebSocket fragments.
+// It is much the same as bufio.Writer, except the thing that it works with
+// WebSocket frames, not the raw data.
+//
+// Writer writes frames with specified OpCode.
+// It uses easyws.State to decide whether the output frames must be masked.
+//
+// Note that it does not check control frame size or other RFC rules.
+// That is, it must be used with special care to write control frames without
+// violation of RFC.
+//
+// If an error occurs writing to a Writer, no more data will be accepted and
+// all subsequent writes will return the error.
+//
+// After all data has been written, the client should call the Flush() method
+// to guarantee all data has been forwarded to the underlying io.Writer.
+type Writer struct {
+	dest io.Writer
+
+	// raw is the buffer holding frame header and payload; buf is its payload
+	// part. Header is written right before the payload.
+	raw []byte
+	buf []byte
+	n   int
+
+	op    easyws.OpCode
+	state easyws.State
+
+	fseq int   // Current fragment sequence number.
+	err  error // Current error.
+}
+
+// NewWriter returns a new Writer whose buffer has the DefaultWriteBuffer size.
+func NewWriter(dest io.Writer, state easyws.State, op easyws.OpCode) *Writer {
+	return NewWriterSize(dest, state, op, 0)
+}
+
+// NewWriterSize returns a new Writer whose buffer size is at most n bytes.
+// That is, every frame written by Writer carries at most n bytes of payload,
+// so large messages are fragmented into chunks of n bytes.
+//
+// If n is zero or negative then the DefaultWriteBuffer is used.
+func NewWriterSize(dest io.Writer, state easyws.State, op easyws.OpCode, n int) *Writer {
+	if n <= 0 {
+		n = DefaultWriteBuffer
+	}
+	raw := make([]byte, easyws.MaxHeaderSize+n)
+	return &Writer{
+		dest:  dest,
+		raw:   raw,
+		buf:   raw[easyws.MaxHeaderSize:],
+		op:    op,
+		state: state,
+	}
+}
+
+// Reset resets Writer as it was created by New() methods. Buffered data is
+// discarded.
+func (w *Writer) Reset(dest io.Writer, state easyws.State, op easyws.OpCode) {
+	w.dest = dest
+	w.state = state
+	w.op = op
+	w.n = 0
+	w.fseq = 0
+	w.err = nil
+}
+
+// Size returns the size of the underlying buffer in bytes.
+func (w *Writer) Size() int {
+	return len(w.buf)
+}
+
+// Available returns how many bytes are unused in the buffer.
+func (w *Writer) Available() int {
+	return len(w.buf) - w.n
+}
+
+// Buffered returns the number of bytes that have been written into the
+// current buffer.
+func (w *Writer) Buffered() int {
+	return w.n
+}
+
+// Write implements io.Writer. Every time the buffer becomes full, its
+// content is written as a non-final fragment of the message.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	for len(p) > w.Available() && w.err == nil {
+		// Buffer is full, so current message will have at least one more
+		// fragment.
+		nn := copy(w.buf[w.n:], p)
+		w.n += nn
+		n += nn
+		p = p[nn:]
+		w.err = w.flushFragment(false)
+	}
+	if w.err != nil {
+		return n, w.err
+	}
+	nn := copy(w.buf[w.n:], p)
+	w.n += nn
+	n += nn
+	return n, nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer as the final
+// fragment of the message. It sends empty final frame if nothing was written
+// after the last fragment. Next Write() starts a new message.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.flushFragment(true)
+	w.fseq = 0
+	return w.err
+}
+
+// FlushFragment writes any buffered data to the underlying io.Writer as a
+// non-final fragment of the message. It does nothing if nothing is buffered.
+func (w *Writer) FlushFragment() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.n == 0 {
+		return nil
+	}
+	w.err = w.flushFragment(false)
+	return w.err
+}
+
+func (w *Writer) flushFragment(fin bool) error {
+	op := w.op
+	if w.fseq > 0 {
+		op = easyws.OpContinuation
+	}
+	f := easyws.NewFrame(op, fin, w.buf[:w.n])
+	if w.state.ClientSide() {
+		f = easyws.MaskFrameInPlace(f)
+	}
+	// Write header right before the payload.
+	start := easyws.MaxHeaderSize - easyws.HeaderSize(f.Header)
+	if _, err := easyws.AppendHeader(w.raw[start:start], f.Header); err != nil {
+		return err
+	}
+	if _, err := w.dest.Write(w.raw[start : easyws.MaxHeaderSize+w.n]); err != nil {
+		return err
+	}
+	w.n = 0
+	w.fseq++
+	return nil
+}
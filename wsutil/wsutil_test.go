@@ -0,0 +1,178 @@
+package wsutil
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/EternalVow/easyws"
+)
+
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func TestWriterReader(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		state  easyws.State
+		size   int
+		msg    []byte
+		frames int
+	}{
+		{"single", easyws.StateClientSide, 16, []byte("hello"), 1},
+		{"exact", easyws.StateServerSide, 5, []byte("hello"), 1},
+		{"fragmented", easyws.StateClientSide, 4, []byte("hello, world"), 3},
+		{"large", easyws.StateServerSide, 100, bytes.Repeat([]byte{'x'}, 1000), 10},
+		{"empty", easyws.StateClientSide, 4, nil, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriterSize(&buf, test.state, easyws.OpText, test.size)
+			if _, err := w.Write(test.msg); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			var frames int
+			peer := easyws.StateServerSide
+			if test.state.ServerSide() {
+				peer = easyws.StateClientSide
+			}
+			r := NewReader(iotest.OneByteReader(&buf), peer)
+			r.CheckUTF8 = true
+			r.OnContinuation = func(easyws.Header, io.Reader) error {
+				frames++
+				return nil
+			}
+			h, err := r.NextFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			frames++
+			if h.OpCode != easyws.OpText {
+				t.Fatalf("unexpected op code: %v", h.OpCode)
+			}
+			act, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(act, test.msg) {
+				t.Fatalf("unexpected message: %q; want %q", act, test.msg)
+			}
+			if frames != test.frames {
+				t.Fatalf("message was sent in %d frames; want %d", frames, test.frames)
+			}
+		})
+	}
+}
+
+func TestReadClientData(t *testing.T) {
+	var in bytes.Buffer
+	WriteClientMessage(&in, easyws.OpPing, []byte("ping"))
+	w := NewWriterSize(&in, easyws.StateClientSide, easyws.OpBinary, 3)
+	w.Write([]byte("foo"))
+	w.FlushFragment()
+	WriteClientMessage(&in, easyws.OpPing, []byte("intermediate"))
+	w.Write([]byte("bar"))
+	w.Flush()
+	WriteClientMessage(&in, easyws.OpClose, easyws.NewCloseFrameBody(easyws.StatusGoingAway, "bye"))
+
+	var out bytes.Buffer
+	rw := readWriter{&in, &out}
+
+	msg, op, err := ReadClientData(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != easyws.OpBinary || string(msg) != "foobar" {
+		t.Fatalf("unexpected message: %v %q", op, msg)
+	}
+	_, _, err = ReadClientData(rw)
+	if err != (easyws.ClosedError{Code: easyws.StatusGoingAway, Reason: "bye"}) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp := []easyws.Frame{
+		easyws.NewPongFrame([]byte("ping")),
+		easyws.NewPongFrame([]byte("intermediate")),
+		easyws.NewCloseFrame(easyws.NewCloseFrameBody(easyws.StatusGoingAway, "")),
+	}
+	for _, f := range exp {
+		act, err := easyws.ReadFrame(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act.Header != f.Header || !bytes.Equal(act.Payload, f.Payload) {
+			t.Fatalf("unexpected response: %+v; want %+v", act, f)
+		}
+	}
+}
+
+func TestReaderCheckUTF8(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterSize(&buf, easyws.StateServerSide, easyws.OpText, 1)
+	w.Write([]byte("\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80"))
+	w.Flush()
+
+	r := NewClientSideReader(&buf)
+	r.CheckUTF8 = true
+	if _, err := r.NextFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err != ErrInvalidUTF8 {
+		t.Fatalf("unexpected error: %v; want %v", err, ErrInvalidUTF8)
+	}
+}
+
+func TestUTF8Reader(t *testing.T) {
+	for _, test := range []struct {
+		data  string
+		valid bool
+	}{
+		{"hello", true},
+		{"κόσμε", true},
+		{"\U0001f600 ߿ࠀ￿", true},
+		{"\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80edited", false},
+		{"\xf4\x90\x80\x80", false},
+		{"\xc0\xaf", false},
+		{"κόσμε\xce", false},
+	} {
+		for _, rd := range []io.Reader{
+			bytes.NewReader([]byte(test.data)),
+			iotest.OneByteReader(bytes.NewReader([]byte(test.data))),
+			iotest.HalfReader(bytes.NewReader([]byte(test.data))),
+		} {
+			u := NewUTF8Reader(rd)
+			_, err := io.ReadAll(u)
+			if valid := err == nil && u.Valid(); valid != test.valid {
+				t.Errorf("%q: valid is %v (err: %v); want %v", test.data, valid, err, test.valid)
+			}
+		}
+	}
+}
+
+func TestCipher(t *testing.T) {
+	mask := easyws.NewMask()
+	msg := []byte("hello, world! this is a long enough message to be ciphered in chunks")
+
+	var buf bytes.Buffer
+	w := NewCipherWriter(&buf, mask)
+	w.Write(msg[:5])
+	w.Write(msg[5:])
+	if bytes.Equal(buf.Bytes(), msg) {
+		t.Fatalf("message is not ciphered")
+	}
+
+	act, err := io.ReadAll(NewCipherReader(iotest.OneByteReader(&buf), mask))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(act, msg) {
+		t.Fatalf("unexpected deciphered message: %q", act)
+	}
+}